@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Alert is a rule that held for a key over an interval.
+type Alert struct {
+	Rule    string
+	Key     string
+	Command string
+	Hits    int
+
+	// The key's share of the hits for all keys
+	Share float64
+}
+
+// ID identifies an alert, by its rule, command and key, across reports.
+func (a *Alert) ID() string {
+	return a.Rule + "/" + a.Command + "/" + a.Key
+}
+
+// Summary describes an alert, for people to read.
+func (a *Alert) Summary() string {
+	hits := "hits"
+	if a.Command != "" {
+		hits = a.Command + " hits"
+	}
+	return fmt.Sprintf("%s: key %q had %d %s (%.1f%% of all) in an interval",
+		a.Rule, a.Key, a.Hits, hits, a.Share*100)
+}
+
+// evaluateAlerts returns an alert for each key each rule holds for, in
+// the order of the rules, then most hits first.  Keys are counted in
+// hot_keys, or, for rules on a command, in command_keys.
+func evaluateAlerts(rules []AlertConfig, hot_keys *HotKeyPool, command_keys *TaggedHotKeyPool) []*Alert {
+	alerts := []*Alert{}
+	for _, rule := range rules {
+		pool := hot_keys
+		if rule.Command != "" {
+			pool = command_keys.Get(rule.Command)
+		}
+		if pool == nil {
+			continue
+		}
+		keys := popTopKeys(pool.GetTopKeys(), -1, 0)
+		total := 0
+		for _, key := range keys {
+			total += key.Hits
+		}
+		for _, key := range keys {
+			if rule.Key != "" && key.Name != rule.Key {
+				continue
+			}
+			share := float64(key.Hits) / float64(total)
+			if (rule.Hits > 0 && key.Hits > rule.Hits) || (rule.Share > 0 && share > rule.Share) {
+				alerts = append(alerts, &Alert{
+					Rule:    rule.Name,
+					Key:     key.Name,
+					Command: rule.Command,
+					Hits:    key.Hits,
+					Share:   share,
+				})
+			}
+		}
+	}
+	return alerts
+}
+
+// formatAlerts adds the hits for the key each alert is for, labelled with
+// the rule and, if it is on a command, the command, and keeps the alerts
+// with the report for notifiers.
+func formatAlerts(report *Report, name MetricName, alerts []*Alert) {
+	report.Alerts = append(report.Alerts, alerts...)
+	for _, alert := range alerts {
+		alert_name := name.Label("alert", alert.Rule)
+		if alert.Command != "" {
+			alert_name = alert_name.Label("command", alert.Command)
+		}
+		report.Gauge(alert_name.Label("key", alert.Key), float64(alert.Hits))
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvaluateAlerts(t *testing.T) {
+	hot_keys := NewHotKeyPool()
+	hot_keys.AddN("foo", 60)
+	hot_keys.AddN("bar", 30)
+	hot_keys.AddN("baz", 10)
+	command_keys := NewTaggedHotKeyPool()
+	command_keys.Add("get", []string{"bar", "bar", "baz"})
+
+	rules := []AlertConfig{
+		{Name: "hot", Share: 0.25},
+		{Name: "foo", Key: "foo", Hits: 100},
+		{Name: "hot_get", Command: "get", Share: 0.5},
+		{Name: "hot_set", Command: "set", Hits: 1},
+	}
+	alerts := evaluateAlerts(rules, hot_keys, command_keys)
+
+	report := NewReport(time.Now())
+	formatAlerts(report, NewMetricName("mcsauna.alerts"), alerts)
+	expected := "mcsauna.alerts.hot.foo 60\nmcsauna.alerts.hot.bar 30\n" +
+		"mcsauna.alerts.hot_get.get.bar 2\n"
+	if output := report.String(); output != expected {
+		t.Errorf("Expected %q, got %q\n", expected, output)
+	}
+	if alerts[0].Share != 0.6 {
+		t.Errorf("Expected a share of 0.6, got %f\n", alerts[0].Share)
+	}
+}
+
+func TestAlertConfig(t *testing.T) {
+	invalid := []string{
+		`{"alerts": [{"share": 0.1}]}`,
+		`{"alerts": [{"name": "hot"}]}`,
+		`{"alerts": [{"name": "hot", "share": 1.5}]}`,
+		`{"alerts": [{"name": "hot", "hits": 10, "command": "get"}]}`,
+	}
+	for _, config_data := range invalid {
+		if _, err := NewConfig([]byte(config_data)); err == nil {
+			t.Errorf("Expected an error for %s\n", config_data)
+		}
+	}
+	_, err := NewConfig([]byte(`{"alerts": [{"name": "hot", "hits": 10, "command": "get"}], "command_sections": ["get"]}`))
+	if err != nil {
+		t.Errorf("Unexpected error %v\n", err)
+	}
+}
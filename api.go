@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Keys returned by the API when the request doesn't say how many
+const API_DEFAULT_KEYS = 20
+
+// APISink serves the hottest keys of recent reports over HTTP, as JSON,
+// for scripts and runbooks to query: those of the latest report and the
+// one before at
+//
+//	GET /v1/topkeys?n=50&command=get
+//
+// and those of every report kept in the history, optionally only since a
+// time, at
+//
+//	GET /v1/history?n=50&command=get&since=2017-07-14T02:40:00Z
+//
+// The command is optional, and only matches keys if it is one of the
+// command_sections.
+type APISink struct {
+	// Metrics are named under this prefix
+	prefix string
+
+	history *ReportHistory
+}
+
+// apiInterval is the hottest keys of a report, hottest first.
+type apiInterval struct {
+	Time string    `json:"time"`
+	Keys []*apiKey `json:"keys"`
+}
+
+type apiKey struct {
+	Key  string `json:"key"`
+	Hits int    `json:"hits"`
+}
+
+// NewAPISink returns a sink keeping the latest history reports, or two if
+// that's fewer.
+func NewAPISink(prefix string, history int) *APISink {
+	if history < 2 {
+		history = 2
+	}
+	return &APISink{prefix: prefix, history: NewReportHistory(history)}
+}
+
+// ListenAndServe serves the API on address until it fails.
+func (a *APISink) ListenAndServe(address string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/topkeys", a.serveTopKeys)
+	mux.HandleFunc("/v1/history", a.serveHistory)
+	return http.ListenAndServe(address, mux)
+}
+
+// Send adds a report to the history, making it the current one.
+func (a *APISink) Send(report *Report) error {
+	a.history.Push(report)
+	return nil
+}
+
+func (a *APISink) serveTopKeys(w http.ResponseWriter, r *http.Request) {
+	n, command, ok := apiParams(w, r)
+	if !ok {
+		return
+	}
+	reports := a.history.Reports()
+	if len(reports) == 0 {
+		writeAPIError(w, http.StatusServiceUnavailable, "No report has been made yet")
+		return
+	}
+	response := map[string]*apiInterval{
+		"current":  newAPIInterval(reports[len(reports)-1], a.prefix, n, command),
+		"previous": nil,
+	}
+	if len(reports) > 1 {
+		response["previous"] = newAPIInterval(reports[len(reports)-2], a.prefix, n, command)
+	}
+	writeAPIResponse(w, http.StatusOK, response)
+}
+
+func (a *APISink) serveHistory(w http.ResponseWriter, r *http.Request) {
+	n, command, ok := apiParams(w, r)
+	if !ok {
+		return
+	}
+	var since time.Time
+	if param := r.URL.Query().Get("since"); param != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, param); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "'since' must be an RFC 3339 time")
+			return
+		}
+	}
+	intervals := []*apiInterval{}
+	for _, report := range a.history.Reports() {
+		if !report.Time.Before(since) {
+			intervals = append(intervals, newAPIInterval(report, a.prefix, n, command))
+		}
+	}
+	writeAPIResponse(w, http.StatusOK, map[string][]*apiInterval{"intervals": intervals})
+}
+
+// apiParams returns the number of keys and command asked for by a GET
+// request, or writes an error and returns false if it isn't one.
+func apiParams(w http.ResponseWriter, r *http.Request) (int, string, bool) {
+	if r.Method != "GET" {
+		writeAPIError(w, http.StatusMethodNotAllowed, "Only GET is supported")
+		return 0, "", false
+	}
+	n := API_DEFAULT_KEYS
+	if param := r.URL.Query().Get("n"); param != "" {
+		var err error
+		if n, err = strconv.Atoi(param); err != nil || n <= 0 {
+			writeAPIError(w, http.StatusBadRequest, "'n' must be a positive number")
+			return 0, "", false
+		}
+	}
+	return n, r.URL.Query().Get("command"), true
+}
+
+// newAPIInterval returns the n hottest keys in a report, for command if
+// set, breaking ties by key.
+func newAPIInterval(report *Report, prefix string, n int, command string) *apiInterval {
+	family := metricName(prefix, "keys").Family()
+	if command != "" {
+		family = metricName(prefix, "commands").Append("keys").Family()
+	}
+	keys := []*apiKey{}
+	for _, m := range report.Metrics {
+		_, key, key_command := m.Name.SplitKeyCommand()
+		if m.Name.Family() == family && key != "" && key_command == command {
+			keys = append(keys, &apiKey{Key: key, Hits: int(m.Value)})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Hits != keys[j].Hits {
+			return keys[i].Hits > keys[j].Hits
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return &apiInterval{Time: report.Time.Format(time.RFC3339), Keys: keys}
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIResponse(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// getTopKeys makes a request of the API, returning the status and the
+// decoded response.
+func getTopKeys(api *APISink, method string, query string) (int, map[string]*apiInterval) {
+	w := httptest.NewRecorder()
+	api.serveTopKeys(w, httptest.NewRequest(method, "/v1/topkeys"+query, nil))
+	response := map[string]*apiInterval{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+func TestAPITopKeys(t *testing.T) {
+	api := NewAPISink("mcsauna", 0)
+	if status, _ := getTopKeys(api, "GET", ""); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected no reports to be unavailable, got %d\n", status)
+	}
+
+	commands := NewMetricName("mcsauna.commands")
+	for i, hits := range []int{1, 2, 3} {
+		report := NewReport(time.Unix(1500000000+int64(i)*5, 0).UTC())
+		report.Count(NewMetricName("mcsauna.keys").Label("key", "foo"), hits)
+		report.Count(NewMetricName("mcsauna.keys").Label("key", "bar"), 2)
+		report.Count(NewMetricName("mcsauna.keys").Label("key", "baz"), 2)
+		report.Count(commands.Label("command", "get").Append("keys").Label("key", "bar"), 2)
+		report.Count(commands.Label("command", "set").Append("keys").Label("key", "foo"), hits)
+		api.Send(report)
+	}
+
+	status, response := getTopKeys(api, "GET", "?n=2")
+	if status != http.StatusOK {
+		t.Fatalf("Expected OK, got %d\n", status)
+	}
+	expected := map[string]*apiInterval{
+		"current":  {"2017-07-14T02:40:10Z", []*apiKey{{"foo", 3}, {"bar", 2}}},
+		"previous": {"2017-07-14T02:40:05Z", []*apiKey{{"bar", 2}, {"baz", 2}}},
+	}
+	if !reflect.DeepEqual(response, expected) {
+		t.Errorf("Unexpected response %v\n", response)
+	}
+
+	_, response = getTopKeys(api, "GET", "?command=set")
+	if keys := response["current"].Keys; !reflect.DeepEqual(keys, []*apiKey{{"foo", 3}}) {
+		t.Errorf("Expected only keys set, got %v\n", keys)
+	}
+
+	if status, _ := getTopKeys(api, "GET", "?n=-1"); status != http.StatusBadRequest {
+		t.Errorf("Expected a bad request, got %d\n", status)
+	}
+	if status, _ := getTopKeys(api, "POST", ""); status != http.StatusMethodNotAllowed {
+		t.Errorf("Expected POST not to be allowed, got %d\n", status)
+	}
+}
+
+func TestAPIHistory(t *testing.T) {
+	api := NewAPISink("mcsauna", 3)
+	for i := 0; i < 5; i++ {
+		report := NewReport(time.Unix(1500000000+int64(i)*5, 0).UTC())
+		report.Count(NewMetricName("mcsauna.keys").Label("key", "foo"), i)
+		api.Send(report)
+	}
+
+	/* Only the last three reports are kept */
+	get := func(query string) (int, []*apiInterval) {
+		w := httptest.NewRecorder()
+		api.serveHistory(w, httptest.NewRequest("GET", "/v1/history"+query, nil))
+		response := map[string][]*apiInterval{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response["intervals"]
+	}
+	_, intervals := get("")
+	expected := []*apiInterval{
+		{"2017-07-14T02:40:10Z", []*apiKey{{"foo", 2}}},
+		{"2017-07-14T02:40:15Z", []*apiKey{{"foo", 3}}},
+		{"2017-07-14T02:40:20Z", []*apiKey{{"foo", 4}}},
+	}
+	if !reflect.DeepEqual(intervals, expected) {
+		t.Errorf("Expected %v, got %v\n", expected, intervals)
+	}
+	if _, intervals := get("?since=2017-07-14T02:40:15Z"); !reflect.DeepEqual(intervals, expected[1:]) {
+		t.Errorf("Expected %v, got %v\n", expected[1:], intervals)
+	}
+	if status, _ := get("?since=yesterday"); status != http.StatusBadRequest {
+		t.Errorf("Expected a bad request, got %d\n", status)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Burst is how a name's hits were spread over an interval: the most it had
+// in any one second, and the total.
+type Burst struct {
+	Peak  int
+	Total int
+
+	// Hits in the second being counted, in seconds since the epoch
+	second  int64
+	current int
+}
+
+// Ratio returns how many times the average rate over an interval the peak
+// rate was, from 1 for steady hits, up to the number of seconds in the
+// interval when every hit came in the same second.
+func (b Burst) Ratio(interval time.Duration) float64 {
+	if b.Total == 0 {
+		return 0
+	}
+	return float64(b.Peak) / (float64(b.Total) / interval.Seconds())
+}
+
+// BurstPool keeps track of hits by name, where the name may be a key or a
+// regexp group, in one-second buckets, so that a key doing all its hits in
+// a stampede can be told apart from one that is steadily hot.
+type BurstPool struct {
+	Lock sync.Mutex
+
+	// Map of names to bursts
+	items map[string]*Burst
+}
+
+func NewBurstPool() *BurstPool {
+	b := &BurstPool{}
+	b.items = make(map[string]*Burst)
+	return b
+}
+
+// Add records a hit at now on each of names.
+func (b *BurstPool) Add(names []string, now time.Time) {
+	b.Lock.Lock()
+	defer b.Lock.Unlock()
+
+	second := now.Unix()
+	for _, name := range names {
+		burst, ok := b.items[name]
+		if !ok {
+			burst = &Burst{}
+			b.items[name] = burst
+		}
+		if burst.second != second {
+			burst.second = second
+			burst.current = 0
+		}
+		burst.current += 1
+		burst.Total += 1
+		if burst.current > burst.Peak {
+			burst.Peak = burst.current
+		}
+	}
+}
+
+func (b *BurstPool) Get(name string) Burst {
+	b.Lock.Lock()
+	defer b.Lock.Unlock()
+
+	if burst, ok := b.items[name]; ok {
+		return *burst
+	}
+	return Burst{}
+}
+
+// GetBurstiest returns a KeyHeap of names ordered by their peak hits in a
+// second, most first.
+func (b *BurstPool) GetBurstiest() *KeyHeap {
+	b.Lock.Lock()
+	defer b.Lock.Unlock()
+
+	burstiest := &KeyHeap{}
+	heap.Init(burstiest)
+
+	for name, burst := range b.items {
+		heap.Push(burstiest, &Key{name, burst.Peak})
+	}
+	return burstiest
+}
+
+// Rotate clears the existing pool, returning a new pool containing the old
+// data.  A second split between intervals counts towards each separately.
+func (b *BurstPool) Rotate() *BurstPool {
+	b.Lock.Lock()
+	defer b.Lock.Unlock()
+
+	// Clone existing
+	new_burst_pool := NewBurstPool()
+	new_burst_pool.items = b.items
+
+	// Clear existing values
+	b.items = make(map[string]*Burst)
+	return new_burst_pool
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"container/heap"
+	"testing"
+	"time"
+)
+
+func TestBurstPool(t *testing.T) {
+	b := NewBurstPool()
+	start := time.Unix(1500000000, 0)
+
+	/* foo is hit steadily, once a second, bar all at once */
+	for i := 0; i < 4; i++ {
+		b.Add([]string{"foo"}, start.Add(time.Duration(i)*time.Second))
+		b.Add([]string{"bar"}, start.Add(2*time.Second+time.Duration(i)*time.Millisecond))
+	}
+	b.Add([]string{"baz", "baz"}, start.Add(3*time.Second))
+
+	if foo := b.Get("foo"); foo.Peak != 1 || foo.Total != 4 || foo.Ratio(4*time.Second) != 1 {
+		t.Errorf("Expected foo to peak at 1 of 4 hits, ratio 1, got %+v\n", foo)
+	}
+	if bar := b.Get("bar"); bar.Peak != 4 || bar.Ratio(4*time.Second) != 4 {
+		t.Errorf("Expected bar to peak at 4 hits, ratio 4, got %+v\n", bar)
+	}
+
+	rotated := b.Rotate()
+	if b.Get("bar").Total != 0 {
+		t.Errorf("Expected rotated pool to be cleared\n")
+	}
+
+	burstiest := rotated.GetBurstiest()
+	for _, expected := range []string{"bar", "baz", "foo"} {
+		key := heap.Pop(burstiest).(*Key)
+		if key.Name != expected {
+			t.Errorf("Expected burstiest %s, got %s\n", expected, key.Name)
+		}
+	}
+}
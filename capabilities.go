@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Capabilities capturing uses, by their numbers in linux/capability.h.
+// Only CAP_NET_RAW is needed to open a capture handle, but libpcap may use
+// CAP_NET_ADMIN to configure the interface.
+const (
+	CAP_NET_ADMIN = 12
+	CAP_NET_RAW   = 13
+)
+
+// parseCapabilities returns the effective capabilities, as a bit mask, in
+// the contents of /proc/<pid>/status, or false if they aren't there.
+func parseCapabilities(status []byte) (uint64, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(status))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "CapEff:" {
+			capabilities, err := strconv.ParseUint(fields[1], 16, 64)
+			return capabilities, err == nil
+		}
+	}
+	return 0, false
+}
+
+// checkCaptureCapabilities returns why mcsauna can't capture, and what to
+// do about it, if it doesn't have CAP_NET_RAW, rather than leaving libpcap
+// to fail with a bare permission error.  Where capabilities can't be read,
+// e.g. on anything but Linux, capture is left to fail, or not.
+func checkCaptureCapabilities() error {
+	status, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return nil
+	}
+	capabilities, ok := parseCapabilities(status)
+	if !ok {
+		return nil
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		executable = os.Args[0]
+	}
+	return capabilitiesError(capabilities, os.Geteuid(), executable)
+}
+
+// capabilitiesError returns an error saying what's needed to capture, if
+// capabilities are short of it, for executable running as euid.
+func capabilitiesError(capabilities uint64, euid int, executable string) error {
+	if capabilities&(1<<CAP_NET_RAW) != 0 {
+		return nil
+	}
+	if euid == 0 {
+		// ... e.g. in a container, which drops it by default
+		return exitError(EXIT_PERMISSION, "capturing needs CAP_NET_RAW, which mcsauna doesn't have, "+
+			"even as root: grant it, e.g. with 'docker run --cap-add NET_RAW --cap-add NET_ADMIN'")
+	}
+	return exitError(EXIT_PERMISSION, "capturing needs CAP_NET_RAW, which mcsauna doesn't have: "+
+		"run it as root, or grant it with 'sudo setcap cap_net_raw,cap_net_admin=eip %s'", executable)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	status := "Name:\tmcsauna\nCapInh:\t0000000000000000\nCapPrm:\t0000000000003000\n" +
+		"CapEff:\t0000000000003000\nCapBnd:\t000001ffffffffff\n"
+	capabilities, ok := parseCapabilities([]byte(status))
+	if !ok || capabilities != 1<<CAP_NET_RAW|1<<CAP_NET_ADMIN {
+		t.Errorf("Expected CAP_NET_RAW and CAP_NET_ADMIN, got %x (%v)\n", capabilities, ok)
+	}
+	if _, ok := parseCapabilities([]byte("Name:\tmcsauna\n")); ok {
+		t.Errorf("Expected no capabilities without CapEff\n")
+	}
+}
+
+func TestCapabilitiesError(t *testing.T) {
+	if err := capabilitiesError(1<<CAP_NET_RAW, 1000, "/usr/bin/mcsauna"); err != nil {
+		t.Errorf("Expected no error with CAP_NET_RAW, got %v\n", err)
+	}
+
+	/* Without it, what to do depends on whether mcsauna is root */
+	err := capabilitiesError(0, 1000, "/usr/bin/mcsauna")
+	if exitStatus(err) != EXIT_PERMISSION || !strings.Contains(err.Error(), "setcap cap_net_raw,cap_net_admin=eip /usr/bin/mcsauna") {
+		t.Errorf("Expected a permission error suggesting setcap, got %v\n", err)
+	}
+	err = capabilitiesError(0, 0, "/usr/bin/mcsauna")
+	if exitStatus(err) != EXIT_PERMISSION || !strings.Contains(err.Error(), "--cap-add NET_RAW") {
+		t.Errorf("Expected a permission error suggesting adding the capability, got %v\n", err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+// Number of bits of each hash used to pick a HyperLogLog register.  2^14
+// registers take up 16KB and give a standard error of about 0.8%.
+const HLL_PRECISION = 14
+
+// HyperLogLog estimates the number of distinct keys added to it, using a
+// fixed amount of memory however many there are.
+type HyperLogLog struct {
+	registers []uint8
+}
+
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{registers: make([]uint8, 1<<HLL_PRECISION)}
+}
+
+func (h *HyperLogLog) Add(key string) {
+	hash := hllHash(key)
+	i := hash >> (64 - HLL_PRECISION)
+	rank := uint8(bits.LeadingZeros64(hash<<HLL_PRECISION|1<<(HLL_PRECISION-1)) + 1)
+	if rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+}
+
+// Count returns the estimated number of distinct keys added.
+func (h *HyperLogLog) Count() int {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, rank := range h.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros += 1
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+
+	// ... the raw estimate is biased for small counts, where counting the
+	// ... empty registers does better
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int(estimate + 0.5)
+}
+
+// hllHash returns a 64 bit hash of key.  FNV-1a alone doesn't mix its high
+// bits well enough for a HyperLogLog, so it is finished off with the
+// MurmurHash3 finalizer.
+func hllHash(key string) uint64 {
+	hash := keyHash64(key)
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb9fe1a85ec53
+	hash ^= hash >> 33
+	return hash
+}
+
+// CardinalityPool estimates the number of distinct keys seen, overall and
+// for each regexp group.
+type CardinalityPool struct {
+	Lock sync.Mutex
+
+	overall *HyperLogLog
+
+	// Map of regexp group names to estimators
+	groups map[string]*HyperLogLog
+}
+
+func NewCardinalityPool() *CardinalityPool {
+	c := &CardinalityPool{}
+	c.overall = NewHyperLogLog()
+	c.groups = make(map[string]*HyperLogLog)
+	return c
+}
+
+// Add adds key to the overall count, and to the count for group unless it
+// is empty.
+func (c *CardinalityPool) Add(key string, group string) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	c.overall.Add(key)
+	if group == "" {
+		return
+	}
+	hll, ok := c.groups[group]
+	if !ok {
+		hll = NewHyperLogLog()
+		c.groups[group] = hll
+	}
+	hll.Add(key)
+}
+
+func (c *CardinalityPool) Count() int {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	return c.overall.Count()
+}
+
+// GroupCount returns the estimated number of distinct keys in group.
+func (c *CardinalityPool) GroupCount(group string) int {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	if hll, ok := c.groups[group]; ok {
+		return hll.Count()
+	}
+	return 0
+}
+
+// Groups returns every group that has been added to, sorted.
+func (c *CardinalityPool) Groups() []string {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	groups := make([]string, 0, len(c.groups))
+	for group := range c.groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// Rotate clears the data on the existing CardinalityPool, returning a new
+// pool containing the old data.
+func (c *CardinalityPool) Rotate() *CardinalityPool {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	// Clone existing
+	new_cardinality_pool := &CardinalityPool{}
+	new_cardinality_pool.overall = c.overall
+	new_cardinality_pool.groups = c.groups
+
+	// Clear existing values
+	c.overall = NewHyperLogLog()
+	c.groups = make(map[string]*HyperLogLog)
+	return new_cardinality_pool
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{0, 10, 1000, 100000} {
+		h := NewHyperLogLog()
+		for i := 0; i < n; i++ {
+			// ... repeats shouldn't be counted again
+			h.Add(fmt.Sprintf("key_%d", i))
+			h.Add(fmt.Sprintf("key_%d", i))
+		}
+		count := h.Count()
+		if float64(count) < float64(n)*0.97 || float64(count) > float64(n)*1.03 {
+			t.Errorf("Expected about %d distinct keys, got %d\n", n, count)
+		}
+	}
+}
+
+func TestCardinalityGroups(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"track_cardinality": true, "regexps": [{"name": "user", "re": "^user_"}]}`))
+	regexp_keys := NewRegexpKeys()
+	regexp_key, _ := NewRegexpKey("^user_", "user")
+	regexp_keys.Add(regexp_key)
+	stats := NewStats(config)
+	processor := NewProcessor(config, regexp_keys, stats)
+
+	processor.countKeys([]string{"user_1", "user_2", "user_1", "other"}, 0)
+
+	rotated := stats.Rotate()
+	if count := rotated.Cardinality.Count(); count != 3 {
+		t.Errorf("Expected 3 distinct keys, got %d\n", count)
+	}
+	if count := rotated.Cardinality.GroupCount("user"); count != 2 {
+		t.Errorf("Expected 2 distinct user keys, got %d\n", count)
+	}
+	if !stringsEqual(rotated.Cardinality.Groups(), []string{"user"}) {
+		t.Errorf("Expected only the user group, got %v\n", rotated.Cardinality.Groups())
+	}
+	if count := stats.Cardinality.Count(); count != 0 {
+		t.Errorf("Expected rotated pool to be cleared, got %d\n", count)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Nagios plugin exit codes, which are also the index of each status's name
+// in CHECK_STATUSES
+const (
+	CHECK_OK       = 0
+	CHECK_WARNING  = 1
+	CHECK_CRITICAL = 2
+)
+
+var CHECK_STATUSES = []string{"OK", "WARNING", "CRITICAL"}
+
+// runCheck waits for an interval of traffic to be captured, then checks
+// it, returning the check line to print and the status to exit with.
+func runCheck(config Config, stats *Stats) (string, int) {
+	time.Sleep(time.Duration(config.Interval))
+	return check(config, stats.Rotate())
+}
+
+// check checks the hottest key's share of all hits, and the fraction of
+// commands that couldn't be parsed, over an interval against the warning
+// and critical thresholds, returning a check line, with performance data,
+// and the worst status of the two.  The Summary must have been counted.
+func check(config Config, rotated *Stats) (string, int) {
+	keys := popTopKeys(rotated.HotKeys.GetTopKeys(), -1, 0)
+	hits := 0
+	for _, key := range keys {
+		hits += key.Hits
+	}
+	top_key, share := "", 0.0
+	if hits > 0 {
+		top_key, share = keys[0].Name, float64(keys[0].Hits)/float64(hits)
+	}
+
+	parse_errors := 0
+	for _, key := range popTopKeys(rotated.Errors.GetTopKeys(), -1, 0) {
+		parse_errors += key.Hits
+	}
+	commands := rotated.Summary.GetHits("commands")
+	error_rate := 0.0
+	if parse_errors > 0 {
+		error_rate = float64(parse_errors) / float64(parse_errors+commands)
+	}
+
+	status := checkStatus(share, config.CheckShareWarning, config.CheckShareCritical)
+	if s := checkStatus(error_rate, config.CheckErrorRateWarning, config.CheckErrorRateCritical); s > status {
+		status = s
+	}
+
+	summary := "no keys seen"
+	if top_key != "" {
+		summary = fmt.Sprintf("hottest key %q has %.1f%% of %d hits", top_key, share*100, hits)
+	}
+	line := fmt.Sprintf(
+		"MCSAUNA %s - %s, %.2f%% of commands unparseable | max_key_share=%g;%s;%s;0;1 parse_error_rate=%g;%s;%s;0;1 hits=%dc",
+		CHECK_STATUSES[status], summary, error_rate*100,
+		share, checkThreshold(config.CheckShareWarning), checkThreshold(config.CheckShareCritical),
+		error_rate, checkThreshold(config.CheckErrorRateWarning), checkThreshold(config.CheckErrorRateCritical),
+		hits)
+	return line, status
+}
+
+// checkStatus returns the status of a value against warning and critical
+// thresholds, either of which is ignored if it isn't set.
+func checkStatus(value float64, warning float64, critical float64) int {
+	if critical > 0 && value >= critical {
+		return CHECK_CRITICAL
+	} else if warning > 0 && value >= warning {
+		return CHECK_WARNING
+	}
+	return CHECK_OK
+}
+
+// checkThreshold formats a threshold for performance data, leaving it
+// empty if it isn't set.
+func checkThreshold(threshold float64) string {
+	if threshold <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%g", threshold)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// Replaces secrets in the configuration when it's printed
+const REDACTED = "REDACTED"
+
+// checkConfig makes the checks on a config that NewConfig leaves until
+// startup: that the regexps and filters compile, that the user and group
+// exist, that the gRPC certificate can be loaded, and that the capture
+// interface is one of interfaces.
+// Every problem found is returned, rather than just the first.
+func checkConfig(config Config, interfaces []string) []error {
+	problems := []error{}
+	if _, err := NewRegexpKeysFromConfig(config.Regexps); err != nil {
+		problems = append(problems, fmt.Errorf("Config error: invalid regexp: %v", err))
+	}
+	if _, err := NewKeyFilter(config.Include, config.Exclude); err != nil {
+		problems = append(problems, fmt.Errorf("Config error: invalid 'include' or 'exclude' rule: %v", err))
+	}
+	if config.User != "" {
+		if _, err := lookupUser(config.User, config.Group); err != nil {
+			problems = append(problems, fmt.Errorf("Config error: %v", err))
+		}
+	}
+	if config.GRPCAddress != "" {
+		if _, err := tls.LoadX509KeyPair(config.GRPCCertFile, config.GRPCKeyFile); err != nil {
+			problems = append(problems, fmt.Errorf("Config error: can't load the gRPC certificate: %v", err))
+		}
+	}
+
+	found := config.Interface == "any"
+	for _, iface := range interfaces {
+		found = found || iface == config.Interface
+	}
+	if !found {
+		problems = append(problems, fmt.Errorf("Config error: no such interface '%s'.", config.Interface))
+	}
+	return problems
+}
+
+// formatConfig returns the config as indented JSON, with every field set,
+// and with secrets redacted.
+func formatConfig(config Config) string {
+	config.S3SecretAccessKey = redact(config.S3SecretAccessKey)
+	config.PagerDutyRoutingKey = redact(config.PagerDutyRoutingKey)
+	config.SlackWebhookURL = redact(config.SlackWebhookURL)
+	headers := map[string]string{}
+	for name, value := range config.WebhookHeaders {
+		headers[name] = redact(value)
+	}
+	config.WebhookHeaders = headers
+	for _, u := range []*string{&config.WebhookURL, &config.InfluxURL, &config.ClickHouseURL} {
+		*u = redactURL(*u)
+	}
+	// ... a NATS URL may have a token, or a user and password
+	if u, err := parseNATSURL(config.NATSURL); err == nil && u.User != nil {
+		u.User = url.User(REDACTED)
+		config.NATSURL = u.String()
+	}
+
+	data, _ := json.MarshalIndent(config, "", "    ")
+	return string(data)
+}
+
+// redact returns REDACTED in place of a secret, if it's set.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return REDACTED
+}
+
+// redactURL returns raw_url with any password in it redacted.
+func redactURL(raw_url string) string {
+	u, err := url.Parse(raw_url)
+	if err != nil || u.User == nil {
+		return raw_url
+	}
+	if _, ok := u.User.Password(); !ok {
+		return raw_url
+	}
+	u.User = url.UserPassword(u.User.Username(), REDACTED)
+	return u.String()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"report_summary": true, "check_error_rate_critical": 0}`))
+	stats := NewStats(config)
+	stats.HotKeys.AddN("foo", 30)
+	stats.HotKeys.AddN("bar", 70)
+	stats.Summary.AddN("commands", 98)
+	stats.Errors.AddN("truncated", 2)
+
+	line, status := check(config, stats.Rotate())
+	expected := `MCSAUNA CRITICAL - hottest key "bar" has 70.0% of 100 hits, 2.00% of commands unparseable | ` +
+		"max_key_share=0.7;0.25;0.5;0;1 parse_error_rate=0.02;0.01;;0;1 hits=100c"
+	if status != CHECK_CRITICAL || line != expected {
+		t.Errorf("Expected %d %q, got %d %q\n", CHECK_CRITICAL, expected, status, line)
+	}
+
+	/* With no traffic, everything is OK */
+	line, status = check(config, stats.Rotate())
+	if status != CHECK_OK {
+		t.Errorf("Expected an OK status with no traffic, got %d %q\n", status, line)
+	}
+}
+
+func TestCheckStatus(t *testing.T) {
+	cases := []struct {
+		value    float64
+		expected int
+	}{
+		{0.1, CHECK_OK},
+		{0.25, CHECK_WARNING},
+		{0.6, CHECK_CRITICAL},
+	}
+	for _, c := range cases {
+		if status := checkStatus(c.value, 0.25, 0.5); status != c.expected {
+			t.Errorf("Expected status %d for %f, got %d\n", c.expected, c.value, status)
+		}
+	}
+	if status := checkStatus(0.9, 0.25, 0); status != CHECK_WARNING {
+		t.Errorf("Expected an unset critical threshold to be ignored, got %d\n", status)
+	}
+}
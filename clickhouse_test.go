@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClickHouseRows(t *testing.T) {
+	report := NewReport(time.Unix(1500000000, 0))
+	report.Count(NewMetricName("mcsauna.commands").Label("command", "get").Append("keys").
+		Label("key", "foo"), 3)
+	report.Gauge(NewMetricName("mcsauna.distribution.gini"), 0.25)
+
+	expected := `{"command":"get","host":"cache1","key":"foo","name":"mcsauna.commands.keys","time":1500000000,"value":3}` + "\n" +
+		`{"command":"","host":"cache1","key":"","name":"mcsauna.distribution.gini","time":1500000000,"value":0.25}` + "\n"
+	if rows := string(clickhouseRows(report, "cache1")); rows != expected {
+		t.Errorf("Expected %q, got %q\n", expected, rows)
+	}
+}
+
+func TestClickHouseSink(t *testing.T) {
+	up := true
+	queries := []string{}
+	inserted := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Query().Get("database") != "mcsauna" {
+			t.Errorf("Expected the database to be kept, got %q\n", r.URL.RawQuery)
+		}
+		query := r.URL.Query().Get("query")
+		queries = append(queries, strings.Fields(query)[0])
+		body, _ := ioutil.ReadAll(r.Body)
+		inserted += string(body)
+	}))
+	defer server.Close()
+
+	/* Reports are inserted two at a time, and kept while ClickHouse is
+	 * down */
+	sink := NewClickHouseSink(server.URL+"/?database=mcsauna", "metrics", "cache1", 2)
+	for hits := 1; hits <= 6; hits++ {
+		up = hits != 2
+		err := sink.Send(testReport(time.Unix(1500000000, 0), hits))
+		if hits == 2 && err == nil {
+			t.Errorf("Expected an error while ClickHouse is down\n")
+		} else if hits != 2 && err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expected := []string{"CREATE", "INSERT", "INSERT"}; strings.Join(queries, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected queries %v, got %v\n", expected, queries)
+	}
+	if n := strings.Count(inserted, "\n"); n != 5 {
+		t.Errorf("Expected 5 rows inserted before closing, got %d\n", n)
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(inserted, "\n"); n != 6 {
+		t.Errorf("Expected the last report inserted on closing, got %d rows\n", n)
+	}
+}
+
+func TestClickHouseConfig(t *testing.T) {
+	for _, table := range []string{"metrics", "mcsauna.metrics"} {
+		if _, err := NewConfig([]byte(`{"clickhouse_table": "` + table + `"}`)); err != nil {
+			t.Errorf("Unexpected error for table %s: %v\n", table, err)
+		}
+	}
+	for _, table := range []string{"", "metrics; DROP TABLE metrics", "a.b.c"} {
+		if _, err := NewConfig([]byte(`{"clickhouse_table": "` + table + `"}`)); err == nil {
+			t.Errorf("Expected error for table %q\n", table)
+		}
+	}
+	if _, err := NewConfig([]byte(`{"clickhouse_batch": 0}`)); err == nil {
+		t.Errorf("Expected error for empty batches\n")
+	}
+}
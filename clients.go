@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+)
+
+// Distinct clients remembered for each key.  Keys with more clients than
+// this are reported as having this many.
+const MAX_CLIENTS_PER_KEY = 1000
+
+// KeyClients keeps track of the distinct clients requesting each key, to
+// tell one client looping on a key apart from a whole fleet requesting it.
+type KeyClients struct {
+	Lock sync.Mutex
+
+	// Map of keys to sets of client addresses
+	clients map[string]map[string]bool
+}
+
+func NewKeyClients() *KeyClients {
+	k := &KeyClients{}
+	k.clients = make(map[string]map[string]bool)
+	return k
+}
+
+// Add records that client requested each of keys.
+func (k *KeyClients) Add(keys []string, client string) {
+	k.Lock.Lock()
+	defer k.Lock.Unlock()
+
+	for _, key := range keys {
+		clients, ok := k.clients[key]
+		if !ok {
+			clients = make(map[string]bool)
+			k.clients[key] = clients
+		}
+		if len(clients) < MAX_CLIENTS_PER_KEY {
+			clients[client] = true
+		}
+	}
+}
+
+// Count returns the number of distinct clients that requested key.
+func (k *KeyClients) Count(key string) int {
+	k.Lock.Lock()
+	defer k.Lock.Unlock()
+	return len(k.clients[key])
+}
+
+// Rotate clears the data on the existing KeyClients, returning a new
+// KeyClients containing the old data.
+func (k *KeyClients) Rotate() *KeyClients {
+	k.Lock.Lock()
+	defer k.Lock.Unlock()
+
+	// Clone existing
+	new_key_clients := NewKeyClients()
+	new_key_clients.clients = k.clients
+
+	// Clear existing values
+	k.clients = make(map[string]map[string]bool)
+	return new_key_clients
+}
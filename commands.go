@@ -0,0 +1,440 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Subcommand run when none is given, so flags alone still capture
+const DEFAULT_COMMAND = "capture"
+
+type Command struct {
+	Usage string
+	Help  string
+	Run   func(args []string) error
+}
+
+var COMMANDS map[string]Command
+
+func init() {
+	// ... set here, as the commands refer back to COMMANDS for their usage
+	COMMANDS = map[string]Command{
+		"capture":         {"[flags]", "capture traffic and report on the hottest keys", captureCommand},
+		"analyze":         {"[flags] FILE.pcap", "report on the traffic in a pcap file", analyzeCommand},
+		"check-config":    {"[flags]", "check the config, print it with defaults filled in, then exit", checkConfigCommand},
+		"interfaces":      {"", "list the interfaces that can be captured on", interfacesCommand},
+		"generate-config": {"[flags]", "print a commented config with every field, optionally suggested from traffic", generateConfigCommand},
+		"version":         {"", "print the version, commit and build date", versionCommand},
+	}
+}
+
+// parseSubcommand splits the command line into the subcommand and its
+// arguments, falling back on DEFAULT_COMMAND if the first argument isn't
+// one.  -version is taken as the version command.
+func parseSubcommand(args []string) (string, []string) {
+	if len(args) > 0 && (args[0] == "-version" || args[0] == "--version") {
+		return "version", args[1:]
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return DEFAULT_COMMAND, args
+	}
+	return args[0], args[1:]
+}
+
+// usage prints the subcommands available.
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, name := range []string{"capture", "analyze", "check-config", "interfaces", "generate-config", "version"} {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, COMMANDS[name].Help)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags a command takes.\n", os.Args[0])
+}
+
+// newFlagSet returns the flag set for the named subcommand, with its usage
+// set.
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s %s\n", os.Args[0], name, COMMANDS[name].Usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// configFlags defines the flags that override the config on flags: short
+// ones for the fields most often set, and one for every field, named after
+// it.  It returns the config file flag, and a function that applies the
+// rest to a config, and checks the result.
+func configFlags(flags *flag.FlagSet) (*string, func(config Config) (Config, error)) {
+	config_file := flags.String("c", "", "config file")
+	var interval Duration
+	flags.Var(&interval, "n", "reporting interval (seconds, or a duration, e.g. 500ms, default 5s)")
+	network_interface := flags.String("i", "", "capture interface (default any)")
+	port := flags.Int("p", 0, "capture port (default 11211)")
+	num_items_to_report := flags.Int("r", 0, "number of items to report (default 20)")
+	quiet := flags.Bool("q", false, "suppress stdout output (default false)")
+	output_file := flags.String("w", "", "file to write output to")
+	output_append := flags.Bool("a", false, "append to the output file rather than replace it")
+	show_errors := flags.Bool("e", true, "show errors in parsing as a metric")
+	debug_errors_file := flags.String("debug-errors-file", "", "file to dump unparseable payloads to")
+	parser_mode := flags.String("parser-mode", "", "strict or lenient protocol parsing (default strict)")
+	top := flags.Bool("top", false, "show the hottest keys interactively, like top")
+	errors_only := flags.Bool("errors-only", false, "only report errors in parsing, by client, rather than keys")
+	dry_run := flags.Bool("dry-run", false, "only report commands parsed and errors, rather than keys")
+	run_duration := flags.Int("d", 0, "seconds to run for, then exit with a last report (default forever)")
+	field_flags := configFieldFlags(flags)
+
+	return config_file, func(config Config) (Config, error) {
+		if interval != 0 {
+			config.Interval = interval
+		}
+		if *network_interface != "" {
+			config.Interface = *network_interface
+		}
+		if *port != 0 {
+			config.Port = *port
+		}
+		if *num_items_to_report != 0 {
+			config.NumItemsToReport = *num_items_to_report
+		}
+		if *quiet != false {
+			config.Quiet = *quiet
+		}
+		if *output_file != "" {
+			config.OutputFile = *output_file
+		}
+		if *output_append != false {
+			config.OutputAppend = *output_append
+		}
+		if *show_errors != true {
+			config.ShowErrors = *show_errors
+		}
+		if *debug_errors_file != "" {
+			config.DebugErrorsFile = *debug_errors_file
+		}
+		if *top != false {
+			config.Top = *top
+		}
+		if *errors_only != false {
+			config.ErrorsOnly = *errors_only
+		}
+		if *dry_run != false {
+			config.DryRun = *dry_run
+		}
+		if *run_duration != 0 {
+			config.RunDuration = *run_duration
+		}
+		if *parser_mode != "" {
+			config.ParserMode = *parser_mode
+		}
+		return applyFieldFlags(config, field_flags)
+	}
+}
+
+// loadConfig reads config_file, or starts from the defaults if there isn't
+// one, then applies the flags to it.
+func loadConfig(config_file string, apply_flags func(config Config) (Config, error)) (Config, error) {
+	var config Config
+	var err error
+	if config_file != "" {
+		config, err = ReadConfig(config_file)
+	} else {
+		config, err = NewConfig([]byte("{}"))
+	}
+	if err == nil {
+		config, err = apply_flags(config)
+	}
+	if err != nil {
+		return config, &ExitError{EXIT_CONFIG, err}
+	}
+	return config, nil
+}
+
+// startLogger sets up logging as config says.
+func startLogger(config Config) error {
+	var err error
+	if logger, err = NewLoggerFromConfig(config); err != nil {
+		return exitError(EXIT_FILE, "can't open the log file: %w", err)
+	}
+	return nil
+}
+
+func captureCommand(args []string) error {
+	flags := newFlagSet("capture")
+	config_file, apply_flags := configFlags(flags)
+	check := flags.Bool("check", false, "check one interval of traffic, then exit with a Nagios plugin status")
+	flags.Parse(args)
+
+	config, err := loadConfig(*config_file, apply_flags)
+	if err != nil {
+		return err
+	}
+	if *check {
+		// ... the error rate is out of all commands
+		config.ReportSummary = true
+	}
+	if config.Daemon && !daemon_child {
+		if *check {
+			return exitError(EXIT_USAGE, "-check can't be used with 'daemon'")
+		}
+		return startDaemon()
+	}
+	if err := startLogger(config); err != nil {
+		return err
+	}
+	if config.PidFile != "" {
+		if err := writePidFile(config.PidFile); err != nil {
+			return err
+		}
+		defer removePidFile(config.PidFile)
+	}
+
+	if err := checkCaptureCapabilities(); err != nil {
+		return err
+	}
+	handle, err := pcap.OpenLive(config.Interface, CAPTURE_SIZE, true, pcap.BlockForever)
+	if err != nil {
+		return captureError(config.Interface, err)
+	}
+	if err := handle.SetBPFFilter(captureFilter(config)); err != nil {
+		return exitError(EXIT_CAPTURE, "can't set the capture filter '%s': %w", captureFilter(config), err)
+	}
+	// Root's privileges are no longer needed once the handle is open
+	if err := dropPrivileges(config.User, config.Group, config.Chroot, config.PidFile); err != nil {
+		return err
+	}
+	logger.Info("Capturing", "interface", config.Interface, "filter", captureFilter(config),
+		"version", VERSION)
+
+	capture_counter := NewCaptureCounter(func() (int, int, error) {
+		capture_stats, err := handle.Stats()
+		if err != nil {
+			return 0, 0, err
+		}
+		return capture_stats.PacketsReceived,
+			capture_stats.PacketsDropped + capture_stats.PacketsIfDropped, nil
+	})
+	return capture(config, handle, capture_counter, *config_file, apply_flags, *check)
+}
+
+func analyzeCommand(args []string) error {
+	flags := newFlagSet("analyze")
+	config_file, apply_flags := configFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitError(EXIT_USAGE, "analyze takes one pcap file")
+	}
+
+	config, err := loadConfig(*config_file, apply_flags)
+	if err != nil {
+		return err
+	}
+	if err := startLogger(config); err != nil {
+		return err
+	}
+
+	handle, err := pcap.OpenOffline(flags.Arg(0))
+	if err != nil {
+		return exitError(EXIT_CAPTURE, "can't read pcap file %s: %w", flags.Arg(0), err)
+	}
+	if err := handle.SetBPFFilter(captureFilter(config)); err != nil {
+		return exitError(EXIT_CAPTURE, "can't set the capture filter '%s': %w", captureFilter(config), err)
+	}
+	logger.Info("Analyzing", "file", flags.Arg(0), "filter", captureFilter(config),
+		"version", VERSION)
+
+	// ... a file has no capture stats, and isn't reloaded
+	return capture(config, handle, nil, "", apply_flags, false)
+}
+
+func checkConfigCommand(args []string) error {
+	flags := newFlagSet("check-config")
+	config_file, apply_flags := configFlags(flags)
+	flags.Parse(args)
+
+	config, err := loadConfig(*config_file, apply_flags)
+	if err != nil {
+		return err
+	}
+	interfaces := []string{}
+	devices, err := pcap.FindAllDevs()
+	if err != nil {
+		return exitError(EXIT_CAPTURE, "can't list interfaces: %w", err)
+	}
+	for _, device := range devices {
+		interfaces = append(interfaces, device.Name)
+	}
+	problems := checkConfig(config, interfaces)
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	if len(problems) > 0 {
+		return exitError(EXIT_CONFIG, "found %d problems with the config", len(problems))
+	}
+	fmt.Println(formatConfig(config))
+	return nil
+}
+
+func interfacesCommand(args []string) error {
+	flags := newFlagSet("interfaces")
+	flags.Parse(args)
+
+	devices, err := pcap.FindAllDevs()
+	if err != nil {
+		return exitError(EXIT_CAPTURE, "can't list interfaces: %w", err)
+	}
+	for _, device := range devices {
+		addresses := []string{}
+		for _, address := range device.Addresses {
+			addresses = append(addresses, address.IP.String())
+		}
+		fmt.Printf("%s\t%s\t%s\n", device.Name, strings.Join(addresses, ","), device.Description)
+	}
+	return nil
+}
+
+func generateConfigCommand(args []string) error {
+	flags := newFlagSet("generate-config")
+	_, apply_flags := configFlags(flags)
+	sniff := flags.Int("sniff", 0, "seconds of traffic to sniff, to suggest the port and regexps from")
+	flags.Parse(args)
+
+	config, err := loadConfig("", apply_flags)
+	if err != nil {
+		return err
+	}
+	notes := []string{}
+	if *sniff > 0 {
+		fmt.Fprintf(os.Stderr, "Sniffing %d seconds of traffic on %s...\n", *sniff, config.Interface)
+		sniffer, err := sniffTraffic(config.Interface, time.Duration(*sniff)*time.Second)
+		if err != nil {
+			return err
+		}
+		if port, ok := sniffer.Port(); ok {
+			config.Port = port
+			config.Regexps = sniffer.Regexps(config.NumItemsToReport)
+			notes = append(notes, fmt.Sprintf(
+				"The port and regexps are suggested from %d commands seen in %d seconds on %s.",
+				sniffer.Commands(), *sniff, config.Interface))
+		} else {
+			notes = append(notes, fmt.Sprintf(
+				"No memcached commands were seen in %d seconds on %s to suggest from.",
+				*sniff, config.Interface))
+		}
+	}
+	fmt.Print(generateConfig(config, notes))
+	return nil
+}
+
+// capture counts the packets read from handle, reporting on them until
+// stopped, or the packets run out.  If config_file is set, the config is
+// reloaded from it on SIGHUP, and if check is, one interval is checked
+// before exiting.  An error is only returned if capture can't start.
+func capture(config Config, handle *pcap.Handle, capture_counter *CaptureCounter,
+	config_file string, apply_flags func(config Config) (Config, error), check bool) error {
+	// Build Regexps
+	regexp_keys, err := NewRegexpKeysFromConfig(config.Regexps)
+	if err != nil {
+		return exitError(EXIT_CONFIG, "Config error: invalid regexp: %w", err)
+	}
+
+	stats := NewStats(config)
+	if config.ProfileRegexps {
+		regexp_keys.Profile = stats.RegexpCost
+	}
+	if config.ReportRegexpMatches {
+		regexp_keys.Matches = stats.RegexpMatches
+	}
+	processor := NewProcessor(config, regexp_keys, stats)
+	if config.DebugErrorsFile != "" {
+		processor.error_dumper, err = NewErrorDumper(config.DebugErrorsFile,
+			config.DebugErrorsBytes, config.DebugErrorsPerSecond)
+		if err != nil {
+			return exitError(EXIT_FILE, "can't open the debug errors file: %w", err)
+		}
+	}
+	if len(config.Include) != 0 || len(config.Exclude) != 0 {
+		processor.key_filter, err = NewKeyFilter(config.Include, config.Exclude)
+		if err != nil {
+			return exitError(EXIT_CONFIG, "Config error: invalid 'include' or 'exclude' rule: %w", err)
+		}
+	}
+	if config.TLSKeyLogFile != "" {
+		processor.tls_key_log, err = NewTLSKeyLog(config.TLSKeyLogFile)
+		if err != nil {
+			return exitError(EXIT_FILE, "can't open the TLS key log: %w", err)
+		}
+	}
+	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+
+	if stats.Window != nil {
+		go startSlideLoop(
+			time.Duration(config.SlidingWindowGranularity)*time.Second, stats)
+	} else if stats.Decayed != nil {
+		go startSlideLoop(DECAY_TICK, stats)
+	}
+
+	// Grab a packet, switching to a new config between packets when the
+	// config is reloaded, and saying it's alive between them when asked
+	stop := make(chan string, 3)
+	reconfigure := make(chan Config)
+	alive := make(chan bool)
+	go func() {
+		packets := packetSource.Packets()
+		for {
+			select {
+			case packet, ok := <-packets:
+				if !ok {
+					stop <- "capture ended"
+					return
+				}
+				processor.ProcessPacket(packet)
+			case new_config := <-reconfigure:
+				if err := processor.Reconfigure(new_config); err != nil {
+					logger.Error("Error reconfiguring capture", "error", err)
+				}
+			case alive <- true:
+			}
+		}
+	}()
+
+	if check {
+		line, status := runCheck(config, stats)
+		fmt.Println(line)
+		// ... returned, rather than exiting here, so the pidfile is removed
+		return &ExitError{Status: status}
+	}
+
+	daemonStarted(nil)
+	if err := sdNotify("READY=1"); err != nil {
+		logger.Warn("Error notifying systemd", "error", err)
+	}
+
+	reload := make(chan Config)
+	if config_file != "" {
+		go startReloadLoop(config_file, apply_flags, config, regexp_keys,
+			handle.SetBPFFilter, reconfigure, reload)
+	}
+
+	// Stop, with a last report, on SIGINT or SIGTERM, or once the run is up
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		stop <- (<-signals).String()
+	}()
+	if config.RunDuration > 0 {
+		go func() {
+			time.Sleep(time.Duration(config.RunDuration) * time.Second)
+			stop <- "run duration reached"
+		}()
+	}
+	return startReportingLoop(config, regexp_keys, stats, capture_counter, stop, reload, alive)
+}
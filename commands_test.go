@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSubcommand(t *testing.T) {
+	tests := []struct {
+		args         []string
+		name         string
+		command_args []string
+	}{
+		{[]string{}, "capture", []string{}},
+		{[]string{"-c", "config.json"}, "capture", []string{"-c", "config.json"}},
+		{[]string{"capture", "-top"}, "capture", []string{"-top"}},
+		{[]string{"analyze", "-p", "11212", "dump.pcap"}, "analyze", []string{"-p", "11212", "dump.pcap"}},
+		{[]string{"interfaces"}, "interfaces", []string{}},
+		{[]string{"--version"}, "version", []string{}},
+	}
+	for _, test := range tests {
+		name, args := parseSubcommand(test.args)
+		if name != test.name || !reflect.DeepEqual(args, test.command_args) {
+			t.Errorf("Expected %s %v for %v, got %s %v\n",
+				test.name, test.command_args, test.args, name, args)
+		}
+	}
+}
+
+func TestConfigFlags(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	config_file, apply_flags := configFlags(flags)
+	if err := flags.Parse([]string{"-c", "config.json", "-p", "11212", "-e=false", "dump.pcap"}); err != nil {
+		t.Fatal(err)
+	}
+	if *config_file != "config.json" {
+		t.Errorf("Expected config file config.json, got %s\n", *config_file)
+	}
+	if flags.Arg(0) != "dump.pcap" {
+		t.Errorf("Expected argument dump.pcap, got %s\n", flags.Arg(0))
+	}
+
+	/* Only the flags given override the config */
+	config, _ := NewConfig([]byte(`{"port": 11211, "interval": 10}`))
+	config, err := apply_flags(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Port != 11212 || config.Interval != Duration(10*time.Second) || config.ShowErrors {
+		t.Errorf("Expected port 11212, interval 10 and no errors, got %d, %d and %v\n",
+			config.Port, config.Interval, config.ShowErrors)
+	}
+
+	/* Without a config file, the flags apply over the defaults */
+	config, err = loadConfig("", apply_flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Port != 11212 || config.Prefix != "mcsauna" {
+		t.Errorf("Expected port 11212 and the default prefix, got %d and %s\n",
+			config.Port, config.Prefix)
+	}
+}
+
+func TestConfigFieldFlags(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	_, apply_flags := configFlags(flags)
+	err := flags.Parse([]string{
+		"-show-unmatched",
+		"-regexp", "user=^user:[0-9]+$", "-regexp", "cart=^cart:",
+		"-ports", "11212,11213", "-ports", "11214",
+		"-proxies", "10.0.0.1:22121=twem1",
+		"-exclude", "prefix:session:",
+		"-graphite-address", "graphite:2003",
+		"-interval", "500ms",
+		"-check-share-warning", "0.5",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, _ := NewConfig([]byte(`{"regexps": [{"name": "other", "re": "^other"}]}`))
+	config, err = apply_flags(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	/* Lists given as flags replace those in the config */
+	if len(config.Regexps) != 2 || config.Regexps[0].Name != "user" || config.Regexps[1].Re != "^cart:" {
+		t.Errorf("Expected regexps user and cart, got %v\n", config.Regexps)
+	}
+	if !reflect.DeepEqual(config.Ports, []int{11212, 11213, 11214}) {
+		t.Errorf("Expected ports 11212 to 11214, got %v\n", config.Ports)
+	}
+	if config.Proxies["10.0.0.1:22121"] != "twem1" || len(config.Exclude) != 1 ||
+		config.Exclude[0].Prefix != "session:" {
+		t.Errorf("Expected a proxy and an exclude rule, got %v and %v\n", config.Proxies, config.Exclude)
+	}
+	if !config.ShowUnmatched || config.GraphiteAddress != "graphite:2003" ||
+		config.Interval != Duration(500*time.Millisecond) || config.CheckShareWarning != 0.5 {
+		t.Errorf("Expected fields set by flags, got %+v\n", config)
+	}
+
+	/* Values are checked when given, and the config once applied */
+	for _, args := range [][]string{
+		{"-port", "memcached"},
+		{"-regexp", "^user"},
+		{"-include", "glob:user*"},
+	} {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		flags.SetOutput(ioutil.Discard)
+		configFlags(flags)
+		if err := flags.Parse(args); err == nil {
+			t.Errorf("Expected an error parsing %v\n", args)
+		}
+	}
+	flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	_, apply_flags = configFlags(flags)
+	flags.Parse([]string{"-format", "xml"})
+	if _, err := apply_flags(config); err == nil {
+		t.Errorf("Expected an error applying an unknown format\n")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		`5`:       5 * time.Second,
+		`0.5`:     500 * time.Millisecond,
+		`"500ms"`: 500 * time.Millisecond,
+		`"2m"`:    2 * time.Minute,
+		`"10"`:    10 * time.Second,
+	}
+	for data, expected := range tests {
+		var d Duration
+		if err := json.Unmarshal([]byte(data), &d); err != nil {
+			t.Errorf("Unexpected error for %s: %v\n", data, err)
+		} else if time.Duration(d) != expected {
+			t.Errorf("Expected %v for %s, got %v\n", expected, data, time.Duration(d))
+		}
+	}
+	for _, data := range []string{`"soon"`, `true`} {
+		var d Duration
+		if err := json.Unmarshal([]byte(data), &d); err == nil {
+			t.Errorf("Expected an error for %s\n", data)
+		}
+	}
+
+	/* Written out as a duration string */
+	if data, _ := json.Marshal(Duration(1500 * time.Millisecond)); string(data) != `"1.5s"` {
+		t.Errorf("Expected \"1.5s\", got %s\n", data)
+	}
+	if _, err := NewConfig([]byte(`{"interval": "0s"}`)); err == nil {
+		t.Errorf("Expected an error for a zero interval\n")
+	}
+}
+
+func TestReadConfigIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcsauna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "regexps.d"), 0777)
+	files := map[string]string{
+		"mcsauna.json": `{
+			"includes": ["base.toml", "regexps.d/*.yaml", "extra.d/*.yaml"],
+			"port": 11212,
+			"regexps": [{"name": "other", "re": "^other"}]
+		}`,
+		"base.toml":            "interval = 10\nport = 11211\nproxies = {\"10.0.0.1:22121\" = \"twem1\"}\n",
+		"regexps.d/users.yaml": "regexps:\n  - {name: user, re: \"^user:\"}\n",
+		"regexps.d/carts.yaml": "regexps:\n  - {name: cart, re: \"^cart:\"}\n" +
+			"proxies:\n  10.0.0.2:22121: twem2\n",
+		"loop.json":    `{"includes": ["loop2.json"]}`,
+		"loop2.json":   `{"includes": ["loop.json"]}`,
+		"missing.json": `{"includes": ["nonexistent.json"]}`,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	/* Lists are appended to, in order, mappings merged, and anything else
+	 * replaced by the including file */
+	config, err := ReadConfig(filepath.Join(dir, "mcsauna.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, re := range config.Regexps {
+		names = append(names, re.Name)
+	}
+	if len(names) != 3 || names[0] != "cart" || names[1] != "user" || names[2] != "other" {
+		t.Errorf("Expected regexps cart, user and other, got %v\n", names)
+	}
+	if config.Interval != Duration(10*time.Second) || config.Port != 11212 {
+		t.Errorf("Expected interval 10s and port 11212, got %v and %d\n", config.Interval, config.Port)
+	}
+	if len(config.Proxies) != 2 {
+		t.Errorf("Expected 2 proxies, got %v\n", config.Proxies)
+	}
+
+	for _, name := range []string{"loop.json", "missing.json"} {
+		if _, err := ReadConfig(filepath.Join(dir, name)); err == nil {
+			t.Errorf("Expected an error reading %s\n", name)
+		}
+	}
+}
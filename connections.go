@@ -0,0 +1,298 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// MAX_PENDING_BYTES caps how much of a partial command we are willing to hold
+// on to for a single connection.  The largest legal memcached item is 1MB, so
+// anything beyond that plus some room for the command line is not a command
+// we will ever be able to complete.
+const MAX_PENDING_BYTES = 1024*1024 + 1024
+
+// MAX_PENDING_REQUESTS caps how many unanswered requests we keep track of
+// for a single connection, so that requests whose responses we never see
+// can't grow without bound.
+const MAX_PENDING_REQUESTS = 1024
+
+// ConnKey identifies one direction of a TCP connection.
+type ConnKey struct {
+	Net       gopacket.Flow
+	Transport gopacket.Flow
+}
+
+// Reverse returns the key for the other direction of the connection.
+func (k ConnKey) Reverse() ConnKey {
+	return ConnKey{k.Net.Reverse(), k.Transport.Reverse()}
+}
+
+// pendingRequest is a request that is waiting for a response from the
+// server.
+type pendingRequest struct {
+	cmd    string
+	names  []string
+	opaque uint32
+	sent   time.Time
+}
+
+// connState holds what we know about a single connection between packets.
+type connState struct {
+	// Bytes at the tail of the previous segment that did not form a complete
+	// command.
+	pending []byte
+
+	// The sequence number we expect the next segment to start at.  If the
+	// next segment doesn't line up, pending can't be safely glued to it.
+	next_seq uint32
+
+	// Which protocol the connection is speaking, detected from the first
+	// data we see on it.
+	protocol int
+
+	// Whether we've seen the start of the connection's data yet, and if it
+	// began with a PROXY protocol header, the real client it carried.
+	started   bool
+	client_ip net.IP
+
+	// Decryption state, for TLS connections
+	tls *tlsStream
+
+	// Requests waiting for a response, oldest first.  Only tracked when
+	// pairing requests with responses, until unpaired is set, once the
+	// server has been sent a request it answers that wasn't recorded.
+	requests []pendingRequest
+	unpaired bool
+
+	last_seen time.Time
+}
+
+// ConnTable keeps per-connection parse state so that commands split across
+// TCP segments can be stitched back together.  It is not safe for concurrent
+// use; it is only meant to be driven from the capture loop.
+type ConnTable struct {
+	conns map[ConnKey]*connState
+
+	// When set, ClientIP reports the client address carried in a PROXY
+	// protocol header rather than the address the packets came from.
+	UseProxyClientIP bool
+
+	// Connections that haven't been seen for this long are forgotten.
+	expiry     time.Duration
+	last_sweep time.Time
+}
+
+func NewConnTable(expiry time.Duration) *ConnTable {
+	return &ConnTable{
+		conns:  make(map[ConnKey]*connState),
+		expiry: expiry,
+	}
+}
+
+// Reassemble returns the payload to parse for a segment on the given
+// connection, prepending any partial command held over from the previous
+// segment.  The second return value is the number of held-over partial
+// commands that had to be thrown away because the segment did not follow on
+// from them.
+func (c *ConnTable) Reassemble(key ConnKey, seq uint32, payload []byte, now time.Time) ([]byte, int) {
+	state, ok := c.conns[key]
+	if !ok {
+		state = &connState{}
+		c.conns[key] = state
+	}
+	state.last_seen = now
+
+	if len(state.pending) == 0 {
+		return payload, 0
+	}
+	pending := state.pending
+	state.pending = nil
+
+	// ... a gap, a retransmission, or reordering; either way the held bytes
+	// ... can't be trusted to join up with this segment.
+	if seq != state.next_seq {
+		return payload, 1
+	}
+
+	joined := make([]byte, 0, len(pending)+len(payload))
+	joined = append(joined, pending...)
+	joined = append(joined, payload...)
+	return joined, 0
+}
+
+// Protocol returns the protocol spoken on a connection.  If it isn't known
+// yet, it is detected from payload, which should be the first data seen on
+// the connection, and remembered for subsequent segments.
+func (c *ConnTable) Protocol(key ConnKey, payload []byte) int {
+	state, ok := c.conns[key]
+	if !ok {
+		return detectProtocol(payload)
+	}
+	if state.protocol == PROTOCOL_UNKNOWN {
+		state.protocol = detectProtocol(payload)
+	}
+	return state.protocol
+}
+
+// StripProxyHeader removes a PROXY protocol header from the start of a
+// connection's data, remembering the client address it carries.  Only the
+// first data seen on a connection is checked.  If the header is incomplete,
+// ERR_TRUNCATED is returned and the caller should hold the payload until
+// the rest of it arrives.
+func (c *ConnTable) StripProxyHeader(key ConnKey, payload []byte) ([]byte, int) {
+	state, ok := c.conns[key]
+	if !ok || state.started {
+		return payload, ERR_NONE
+	}
+
+	client_ip, header_len, cmd_err := parseProxyHeader(payload)
+	if cmd_err == ERR_TRUNCATED {
+		return payload, cmd_err
+	}
+	state.started = true
+	state.client_ip = client_ip
+	return payload[header_len:], cmd_err
+}
+
+// ClientIP returns the address of the client on the other end of a
+// connection.
+func (c *ConnTable) ClientIP(key ConnKey) string {
+	if state, ok := c.conns[key]; ok && c.UseProxyClientIP && state.client_ip != nil {
+		return state.client_ip.String()
+	}
+	return key.Net.Src().String()
+}
+
+// TLSStream returns the decryption state for a TLS connection.
+func (c *ConnTable) TLSStream(key ConnKey) *tlsStream {
+	state, ok := c.conns[key]
+	if !ok {
+		state = &connState{}
+		c.conns[key] = state
+	}
+	if state.tls == nil {
+		state.tls = &tlsStream{}
+	}
+	return state.tls
+}
+
+// PushRequest records a request that is waiting for a response.  Responses
+// on TLS connections can't be decrypted, so their requests aren't recorded.
+func (c *ConnTable) PushRequest(key ConnKey, req pendingRequest) {
+	state, ok := c.conns[key]
+	if !ok {
+		state = &connState{}
+		c.conns[key] = state
+	}
+	if state.tls != nil || state.unpaired {
+		return
+	}
+	if len(state.requests) >= MAX_PENDING_REQUESTS {
+		state.requests = state.requests[1:]
+	}
+	state.requests = append(state.requests, req)
+}
+
+// StopPairing stops recording requests on an ASCII connection, once the
+// server has been sent one that wasn't recorded, e.g. one that couldn't be
+// parsed, which it still answers.  Responses are paired with requests in
+// order, so every later response would otherwise be paired with the wrong
+// request.
+func (c *ConnTable) StopPairing(key ConnKey) {
+	state, ok := c.conns[key]
+	if !ok {
+		state = &connState{}
+		c.conns[key] = state
+	}
+	state.unpaired = true
+	state.requests = nil
+}
+
+// PopRequest returns the oldest request waiting for a response on the
+// connection.  The server answers requests in order, so this is the request
+// that the next response is for.
+func (c *ConnTable) PopRequest(key ConnKey) (pendingRequest, bool) {
+	state, ok := c.conns[key]
+	if !ok || len(state.requests) == 0 {
+		return pendingRequest{}, false
+	}
+	req := state.requests[0]
+	state.requests = state.requests[1:]
+	return req, true
+}
+
+// PopRequestByOpaque returns the request with the given opaque value, for
+// binary connections.  Quiet requests may not get a response at all, so any
+// requests older than the one found are discarded.
+func (c *ConnTable) PopRequestByOpaque(key ConnKey, opaque uint32) (pendingRequest, bool) {
+	state, ok := c.conns[key]
+	if !ok {
+		return pendingRequest{}, false
+	}
+	for i, req := range state.requests {
+		if req.opaque == opaque {
+			state.requests = state.requests[i+1:]
+			return req, true
+		}
+	}
+	return pendingRequest{}, false
+}
+
+// Hold remembers a truncated command at the end of a segment so it can be
+// completed by the next one.  next_seq is the sequence number that segment
+// is expected to start at.  Hold returns false if the data is too large to
+// be held, in which case the caller should treat it as truncated.
+func (c *ConnTable) Hold(key ConnKey, next_seq uint32, partial []byte, now time.Time) bool {
+	if len(partial) > MAX_PENDING_BYTES {
+		return false
+	}
+	state, ok := c.conns[key]
+	if !ok {
+		state = &connState{}
+		c.conns[key] = state
+	}
+
+	// ... copy, since partial may point into a packet buffer we don't own
+	state.pending = append([]byte{}, partial...)
+	state.next_seq = next_seq
+	state.last_seen = now
+	return true
+}
+
+// Close forgets a connection, returning the number of partial commands that
+// were still being held for it.
+func (c *ConnTable) Close(key ConnKey) int {
+	state, ok := c.conns[key]
+	if !ok {
+		return 0
+	}
+	delete(c.conns, key)
+	if len(state.pending) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Expire forgets connections that have been idle for longer than the table's
+// expiry, returning the number of partial commands that were discarded.  To
+// keep the capture loop cheap, the table is swept at most once a second.
+func (c *ConnTable) Expire(now time.Time) int {
+	if now.Sub(c.last_sweep) < time.Second {
+		return 0
+	}
+	c.last_sweep = now
+
+	dropped := 0
+	for key, state := range c.conns {
+		if now.Sub(state.last_seen) > c.expiry {
+			dropped += c.Close(key)
+		}
+	}
+	return dropped
+}
+
+func (c *ConnTable) Len() int {
+	return len(c.conns)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func testConnKey(src_port byte) ConnKey {
+	return ConnKey{
+		gopacket.NewFlow(layers.EndpointIPv4, []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}),
+		gopacket.NewFlow(layers.EndpointTCPPort, []byte{0, src_port}, []byte{0x2b, 0xcb}),
+	}
+}
+
+func TestConnTableReassemble(t *testing.T) {
+	conns := NewConnTable(30 * time.Second)
+	key := testConnKey(1)
+	now := time.Now()
+
+	// Nothing held, payload passes straight through
+	payload, dropped := conns.Reassemble(key, 100, []byte("get fo"), now)
+	if !bytes.Equal(payload, []byte("get fo")) || dropped != 0 {
+		t.Errorf("Expected untouched payload, got %q (%d dropped)\n", payload, dropped)
+	}
+
+	// Held data is prepended to the next segment
+	if !conns.Hold(key, 106, payload, now) {
+		t.Fatalf("Expected partial command to be held\n")
+	}
+	payload, dropped = conns.Reassemble(key, 106, []byte("o\r\n"), now)
+	if !bytes.Equal(payload, []byte("get foo\r\n")) || dropped != 0 {
+		t.Errorf("Expected reassembled payload, got %q (%d dropped)\n", payload, dropped)
+	}
+
+	// ... but only once
+	payload, _ = conns.Reassemble(key, 109, []byte("get bar\r\n"), now)
+	if !bytes.Equal(payload, []byte("get bar\r\n")) {
+		t.Errorf("Expected held data to be consumed, got %q\n", payload)
+	}
+
+	// Connections are tracked independently
+	conns.Hold(key, 200, []byte("get a"), now)
+	payload, _ = conns.Reassemble(testConnKey(2), 200, []byte("get b\r\n"), now)
+	if !bytes.Equal(payload, []byte("get b\r\n")) {
+		t.Errorf("Expected data from another connection to be ignored, got %q\n", payload)
+	}
+}
+
+func TestConnTableSequenceGap(t *testing.T) {
+	conns := NewConnTable(30 * time.Second)
+	key := testConnKey(1)
+	now := time.Now()
+
+	conns.Hold(key, 106, []byte("get fo"), now)
+	payload, dropped := conns.Reassemble(key, 500, []byte("get bar\r\n"), now)
+	if !bytes.Equal(payload, []byte("get bar\r\n")) {
+		t.Errorf("Expected held data to be discarded on gap, got %q\n", payload)
+	}
+	if dropped != 1 {
+		t.Errorf("Expected 1 dropped partial command, got %d\n", dropped)
+	}
+}
+
+func TestConnTableExpire(t *testing.T) {
+	conns := NewConnTable(30 * time.Second)
+	now := time.Now()
+
+	conns.Hold(testConnKey(1), 106, []byte("get fo"), now)
+	conns.Hold(testConnKey(2), 106, []byte("get ba"), now.Add(20*time.Second))
+
+	if dropped := conns.Expire(now.Add(40 * time.Second)); dropped != 1 {
+		t.Errorf("Expected 1 expired partial command, got %d\n", dropped)
+	}
+	if conns.Len() != 1 {
+		t.Errorf("Expected 1 connection left, got %d\n", conns.Len())
+	}
+
+	if conns.Hold(testConnKey(3), 0, make([]byte, MAX_PENDING_BYTES+1), now) {
+		t.Errorf("Expected oversized partial command to be refused\n")
+	}
+
+	for _, config := range []string{`{"conn_expiry": 0}`, `{"conn_expiry": -30}`} {
+		if _, err := NewConfig([]byte(config)); err == nil {
+			t.Errorf("Expected an error for %s\n", config)
+		}
+	}
+}
+
+func TestConnTableProtocol(t *testing.T) {
+	conns := NewConnTable(30 * time.Second)
+	now := time.Now()
+
+	binary_key, ascii_key := testConnKey(1), testConnKey(2)
+	conns.Reassemble(binary_key, 0, []byte{BINARY_REQUEST_MAGIC}, now)
+	conns.Reassemble(ascii_key, 0, []byte("get foo\r\n"), now)
+
+	if p := conns.Protocol(binary_key, []byte{BINARY_REQUEST_MAGIC}); p != PROTOCOL_BINARY {
+		t.Errorf("Expected binary protocol, got %d\n", p)
+	}
+	if p := conns.Protocol(ascii_key, []byte("get foo\r\n")); p != PROTOCOL_ASCII {
+		t.Errorf("Expected ascii protocol, got %d\n", p)
+	}
+
+	// The protocol sticks, even if a later segment looks like the other one
+	if p := conns.Protocol(binary_key, []byte("get foo\r\n")); p != PROTOCOL_BINARY {
+		t.Errorf("Expected binary protocol to be remembered, got %d\n", p)
+	}
+}
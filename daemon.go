@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Set in the environment of an mcsauna started in the background by daemon
+const DAEMON_ENV = "MCSAUNA_DAEMON"
+
+// The pipe a daemon tells the mcsauna that started it whether capture
+// started on: its first extra file.  It writes DAEMON_READY if it did, or
+// the error stopping it.
+const (
+	DAEMON_READY_FD = 3
+	DAEMON_READY    = "ready"
+)
+
+// daemon_child is set in an mcsauna started in the background, and
+// daemon_ready is the pipe back to the mcsauna that started it, until
+// daemonStarted is called.
+var (
+	daemon_child bool
+	daemon_ready *os.File
+)
+
+func init() {
+	if os.Getenv(DAEMON_ENV) == "" {
+		return
+	}
+	daemon_child = true
+	daemon_ready = os.NewFile(DAEMON_READY_FD, "daemon-ready")
+	// ... not passed on to anything it runs
+	syscall.CloseOnExec(DAEMON_READY_FD)
+	os.Unsetenv(DAEMON_ENV)
+}
+
+// startDaemon starts mcsauna again, with the same arguments, in the
+// background, returning once capture has started there, or with the error
+// that stopped it and the status it exited with.  Go can't fork, so rather
+// than forking twice, it runs in a new session, with no terminal, and
+// stdin, stdout and stderr on /dev/null.
+func startDaemon() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return exitError(EXIT_ERROR, "can't find mcsauna to start in the background: %w", err)
+	}
+	null, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return exitError(EXIT_FILE, "can't open %s: %w", os.DevNull, err)
+	}
+	defer null.Close()
+	ready_r, ready_w, err := os.Pipe()
+	if err != nil {
+		return exitError(EXIT_ERROR, "can't start in the background: %w", err)
+	}
+	defer ready_r.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), DAEMON_ENV+"=1")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = null, null, null
+	cmd.ExtraFiles = []*os.File{ready_w}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	ready_w.Close()
+	if err != nil {
+		return exitError(EXIT_ERROR, "can't start in the background: %w", err)
+	}
+
+	message, _ := ioutil.ReadAll(ready_r)
+	if strings.TrimSpace(string(message)) == DAEMON_READY {
+		return nil
+	}
+	// ... it stopped, and its status says why
+	status := EXIT_ERROR
+	var exit_err *exec.ExitError
+	if err := cmd.Wait(); errors.As(err, &exit_err) && exit_err.ExitCode() > 0 {
+		status = exit_err.ExitCode()
+	}
+	if len(message) == 0 {
+		message = []byte("stopped before capture started")
+	}
+	return &ExitError{status, errors.New(strings.TrimSpace(string(message)))}
+}
+
+// daemonStarted tells the mcsauna that started this one in the background,
+// if it was, that capture has started, or with err, why it couldn't.
+func daemonStarted(err error) {
+	if daemon_ready == nil {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(daemon_ready, err)
+	} else {
+		fmt.Fprintln(daemon_ready, DAEMON_READY)
+	}
+	daemon_ready.Close()
+	daemon_ready = nil
+}
+
+// writePidFile writes mcsauna's pid to path, unless it holds the pid of
+// another that's still running.  A pidfile whose process is gone is stale,
+// and replaced.
+func writePidFile(path string) error {
+	// ... written in full to a file of its own, then linked into place,
+	// ... so that the pidfile is created with the pid in it, or not at all
+	temp_path := fmt.Sprintf("%s.%d", path, os.Getpid())
+	err := ioutil.WriteFile(temp_path, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+	if err != nil {
+		return exitError(EXIT_FILE, "can't write pidfile: %w", err)
+	}
+	defer os.Remove(temp_path)
+
+	for {
+		err := os.Link(temp_path, path)
+		if err == nil {
+			return nil
+		} else if !os.IsExist(err) {
+			return exitError(EXIT_FILE, "can't write pidfile: %w", err)
+		}
+		pid, err := readPidFile(path)
+		if err == nil && pidRunning(pid) {
+			return exitError(EXIT_ERROR, "already running, as pid %d in %s", pid, path)
+		}
+		logger.Warn("Replacing stale pidfile", "path", path, "pid", pid)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return exitError(EXIT_FILE, "can't remove stale pidfile: %w", err)
+		}
+	}
+}
+
+// removePidFile removes the pidfile at path, if it still holds mcsauna's
+// pid.
+func removePidFile(path string) {
+	if pid, err := readPidFile(path); err == nil && pid == os.Getpid() {
+		os.Remove(path)
+	}
+}
+
+// readPidFile returns the pid in the pidfile at path.
+func readPidFile(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// pidRunning returns whether a process other than mcsauna has pid.
+func pidRunning(pid int) bool {
+	if pid <= 0 || pid == os.Getpid() {
+		return false
+	}
+	// ... signal 0 isn't sent, only checked for, and a process of another
+	// ... user's can't be signalled, but is still running
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
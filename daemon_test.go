@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcsauna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mcsauna.pid")
+
+	if err := writePidFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if pid, err := readPidFile(path); err != nil || pid != os.Getpid() {
+		t.Errorf("Expected pid %d in the pidfile, got %d (%v)\n", os.Getpid(), pid, err)
+	}
+
+	/* Another mcsauna, still running, keeps its pidfile */
+	ioutil.WriteFile(path, []byte(fmt.Sprintf("%d\n", os.Getppid())), 0644)
+	if err := writePidFile(path); err == nil {
+		t.Errorf("Expected an error replacing a running process's pidfile\n")
+	}
+	removePidFile(path)
+	if pid, _ := readPidFile(path); pid != os.Getppid() {
+		t.Errorf("Expected another process's pidfile to be kept, got pid %d\n", pid)
+	}
+
+	/* ...but one whose process is gone is replaced */
+	ioutil.WriteFile(path, []byte("99999999\n"), 0644)
+	if err := writePidFile(path); err != nil {
+		t.Errorf("Expected a stale pidfile to be replaced, got %v\n", err)
+	}
+	removePidFile(path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the pidfile to be removed, got %v\n", err)
+	}
+}
+
+func TestDaemonConfig(t *testing.T) {
+	if _, err := NewConfig([]byte(`{"daemon": true}`)); err == nil {
+		t.Errorf("Expected an error for a daemon logging to stderr\n")
+	}
+	_, err := NewConfig([]byte(`{"daemon": true, "log_output": "syslog", "pidfile": "/run/mcsauna.pid"}`))
+	if err != nil {
+		t.Errorf("Expected a daemon logging to syslog to be valid, got %v\n", err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrorDumper writes hex dumps of payloads that failed to parse, so gaps in
+// protocol support can be diagnosed without running tcpdump alongside.
+// Dumps are rate-limited so a stream of garbage can't fill the disk.
+type ErrorDumper struct {
+	out io.Writer
+
+	// Only the first max_bytes of each payload are dumped.
+	max_bytes int
+
+	// At most per_second dumps are written in any one second.
+	per_second   int
+	window_start time.Time
+	window_count int
+
+	// Number of dumps skipped due to rate limiting since the last one that
+	// was written.
+	suppressed int
+}
+
+// NewErrorDumper opens (or creates) the file at path for appending dumps.
+func NewErrorDumper(path string, max_bytes int, per_second int) (*ErrorDumper, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return newErrorDumper(f, max_bytes, per_second), nil
+}
+
+func newErrorDumper(out io.Writer, max_bytes int, per_second int) *ErrorDumper {
+	return &ErrorDumper{
+		out:        out,
+		max_bytes:  max_bytes,
+		per_second: per_second,
+	}
+}
+
+// Dump writes a header line describing the failure followed by a hex + ascii
+// dump of the start of the payload, unless the rate limit has been reached.
+func (d *ErrorDumper) Dump(now time.Time, cmd_err int, conn ConnKey, payload []byte) error {
+	if now.Sub(d.window_start) >= time.Second {
+		d.window_start = now
+		d.window_count = 0
+	}
+	if d.window_count >= d.per_second {
+		d.suppressed += 1
+		return nil
+	}
+	d.window_count += 1
+
+	header := fmt.Sprintf("%s %s %s:%s -> %s:%s (%d bytes",
+		now.Format(time.RFC3339Nano), ERR_TO_STAT[cmd_err],
+		conn.Net.Src(), conn.Transport.Src(), conn.Net.Dst(), conn.Transport.Dst(),
+		len(payload))
+	if d.suppressed > 0 {
+		header += fmt.Sprintf(", %d dumps suppressed", d.suppressed)
+		d.suppressed = 0
+	}
+	header += ")\n"
+
+	if len(payload) > d.max_bytes {
+		payload = payload[:d.max_bytes]
+	}
+	_, err := io.WriteString(d.out, header+hex.Dump(payload)+"\n")
+	return err
+}
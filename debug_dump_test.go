@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorDumper(t *testing.T) {
+	out := &bytes.Buffer{}
+	dumper := newErrorDumper(out, 4, 2)
+	now := time.Now()
+
+	dumper.Dump(now, ERR_INVALID_CMD, testConnKey(1), []byte("foo bar\r\n"))
+	if !strings.Contains(out.String(), "invalid_cmd") {
+		t.Errorf("Expected dump to name the error, got %q\n", out.String())
+	}
+	if !strings.Contains(out.String(), "|foo |") {
+		t.Errorf("Expected dump to be limited to 4 bytes, got %q\n", out.String())
+	}
+
+	// Rate limiting
+	dumper.Dump(now, ERR_INVALID_CMD, testConnKey(1), []byte("a"))
+	dumper.Dump(now, ERR_INVALID_CMD, testConnKey(1), []byte("b"))
+	dumper.Dump(now, ERR_INVALID_CMD, testConnKey(1), []byte("c"))
+	if strings.Contains(out.String(), "|b|") {
+		t.Errorf("Expected dumps beyond the rate limit to be suppressed\n")
+	}
+	dumper.Dump(now.Add(time.Second), ERR_INVALID_CMD, testConnKey(1), []byte("d"))
+	if !strings.Contains(out.String(), "2 dumps suppressed") {
+		t.Errorf("Expected suppressed dumps to be reported, got %q\n", out.String())
+	}
+}
+
+func TestErrorDumperConfig(t *testing.T) {
+	/* Limits that would dump nothing, or panic, are refused */
+	for _, config := range []string{
+		`{"debug_errors_file": "/tmp/errors", "debug_errors_bytes": -1}`,
+		`{"debug_errors_file": "/tmp/errors", "debug_errors_bytes": 0}`,
+		`{"debug_errors_file": "/tmp/errors", "debug_errors_per_second": 0}`,
+	} {
+		if _, err := NewConfig([]byte(config)); err == nil {
+			t.Errorf("Expected an error for %s\n", config)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Scores below this are forgotten, so that keys that have cooled off don't
+// take up memory forever.
+const DECAY_MIN_SCORE = 0.5
+
+// How often hits are folded into decayed scores
+const DECAY_TICK = time.Second
+
+// DecayedScores scores keys by how hot they are right now.  Each hit adds
+// weight to its key that halves every half life, so a key's score is the sum
+// of its hits, each weighted by 0.5^(age / half life).
+//
+// Rather than timestamping every hit, hits are counted in a HotKeyPool and
+// folded into the scores every tick, so ages are rounded to the tick.
+type DecayedScores struct {
+	Lock sync.Mutex
+
+	// Factor every score is multiplied by each tick
+	decay float64
+
+	// Map of keys to scores
+	scores map[string]float64
+}
+
+func NewDecayedScores(half_life time.Duration, tick time.Duration) *DecayedScores {
+	d := &DecayedScores{}
+	d.decay = math.Pow(0.5, tick.Seconds()/half_life.Seconds())
+	d.scores = make(map[string]float64)
+	return d
+}
+
+// Fold decays every score by a tick, then adds the hits counted during it.
+func (d *DecayedScores) Fold(pool *HotKeyPool) {
+	d.Lock.Lock()
+	defer d.Lock.Unlock()
+
+	for key, score := range d.scores {
+		score *= d.decay
+		if score < DECAY_MIN_SCORE {
+			delete(d.scores, key)
+		} else {
+			d.scores[key] = score
+		}
+	}
+	for _, key := range *pool.GetTopKeys() {
+		d.scores[key.Name] += float64(key.Hits)
+	}
+}
+
+// Snapshot returns a new pool holding each key's current score, rounded to
+// the nearest hit.
+func (d *DecayedScores) Snapshot() *HotKeyPool {
+	d.Lock.Lock()
+	defer d.Lock.Unlock()
+
+	snapshot := NewHotKeyPool()
+	for key, score := range d.scores {
+		snapshot.AddN(key, int(score+0.5))
+	}
+	return snapshot
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecayedScores(t *testing.T) {
+	d := NewDecayedScores(2*time.Second, time.Second)
+
+	pool := NewHotKeyPool()
+	pool.AddN("foo", 100)
+	pool.AddN("bar", 1)
+	d.Fold(pool)
+
+	// ... two ticks is one half life
+	d.Fold(NewHotKeyPool())
+	d.Fold(NewHotKeyPool())
+	snapshot := d.Snapshot()
+	if hits := snapshot.GetHits("foo"); hits != 50 {
+		t.Errorf("Expected foo to decay to 50, got %d\n", hits)
+	}
+
+	// ... once it has decayed below DECAY_MIN_SCORE
+	d.Fold(NewHotKeyPool())
+	snapshot = d.Snapshot()
+	if hits := snapshot.GetHits("bar"); hits != 0 {
+		t.Errorf("Expected bar to be forgotten, got %d\n", hits)
+	}
+	if snapshot.GetTopKeys().Len() != 1 {
+		t.Errorf("Expected only foo to be scored\n")
+	}
+}
+
+func TestDecayedStats(t *testing.T) {
+	config, err := NewConfig([]byte(`{"decay_half_life": 60}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := NewStats(config)
+	stats.HotKeys.Add([]string{"foo", "foo"})
+	stats.Slide()
+
+	// ... scores aren't reset by rotation
+	stats.Rotate()
+	if hits := stats.Rotate().HotKeys.GetHits("foo"); hits != 2 {
+		t.Errorf("Expected a score of 2 for foo, got %d\n", hits)
+	}
+
+	_, err = NewConfig([]byte(`{"decay_half_life": 60, "sliding_window": 30}`))
+	if err == nil {
+		t.Errorf("Expected error for decay with a sliding window\n")
+	}
+}
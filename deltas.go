@@ -0,0 +1,51 @@
+package main
+
+import (
+	"container/heap"
+)
+
+// KeyDeltas compares the keys reported each interval with those reported
+// the interval before, for watching how the standings change rather than
+// the standings themselves.
+type KeyDeltas struct {
+	// Map of keys reported in the last interval to their hits
+	previous map[string]int
+}
+
+func NewKeyDeltas() *KeyDeltas {
+	return &KeyDeltas{previous: make(map[string]int)}
+}
+
+// Update compares keys with those of the last interval, returning KeyHeaps
+// of the keys that entered, by hits, that departed, by hits in the last
+// interval, and of the keys in both whose hits rose or fell, by how much.
+// keys are then remembered for the next interval.
+func (d *KeyDeltas) Update(keys []*Key) (entered, departed, rose, fell *KeyHeap) {
+	entered, departed, rose, fell = &KeyHeap{}, &KeyHeap{}, &KeyHeap{}, &KeyHeap{}
+	current := make(map[string]int, len(keys))
+	for _, key := range keys {
+		current[key.Name] = key.Hits
+
+		previous, ok := d.previous[key.Name]
+		switch {
+		case !ok:
+			*entered = append(*entered, &Key{key.Name, key.Hits})
+		case key.Hits > previous:
+			*rose = append(*rose, &Key{key.Name, key.Hits - previous})
+		case key.Hits < previous:
+			*fell = append(*fell, &Key{key.Name, previous - key.Hits})
+		}
+	}
+	for name, hits := range d.previous {
+		if _, ok := current[name]; !ok {
+			*departed = append(*departed, &Key{name, hits})
+		}
+	}
+	d.previous = current
+
+	heap.Init(entered)
+	heap.Init(departed)
+	heap.Init(rose)
+	heap.Init(fell)
+	return entered, departed, rose, fell
+}
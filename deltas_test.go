@@ -0,0 +1,42 @@
+package main
+
+import (
+	"container/heap"
+	"testing"
+)
+
+func TestKeyDeltas(t *testing.T) {
+	d := NewKeyDeltas()
+	entered, _, _, _ := d.Update([]*Key{&Key{"foo", 100}, &Key{"bar", 20}, &Key{"baz", 50}})
+	if entered.Len() != 3 {
+		t.Errorf("Expected every key to enter the first interval, got %d\n", entered.Len())
+	}
+	entered, departed, rose, fell := d.Update([]*Key{&Key{"foo", 130}, &Key{"bar", 10}, &Key{"qux", 3}})
+
+	for name, test := range map[string]struct {
+		actual   *KeyHeap
+		expected []Key
+	}{
+		"entered":  {entered, []Key{Key{"qux", 3}}},
+		"departed": {departed, []Key{Key{"baz", 50}}},
+		"rose":     {rose, []Key{Key{"foo", 30}}},
+		"fell":     {fell, []Key{Key{"bar", 10}}},
+	} {
+		if test.actual.Len() != len(test.expected) {
+			t.Errorf("Expected %d keys %s, got %d\n", len(test.expected), name, test.actual.Len())
+			continue
+		}
+		for _, key := range test.expected {
+			popped_key := heap.Pop(test.actual).(*Key)
+			if key != *popped_key {
+				t.Errorf("Expected %s %v, got %v\n", name, key, *popped_key)
+			}
+		}
+	}
+
+	for _, config := range []string{`{"deltas": true, "top": true}`, `{"deltas": true, "api_address": ":8080"}`} {
+		if _, err := NewConfig([]byte(config)); err == nil {
+			t.Errorf("Expected error for %s\n", config)
+		}
+	}
+}
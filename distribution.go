@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// HitDistribution summarizes how hits are spread across keys, to show how
+// skewed the workload is.
+type HitDistribution struct {
+	// Hits per key at each percentile
+	P50, P90, P99 int
+
+	// Gini coefficient of hits per key, from 0 when every key has the same
+	// number of hits, towards 1 when a few keys have nearly all of them
+	Gini float64
+}
+
+func NewHitDistribution(keys []*Key) HitDistribution {
+	if len(keys) == 0 {
+		return HitDistribution{}
+	}
+	hits := make([]int, len(keys))
+	for i, key := range keys {
+		hits[i] = key.Hits
+	}
+	sort.Ints(hits)
+
+	total, weighted := 0.0, 0.0
+	for i, h := range hits {
+		total += float64(h)
+		weighted += float64(i+1) * float64(h)
+	}
+	n := float64(len(hits))
+	gini := 0.0
+	if total > 0 {
+		gini = 2*weighted/(n*total) - (n+1)/n
+	}
+
+	return HitDistribution{
+		P50:  percentile(hits, 50),
+		P90:  percentile(hits, 90),
+		P99:  percentile(hits, 99),
+		Gini: gini,
+	}
+}
+
+// percentile returns the pth percentile of sorted, by nearest rank.
+func percentile(sorted []int, p float64) int {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
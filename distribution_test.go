@@ -0,0 +1,33 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHitDistribution(t *testing.T) {
+	keys := []*Key{}
+	for i := 1; i <= 100; i++ {
+		keys = append(keys, &Key{"", i})
+	}
+	d := NewHitDistribution(keys)
+	if d.P50 != 50 || d.P90 != 90 || d.P99 != 99 {
+		t.Errorf("Expected percentiles 50/90/99, got %d/%d/%d\n", d.P50, d.P90, d.P99)
+	}
+
+	// ... every key equally hot
+	d = NewHitDistribution([]*Key{&Key{"a", 5}, &Key{"b", 5}, &Key{"c", 5}})
+	if d.Gini != 0 {
+		t.Errorf("Expected Gini of 0 for an even workload, got %f\n", d.Gini)
+	}
+
+	// ... one key has every hit
+	d = NewHitDistribution([]*Key{&Key{"a", 0}, &Key{"b", 0}, &Key{"c", 0}, &Key{"d", 100}})
+	if math.Abs(d.Gini-0.75) > 1e-9 {
+		t.Errorf("Expected Gini of 0.75, got %f\n", d.Gini)
+	}
+
+	if d := NewHitDistribution([]*Key{}); d.P50 != 0 || d.Gini != 0 {
+		t.Errorf("Expected empty distribution for no keys, got %+v\n", d)
+	}
+}
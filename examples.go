@@ -0,0 +1,70 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// KeyExamples keeps track of some of the raw keys counted under each name,
+// where keys are grouped by regexps or rolled up, so that a hot group can
+// be traced back to the keys that made it hot.
+type KeyExamples struct {
+	Lock sync.Mutex
+
+	// Distinct raw keys remembered for each name; the first seen are kept
+	limit int
+
+	// Map of names to hits by raw key
+	examples map[string]map[string]int
+}
+
+func NewKeyExamples(limit int) *KeyExamples {
+	k := &KeyExamples{limit: limit}
+	k.examples = make(map[string]map[string]int)
+	return k
+}
+
+// Add records a hit on key, counted under name.
+func (k *KeyExamples) Add(name string, key string) {
+	k.Lock.Lock()
+	defer k.Lock.Unlock()
+
+	examples, ok := k.examples[name]
+	if !ok {
+		examples = make(map[string]int)
+		k.examples[name] = examples
+	}
+	if _, ok := examples[key]; ok || len(examples) < k.limit {
+		examples[key] += 1
+	}
+}
+
+// GetExamples returns a KeyHeap of the raw keys remembered for name,
+// ordered by hits.
+func (k *KeyExamples) GetExamples(name string) *KeyHeap {
+	k.Lock.Lock()
+	defer k.Lock.Unlock()
+
+	examples := &KeyHeap{}
+	heap.Init(examples)
+
+	for key, hits := range k.examples[name] {
+		heap.Push(examples, &Key{key, hits})
+	}
+	return examples
+}
+
+// Rotate clears the data on the existing KeyExamples, returning a new
+// KeyExamples containing the old data.
+func (k *KeyExamples) Rotate() *KeyExamples {
+	k.Lock.Lock()
+	defer k.Lock.Unlock()
+
+	// Clone existing
+	new_key_examples := NewKeyExamples(k.limit)
+	new_key_examples.examples = k.examples
+
+	// Clear existing values
+	k.examples = make(map[string]map[string]int)
+	return new_key_examples
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestKeyExamples(t *testing.T) {
+	k := NewKeyExamples(2)
+	k.Add("user", "user:1")
+	k.Add("user", "user:2")
+	k.Add("user", "user:2")
+	k.Add("user", "user:3")
+	k.Add("post", "post:1")
+
+	/* Only the first two keys seen are kept, but still counted */
+	examples := popTopKeys(k.Rotate().GetExamples("user"), -1, 0)
+	if len(examples) != 2 || *examples[0] != (Key{"user:2", 2}) || *examples[1] != (Key{"user:1", 1}) {
+		t.Errorf("Expected user:2 then user:1, got %v\n", examples)
+	}
+	if k.GetExamples("user").Len() != 0 {
+		t.Errorf("Expected rotated examples to be cleared\n")
+	}
+
+	invalid := []string{
+		`{"key_examples": 3}`,
+		`{"key_examples": -1, "key_delimiter": ":"}`,
+		`{"key_examples": 3, "key_delimiter": ":", "hash_keys": true}`,
+	}
+	for _, config := range invalid {
+		if _, err := NewConfig([]byte(config)); err == nil {
+			t.Errorf("Expected error for %s\n", config)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Statuses mcsauna exits with, by what stopped it
+const (
+	EXIT_OK         = 0
+	EXIT_ERROR      = 1
+	EXIT_USAGE      = 2
+	EXIT_CONFIG     = 3
+	EXIT_CAPTURE    = 4
+	EXIT_PERMISSION = 5
+	EXIT_FILE       = 6
+)
+
+// ExitError is an error that stops mcsauna, with the status to exit with.
+// Without Err, there's nothing more to say than the status, e.g. that of a
+// check, which has printed its result already.
+type ExitError struct {
+	Status int
+	Err    error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return ""
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// exitError returns an ExitError with status, formatting its message as
+// fmt.Errorf does.
+func exitError(status int, format string, args ...interface{}) error {
+	return &ExitError{status, fmt.Errorf(format, args...)}
+}
+
+// exitStatus returns the status to exit with for err.
+func exitStatus(err error) int {
+	var exit_err *ExitError
+	if errors.As(err, &exit_err) {
+		return exit_err.Status
+	}
+	return EXIT_ERROR
+}
+
+// captureError explains an error opening iface to capture on, with what
+// to do about it.
+func captureError(iface string, err error) error {
+	message := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(message, "permission") || strings.Contains(message, "not permitted"):
+		return exitError(EXIT_PERMISSION,
+			"permission denied opening %s: run as root or grant CAP_NET_RAW and CAP_NET_ADMIN: %w", iface, err)
+	case strings.Contains(message, "no such device"):
+		return exitError(EXIT_CAPTURE,
+			"no such interface %s: run 'mcsauna interfaces' to list them: %w", iface, err)
+	}
+	return exitError(EXIT_CAPTURE, "can't capture on %s: %w", iface, err)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{errors.New("boom"), EXIT_ERROR},
+		{exitError(EXIT_CONFIG, "Config error: bad"), EXIT_CONFIG},
+		/* Wrapping again keeps the status */
+		{fmt.Errorf("loading: %w", exitError(EXIT_FILE, "can't open")), EXIT_FILE},
+		/* A check's status, with nothing more to say */
+		{&ExitError{Status: 2}, 2},
+	}
+	for _, test := range tests {
+		if status := exitStatus(test.err); status != test.status {
+			t.Errorf("Expected status %d for '%s', got %d\n", test.status, test.err, status)
+		}
+	}
+}
+
+func TestExitErrorWithoutErr(t *testing.T) {
+	if message := (&ExitError{Status: 1}).Error(); message != "" {
+		t.Errorf("Expected no message without an error, got '%s'\n", message)
+	}
+}
+
+func TestCaptureError(t *testing.T) {
+	tests := []struct {
+		err    string
+		status int
+		advice string
+	}{
+		{"eth9: You don't have permission to capture on that device (socket: Operation not permitted)",
+			EXIT_PERMISSION, "CAP_NET_RAW"},
+		{"eth9: No such device exists (SIOCGIFHWADDR: No such device)",
+			EXIT_CAPTURE, "mcsauna interfaces"},
+		{"eth9: That device is not up", EXIT_CAPTURE, "can't capture on eth9"},
+	}
+	for _, test := range tests {
+		err := captureError("eth9", errors.New(test.err))
+		if status := exitStatus(err); status != test.status {
+			t.Errorf("Expected status %d for '%s', got %d\n", test.status, test.err, status)
+		}
+		if !strings.Contains(err.Error(), test.advice) || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Expected '%s' and the cause in '%s'\n", test.advice, err)
+		}
+	}
+}
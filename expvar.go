@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ExpvarSink serves Go's runtime stats, e.g. memstats, and mcsauna's
+// self-metrics over HTTP, at /debug/vars, in the JSON format of the expvar
+// package, so that process health can be scraped by generic Go monitoring
+// tools independently of any other output.  Self-metrics counted over each
+// interval are served as totals since mcsauna started, under "mcsauna",
+// along with the latest value of the rest, e.g. goroutines.
+type ExpvarSink struct {
+	Lock sync.Mutex
+
+	// Self-metrics are named under this prefix
+	prefix string
+
+	vars map[string]float64
+}
+
+func NewExpvarSink(prefix string) *ExpvarSink {
+	return &ExpvarSink{prefix: prefix + ".", vars: map[string]float64{}}
+}
+
+// ListenAndServe serves the vars on address until it fails.
+func (e *ExpvarSink) ListenAndServe(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", e)
+	return http.ListenAndServe(address, mux)
+}
+
+// Send adds the self-metrics in a report to the vars being served.
+func (e *ExpvarSink) Send(report *Report) error {
+	e.Lock.Lock()
+	defer e.Lock.Unlock()
+	for _, m := range report.Metrics {
+		name := m.Name.String()
+		if !strings.HasPrefix(name, e.prefix) {
+			continue
+		}
+		name = strings.TrimPrefix(name, e.prefix)
+		if m.Counter {
+			e.vars[name] += m.Value
+		} else {
+			e.vars[name] = m.Value
+		}
+	}
+	e.vars["reports"]++
+	return nil
+}
+
+func (e *ExpvarSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vars := map[string]json.RawMessage{}
+	expvar.Do(func(kv expvar.KeyValue) {
+		vars[kv.Key] = json.RawMessage(kv.Value.String())
+	})
+	e.Lock.Lock()
+	self, err := json.Marshal(e.vars)
+	e.Lock.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	vars["mcsauna"] = self
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(vars)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExpvarSink(t *testing.T) {
+	sink := NewExpvarSink("mcsauna.self")
+	for i := 1; i <= 2; i++ {
+		report := NewReport(time.Now())
+		report.Count(NewMetricName("mcsauna.self.packets_processed"), 10)
+		report.Gauge(NewMetricName("mcsauna.self.goroutines"), float64(i))
+		report.Count(NewMetricName("mcsauna.keys").Label("key", "foo"), 3)
+		sink.Send(report)
+	}
+
+	w := httptest.NewRecorder()
+	sink.ServeHTTP(w, httptest.NewRequest("GET", "/debug/vars", nil))
+	var vars struct {
+		Memstats map[string]interface{} `json:"memstats"`
+		Mcsauna  map[string]float64     `json:"mcsauna"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars.Memstats == nil {
+		t.Errorf("Expected runtime memstats to be served\n")
+	}
+	expected := map[string]float64{"packets_processed": 20, "goroutines": 2, "reports": 2}
+	if len(vars.Mcsauna) != len(expected) {
+		t.Errorf("Expected %v, got %v\n", expected, vars.Mcsauna)
+	}
+	for name, value := range expected {
+		if vars.Mcsauna[name] != value {
+			t.Errorf("Expected %s to be %f, got %f\n", name, value, vars.Mcsauna[name])
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Default upper bounds of the buckets that the number of keys in each
+// multiget is counted in.  Larger multigets are counted in a final "over"
+// bucket.
+var FANOUT_BUCKETS = []int{1, 2, 4, 8, 16, 32, 64, 128, 256, 512}
+
+// fanoutBucket returns the name of the bucket a multiget of num_keys keys
+// is counted in, given the upper bounds of the buckets.
+func fanoutBucket(buckets []int, num_keys int) string {
+	for _, bound := range buckets {
+		if num_keys <= bound {
+			return fmt.Sprintf("le_%d", bound)
+		}
+	}
+	return fmt.Sprintf("over_%d", buckets[len(buckets)-1])
+}
+
+// formatFanout adds the number of multigets in each bucket, smallest first.
+func formatFanout(report *Report, name MetricName, pool *HotKeyPool, buckets []int) {
+	for _, bound := range buckets {
+		bucket := fanoutBucket(buckets, bound)
+		report.Count(name.Label("bucket", bucket), pool.GetHits(bucket))
+	}
+	bucket := fanoutBucket(buckets, buckets[len(buckets)-1]+1)
+	report.Count(name.Label("bucket", bucket), pool.GetHits(bucket))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFanoutBucket(t *testing.T) {
+	expected := map[int]string{
+		1:    "le_1",
+		2:    "le_2",
+		3:    "le_4",
+		500:  "le_512",
+		512:  "le_512",
+		513:  "over_512",
+		5000: "over_512",
+	}
+	for num_keys, bucket := range expected {
+		if actual := fanoutBucket(FANOUT_BUCKETS, num_keys); actual != bucket {
+			t.Errorf("Expected %d keys in %s, got %s\n", num_keys, bucket, actual)
+		}
+	}
+}
+
+func TestFormatFanout(t *testing.T) {
+	pool := NewHotKeyPool()
+	pool.Add([]string{fanoutBucket(FANOUT_BUCKETS, 1), fanoutBucket(FANOUT_BUCKETS, 1),
+		fanoutBucket(FANOUT_BUCKETS, 600)})
+
+	report := NewReport(time.Now())
+	formatFanout(report, NewMetricName("mcsauna.fanout"), pool, FANOUT_BUCKETS)
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	if len(lines) != len(FANOUT_BUCKETS)+1 {
+		t.Fatalf("Expected a line per bucket, got %q\n", lines)
+	}
+	if lines[0] != "mcsauna.fanout.le_1 2" || lines[1] != "mcsauna.fanout.le_2 0" ||
+		lines[len(lines)-1] != "mcsauna.fanout.over_512 1" {
+		t.Errorf("Unexpected output %q\n", lines)
+	}
+}
+
+func TestFanoutBucketsConfig(t *testing.T) {
+	config, err := NewConfig([]byte(`{"report_fanout": true, "fanout_buckets": [1, 10, 100]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewHotKeyPool()
+	for _, num_keys := range []int{1, 5, 10, 50, 500} {
+		pool.Add([]string{fanoutBucket(config.FanoutBuckets, num_keys)})
+	}
+
+	report := NewReport(time.Now())
+	formatFanout(report, NewMetricName("mcsauna.fanout"), pool, config.FanoutBuckets)
+	expected := "mcsauna.fanout.le_1 1\nmcsauna.fanout.le_10 2\n" +
+		"mcsauna.fanout.le_100 1\nmcsauna.fanout.over_100 1\n"
+	if output := report.String(); output != expected {
+		t.Errorf("Expected %q, got %q\n", expected, output)
+	}
+
+	for _, buckets := range []string{`[]`, `[0, 1]`, `[4, 2]`, `[1, 1]`} {
+		if _, err := NewConfig([]byte(`{"fanout_buckets": ` + buckets + `}`)); err == nil {
+			t.Errorf("Expected error for buckets %s\n", buckets)
+		}
+	}
+}
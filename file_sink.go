@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileSink writes each report, in the given format, to a file: either
+// replacing its contents, or appending to it, to keep a log of reports.  An
+// appended-to file can be rotated when it gets too big or too old.
+//
+// Reports can be gzipped, each appended report as a gzip member of its own,
+// which gunzip and zcat read as one stream.
+type FileSink struct {
+	path   string
+	format *ReportFormat
+	gzip   bool
+
+	// Set when appending
+	file *rotatingFile
+}
+
+// NewFileSink returns a sink replacing the contents of path with each
+// report, gzipped if compress is set.  The file is replaced atomically, so
+// anything reading it always sees a whole report.
+func NewFileSink(path string, format *ReportFormat, compress bool) *FileSink {
+	return &FileSink{path: path, format: format, gzip: compress}
+}
+
+// NewAppendingFileSink returns a sink appending each report to path,
+// gzipped if compress is set, and rotating it as limited by max_bytes,
+// max_age and retain.
+func NewAppendingFileSink(path string, format *ReportFormat, compress bool, max_bytes int64, max_age time.Duration, retain int) *FileSink {
+	return &FileSink{
+		path:   path,
+		format: format,
+		gzip:   compress,
+		file:   newRotatingFile(path, max_bytes, max_age, retain),
+	}
+}
+
+func (s *FileSink) Send(report *Report) error {
+	formatted, err := s.format.Format(report)
+	if err != nil {
+		return err
+	}
+	output := []byte(formatted)
+	if s.gzip {
+		compressed := &bytes.Buffer{}
+		w := gzip.NewWriter(compressed)
+		w.Write(output)
+		w.Close()
+		output = compressed.Bytes()
+	}
+	if s.file == nil {
+		return writeFileAtomic(s.path, output)
+	}
+	return s.file.Write(report.Time, output)
+}
+
+// rotatingFile is a file that's appended to, and rotated when it gets too
+// big or too old, by renaming it to "<path>.1" (and any "<path>.1" to
+// "<path>.2", and so on).
+type rotatingFile struct {
+	path   string
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	// Rotate before the file would grow past max_bytes, or once it has been
+	// written to for max_age, if non-zero, keeping retain rotated files, or
+	// all of them if zero
+	max_bytes int64
+	max_age   time.Duration
+	retain    int
+
+	// Set once rotating has failed, until it succeeds again
+	rotate_failed bool
+}
+
+// rotateError is returned by rotatingFile.Write the first time the file
+// can't be rotated, though what was written was still appended to it.
+type rotateError struct {
+	path string
+	err  error
+}
+
+func (e *rotateError) Error() string {
+	return fmt.Sprintf("can't rotate %s: %v", e.path, e.err)
+}
+
+func newRotatingFile(path string, max_bytes int64, max_age time.Duration, retain int) *rotatingFile {
+	return &rotatingFile{path: path, max_bytes: max_bytes, max_age: max_age, retain: retain}
+}
+
+// Write appends data to the file at now, rotating it first if it's due.
+// The file is opened on the first write.  If it can't be rotated, data is
+// appended to it anyway, and a rotateError returned, only the first time.
+func (f *rotatingFile) Write(now time.Time, data []byte) error {
+	if f.file == nil {
+		if err := f.open(now); err != nil {
+			return err
+		}
+	}
+	var rotate_err error
+	too_big := f.max_bytes > 0 && f.size+int64(len(data)) > f.max_bytes
+	too_old := f.max_age > 0 && now.Sub(f.opened) >= f.max_age
+	if f.size > 0 && (too_big || too_old) {
+		// ... renamed while still open, so that it can still be written
+		// ... to if a new file can't be opened
+		old_file := f.file
+		rotate_err = f.rotate()
+		if rotate_err == nil {
+			rotate_err = f.open(now)
+		}
+		if rotate_err == nil {
+			old_file.Close()
+			f.rotate_failed = false
+		}
+	}
+	n, err := f.file.Write(data)
+	f.size += int64(n)
+	if err != nil {
+		return err
+	} else if rotate_err != nil && !f.rotate_failed {
+		f.rotate_failed = true
+		return &rotateError{f.path, rotate_err}
+	}
+	return nil
+}
+
+// open opens the file for appending, at now.
+func (f *rotatingFile) open(now time.Time) error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = now
+	return nil
+}
+
+// rotate shifts each rotated file along one, dropping the oldest if there
+// are already as many as are retained, and moves the file into first
+// place.
+func (f *rotatingFile) rotate() error {
+	rotated := 0
+	for {
+		if _, err := os.Stat(f.rotatedPath(rotated + 1)); err != nil {
+			break
+		}
+		rotated += 1
+	}
+	for f.retain > 0 && rotated >= f.retain {
+		if err := os.Remove(f.rotatedPath(rotated)); err != nil {
+			return err
+		}
+		rotated -= 1
+	}
+	for i := rotated; i > 0; i-- {
+		if err := os.Rename(f.rotatedPath(i), f.rotatedPath(i+1)); err != nil {
+			return err
+		}
+	}
+	return os.Rename(f.path, f.rotatedPath(1))
+}
+
+func (f *rotatingFile) rotatedPath(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
+
+// writeFileAtomic replaces the contents of path with data by writing it to
+// a temporary file alongside and renaming that over path, so that anything
+// reading path sees either the old contents or the new, never part of
+// them.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if close_err := tmp.Close(); err == nil {
+		err = close_err
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readFile returns the contents of a file, or "" if it doesn't exist.
+func readFile(path string) string {
+	data, _ := ioutil.ReadFile(path)
+	return string(data)
+}
+
+func TestFileSinkReplace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcsauna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out")
+
+	sink := NewFileSink(path, &ReportFormat{name: "text"}, false)
+	now := time.Unix(1500000000, 0)
+	for _, hits := range []int{1, 2} {
+		if err := sink.Send(testReport(now, hits)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if output := readFile(path); output != "mcsauna.keys.foo 2\n" {
+		t.Errorf("Expected only the last report, got %q\n", output)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Expected temporary files to be cleaned up, got %d files\n", len(files))
+	}
+}
+
+func TestFileSinkRotateBySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcsauna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out")
+
+	/* Each report is 19 bytes, so two fit in a file */
+	sink := NewAppendingFileSink(path, &ReportFormat{name: "text"}, false, 40, 0, 2)
+	now := time.Unix(1500000000, 0)
+	for hits := 1; hits <= 7; hits++ {
+		if err := sink.Send(testReport(now, hits)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := map[string]string{
+		path:        "mcsauna.keys.foo 7\n",
+		path + ".1": "mcsauna.keys.foo 5\nmcsauna.keys.foo 6\n",
+		path + ".2": "mcsauna.keys.foo 3\nmcsauna.keys.foo 4\n",
+		path + ".3": "",
+	}
+	for file, contents := range expected {
+		if output := readFile(file); output != contents {
+			t.Errorf("Expected %s to contain %q, got %q\n", file, contents, output)
+		}
+	}
+}
+
+func TestFileSinkRotateByAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcsauna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out")
+
+	sink := NewAppendingFileSink(path, &ReportFormat{name: "text"}, false, 0, time.Minute, 0)
+	now := time.Unix(1500000000, 0)
+	for i := 0; i < 5; i++ {
+		if err := sink.Send(testReport(now.Add(time.Duration(i)*30*time.Second), i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := map[string]string{
+		path:        "mcsauna.keys.foo 4\n",
+		path + ".1": "mcsauna.keys.foo 2\nmcsauna.keys.foo 3\n",
+		path + ".2": "mcsauna.keys.foo 0\nmcsauna.keys.foo 1\n",
+	}
+	for file, contents := range expected {
+		if output := readFile(file); output != contents {
+			t.Errorf("Expected %s to contain %q, got %q\n", file, contents, output)
+		}
+	}
+}
+
+func TestFileSinkGzip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcsauna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.gz")
+
+	sink := NewAppendingFileSink(path, &ReportFormat{name: "text"}, true, 0, 0, 0)
+	now := time.Unix(1500000000, 0)
+	for _, hits := range []int{1, 2} {
+		if err := sink.Send(testReport(now, hits)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "mcsauna.keys.foo 1\nmcsauna.keys.foo 2\n"; string(output) != expected {
+		t.Errorf("Expected %q, got %q\n", expected, output)
+	}
+}
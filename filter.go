@@ -0,0 +1,97 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// KeyFilter decides which keys are counted.  If there are any include
+// rules, a key must match one of them to be counted; a key matching any
+// exclude rule is never counted.
+type KeyFilter struct {
+	include []*keyRule
+	exclude []*keyRule
+}
+
+// keyRule matches keys exactly, by prefix, or by regexp.
+type keyRule struct {
+	exact  string
+	prefix string
+	re     *regexp.Regexp
+}
+
+func newKeyRule(config KeyFilterConfig) (*keyRule, error) {
+	rule := &keyRule{exact: config.Exact, prefix: config.Prefix}
+	if config.Re != "" {
+		re, err := regexp.Compile(config.Re)
+		if err != nil {
+			return nil, err
+		}
+		rule.re = re
+	}
+	return rule, nil
+}
+
+func (r *keyRule) match(key string) bool {
+	if r.re != nil {
+		return r.re.MatchString(key)
+	} else if r.prefix != "" {
+		return strings.HasPrefix(key, r.prefix)
+	}
+	return key == r.exact
+}
+
+func NewKeyFilter(include []KeyFilterConfig, exclude []KeyFilterConfig) (*KeyFilter, error) {
+	f := &KeyFilter{}
+	for _, config := range include {
+		rule, err := newKeyRule(config)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, rule)
+	}
+	for _, config := range exclude {
+		rule, err := newKeyRule(config)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, rule)
+	}
+	return f, nil
+}
+
+// Allow returns whether key should be counted.
+func (f *KeyFilter) Allow(key string) bool {
+	for _, rule := range f.exclude {
+		if rule.match(key) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, rule := range f.include {
+		if rule.match(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns the keys that should be counted.  keys is returned as is
+// if they all should be.
+func (f *KeyFilter) Filter(keys []string) []string {
+	for i, key := range keys {
+		if f.Allow(key) {
+			continue
+		}
+		allowed := append([]string{}, keys[:i]...)
+		for _, key := range keys[i+1:] {
+			if f.Allow(key) {
+				allowed = append(allowed, key)
+			}
+		}
+		return allowed
+	}
+	return keys
+}
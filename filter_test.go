@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestKeyFilter(t *testing.T) {
+	filter, err := NewKeyFilter(
+		[]KeyFilterConfig{
+			KeyFilterConfig{Prefix: "user:"},
+			KeyFilterConfig{Re: "^post:[0-9]+$"},
+		},
+		[]KeyFilterConfig{
+			KeyFilterConfig{Exact: "user:heartbeat"},
+			KeyFilterConfig{Prefix: "user:session:"},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]bool{
+		"user:1":         true,
+		"user:heartbeat": false,
+		"user:session:1": false,
+		"post:1":         true,
+		"post:1:body":    false,
+		"comment:1":      false,
+	}
+	for key, allowed := range expected {
+		if filter.Allow(key) != allowed {
+			t.Errorf("Expected Allow(%s) to be %v\n", key, allowed)
+		}
+	}
+
+	keys := filter.Filter([]string{"user:1", "comment:1", "post:2", "user:heartbeat"})
+	if !stringsEqual(keys, []string{"user:1", "post:2"}) {
+		t.Errorf("Unexpected filtered keys %v\n", keys)
+	}
+
+	// ... with no include rules, everything not excluded is allowed
+	filter, _ = NewKeyFilter(nil, []KeyFilterConfig{KeyFilterConfig{Exact: "foo"}})
+	if filter.Allow("foo") || !filter.Allow("bar") {
+		t.Errorf("Expected only foo to be excluded\n")
+	}
+
+	if _, err := NewKeyFilter([]KeyFilterConfig{KeyFilterConfig{Re: "("}}, nil); err == nil {
+		t.Errorf("Expected error for invalid regexp\n")
+	}
+}
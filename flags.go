@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Config fields without a flag of their own: includes are only read from
+// files
+var UNFLAGGED_FIELDS = map[string]bool{"includes": true}
+
+// configFlag is a flag setting a config field, by its JSON name, to the
+// value it would have in a JSON config.  Lists and mappings are added to
+// each time the flag is given.
+type configFlag struct {
+	field string
+	typ   reflect.Type
+
+	// nil until the flag is given
+	value interface{}
+}
+
+func (f *configFlag) String() string {
+	if f == nil || f.value == nil {
+		return ""
+	}
+	data, _ := json.Marshal(f.value)
+	return string(data)
+}
+
+func (f *configFlag) IsBoolFlag() bool {
+	return f.typ.Kind() == reflect.Bool
+}
+
+func (f *configFlag) Set(text string) error {
+	switch {
+	case f.typ == reflect.TypeOf(Duration(0)):
+		var d Duration
+		if err := d.Set(text); err != nil {
+			return err
+		}
+		f.value = text
+	case f.typ.Kind() == reflect.Slice && f.typ.Elem().Kind() == reflect.Struct:
+		entry, err := parseFlagEntry(f.typ.Elem(), text)
+		if err != nil {
+			return err
+		}
+		list, _ := f.value.([]interface{})
+		f.value = append(list, entry)
+	case f.typ.Kind() == reflect.Slice:
+		// ... repeated, or separated by commas
+		list, _ := f.value.([]interface{})
+		for _, part := range strings.Split(text, ",") {
+			entry, err := parseFlagScalar(f.typ.Elem().Kind(), part)
+			if err != nil {
+				return err
+			}
+			list = append(list, entry)
+		}
+		f.value = list
+	case f.typ.Kind() == reflect.Map:
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("expected key=value, got '%s'", text)
+		}
+		mapping, ok := f.value.(map[string]interface{})
+		if !ok {
+			mapping = map[string]interface{}{}
+		}
+		mapping[parts[0]] = parts[1]
+		f.value = mapping
+	default:
+		value, err := parseFlagScalar(f.typ.Kind(), text)
+		if err != nil {
+			return err
+		}
+		f.value = value
+	}
+	return nil
+}
+
+// usage returns the help for the flag, with the form of its value in
+// backquotes.
+func (f *configFlag) usage() string {
+	switch {
+	case f.typ == reflect.TypeOf([]RegexpConfig{}):
+		return fmt.Sprintf("add to '%s', as `name=pattern`", f.field)
+	case f.typ == reflect.TypeOf([]KeyFilterConfig{}):
+		return fmt.Sprintf("add to '%s', as `exact:key`, prefix:key or re:pattern", f.field)
+	case f.typ.Kind() == reflect.Slice && f.typ.Elem().Kind() == reflect.Struct:
+		return fmt.Sprintf("add to '%s', as a `JSON object`", f.field)
+	case f.typ.Kind() == reflect.Slice:
+		return fmt.Sprintf("add to '%s' (repeatable, or comma-separated)", f.field)
+	case f.typ.Kind() == reflect.Map:
+		return fmt.Sprintf("add to '%s', as `key=value`", f.field)
+	}
+	return fmt.Sprintf("set '%s'", f.field)
+}
+
+// parseFlagScalar parses text as a bool, number or string.
+func parseFlagScalar(kind reflect.Kind, text string) (interface{}, error) {
+	switch kind {
+	case reflect.Bool:
+		return strconv.ParseBool(text)
+	case reflect.Int:
+		return strconv.Atoi(text)
+	case reflect.Float64:
+		return strconv.ParseFloat(text, 64)
+	}
+	return text, nil
+}
+
+// parseFlagEntry parses an entry of a list of typ, e.g. a regexp, as the
+// fields of a JSON object.  Regexps are given as "name=pattern", key filter
+// rules as "exact:key", "prefix:key" or "re:pattern", and anything else as
+// JSON.
+func parseFlagEntry(typ reflect.Type, text string) (interface{}, error) {
+	switch typ {
+	case reflect.TypeOf(RegexpConfig{}):
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected name=pattern, got '%s'", text)
+		}
+		return map[string]interface{}{"name": parts[0], "re": parts[1]}, nil
+	case reflect.TypeOf(KeyFilterConfig{}):
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 || (parts[0] != "exact" && parts[0] != "prefix" && parts[0] != "re") {
+			return nil, fmt.Errorf("expected exact:key, prefix:key or re:pattern, got '%s'", text)
+		}
+		return map[string]interface{}{parts[0]: parts[1]}, nil
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &entry); err != nil {
+		return nil, fmt.Errorf("expected a JSON object, got '%s'", text)
+	}
+	return entry, nil
+}
+
+// configFieldFlags defines a flag on flags for every config field without
+// one already, named after it, e.g. -graphite-address for
+// "graphite_address", or -regexp for "regexps".
+func configFieldFlags(flags *flag.FlagSet) []*configFlag {
+	field_flags := []*configFlag{}
+	typ := reflect.TypeOf(Config{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if field == "" || UNFLAGGED_FIELDS[field] {
+			continue
+		}
+		name := strings.Replace(field, "_", "-", -1)
+		if field == "regexps" {
+			name = "regexp"
+		}
+		if flags.Lookup(name) != nil {
+			continue
+		}
+		field_flag := &configFlag{field: field, typ: typ.Field(i).Type}
+		flags.Var(field_flag, name, field_flag.usage())
+		field_flags = append(field_flags, field_flag)
+	}
+	return field_flags
+}
+
+// applyFieldFlags returns config with the fields of the flags given set,
+// checked as NewConfig checks a config file.
+func applyFieldFlags(config Config, field_flags []*configFlag) (Config, error) {
+	fields := configFields(config)
+	for _, field_flag := range field_flags {
+		if field_flag.value != nil {
+			fields[field_flag.field] = field_flag.value
+		}
+	}
+	config_data, err := json.Marshal(fields)
+	if err != nil {
+		return config, err
+	}
+	return NewConfig(config_data)
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// A section of a generated config: fields set together, and what they do
+type configSection struct {
+	Comment string
+	Fields  []string
+}
+
+// Every config field, in sections commented for generate-config
+var CONFIG_SECTIONS = []configSection{
+	{`Further config files, or globs, relative to this one, to read first and
+merge this one with: lists are appended to, and other fields replaced.`,
+		[]string{"includes"}},
+	{`Regexps grouping keys under names, e.g. {name: user, re: "^user:[0-9]+$"}.
+Regexps are tried by priority, highest first, then in the order listed, and
+the first to match a key wins.`,
+		[]string{"regexps"}},
+	{`How often to report: a number of seconds, or a duration, e.g. "500ms".`,
+		[]string{"interval"}},
+	{`Seconds to run for before exiting with a last report, or 0 for forever.`,
+		[]string{"run_duration"}},
+	{`The interface to capture on, or "any", and the port memcached listens on.`,
+		[]string{"interface", "port"}},
+	{`The number of hottest keys to report, unless grouping keys by regexps.`,
+		[]string{"num_items_to_report"}},
+	{`Where reports go: stdout, unless quiet, and output_file, if set.  Reports
+are written as "text", "json", "ndjson" or "csv", or with template, a Go
+text/template for each line.`,
+		[]string{"quiet", "output_file", "format", "template"}},
+	{`Report errors in parsing as metrics.`,
+		[]string{"show_errors"}},
+	{`Show the hottest keys interactively in the terminal, like top, rather
+than writing reports to stdout.`,
+		[]string{"top"}},
+	{`Prepended to every metric, with "{hostname}" and "{interface}" expanded.`,
+		[]string{"prefix"}},
+	{`Add the time of each report to each line of text, as "epoch" or
+"rfc3339".`,
+		[]string{"timestamps"}},
+	{`How lines of text are laid out: "space" or "tab" before the value;
+"joined" or "fields" for whether keys, commands and so on are dot-joined into
+the name; and "none", "underscore" or "percent" escaping of characters in
+them that would break up the line.`,
+		[]string{"output_separator", "output_labels", "output_escape"}},
+	{`Append reports to output_file rather than replacing it, rotating it past
+output_max_bytes or after output_max_age seconds (if set), and keeping
+output_retain rotated files (or all, if 0).`,
+		[]string{"output_append", "output_max_bytes", "output_max_age", "output_retain"}},
+	{`Gzip output_file.`,
+		[]string{"output_gzip"}},
+	{`Append errors in parsing to errors_file, and send them only to the outputs
+in errors_outputs, e.g. ["stdout"], rather than to all of them.`,
+		[]string{"errors_file", "errors_outputs"}},
+	{`Report only errors in parsing, by client, without counting keys.`,
+		[]string{"errors_only"}},
+	{`Report only how many of each command were parsed, and errors, without
+counting keys, to check capture and parsing work on a new host.`,
+		[]string{"dry_run"}},
+	{`Report the keys no regexp matched.`,
+		[]string{"show_unmatched"}},
+	{`Seconds to hold a command cut off at the end of a packet for the rest.`,
+		[]string{"conn_expiry"}},
+	{`Append hex dumps of payloads that fail to parse to debug_errors_file, up
+to debug_errors_bytes of each, and debug_errors_per_second dumps a second.`,
+		[]string{"debug_errors_file", "debug_errors_bytes", "debug_errors_per_second"}},
+	{`"strict", or "lenient" to tolerate common deviations from the protocol.`,
+		[]string{"parser_mode"}},
+	{`"ascii", "binary", or "auto" to detect it for each connection.`,
+		[]string{"protocol"}},
+	{`Count traffic against the client in a PROXY protocol header, rather than
+the proxy sending it.`,
+		[]string{"use_proxy_client_ip"}},
+	{`A TLS key log file, as written by clients with SSLKEYLOGFILE set, to
+decrypt TLS connections with.`,
+		[]string{"tls_key_log_file"}},
+	{`Capture responses too, to report latency by command and by key.`,
+		[]string{"track_latency"}},
+	{`Proxy addresses, e.g. twemproxy's, by name, to also report keys by proxy.`,
+		[]string{"proxies"}},
+	{`How keys are counted: "exact", "space_saving", or "count_min" for a sketch
+of sketch_depth rows of sketch_width counters, reporting only the
+sketch_candidates hottest keys.`,
+		[]string{"counter", "sketch_width", "sketch_depth", "sketch_candidates"}},
+	{`With the "space_saving" counter, the number of keys to keep counts for.`,
+		[]string{"space_saving_counters"}},
+	{`Shards to split keys across, each with its own lock and counter.`,
+		[]string{"hot_key_shards"}},
+	{`Report keys over a window of this many seconds, sliding forward every
+sliding_window_granularity seconds, rather than each interval.`,
+		[]string{"sliding_window", "sliding_window_granularity"}},
+	{`Report keys by a score whose hits halve in weight every this many seconds.`,
+		[]string{"decay_half_life"}},
+	{`Commands, e.g. "get" and "set", to also report keys for separately.`,
+		[]string{"command_sections"}},
+	{`Also rank keys by bytes on the wire.`,
+		[]string{"rank_by_bytes"}},
+	{`Further ports to capture, e.g. for several memcached instances.`,
+		[]string{"ports"}},
+	{`Also report keys by server address and port.`,
+		[]string{"per_server"}},
+	{`Estimate the number of distinct keys, overall and by regexp.`,
+		[]string{"track_cardinality"}},
+	{`Roll keys up by splitting them on key_delimiter, replacing numbers with
+"*", and keeping the first key_segments parts (or all, if 0), instead of
+using regexps.`,
+		[]string{"key_delimiter", "key_segments"}},
+	{`Report up to this many raw keys counted under each regexp or rolled up key.`,
+		[]string{"key_examples"}},
+	{`Group keys by their structure, reporting the busiest namespaces, and
+writing regexps for them to namespace_suggestions_file, if set.`,
+		[]string{"discover_namespaces", "namespace_suggestions_file"}},
+	{`Report percentiles of hits per key, and how concentrated hits are.`,
+		[]string{"report_distribution"}},
+	{`Report the reads, writes and deletes of each key reported.`,
+		[]string{"report_mix"}},
+	{`With the "exact" counter, the most keys, and bytes of them, to hold, or 0
+for no limit.  The coldest keys are evicted past them.`,
+		[]string{"max_keys", "max_key_bytes"}},
+	{`Report how the keys reported changed since the last interval instead.`,
+		[]string{"deltas"}},
+	{`Keep counts increasing across intervals rather than resetting them.`,
+		[]string{"cumulative"}},
+	{`Leave out keys and errors with fewer hits than this.`,
+		[]string{"min_hits"}},
+	{`Report keys matched by one regexp that another would also have matched.`,
+		[]string{"report_regexp_conflicts"}},
+	{`Report the regexps that took the most time to match.`,
+		[]string{"profile_regexps"}},
+	{`Report how many keys each regexp matched, and how many none did.`,
+		[]string{"report_regexp_matches"}},
+	{`Report the keys whose hits rose the most since the last interval.`,
+		[]string{"report_movers"}},
+	{`Report how many distinct clients requested each key reported.`,
+		[]string{"report_clients"}},
+	{`Only count keys matching an include rule, if there are any, and no exclude
+rule, e.g. {prefix: "session:"}, {exact: "foo"} or {re: "^tmp_"}.`,
+		[]string{"include", "exclude"}},
+	{`Alerts to raise, e.g. {name: hot, key: foo, hits: 10000} or {share: 0.5}.`,
+		[]string{"alerts"}},
+	{`Thresholds on the hottest key's share of hits, and on the error rate, for
+"-check" to warn or go critical at, or 0 not to check.`,
+		[]string{"check_share_warning", "check_share_critical",
+			"check_error_rate_warning", "check_error_rate_critical"}},
+	{`Also notify Slack and PagerDuty of alerts, at most once every
+alert_cooldown seconds each.`,
+		[]string{"slack_webhook_url", "pagerduty_routing_key", "pagerduty_severity", "alert_cooldown"}},
+	{`Only count keys requested by these commands, if set.`,
+		[]string{"commands"}},
+	{`Report keys hashed, keeping them as they are up to the first
+hash_keys_prefix_delimiter, if set.`,
+		[]string{"hash_keys", "hash_keys_prefix_delimiter"}},
+	{`Report a histogram of keys per get, with these upper bounds.`,
+		[]string{"report_fanout", "fanout_buckets"}},
+	{`Report the keys with the most hits in any one second.`,
+		[]string{"report_burstiness"}},
+	{`Report the fraction of keys seen only once.`,
+		[]string{"report_one_hit_wonders"}},
+	{`Report totals for all traffic.`,
+		[]string{"report_summary"}},
+	{`Count a key repeated in one request once.`,
+		[]string{"dedupe_keys"}},
+	{`Send reports to Carbon at "host:port", buffering up to
+graphite_buffer_bytes while it's down.`,
+		[]string{"graphite_address", "graphite_buffer_bytes"}},
+	{`Send reports to StatsD at "host:port", over "udp" or "tcp", in packets of
+up to statsd_packet_bytes, or one metric to a packet if 0.`,
+		[]string{"statsd_address", "statsd_protocol", "statsd_prefix", "statsd_packet_bytes"}},
+	{`Send to StatsD as DogStatsD, with labels and statsd_tags as tags.`,
+		[]string{"dogstatsd", "statsd_tags"}},
+	{`Serve the latest report for Prometheus here, e.g. ":9150", at /metrics.`,
+		[]string{"prometheus_address"}},
+	{`Serve the hottest keys as JSON here, e.g. ":8080", at /v1/topkeys.`,
+		[]string{"api_address"}},
+	{`Reports to keep for /v1/history and gRPC subscribers.`,
+		[]string{"report_history"}},
+	{`Serve runtime stats and self-metrics here, e.g. ":6060", at /debug/vars.`,
+		[]string{"expvar_address"}},
+	{`Write reports in InfluxDB line protocol to influx_file, and/or post them
+to influx_url.`,
+		[]string{"influx_file", "influx_url"}},
+	{`Export reports to an OpenTelemetry collector at this OTLP/HTTP endpoint.`,
+		[]string{"otlp_endpoint", "otlp_service_name"}},
+	{`Send reports to syslog, locally, or to syslog_address if set.`,
+		[]string{"syslog", "syslog_network", "syslog_address", "syslog_facility", "syslog_severity"}},
+	{`mcsauna's own logs: "debug", "info", "warn" or "error" and above, as "text"
+or "json", to "stderr", "syslog" or "file", appending to log_file, rotated
+before it grows past log_max_bytes, or after log_max_age seconds, if set,
+keeping log_retain rotated files, or all of them if zero.`,
+		[]string{"log_level", "log_format", "log_output", "log_file",
+			"log_max_bytes", "log_max_age", "log_retain"}},
+	{`Run in the background once capture has started, for SysV-style init
+scripts, logging to syslog or log_file, and/or write the pid to pidfile.`,
+		[]string{"daemon", "pidfile"}},
+	{`Once capturing, switch to this user, and group, if set, rather than theirs,
+after chrooting, if set.`,
+		[]string{"user", "group", "chroot"}},
+	{`Publish reports to a Kafka topic.`,
+		[]string{"kafka_brokers", "kafka_topic"}},
+	{`Publish reports to a NATS subject.`,
+		[]string{"nats_url", "nats_subject"}},
+	{`Store the latest report in memcached, expiring after memcached_report_ttl
+seconds, if set.`,
+		[]string{"memcached_report_address", "memcached_report_key", "memcached_report_ttl"}},
+	{`Post reports to a webhook, timing out after webhook_timeout seconds and
+retrying webhook_retries times.`,
+		[]string{"webhook_url", "webhook_headers", "webhook_retries", "webhook_timeout"}},
+	{`Record reports in SQLite, for sqlite_retention seconds, if set.  This
+needs mcsauna built with "-tags sqlite".`,
+		[]string{"sqlite_file", "sqlite_retention"}},
+	{`Insert reports into ClickHouse, clickhouse_batch at a time.`,
+		[]string{"clickhouse_url", "clickhouse_table", "clickhouse_batch"}},
+	{`Write reports to Parquet files in parquet_dir, starting a new one every
+parquet_rotate seconds.`,
+		[]string{"parquet_dir", "parquet_rotate"}},
+	{`Upload reports to S3, or a store with an S3-compatible API at s3_endpoint,
+with credentials from the environment, or the AWS tools', if not set here.`,
+		[]string{"s3_bucket", "s3_prefix", "s3_region", "s3_endpoint",
+			"s3_access_key_id", "s3_secret_access_key"}},
+	{`Stream reports to clients of a Unix socket at this path.`,
+		[]string{"socket_path"}},
+	{`Stream reports to gRPC subscribers here, over TLS with this certificate.`,
+		[]string{"grpc_address", "grpc_cert_file", "grpc_key_file"}},
+}
+
+// generateConfig returns config as YAML, with every field commented.  Each
+// of notes is commented at the top.
+func generateConfig(config Config, notes []string) string {
+	output := &bytes.Buffer{}
+	output.WriteString("# mcsauna config, with every field set.  Save it as a .yaml file, and\n" +
+		"# remove the fields left at their defaults, if you like.\n")
+	for _, note := range notes {
+		output.WriteString(yamlComment(note))
+	}
+
+	fields := configFields(config)
+	for _, section := range CONFIG_SECTIONS {
+		output.WriteString("\n" + yamlComment(section.Comment))
+		for _, field := range section.Fields {
+			// ... JSON is YAML, so values are written as JSON, lists a
+			// ... line to each entry
+			if values, ok := fields[field].([]interface{}); ok && len(values) > 0 {
+				fmt.Fprintf(output, "%s:\n", field)
+				for _, value := range values {
+					fmt.Fprintf(output, "  - %s\n", yamlValue(value))
+				}
+			} else {
+				fmt.Fprintf(output, "%s: %s\n", field, yamlValue(fields[field]))
+			}
+		}
+	}
+	return output.String()
+}
+
+// yamlComment returns text commented out, a line at a time.
+func yamlComment(text string) string {
+	return "# " + strings.Replace(text, "\n", "\n# ", -1) + "\n"
+}
+
+// yamlValue returns value written as JSON, on one line.
+func yamlValue(value interface{}) string {
+	output := &bytes.Buffer{}
+	encoder := json.NewEncoder(output)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	return strings.TrimSpace(output.String())
+}
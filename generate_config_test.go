@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigSections(t *testing.T) {
+	/* Every field is in exactly one section */
+	config, _ := NewConfig([]byte{})
+	fields := configFields(config)
+	seen := map[string]bool{}
+	for _, section := range CONFIG_SECTIONS {
+		for _, field := range section.Fields {
+			if _, ok := fields[field]; !ok {
+				t.Errorf("Unknown field %s\n", field)
+			} else if seen[field] {
+				t.Errorf("Field %s is in more than one section\n", field)
+			}
+			seen[field] = true
+		}
+	}
+	for field := range fields {
+		if !seen[field] {
+			t.Errorf("Field %s isn't in a section\n", field)
+		}
+	}
+}
+
+func TestGenerateConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcsauna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config, _ := NewConfig([]byte(`{
+		"port": 11212,
+		"regexps": [{"name": "user", "re": "^user:[0-9]+:<profile>$"}],
+		"webhook_headers": {"Authorization": "Bearer xyz"}
+	}`))
+	generated := generateConfig(config, []string{"Suggested from traffic."})
+	if !strings.HasPrefix(generated, "# mcsauna config") ||
+		!strings.Contains(generated, "\n# Suggested from traffic.\n") {
+		t.Errorf("Expected a commented header, got %q\n", generated)
+	}
+	if !strings.Contains(generated, "regexps:\n  - {") {
+		t.Errorf("Expected regexps a line to each, got %q\n", generated)
+	}
+
+	/* The generated config reads back the same */
+	path := filepath.Join(dir, "mcsauna.yaml")
+	if err := ioutil.WriteFile(path, []byte(generated), 0666); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadConfig(path)
+	if err != nil {
+		t.Fatalf("Error reading back %q: %v\n", generated, err)
+	}
+	if !reflect.DeepEqual(read, config) {
+		t.Errorf("Expected %+v, got %+v\n", config, read)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	GRAPHITE_DIAL_TIMEOUT  = 5 * time.Second
+	GRAPHITE_WRITE_TIMEOUT = 5 * time.Second
+
+	// After failing to send, wait this long before trying again, doubling
+	// on each further failure up to the maximum
+	GRAPHITE_MIN_BACKOFF = time.Second
+	GRAPHITE_MAX_BACKOFF = time.Minute
+)
+
+// Characters that separate the fields of a line in Carbon's plaintext
+// protocol, and so can't appear in a metric name, e.g. in binary protocol
+// keys
+var GRAPHITE_NAME_ESCAPER = strings.NewReplacer(" ", "_", "\t", "_", "\r", "_", "\n", "_")
+
+// GraphiteSink sends reports to Carbon, over TCP, in its plaintext
+// protocol.  Reports that can't be sent, e.g. while Carbon is restarting,
+// are buffered and sent once it is back, up to a limit, beyond which the
+// oldest are dropped.
+type GraphiteSink struct {
+	dial func() (net.Conn, error)
+	conn net.Conn
+
+	// Reports waiting to be sent, oldest first
+	buffer     []string
+	buffered   int
+	max_buffer int
+
+	// Reports dropped since the last call to Dropped
+	dropped int
+
+	backoff      time.Duration
+	next_attempt time.Time
+}
+
+// NewGraphiteSink returns a sink sending to Carbon at address, buffering up
+// to max_buffer bytes of reports while it is unreachable.  No connection is
+// made until the first report is sent.
+func NewGraphiteSink(address string, max_buffer int) *GraphiteSink {
+	return newGraphiteSink(func() (net.Conn, error) {
+		return net.DialTimeout("tcp", address, GRAPHITE_DIAL_TIMEOUT)
+	}, max_buffer)
+}
+
+func newGraphiteSink(dial func() (net.Conn, error), max_buffer int) *GraphiteSink {
+	return &GraphiteSink{dial: dial, max_buffer: max_buffer}
+}
+
+// Send sends a report, along with any buffered reports.  If they can't be
+// sent, they are kept for next time, and no further attempt is made until
+// the backoff has passed.
+func (g *GraphiteSink) Send(report *Report) error {
+	now := report.Time
+	g.push(formatGraphite(report))
+	if now.Before(g.next_attempt) {
+		return nil
+	}
+
+	if g.conn == nil {
+		conn, err := g.dial()
+		if err != nil {
+			g.fail(now)
+			return err
+		}
+		g.conn = conn
+	}
+	for len(g.buffer) > 0 {
+		g.conn.SetWriteDeadline(time.Now().Add(GRAPHITE_WRITE_TIMEOUT))
+		if _, err := g.conn.Write([]byte(g.buffer[0])); err != nil {
+			g.conn.Close()
+			g.conn = nil
+			g.fail(now)
+			return err
+		}
+		g.buffered -= len(g.buffer[0])
+		g.buffer = g.buffer[1:]
+	}
+	g.backoff = 0
+	return nil
+}
+
+// Dropped returns the number of reports dropped from the buffer since it
+// was last called.
+func (g *GraphiteSink) Dropped() int {
+	dropped := g.dropped
+	g.dropped = 0
+	return dropped
+}
+
+// push buffers a report, dropping the oldest reports to make room for it.
+func (g *GraphiteSink) push(report string) {
+	g.buffer = append(g.buffer, report)
+	g.buffered += len(report)
+	for g.buffered > g.max_buffer && len(g.buffer) > 0 {
+		g.buffered -= len(g.buffer[0])
+		g.buffer = g.buffer[1:]
+		g.dropped += 1
+	}
+}
+
+func (g *GraphiteSink) fail(now time.Time) {
+	g.backoff *= 2
+	if g.backoff < GRAPHITE_MIN_BACKOFF {
+		g.backoff = GRAPHITE_MIN_BACKOFF
+	} else if g.backoff > GRAPHITE_MAX_BACKOFF {
+		g.backoff = GRAPHITE_MAX_BACKOFF
+	}
+	g.next_attempt = now.Add(g.backoff)
+}
+
+// formatGraphite formats a report as lines of "<metric> <value>
+// <timestamp>".
+func formatGraphite(report *Report) string {
+	output := ""
+	for _, m := range report.Metrics {
+		output += fmt.Sprintf("%s %s %d\n", GRAPHITE_NAME_ESCAPER.Replace(m.Name.String()), m.FormatValue(),
+			report.Time.Unix())
+	}
+	return output
+}
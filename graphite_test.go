@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFormatGraphite(t *testing.T) {
+	report := NewReport(time.Unix(1500000000, 0))
+	report.Count(NewMetricName("mcsauna.keys.foo"), 3)
+	report.Gauge(NewMetricName("mcsauna.distribution.gini"), 0.25)
+	output := formatGraphite(report)
+	expected := "mcsauna.keys.foo 3 1500000000\nmcsauna.distribution.gini 0.250 1500000000\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q\n", expected, output)
+	}
+
+	/* Spaces and line breaks in keys are replaced, rather than ending the
+	 * line */
+	report = NewReport(time.Unix(1500000000, 0))
+	report.Count(NewMetricName("mcsauna.keys.foo bar\nbaz"), 3)
+	if output := formatGraphite(report); output != "mcsauna.keys.foo_bar_baz 3 1500000000\n" {
+		t.Errorf("Expected spaces and line breaks escaped, got %q\n", output)
+	}
+}
+
+func TestGraphiteSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// ... Carbon is down for the first two reports
+	up := false
+	sink := newGraphiteSink(func() (net.Conn, error) {
+		if !up {
+			return nil, errors.New("connection refused")
+		}
+		return net.Dial("tcp", listener.Addr().String())
+	}, 1024)
+	now := time.Unix(1500000000, 0)
+
+	if err := sink.Send(testReport(now, 1)); err == nil {
+		t.Errorf("Expected error while Carbon is down\n")
+	}
+	up = true
+	if err := sink.Send(testReport(now.Add(500*time.Millisecond), 2)); err != nil {
+		t.Errorf("Expected no attempt to send during backoff, got %v\n", err)
+	}
+	if err := sink.Send(testReport(now.Add(time.Second), 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	for _, expected := range []string{
+		"mcsauna.keys.foo 1 1500000000\n",
+		"mcsauna.keys.foo 2 1500000000\n",
+		"mcsauna.keys.foo 3 1500000001\n",
+	} {
+		line, err := reader.ReadString('\n')
+		if err != nil || line != expected {
+			t.Errorf("Expected %q, got %q (%v)\n", expected, line, err)
+		}
+	}
+}
+
+func TestGraphiteSinkBuffer(t *testing.T) {
+	sink := newGraphiteSink(func() (net.Conn, error) {
+		return nil, errors.New("connection refused")
+	}, 64)
+	now := time.Unix(1500000000, 0)
+
+	for i := 0; i < 4; i++ {
+		sink.Send(testReport(now.Add(time.Duration(i)*time.Hour), 1))
+	}
+	// ... each report is 30 bytes, so only two fit
+	if len(sink.buffer) != 2 {
+		t.Errorf("Expected 2 buffered reports, got %d\n", len(sink.buffer))
+	}
+	if dropped := sink.Dropped(); dropped != 2 {
+		t.Errorf("Expected 2 dropped reports, got %d\n", dropped)
+	}
+	if dropped := sink.Dropped(); dropped != 0 {
+		t.Errorf("Expected dropped reports to be reset, got %d\n", dropped)
+	}
+}
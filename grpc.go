@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The one method of the Reports service in mcsauna.proto
+const GRPC_SUBSCRIBE_PATH = "/mcsauna.Reports/Subscribe"
+
+// gRPC status codes
+const (
+	GRPC_OK                 = "0"
+	GRPC_INVALID_ARGUMENT   = "3"
+	GRPC_RESOURCE_EXHAUSTED = "8"
+	GRPC_UNIMPLEMENTED      = "12"
+)
+
+// Subscribe requests bigger than this are refused
+const GRPC_MAX_REQUEST_BYTES = 1 << 16
+
+// GRPCSink serves the Reports gRPC service, described in mcsauna.proto,
+// over HTTP/2.  Each subscriber is streamed every report from when it
+// subscribes, filtered to the namespaces and commands it asks for, after
+// the reports in the history, if kept and asked for.  Reports wait in a
+// queue as big as the history for a subscriber to take them, and one that
+// falls further behind has its stream ended with RESOURCE_EXHAUSTED, rather
+// than miss reports.
+type GRPCSink struct {
+	Lock sync.Mutex
+
+	subscribers map[*grpcSubscriber]bool
+
+	// How many reports may wait for each subscriber
+	queue int
+
+	// Recent reports, if kept
+	history *ReportHistory
+}
+
+type grpcSubscriber struct {
+	// Only metrics for keys starting with one of namespaces, and for one of
+	// commands, are sent, if either is set
+	namespaces []string
+	commands   map[string]bool
+
+	// Whether to send the reports in the history first
+	replay bool
+
+	reports chan *Report
+
+	// Closed when reports is full, and the subscriber has fallen behind
+	behind chan struct{}
+}
+
+// NewGRPCSink returns a sink keeping the latest history reports, if any,
+// for subscribers to replay.
+func NewGRPCSink(history int) *GRPCSink {
+	g := &GRPCSink{subscribers: map[*grpcSubscriber]bool{}, queue: 1}
+	if history > 0 {
+		g.history = NewReportHistory(history)
+		g.queue = history
+	}
+	return g
+}
+
+// ListenAndServeTLS serves subscribers at address.  gRPC needs HTTP/2,
+// which is only served over TLS, with the certificate and key in cert_file
+// and key_file.
+func (g *GRPCSink) ListenAndServeTLS(address string, cert_file string, key_file string) error {
+	server := &http.Server{Addr: address, Handler: g}
+	return server.ListenAndServeTLS(cert_file, key_file)
+}
+
+func (g *GRPCSink) Send(report *Report) error {
+	g.Lock.Lock()
+	defer g.Lock.Unlock()
+	if g.history != nil {
+		g.history.Push(report)
+	}
+	for subscriber := range g.subscribers {
+		select {
+		case subscriber.reports <- report:
+		default:
+			// ... ended, rather than left to miss this report
+			delete(g.subscribers, subscriber)
+			close(subscriber.behind)
+		}
+	}
+	return nil
+}
+
+func (g *GRPCSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	if r.URL.Path != GRPC_SUBSCRIBE_PATH {
+		w.Header().Set("Grpc-Status", GRPC_UNIMPLEMENTED)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	subscriber, err := readGRPCSubscribe(r.Body)
+	if err != nil {
+		w.Header().Set("Grpc-Status", GRPC_INVALID_ARGUMENT)
+		w.Header().Set("Grpc-Message", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	// ... subscribing and taking the history together, so that no report is
+	// missed or sent twice in between, while reports sent meanwhile queue
+	subscriber.reports = make(chan *Report, g.queue)
+	subscriber.behind = make(chan struct{})
+	var replay []*Report
+	g.Lock.Lock()
+	g.subscribers[subscriber] = true
+	if subscriber.replay && g.history != nil {
+		replay = g.history.Reports()
+	}
+	g.Lock.Unlock()
+	defer func() {
+		g.Lock.Lock()
+		delete(g.subscribers, subscriber)
+		g.Lock.Unlock()
+	}()
+
+	for _, report := range replay {
+		if err := writeGRPCReport(w, report, subscriber); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case report := <-subscriber.reports:
+			if err := writeGRPCReport(w, report, subscriber); err != nil {
+				return
+			}
+		case <-subscriber.behind:
+			w.Header().Set("Grpc-Status", GRPC_RESOURCE_EXHAUSTED)
+			w.Header().Set("Grpc-Message", "Subscriber fell behind")
+			return
+		case <-r.Context().Done():
+			w.Header().Set("Grpc-Status", GRPC_OK)
+			return
+		}
+	}
+}
+
+// writeGRPCReport writes a report a subscriber asked for in a gRPC frame.
+func writeGRPCReport(w http.ResponseWriter, report *Report, subscriber *grpcSubscriber) error {
+	msg := encodeGRPCReport(report, subscriber)
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	if _, err := w.Write(append(frame, msg...)); err != nil {
+		return err
+	}
+	w.(http.Flusher).Flush()
+	return nil
+}
+
+// readGRPCSubscribe reads a SubscribeRequest, in a single gRPC frame, into
+// a new subscriber.
+func readGRPCSubscribe(body io.Reader) (*grpcSubscriber, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(body, header); err != nil {
+		return nil, err
+	} else if header[0] != 0 {
+		return nil, errors.New("Compressed requests aren't supported")
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > GRPC_MAX_REQUEST_BYTES {
+		return nil, errors.New("Request too large")
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(body, msg); err != nil {
+		return nil, err
+	}
+
+	subscriber := &grpcSubscriber{}
+	d := &protoDecoder{data: msg}
+	for d.More() {
+		field, value := d.Field()
+		switch field {
+		case 1:
+			subscriber.namespaces = append(subscriber.namespaces, string(value))
+		case 2:
+			if subscriber.commands == nil {
+				subscriber.commands = map[string]bool{}
+			}
+			subscriber.commands[string(value)] = true
+		case 3:
+			replay, _ := binary.Uvarint(value)
+			subscriber.replay = replay != 0
+		}
+	}
+	return subscriber, d.err
+}
+
+// wants returns whether a subscriber asked for a metric.
+func (s *grpcSubscriber) wants(m *Metric) bool {
+	_, key, command := m.Name.SplitKeyCommand()
+	if s.commands != nil && !s.commands[command] {
+		return false
+	} else if len(s.namespaces) == 0 {
+		return true
+	}
+	for _, namespace := range s.namespaces {
+		if key != "" && strings.HasPrefix(key, namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeGRPCReport encodes the metrics in a report a subscriber asked for
+// as a Report message.
+func encodeGRPCReport(report *Report, subscriber *grpcSubscriber) []byte {
+	msg := &protoEncoder{}
+	msg.Varint(1, uint64(report.Time.Unix()))
+	for _, m := range report.Metrics {
+		if !subscriber.wants(m) {
+			continue
+		}
+		metric := &protoEncoder{}
+		metric.Delimited(1, []byte(m.Name.Family()))
+		metric.Double(2, m.Value)
+		if m.Counter {
+			metric.Varint(3, 1)
+		}
+		labels := m.Name.Labels()
+		sort.SliceStable(labels, func(i, j int) bool { return labels[i].Label < labels[j].Label })
+		for _, label := range labels {
+			entry := &protoEncoder{}
+			entry.Delimited(1, []byte(label.Label))
+			entry.Delimited(2, []byte(label.Value))
+			metric.Delimited(4, entry.Bytes())
+		}
+		msg.Delimited(2, metric.Bytes())
+	}
+	return msg.Bytes()
+}
+
+// protoEncoder writes protobuf fields.
+type protoEncoder struct {
+	bytes.Buffer
+}
+
+func (e *protoEncoder) tag(field int, wire_type int) {
+	e.varint(uint64(field<<3 | wire_type))
+}
+
+func (e *protoEncoder) varint(v uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	e.Write(buf[:binary.PutUvarint(buf, v)])
+}
+
+func (e *protoEncoder) Varint(field int, v uint64) {
+	e.tag(field, 0)
+	e.varint(v)
+}
+
+func (e *protoEncoder) Double(field int, v float64) {
+	e.tag(field, 1)
+	binary.Write(e, binary.LittleEndian, math.Float64bits(v))
+}
+
+// Delimited writes a length-delimited field, i.e. a string, bytes or an
+// embedded message.
+func (e *protoEncoder) Delimited(field int, value []byte) {
+	e.tag(field, 2)
+	e.varint(uint64(len(value)))
+	e.Write(value)
+}
+
+// protoDecoder reads protobuf fields.  After the first error, there are no
+// more fields, and err is set.
+type protoDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *protoDecoder) More() bool {
+	return d.err == nil && len(d.data) > 0
+}
+
+// Field reads the next field, returning its number and, if it is
+// length-delimited, its value, or if it is a varint, its encoding.  Fields
+// of other types are skipped over.
+func (d *protoDecoder) Field() (int, []byte) {
+	tag := d.varint()
+	field, wire_type := int(tag>>3), int(tag&7)
+	switch wire_type {
+	case 0:
+		start := d.data
+		d.varint()
+		if d.err == nil {
+			return field, start[:len(start)-len(d.data)]
+		}
+	case 1:
+		d.next(8)
+	case 2:
+		return field, d.next(int(d.varint()))
+	case 5:
+		d.next(4)
+	default:
+		d.err = errors.New("Unsupported protobuf wire type")
+	}
+	return field, nil
+}
+
+func (d *protoDecoder) varint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errors.New("Malformed protobuf varint")
+		d.data = nil
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *protoDecoder) next(n int) []byte {
+	if d.err != nil || n < 0 || n > len(d.data) {
+		if d.err == nil {
+			d.err = errors.New("Protobuf message truncated")
+		}
+		d.data = nil
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// grpcFrame wraps a message in a gRPC frame.
+func grpcFrame(msg []byte) []byte {
+	frame := make([]byte, 5)
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+// decodeGRPCMetrics returns the name and labels of each metric in a Report
+// message, as "name{label=value,...}".
+func decodeGRPCMetrics(msg []byte) []string {
+	metrics := []string{}
+	d := &protoDecoder{data: msg}
+	for d.More() {
+		field, value := d.Field()
+		if field != 2 {
+			continue
+		}
+		metric := ""
+		labels := ""
+		md := &protoDecoder{data: value}
+		for md.More() {
+			field, value := md.Field()
+			switch field {
+			case 1:
+				metric = string(value)
+			case 4:
+				ld := &protoDecoder{data: value}
+				_, label := ld.Field()
+				_, label_value := ld.Field()
+				labels += string(label) + "=" + string(label_value) + ","
+			}
+		}
+		metrics = append(metrics, metric+"{"+labels+"}")
+	}
+	return metrics
+}
+
+func TestGRPCSink(t *testing.T) {
+	sink := NewGRPCSink(0)
+	server := httptest.NewUnstartedServer(sink)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	/* Subscribe to keys starting with "user" hit by gets */
+	request := &protoEncoder{}
+	request.Delimited(1, []byte("user"))
+	request.Delimited(2, []byte("get"))
+	req, _ := http.NewRequest("POST", server.URL+GRPC_SUBSCRIBE_PATH,
+		bytes.NewReader(grpcFrame(request.Bytes())))
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("Expected HTTP/2, got %s\n", resp.Proto)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		sink.Lock.Lock()
+		subscribed := len(sink.subscribers)
+		sink.Lock.Unlock()
+		if subscribed == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	report := NewReport(time.Unix(1500000000, 0))
+	keys := NewMetricName("mcsauna.commands").Label("command", "get").Append("keys")
+	report.Count(keys.Label("key", "user_1"), 3)
+	report.Count(keys.Label("key", "session_1"), 2)
+	report.Count(NewMetricName("mcsauna.commands").Label("command", "set").Append("keys").
+		Label("key", "user_1"), 1)
+	report.Count(NewMetricName("mcsauna.keys").Label("key", "user_1"), 4)
+	sink.Send(report)
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(resp.Body, header); err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	if _, err := io.ReadFull(resp.Body, msg); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"mcsauna.commands.keys{command=get,key=user_1,}"}
+	if metrics := decodeGRPCMetrics(msg); !stringsEqual(metrics, expected) {
+		t.Errorf("Expected %q, got %q\n", expected, metrics)
+	}
+}
+
+func TestGRPCSinkUnimplemented(t *testing.T) {
+	server := httptest.NewUnstartedServer(NewGRPCSink(0))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL+"/mcsauna.Reports/Unknown",
+		bytes.NewReader(grpcFrame(nil)))
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if status := resp.Header.Get("Grpc-Status"); status != GRPC_UNIMPLEMENTED {
+		t.Errorf("Expected status %s, got %q\n", GRPC_UNIMPLEMENTED, status)
+	}
+}
+
+func TestGRPCSinkReplay(t *testing.T) {
+	sink := NewGRPCSink(2)
+	server := httptest.NewUnstartedServer(sink)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	/* Reports sent before subscribing are replayed, oldest first, up to
+	 * the size of the history */
+	for hits := 1; hits <= 3; hits++ {
+		sink.Send(testReport(time.Unix(1500000000+int64(hits), 0), hits))
+	}
+	request := &protoEncoder{}
+	request.Varint(3, 1)
+	req, _ := http.NewRequest("POST", server.URL+GRPC_SUBSCRIBE_PATH,
+		bytes.NewReader(grpcFrame(request.Bytes())))
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	for _, expected := range []uint64{1500000002, 1500000003} {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(resp.Body, header); err != nil {
+			t.Fatal(err)
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(header[1:]))
+		if _, err := io.ReadFull(resp.Body, msg); err != nil {
+			t.Fatal(err)
+		}
+		d := &protoDecoder{data: msg}
+		field, value := d.Field()
+		if timestamp, _ := binary.Uvarint(value); field != 1 || timestamp != expected {
+			t.Errorf("Expected a report at %d, got %d\n", expected, timestamp)
+		}
+	}
+}
+
+func TestGRPCSinkBehind(t *testing.T) {
+	sink := NewGRPCSink(2)
+	subscriber := &grpcSubscriber{reports: make(chan *Report, sink.queue), behind: make(chan struct{})}
+	sink.subscribers[subscriber] = true
+
+	/* Reports queue, up to the size of the history, for a subscriber that
+	 * isn't taking them */
+	for hits := 1; hits <= 2; hits++ {
+		sink.Send(testReport(time.Unix(1500000000+int64(hits), 0), hits))
+	}
+	select {
+	case <-subscriber.behind:
+		t.Errorf("Expected a subscriber with a queue to keep up\n")
+	default:
+	}
+	if len(subscriber.reports) != 2 || !sink.subscribers[subscriber] {
+		t.Errorf("Expected 2 reports queued, got %d\n", len(subscriber.reports))
+	}
+
+	/* Any more, and it's ended rather than left to miss one */
+	sink.Send(testReport(time.Unix(1500000003, 0), 3))
+	select {
+	case <-subscriber.behind:
+	default:
+		t.Errorf("Expected a subscriber falling behind to be ended\n")
+	}
+	if sink.subscribers[subscriber] {
+		t.Errorf("Expected a subscriber falling behind to be unsubscribed\n")
+	}
+	/* ... and only once */
+	sink.Send(testReport(time.Unix(1500000004, 0), 4))
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Number of hex digits of a key's hash to report it by
+const HASHED_KEY_DIGITS = 12
+
+// hashKey replaces key with a hash of it, so that it can be reported
+// without revealing what it is.  If delimiter is set and found in key, the
+// part of key up to and including it is kept, so that e.g. "user:1234" is
+// still recognisable as a "user:" key.
+func hashKey(key string, delimiter string) string {
+	prefix := ""
+	if delimiter != "" {
+		if i := strings.Index(key, delimiter); i != -1 {
+			prefix, key = key[:i+len(delimiter)], key[i+len(delimiter):]
+		}
+	}
+	sum := sha256.Sum256([]byte(key))
+	return prefix + hex.EncodeToString(sum[:])[:HASHED_KEY_DIGITS]
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+)
+
+type HashKeyTest struct {
+	Key       string
+	Delimiter string
+	Expected  string
+}
+
+var HASH_KEY_TEST_TABLE = []HashKeyTest{
+	HashKeyTest{"1234", "", "03ac674216f3"},
+	HashKeyTest{"user:1234", ":", "user:03ac674216f3"},
+	HashKeyTest{"user:x:1234", ":", "user:" + hashKey("x:1234", "")},
+	HashKeyTest{"1234", ":", "03ac674216f3"},
+}
+
+func TestHashKey(t *testing.T) {
+	for test_i, test := range HASH_KEY_TEST_TABLE {
+		actual := hashKey(test.Key, test.Delimiter)
+		if actual != test.Expected {
+			t.Errorf("Test %d: expected %s to hash to %s, got %s\n",
+				test_i, test.Key, test.Expected, actual)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sync"
+)
+
+// ReportHistory keeps the latest reports, up to a limit, in a ring, so
+// that what was hot before anyone started looking can still be seen.
+type ReportHistory struct {
+	Lock sync.Mutex
+
+	// Ring of reports, oldest first from next
+	reports []*Report
+	next    int
+}
+
+func NewReportHistory(size int) *ReportHistory {
+	h := &ReportHistory{}
+	h.reports = make([]*Report, size)
+	return h
+}
+
+// Push adds a report to the history, replacing the oldest.
+func (h *ReportHistory) Push(report *Report) {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+
+	h.reports[h.next] = report
+	h.next = (h.next + 1) % len(h.reports)
+}
+
+// Reports returns the reports in the history, oldest first.
+func (h *ReportHistory) Reports() []*Report {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+
+	reports := []*Report{}
+	for i := range h.reports {
+		if report := h.reports[(h.next+i)%len(h.reports)]; report != nil {
+			reports = append(reports, report)
+		}
+	}
+	return reports
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReportHistory(t *testing.T) {
+	history := NewReportHistory(3)
+	if reports := history.Reports(); len(reports) != 0 {
+		t.Errorf("Expected an empty history, got %d reports\n", len(reports))
+	}
+	reports := []*Report{}
+	for i := 0; i < 5; i++ {
+		report := NewReport(time.Unix(1500000000+int64(i), 0))
+		reports = append(reports, report)
+		history.Push(report)
+		if i == 1 {
+			if kept := history.Reports(); len(kept) != 2 || kept[0] != reports[0] || kept[1] != reports[1] {
+				t.Errorf("Expected the first two reports, got %v\n", kept)
+			}
+		}
+	}
+	kept := history.Reports()
+	if len(kept) != 3 || kept[0] != reports[2] || kept[1] != reports[3] || kept[2] != reports[4] {
+		t.Errorf("Expected the last three reports, oldest first, got %v\n", kept)
+	}
+}
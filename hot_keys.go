@@ -2,6 +2,7 @@ package main
 
 import (
 	"container/heap"
+	"sort"
 	"sync"
 )
 
@@ -34,68 +35,341 @@ func (h *KeyHeap) Pop() interface{} {
 	return x
 }
 
-type HotKeyPool struct {
+// keyCounter stores the hit counts behind a HotKeyPool.  Implementations
+// need not be safe for concurrent use, since the pool serializes access.
+type keyCounter interface {
+	// Incr adds n hits to key
+	Incr(key string, n int)
+
+	// Hits returns the number of hits for key
+	Hits(key string) int
+
+	// Keys returns every key being tracked, along with its hits
+	Keys() []*Key
+}
+
+// exactCounter counts the hits of every key exactly, using memory in
+// proportion to the number of distinct keys.
+type exactCounter map[string]int
+
+func newExactCounter() keyCounter {
+	return exactCounter{}
+}
+
+func (c exactCounter) Incr(key string, n int) {
+	c[key] += n
+}
+
+func (c exactCounter) Hits(key string) int {
+	return c[key]
+}
+
+func (c exactCounter) Keys() []*Key {
+	keys := make([]*Key, 0, len(c))
+	for key, hits := range c {
+		keys = append(keys, &Key{key, hits})
+	}
+	return keys
+}
+
+// hotKeyShard holds the counts for a subset of the keys in a HotKeyPool,
+// under its own lock.
+type hotKeyShard struct {
 	Lock sync.Mutex
 
-	// Map of keys to hits
-	items map[string]int
+	// nil until the first hit, so that pools that are never counted in,
+	// e.g. for features that are off, cost nothing, even with a sketch
+	counter keyCounter
+}
+
+// evictingCounter is implemented by counters that forget keys to stay within
+// a memory limit.
+type evictingCounter interface {
+	// Evictions returns the number of keys that have been forgotten
+	Evictions() int
+}
+
+// Rough number of bytes each key takes up in a counter, besides the key
+// itself
+const COUNTER_ENTRY_OVERHEAD = 48
+
+// cappedCounter counts hits exactly, but when it holds more than max_keys
+// keys, or more than max_bytes bytes of keys, it evicts the keys with the
+// fewest hits.  A limit of zero means no limit.
+type cappedCounter struct {
+	exactCounter
+
+	max_keys  int
+	max_bytes int
+	bytes     int
+	evictions int
+}
+
+func newCappedCounter(max_keys int, max_bytes int) *cappedCounter {
+	return &cappedCounter{
+		exactCounter: exactCounter{},
+		max_keys:     max_keys,
+		max_bytes:    max_bytes,
+	}
+}
+
+func (c *cappedCounter) Incr(key string, n int) {
+	if _, ok := c.exactCounter[key]; !ok {
+		c.bytes += len(key) + COUNTER_ENTRY_OVERHEAD
+	}
+	c.exactCounter[key] += n
+
+	if (c.max_keys > 0 && len(c.exactCounter) > c.max_keys) ||
+		(c.max_bytes > 0 && c.bytes > c.max_bytes) {
+		c.evict()
+	}
+}
+
+// evict forgets the keys with the fewest hits, until the counter is back
+// under 90% of its limits.  Evicting more than is strictly needed means the
+// cost of finding the coldest keys is only paid once in a while.
+func (c *cappedCounter) evict() {
+	keys := c.Keys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Hits < keys[j].Hits })
+	for _, key := range keys {
+		if (c.max_keys == 0 || len(c.exactCounter) <= c.max_keys*9/10) &&
+			(c.max_bytes == 0 || c.bytes <= c.max_bytes*9/10) {
+			return
+		}
+		delete(c.exactCounter, key.Name)
+		c.bytes -= len(key.Name) + COUNTER_ENTRY_OVERHEAD
+		c.evictions += 1
+	}
+}
+
+func (c *cappedCounter) Evictions() int {
+	return c.evictions
+}
+
+// HotKeyPool counts hits by key.  Keys are split across shards by hash, each
+// with its own lock, so that counting can continue in one shard while
+// another is busy.
+type HotKeyPool struct {
+	shards []*hotKeyShard
+
+	// How to make a new, empty, counter for a shard on rotation
+	new_counter func() keyCounter
 }
 
 func NewHotKeyPool() *HotKeyPool {
+	return newHotKeyPool(newExactCounter, 1)
+}
+
+func newHotKeyPool(new_counter func() keyCounter, num_shards int) *HotKeyPool {
 	h := &HotKeyPool{}
-	h.items = make(map[string]int)
+	h.shards = make([]*hotKeyShard, num_shards)
+	for i := range h.shards {
+		h.shards[i] = &hotKeyShard{}
+	}
+	h.new_counter = new_counter
 	return h
 }
 
+func (h *HotKeyPool) shard(key string) *hotKeyShard {
+	if len(h.shards) == 1 {
+		return h.shards[0]
+	}
+	// ... scramble the hash, so keys in the same shard don't all share the
+	// ... same low bits, which sketches use to pick counters
+	hash, _ := keyHashes(key)
+	return h.shards[(hash*0x9e3779b1>>16)%uint32(len(h.shards))]
+}
+
 // Add adds a new key to the hit counter or increments the key's hit counter
 // if it is already present.
 func (h *HotKeyPool) Add(keys []string) {
-	h.Lock.Lock()
-	defer h.Lock.Unlock()
-
 	for _, key := range keys {
-		if _, ok := h.items[key]; ok {
-			h.items[key] += 1
-		} else {
-			h.items[key] = 1
-		}
+		h.AddN(key, 1)
+	}
+}
+
+// AddN adds n hits to a single key.
+func (h *HotKeyPool) AddN(key string, n int) {
+	shard := h.shard(key)
+	shard.Lock.Lock()
+	defer shard.Lock.Unlock()
 
+	if shard.counter == nil {
+		shard.counter = h.new_counter()
 	}
+	shard.counter.Incr(key, n)
 }
 
 // GetTopKeys returns a KeyHeap object.  Keys can be popped from the
 // resulting object and will be ordered by hits, descending.
 func (h *HotKeyPool) GetTopKeys() *KeyHeap {
-	h.Lock.Lock()
-	defer h.Lock.Unlock()
-
-	top_keys := &KeyHeap{}
-	heap.Init(top_keys)
-
-	for key, hits := range h.items {
-		heap.Push(top_keys, &Key{key, hits})
+	// ... each key only lives in one shard, so merging them is just a
+	// ... matter of collecting all their keys together
+	top_keys := KeyHeap{}
+	for _, shard := range h.shards {
+		shard.Lock.Lock()
+		if shard.counter != nil {
+			top_keys = append(top_keys, shard.counter.Keys()...)
+		}
+		shard.Lock.Unlock()
 	}
-	return top_keys
+	heap.Init(&top_keys)
+	return &top_keys
 }
 
 func (h *HotKeyPool) GetHits(key string) int {
-	h.Lock.Lock()
-	defer h.Lock.Unlock()
-	return h.items[key]
+	shard := h.shard(key)
+	shard.Lock.Lock()
+	defer shard.Lock.Unlock()
+	if shard.counter == nil {
+		return 0
+	}
+	return shard.counter.Hits(key)
+}
+
+// Merge adds the hits from other to h.
+func (h *HotKeyPool) Merge(other *HotKeyPool) {
+	for _, key := range *other.GetTopKeys() {
+		h.AddN(key.Name, key.Hits)
+	}
 }
 
 // Rotate clears the data on the existing HotKeyPool, returning a new pool
 // containing the old data.  This allows sorting and reporting to happen in
 // another goroutine, while counting can continue on new keys.
 func (h *HotKeyPool) Rotate() *HotKeyPool {
-	h.Lock.Lock()
-	defer h.Lock.Unlock()
+	new_hot_key_pool := &HotKeyPool{}
+	new_hot_key_pool.shards = make([]*hotKeyShard, len(h.shards))
+	new_hot_key_pool.new_counter = h.new_counter
+
+	for i, shard := range h.shards {
+		shard.Lock.Lock()
+
+		// Clone existing
+		new_hot_key_pool.shards[i] = &hotKeyShard{counter: shard.counter}
+
+		// Clear existing values
+		shard.counter = nil
+		shard.Lock.Unlock()
+	}
+	return new_hot_key_pool
+}
+
+// Evictions returns the number of keys that have been forgotten to keep
+// within a memory limit.
+func (h *HotKeyPool) Evictions() int {
+	evictions := 0
+	for _, shard := range h.shards {
+		shard.Lock.Lock()
+		if counter, ok := shard.counter.(evictingCounter); ok {
+			evictions += counter.Evictions()
+		}
+		shard.Lock.Unlock()
+	}
+	return evictions
+}
+
+// keyHashes returns two independent hashes of key, used to pick its shard
+// and its counters in a sketch.
+func keyHashes(key string) (uint32, uint32) {
+	hash := keyHash64(key)
+	// ... the second hash must be odd, so that rows of a sketch don't
+	// ... collide when the width is a power of two
+	return uint32(hash), uint32(hash>>32) | 1
+}
+
+// keyHash64 is FNV-1a, inlined to avoid allocating for the conversion to
+// []byte.
+func keyHash64(key string) uint64 {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= 1099511628211
+	}
+	return hash
+}
+
+// TaggedHotKeyPool keeps a separate HotKeyPool for each of a set of tags,
+// e.g. one per proxy instance, so keys can be reported per tag.
+type TaggedHotKeyPool struct {
+	Lock sync.Mutex
+
+	// Map of tags to pools
+	pools map[string]*HotKeyPool
+
+	new_counter func() keyCounter
+	num_shards  int
+}
+
+func NewTaggedHotKeyPool() *TaggedHotKeyPool {
+	return newTaggedHotKeyPool(newExactCounter, 1)
+}
+
+func newTaggedHotKeyPool(new_counter func() keyCounter, num_shards int) *TaggedHotKeyPool {
+	t := &TaggedHotKeyPool{}
+	t.pools = make(map[string]*HotKeyPool)
+	t.new_counter = new_counter
+	t.num_shards = num_shards
+	return t
+}
+
+// Add adds keys to the pool for tag, creating it if needed.
+func (t *TaggedHotKeyPool) Add(tag string, keys []string) {
+	t.Lock.Lock()
+	pool, ok := t.pools[tag]
+	if !ok {
+		pool = newHotKeyPool(t.new_counter, t.num_shards)
+		t.pools[tag] = pool
+	}
+	t.Lock.Unlock()
+
+	pool.Add(keys)
+}
+
+// Get returns the pool for tag, or nil if nothing has been tagged with it.
+func (t *TaggedHotKeyPool) Get(tag string) *HotKeyPool {
+	t.Lock.Lock()
+	defer t.Lock.Unlock()
+	return t.pools[tag]
+}
+
+// Tags returns every tag that has been added to, sorted.
+func (t *TaggedHotKeyPool) Tags() []string {
+	t.Lock.Lock()
+	defer t.Lock.Unlock()
+
+	tags := make([]string, 0, len(t.pools))
+	for tag := range t.pools {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Evictions returns the number of keys that have been forgotten to keep
+// within a memory limit, across every tag.
+func (t *TaggedHotKeyPool) Evictions() int {
+	t.Lock.Lock()
+	defer t.Lock.Unlock()
+
+	evictions := 0
+	for _, pool := range t.pools {
+		evictions += pool.Evictions()
+	}
+	return evictions
+}
+
+// Rotate clears the data on the existing TaggedHotKeyPool, returning a new
+// pool containing the old data.
+func (t *TaggedHotKeyPool) Rotate() *TaggedHotKeyPool {
+	t.Lock.Lock()
+	defer t.Lock.Unlock()
 
 	// Clone existing
-	new_hot_key_pool := NewHotKeyPool()
-	new_hot_key_pool.items = h.items
+	new_tagged_pool := newTaggedHotKeyPool(t.new_counter, t.num_shards)
+	new_tagged_pool.pools = t.pools
 
 	// Clear existing values
-	h.items = make(map[string]int)
-	return new_hot_key_pool
+	t.pools = make(map[string]*HotKeyPool)
+	return new_tagged_pool
 }
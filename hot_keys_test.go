@@ -2,6 +2,7 @@ package main
 
 import (
 	"container/heap"
+	"fmt"
 	"testing"
 )
 
@@ -54,3 +55,84 @@ func TestHotKeysClone(t *testing.T) {
 		}
 	}
 }
+
+func TestTaggedHotKeys(t *testing.T) {
+	tagged := NewTaggedHotKeyPool()
+	tagged.Add("b", []string{"foo", "foo"})
+	tagged.Add("a", []string{"bar"})
+
+	rotated := tagged.Rotate()
+	if len(tagged.Tags()) != 0 {
+		t.Errorf("Expected rotated pool to be cleared\n")
+	}
+	if !stringsEqual(rotated.Tags(), []string{"a", "b"}) {
+		t.Errorf("Expected tags [a b], got %v\n", rotated.Tags())
+	}
+	if hits := rotated.Get("b").GetHits("foo"); hits != 2 {
+		t.Errorf("Expected 2 hits for foo in b, got %d\n", hits)
+	}
+	if rotated.Get("c") != nil {
+		t.Errorf("Expected no pool for unused tag\n")
+	}
+}
+
+func TestShardedHotKeys(t *testing.T) {
+	h := newHotKeyPool(newExactCounter, 4)
+	h.Add([]string{"foo", "foo", "baz", "baz", "bar", "baz"})
+
+	rotated := h.Rotate()
+	if h.GetTopKeys().Len() != 0 {
+		t.Errorf("Expected rotated pool to be cleared\n")
+	}
+	top_keys := rotated.GetTopKeys()
+	expected_keys := []string{"baz", "foo", "bar"}
+	for _, key := range expected_keys {
+		popped_key := heap.Pop(top_keys).(*Key)
+		if key != popped_key.Name {
+			t.Errorf("Expected top key %v, got %v\n", key, popped_key.Name)
+		}
+	}
+}
+
+func TestCappedHotKeys(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"max_keys": 10}`))
+	stats := NewStats(config)
+	h := stats.HotKeys
+	for i := 0; i < 5; i++ {
+		h.Add([]string{"foo", "bar"})
+	}
+	for i := 0; i < 20; i++ {
+		h.Add([]string{fmt.Sprintf("noise_%d", i)})
+	}
+
+	if n := h.GetTopKeys().Len(); n > 10 {
+		t.Errorf("Expected at most 10 keys, got %d\n", n)
+	}
+	for _, key := range []string{"foo", "bar"} {
+		if hits := h.GetHits(key); hits != 5 {
+			t.Errorf("Expected hot key %s to survive eviction with 5 hits, got %d\n", key, hits)
+		}
+	}
+	if evictions := stats.Evictions(); evictions < 12 {
+		t.Errorf("Expected at least 12 evictions, got %d\n", evictions)
+	}
+
+	/* A limit smaller than the number of shards still limits each shard */
+	config, _ = NewConfig([]byte(`{"max_keys": 2, "hot_key_shards": 4}`))
+	stats = NewStats(config)
+	for i := 0; i < 20; i++ {
+		stats.HotKeys.Add([]string{fmt.Sprintf("noise_%d", i)})
+	}
+	if n := stats.HotKeys.GetTopKeys().Len(); n > 4 {
+		t.Errorf("Expected at most a key per shard, got %d\n", n)
+	}
+
+	// ... evicting down to 90% of the limit leaves room for just one key
+	counter := newCappedCounter(0, 2*(COUNTER_ENTRY_OVERHEAD+3))
+	counter.Incr("foo", 2)
+	counter.Incr("bar", 1)
+	counter.Incr("baz", 3)
+	if len(counter.exactCounter) != 1 || counter.Hits("baz") != 3 || counter.Evictions() != 2 {
+		t.Errorf("Expected only baz to survive eviction, got %v\n", counter.exactCounter)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const INFLUX_TIMEOUT = 10 * time.Second
+
+var (
+	INFLUX_MEASUREMENT_ESCAPER = strings.NewReplacer(",", `\,`, " ", `\ `)
+	INFLUX_TAG_ESCAPER         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// InfluxSink writes reports in InfluxDB line protocol, to a file, to an
+// InfluxDB (or Telegraf) HTTP write endpoint, or both.
+type InfluxSink struct {
+	// Appended to, if set
+	path string
+
+	// Posted to, if set, e.g. "http://localhost:8086/write?db=mcsauna"
+	url    string
+	client *http.Client
+}
+
+func NewInfluxSink(path string, url string) *InfluxSink {
+	return &InfluxSink{
+		path:   path,
+		url:    url,
+		client: &http.Client{Timeout: INFLUX_TIMEOUT},
+	}
+}
+
+func (s *InfluxSink) Send(report *Report) error {
+	lines := formatInflux(report)
+	if s.path != "" {
+		f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString(lines)
+		if close_err := f.Close(); err == nil {
+			err = close_err
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if s.url != "" {
+		resp, err := s.client.Post(s.url, "text/plain; charset=utf-8",
+			bytes.NewBufferString(lines))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("InfluxDB responded %s", resp.Status)
+		}
+	}
+	return nil
+}
+
+// formatInflux formats a report as InfluxDB line protocol, with a
+// measurement for each family of metric names, the rest of each name as
+// tags, and the value in a "value" field.  Counts are written as integers.
+func formatInflux(report *Report) string {
+	output := ""
+	for _, m := range report.Metrics {
+		line := INFLUX_MEASUREMENT_ESCAPER.Replace(m.Name.Family())
+		for _, label := range m.Name.Labels() {
+			line += "," + INFLUX_TAG_ESCAPER.Replace(label.Label) +
+				"=" + INFLUX_TAG_ESCAPER.Replace(label.Value)
+		}
+		line += " value=" + m.FormatValue()
+		if m.Counter {
+			line += "i"
+		}
+		output += fmt.Sprintf("%s %d\n", line, report.Time.UnixNano())
+	}
+	return output
+}
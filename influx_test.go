@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func testInfluxReport() *Report {
+	report := NewReport(time.Unix(1500000000, 0))
+	report.Count(NewMetricName("mcsauna.commands").Label("command", "get").Append("keys").
+		Label("key", "a b,c=d"), 2)
+	report.Gauge(NewMetricName("mcsauna.distribution.gini"), 0.25)
+	return report
+}
+
+func TestFormatInflux(t *testing.T) {
+	expected := "mcsauna.commands.keys,command=get,key=a\\ b\\,c\\=d value=2i 1500000000000000000\n" +
+		"mcsauna.distribution.gini value=0.250 1500000000000000000\n"
+	if output := formatInflux(testInfluxReport()); output != expected {
+		t.Errorf("Expected %q, got %q\n", expected, output)
+	}
+}
+
+func TestInfluxSink(t *testing.T) {
+	posted := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		posted += string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	f, err := ioutil.TempFile("", "mcsauna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	sink := NewInfluxSink(f.Name(), server.URL+"/write?db=mcsauna")
+	for i := 0; i < 2; i++ {
+		if err := sink.Send(testInfluxReport()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := formatInflux(testInfluxReport()) + formatInflux(testInfluxReport())
+	if posted != expected {
+		t.Errorf("Expected %q to be posted, got %q\n", expected, posted)
+	}
+	if written, _ := ioutil.ReadFile(f.Name()); string(written) != expected {
+		t.Errorf("Expected %q to be appended, got %q\n", expected, written)
+	}
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	sink = NewInfluxSink("", missing.URL+"/write?db=mcsauna")
+	if err := sink.Send(testInfluxReport()); err == nil {
+		t.Errorf("Expected error for failed write\n")
+	}
+}
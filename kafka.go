@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	KAFKA_TIMEOUT   = 10 * time.Second
+	KAFKA_CLIENT_ID = "mcsauna"
+
+	// The largest response we'll read, as the largest request a broker
+	// accepts by default
+	KAFKA_MAX_RESPONSE = 100 << 20
+
+	// API keys and the versions of them we speak
+	KAFKA_PRODUCE          = 0
+	KAFKA_PRODUCE_VERSION  = 3
+	KAFKA_METADATA         = 3
+	KAFKA_METADATA_VERSION = 1
+)
+
+var KAFKA_CRC_TABLE = crc32.MakeTable(crc32.Castagnoli)
+
+// KafkaSink publishes each report, as JSON, to a Kafka topic, keyed by host
+// name so that each host's reports stay in order on one partition.  It
+// speaks just enough of the Kafka protocol to find the leader of that
+// partition and produce to it, waiting for the leader to acknowledge each
+// report.
+type KafkaSink struct {
+	brokers []string
+	topic   string
+	key     string
+
+	// The leader of our partition, once found
+	conn      net.Conn
+	partition int32
+
+	correlation_id int32
+}
+
+// NewKafkaSink returns a sink publishing to topic, bootstrapping from
+// brokers, each a "host:port".  No connection is made until the first
+// report is sent.
+func NewKafkaSink(brokers []string, topic string) *KafkaSink {
+	hostname, _ := os.Hostname()
+	return &KafkaSink{brokers: brokers, topic: topic, key: hostname}
+}
+
+// Send publishes a report.  If it can't be, the connection is dropped, and
+// the leader looked up again for the next report.
+func (k *KafkaSink) Send(report *Report) error {
+	if k.conn == nil {
+		if err := k.connect(); err != nil {
+			return err
+		}
+	}
+	err := k.produce([]byte(k.key), []byte(report.JSON()), report.Time)
+	if err != nil {
+		k.conn.Close()
+		k.conn = nil
+	}
+	return err
+}
+
+// connect asks each broker in turn for the topic's metadata, until one
+// answers, then connects to the leader of the partition our key maps to.
+func (k *KafkaSink) connect() error {
+	err := errors.New("No Kafka brokers configured.")
+	for _, broker := range k.brokers {
+		var conn net.Conn
+		conn, err = net.DialTimeout("tcp", broker, KAFKA_TIMEOUT)
+		if err != nil {
+			continue
+		}
+		k.conn = conn
+		var leaders []string
+		leaders, err = k.metadata()
+		conn.Close()
+		k.conn = nil
+		if err != nil {
+			continue
+		}
+
+		k.partition = int32(keyHash64(k.key) % uint64(len(leaders)))
+		if leaders[k.partition] == "" {
+			return fmt.Errorf("Kafka partition %s/%d has no leader", k.topic, k.partition)
+		}
+		k.conn, err = net.DialTimeout("tcp", leaders[k.partition], KAFKA_TIMEOUT)
+		return err
+	}
+	return err
+}
+
+// metadata returns the address of the leader of each of the topic's
+// partitions, by partition, or "" for any without a leader.
+func (k *KafkaSink) metadata() ([]string, error) {
+	req := &kafkaEncoder{}
+	req.Int32(1)
+	req.Str(k.topic)
+	resp, err := k.request(KAFKA_METADATA, KAFKA_METADATA_VERSION, req.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	brokers := make(map[int32]string)
+	for i, n := 0, resp.Int32(); i < int(n) && resp.err == nil; i++ {
+		node_id := resp.Int32()
+		host := resp.Str()
+		port := resp.Int32()
+		resp.Str() // rack
+		brokers[node_id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	resp.Int32() // controller id
+
+	var leaders []string
+	for i, n := 0, resp.Int32(); i < int(n) && resp.err == nil; i++ {
+		topic_err := resp.Int16()
+		topic := resp.Str()
+		resp.Int8() // is internal
+		partitions := make(map[int32]string)
+		for j, m := 0, resp.Int32(); j < int(m) && resp.err == nil; j++ {
+			resp.Int16() // partition error
+			partition := resp.Int32()
+			partitions[partition] = brokers[resp.Int32()]
+			resp.Int32Array() // replicas
+			resp.Int32Array() // in-sync replicas
+		}
+		if topic != k.topic {
+			continue
+		} else if topic_err != 0 {
+			return nil, fmt.Errorf("Kafka error %d fetching metadata for %s", topic_err, topic)
+		}
+		leaders = make([]string, len(partitions))
+		for partition, leader := range partitions {
+			if int(partition) < len(leaders) {
+				leaders[partition] = leader
+			}
+		}
+	}
+	if resp.err != nil {
+		return nil, resp.err
+	} else if len(leaders) == 0 {
+		return nil, fmt.Errorf("Kafka topic %s not found", k.topic)
+	}
+	return leaders, nil
+}
+
+// produce publishes a single record to our partition, waiting for the
+// leader to acknowledge it.
+func (k *KafkaSink) produce(key []byte, value []byte, now time.Time) error {
+	batch := kafkaRecordBatch(key, value, now)
+
+	req := &kafkaEncoder{}
+	req.Int16(-1) // no transactional id
+	req.Int16(1)  // acks from the leader
+	req.Int32(int32(KAFKA_TIMEOUT / time.Millisecond))
+	req.Int32(1)
+	req.Str(k.topic)
+	req.Int32(1)
+	req.Int32(k.partition)
+	req.Int32(int32(len(batch)))
+	req.Write(batch)
+	resp, err := k.request(KAFKA_PRODUCE, KAFKA_PRODUCE_VERSION, req.Bytes())
+	if err != nil {
+		return err
+	}
+
+	for i, n := 0, resp.Int32(); i < int(n) && resp.err == nil; i++ {
+		resp.Str() // topic
+		for j, m := 0, resp.Int32(); j < int(m) && resp.err == nil; j++ {
+			resp.Int32() // partition
+			if code := resp.Int16(); code != 0 {
+				return fmt.Errorf("Kafka error %d producing to %s/%d", code, k.topic, k.partition)
+			}
+			resp.Int64() // base offset
+			resp.Int64() // log append time
+		}
+	}
+	return resp.err
+}
+
+// request sends a request to the connected broker and returns a decoder
+// for the body of its response.
+func (k *KafkaSink) request(api_key int16, api_version int16, body []byte) (*kafkaDecoder, error) {
+	k.correlation_id += 1
+	req := &kafkaEncoder{}
+	req.Int16(api_key)
+	req.Int16(api_version)
+	req.Int32(k.correlation_id)
+	req.Str(KAFKA_CLIENT_ID)
+	req.Write(body)
+
+	k.conn.SetDeadline(time.Now().Add(KAFKA_TIMEOUT))
+	msg := &kafkaEncoder{}
+	msg.Int32(int32(req.Len()))
+	msg.Write(req.Bytes())
+	if _, err := k.conn.Write(msg.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var size int32
+	if err := binary.Read(k.conn, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	// ... which must at least have room for the correlation id
+	if size < 4 || size > KAFKA_MAX_RESPONSE {
+		return nil, fmt.Errorf("Kafka response of invalid size %d", size)
+	}
+	resp := make([]byte, size)
+	if _, err := io.ReadFull(k.conn, resp); err != nil {
+		return nil, err
+	}
+	decoder := &kafkaDecoder{data: resp}
+	if decoder.Int32() != k.correlation_id {
+		return nil, errors.New("Kafka response out of order")
+	}
+	return decoder, decoder.err
+}
+
+// kafkaRecordBatch encodes a record batch (magic 2) holding a single record.
+func kafkaRecordBatch(key []byte, value []byte, now time.Time) []byte {
+	record := &kafkaEncoder{}
+	record.Int8(0)   // attributes
+	record.Varint(0) // timestamp delta
+	record.Varint(0) // offset delta
+	record.VarBytes(key)
+	record.VarBytes(value)
+	record.Varint(0) // headers
+
+	// Everything covered by the CRC
+	batch := &kafkaEncoder{}
+	timestamp := now.UnixNano() / int64(time.Millisecond)
+	batch.Int16(0) // attributes
+	batch.Int32(0) // last offset delta
+	batch.Int64(timestamp)
+	batch.Int64(timestamp)
+	batch.Int64(-1) // producer id
+	batch.Int16(-1) // producer epoch
+	batch.Int32(-1) // base sequence
+	batch.Int32(1)
+	batch.Varint(int64(record.Len()))
+	batch.Write(record.Bytes())
+
+	header := &kafkaEncoder{}
+	header.Int64(0) // base offset
+	header.Int32(int32(4 + 1 + 4 + batch.Len()))
+	header.Int32(-1) // partition leader epoch
+	header.Int8(2)   // magic
+	header.Int32(int32(crc32.Checksum(batch.Bytes(), KAFKA_CRC_TABLE)))
+	header.Write(batch.Bytes())
+	return header.Bytes()
+}
+
+// kafkaEncoder writes Kafka's primitive types.
+type kafkaEncoder struct {
+	bytes.Buffer
+}
+
+func (e *kafkaEncoder) Int8(v int8)   { e.WriteByte(byte(v)) }
+func (e *kafkaEncoder) Int16(v int16) { binary.Write(e, binary.BigEndian, v) }
+func (e *kafkaEncoder) Int32(v int32) { binary.Write(e, binary.BigEndian, v) }
+func (e *kafkaEncoder) Int64(v int64) { binary.Write(e, binary.BigEndian, v) }
+
+func (e *kafkaEncoder) Str(s string) {
+	e.Int16(int16(len(s)))
+	e.WriteString(s)
+}
+
+// Varint writes a zigzag-encoded variable length integer.
+func (e *kafkaEncoder) Varint(v int64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	e.Write(buf[:binary.PutVarint(buf, v)])
+}
+
+// VarBytes writes bytes prefixed with their length as a varint, or -1 if
+// nil.
+func (e *kafkaEncoder) VarBytes(b []byte) {
+	if b == nil {
+		e.Varint(-1)
+		return
+	}
+	e.Varint(int64(len(b)))
+	e.Write(b)
+}
+
+// kafkaDecoder reads Kafka's primitive types.  After the first error, every
+// read returns zero, and err is set.
+type kafkaDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err == nil && len(d.data) < n {
+		d.err = errors.New("Kafka response truncated")
+	}
+	if d.err != nil {
+		return make([]byte, n)
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *kafkaDecoder) Int8() int8   { return int8(d.next(1)[0]) }
+func (d *kafkaDecoder) Int16() int16 { return int16(binary.BigEndian.Uint16(d.next(2))) }
+func (d *kafkaDecoder) Int32() int32 { return int32(binary.BigEndian.Uint32(d.next(4))) }
+func (d *kafkaDecoder) Int64() int64 { return int64(binary.BigEndian.Uint64(d.next(8))) }
+
+// Str reads a nullable string, returning "" if null.
+func (d *kafkaDecoder) Str() string {
+	n := d.Int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *kafkaDecoder) Int32Array() []int32 {
+	n := d.Int32()
+	values := []int32{}
+	for i := 0; i < int(n) && d.err == nil; i++ {
+		values = append(values, d.Int32())
+	}
+	return values
+}
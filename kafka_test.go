@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeKafkaBroker answers metadata requests with itself as the leader of the
+// only partition of topic, and error_code to every produce request, sending
+// the value of each record produced to values.
+func fakeKafkaBroker(t *testing.T, listener net.Listener, topic string, error_code int16, values chan string) {
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	port_num, _ := strconv.Atoi(port)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			for {
+				var size int32
+				if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+					return
+				}
+				data := make([]byte, size)
+				if _, err := io.ReadFull(conn, data); err != nil {
+					return
+				}
+				req := &kafkaDecoder{data: data}
+				api_key := req.Int16()
+				req.Int16() // version
+				correlation_id := req.Int32()
+				req.Str() // client id
+
+				resp := &kafkaEncoder{}
+				resp.Int32(correlation_id)
+				switch api_key {
+				case KAFKA_METADATA:
+					resp.Int32(1)
+					resp.Int32(7)
+					resp.Str(host)
+					resp.Int32(int32(port_num))
+					resp.Int16(-1) // rack
+					resp.Int32(7)  // controller
+					resp.Int32(1)
+					resp.Int16(0)
+					resp.Str(topic)
+					resp.Int8(0)
+					resp.Int32(1)
+					resp.Int16(0)
+					resp.Int32(0)
+					resp.Int32(7)
+					resp.Int32(1)
+					resp.Int32(7)
+					resp.Int32(1)
+					resp.Int32(7)
+				case KAFKA_PRODUCE:
+					values <- kafkaRecordValue(t, req)
+					resp.Int32(1)
+					resp.Str(topic)
+					resp.Int32(1)
+					resp.Int32(0)
+					resp.Int16(error_code)
+					resp.Int64(0)
+					resp.Int64(-1)
+					resp.Int32(0) // throttle time
+				}
+				msg := &kafkaEncoder{}
+				msg.Int32(int32(resp.Len()))
+				msg.Write(resp.Bytes())
+				conn.Write(msg.Bytes())
+			}
+		}(conn)
+	}
+}
+
+// kafkaRecordValue decodes a produce request, checking the CRC of its
+// record batch, and returns the value of the first record.
+func kafkaRecordValue(t *testing.T, req *kafkaDecoder) string {
+	req.Int16() // transactional id
+	req.Int16() // acks
+	req.Int32() // timeout
+	req.Int32() // topics
+	req.Str()
+	req.Int32() // partitions
+	req.Int32()
+	req.Int32() // record set size
+	req.Int64() // base offset
+	req.Int32() // batch length
+	req.Int32() // partition leader epoch
+	if magic := req.Int8(); magic != 2 {
+		t.Errorf("Expected magic 2, got %d\n", magic)
+	}
+	crc := uint32(req.Int32())
+	if crc != crc32.Checksum(req.data, KAFKA_CRC_TABLE) {
+		t.Errorf("Record batch CRC mismatch\n")
+	}
+	req.next(2 + 4 + 8 + 8 + 8 + 2 + 4)
+	if count := req.Int32(); count != 1 {
+		t.Errorf("Expected 1 record, got %d\n", count)
+	}
+	varint := func() int64 {
+		v, n := binary.Varint(req.data)
+		req.next(n)
+		return v
+	}
+	varint()   // record length
+	req.Int8() // attributes
+	varint()   // timestamp delta
+	varint()   // offset delta
+	req.next(int(varint()))
+	return string(req.next(int(varint())))
+}
+
+func TestKafkaSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	values := make(chan string, 1)
+	go fakeKafkaBroker(t, listener, "mcsauna", 0, values)
+
+	sink := NewKafkaSink([]string{"127.0.0.1:1", listener.Addr().String()}, "mcsauna")
+	report := NewReport(time.Unix(1500000000, 0))
+	report.Count(NewMetricName("mcsauna.keys").Label("key", "foo"), 3)
+	for i := 0; i < 2; i++ {
+		if err := sink.Send(report); err != nil {
+			t.Fatal(err)
+		}
+		if value := <-values; value != report.JSON() {
+			t.Errorf("Expected %q, got %q\n", report.JSON(), value)
+		}
+	}
+}
+
+func TestKafkaSinkError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	values := make(chan string, 1)
+	go fakeKafkaBroker(t, listener, "mcsauna", 6, values)
+
+	sink := NewKafkaSink([]string{listener.Addr().String()}, "mcsauna")
+	if err := sink.Send(NewReport(time.Unix(1500000000, 0))); err == nil {
+		t.Errorf("Expected an error producing to a partition with no leader\n")
+	}
+	<-values
+	if sink.conn != nil {
+		t.Errorf("Expected connection to be dropped after an error\n")
+	}
+}
+
+func TestKafkaSinkUnknownTopic(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go fakeKafkaBroker(t, listener, "other", 0, make(chan string, 1))
+
+	sink := NewKafkaSink([]string{listener.Addr().String()}, "mcsauna")
+	if err := sink.Send(NewReport(time.Unix(1500000000, 0))); err == nil {
+		t.Errorf("Expected an error producing to an unknown topic\n")
+	}
+}
+
+func TestKafkaSinkBadResponseSize(t *testing.T) {
+	for _, size := range []int32{-1, 2, KAFKA_MAX_RESPONSE + 1} {
+		client, broker := net.Pipe()
+		go func() {
+			/* Read the request, then answer with a bogus size */
+			var length int32
+			binary.Read(broker, binary.BigEndian, &length)
+			io.CopyN(ioutil.Discard, broker, int64(length))
+			binary.Write(broker, binary.BigEndian, size)
+			broker.Close()
+		}()
+		sink := &KafkaSink{conn: client}
+		if _, err := sink.request(KAFKA_METADATA, KAFKA_METADATA_VERSION, nil); err == nil {
+			t.Errorf("Expected an error for a response of size %d\n", size)
+		}
+		client.Close()
+	}
+}
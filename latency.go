@@ -0,0 +1,111 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Latency summarizes the latencies of a group of requests.
+type Latency struct {
+	Count int
+	Total time.Duration
+	Max   time.Duration
+}
+
+func (l Latency) Mean() time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+	return l.Total / time.Duration(l.Count)
+}
+
+// LatencyPool keeps track of request latencies by name, where the name may
+// be a command, a key or a regexp group.
+type LatencyPool struct {
+	Lock sync.Mutex
+
+	// Map of names to latency summaries
+	items map[string]*Latency
+}
+
+func NewLatencyPool() *LatencyPool {
+	l := &LatencyPool{}
+	l.items = make(map[string]*Latency)
+	return l
+}
+
+// Add records a request that took d against each of names.
+func (l *LatencyPool) Add(names []string, d time.Duration) {
+	l.Lock.Lock()
+	defer l.Lock.Unlock()
+
+	for _, name := range names {
+		latency, ok := l.items[name]
+		if !ok {
+			latency = &Latency{}
+			l.items[name] = latency
+		}
+		latency.Count += 1
+		latency.Total += d
+		if d > latency.Max {
+			latency.Max = d
+		}
+	}
+}
+
+func (l *LatencyPool) Get(name string) Latency {
+	l.Lock.Lock()
+	defer l.Lock.Unlock()
+
+	if latency, ok := l.items[name]; ok {
+		return *latency
+	}
+	return Latency{}
+}
+
+// GetSlowest returns a KeyHeap of names ordered by mean latency, slowest
+// first.  The "hits" of each entry are its mean latency in microseconds.
+func (l *LatencyPool) GetSlowest() *KeyHeap {
+	l.Lock.Lock()
+	defer l.Lock.Unlock()
+
+	slowest := &KeyHeap{}
+	heap.Init(slowest)
+
+	for name, latency := range l.items {
+		heap.Push(slowest, &Key{name, int(latency.Mean() / time.Microsecond)})
+	}
+	return slowest
+}
+
+// GetCostliest returns a KeyHeap of names ordered by total latency,
+// costliest first.  The "hits" of each entry are its total latency in
+// microseconds.
+func (l *LatencyPool) GetCostliest() *KeyHeap {
+	l.Lock.Lock()
+	defer l.Lock.Unlock()
+
+	costliest := &KeyHeap{}
+	heap.Init(costliest)
+
+	for name, latency := range l.items {
+		heap.Push(costliest, &Key{name, int(latency.Total / time.Microsecond)})
+	}
+	return costliest
+}
+
+// Rotate clears the data on the existing LatencyPool, returning a new pool
+// containing the old data.
+func (l *LatencyPool) Rotate() *LatencyPool {
+	l.Lock.Lock()
+	defer l.Lock.Unlock()
+
+	// Clone existing
+	new_latency_pool := NewLatencyPool()
+	new_latency_pool.items = l.items
+
+	// Clear existing values
+	l.items = make(map[string]*Latency)
+	return new_latency_pool
+}
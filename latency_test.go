@@ -0,0 +1,32 @@
+package main
+
+import (
+	"container/heap"
+	"testing"
+	"time"
+)
+
+func TestLatencyPool(t *testing.T) {
+	l := NewLatencyPool()
+	l.Add([]string{"foo", "bar"}, 2*time.Millisecond)
+	l.Add([]string{"foo"}, 4*time.Millisecond)
+	l.Add([]string{"baz"}, 1*time.Millisecond)
+
+	foo := l.Get("foo")
+	if foo.Count != 2 || foo.Mean() != 3*time.Millisecond || foo.Max != 4*time.Millisecond {
+		t.Errorf("Expected foo to have 2 requests, mean 3ms, max 4ms, got %+v\n", foo)
+	}
+
+	rotated := l.Rotate()
+	if l.Get("foo").Count != 0 {
+		t.Errorf("Expected rotated pool to be cleared\n")
+	}
+
+	slowest := rotated.GetSlowest()
+	for _, expected := range []string{"foo", "bar", "baz"} {
+		key := heap.Pop(slowest).(*Key)
+		if key.Name != expected {
+			t.Errorf("Expected slowest %s, got %s\n", expected, key.Name)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log levels, least severe first
+const (
+	LOG_DEBUG = iota
+	LOG_INFO
+	LOG_WARN
+	LOG_ERROR
+)
+
+var LOG_LEVELS = map[string]int{
+	"debug": LOG_DEBUG,
+	"info":  LOG_INFO,
+	"warn":  LOG_WARN,
+	"error": LOG_ERROR,
+}
+
+var LOG_LEVEL_NAMES = []string{"debug", "info", "warn", "error"}
+
+// The syslog severity of each log level
+var LOG_SYSLOG_SEVERITIES = []int{7, 6, 4, 3}
+
+// Where logs go until the config has been read
+var logger = NewLogger(&writerLogOutput{os.Stderr}, LOG_INFO, false)
+
+// Logger writes mcsauna's own logs, as opposed to the reports it makes, at
+// a level and above.  Each line has the time, level and a message, followed
+// by any number of fields, given as pairs of names and values, either as
+// text, e.g.
+//
+//	2017-07-14T02:40:00Z error Error sending report output=graphite error="connection refused"
+//
+// or as a JSON object.
+type Logger struct {
+	Lock sync.Mutex
+
+	out   logOutput
+	level int
+	json  bool
+}
+
+// logOutput is somewhere log lines are written, e.g. stderr or syslog.
+type logOutput interface {
+	WriteLog(level int, now time.Time, line string) error
+}
+
+func NewLogger(out logOutput, level int, json bool) *Logger {
+	return &Logger{out: out, level: level, json: json}
+}
+
+// NewLoggerFromConfig returns a logger writing where the config says to.
+func NewLoggerFromConfig(config Config) (*Logger, error) {
+	var out logOutput
+	switch config.LogOutput {
+	case "stderr":
+		out = &writerLogOutput{os.Stderr}
+	case "syslog":
+		out = &syslogLogOutput{NewSyslogSink(config.SyslogNetwork, config.SyslogAddress,
+			config.SyslogFacility, config.SyslogSeverity, nil)}
+	case "file":
+		file := newRotatingFile(config.LogFile, int64(config.LogMaxBytes),
+			time.Duration(config.LogMaxAge)*time.Second, config.LogRetain)
+		// ... opened now, so that a file that can't be is found at startup
+		if err := file.open(time.Now()); err != nil {
+			return nil, err
+		}
+		out = &fileLogOutput{file}
+	}
+	return NewLogger(out, LOG_LEVELS[config.LogLevel], config.LogFormat == "json"), nil
+}
+
+func (l *Logger) Debug(msg string, fields ...interface{}) {
+	l.log(LOG_DEBUG, msg, fields)
+}
+
+func (l *Logger) Info(msg string, fields ...interface{}) {
+	l.log(LOG_INFO, msg, fields)
+}
+
+func (l *Logger) Warn(msg string, fields ...interface{}) {
+	l.log(LOG_WARN, msg, fields)
+}
+
+func (l *Logger) Error(msg string, fields ...interface{}) {
+	l.log(LOG_ERROR, msg, fields)
+}
+
+// Fatal logs an error, then exits.
+func (l *Logger) Fatal(msg string, fields ...interface{}) {
+	l.log(LOG_ERROR, msg, fields)
+	os.Exit(EXIT_ERROR)
+}
+
+func (l *Logger) log(level int, msg string, fields []interface{}) {
+	if level < l.level {
+		return
+	}
+	now := time.Now().UTC()
+	line := l.format(now, level, msg, fields)
+	l.Lock.Lock()
+	defer l.Lock.Unlock()
+	// ... there's nowhere left to report failing to log, except failing to
+	// ... rotate the log file, which is still written to
+	err := l.out.WriteLog(level, now, line)
+	var rotate_err *rotateError
+	if errors.As(err, &rotate_err) {
+		l.out.WriteLog(LOG_ERROR, now, l.format(now, LOG_ERROR, "Error rotating log file",
+			[]interface{}{"error", err}))
+	}
+}
+
+// format formats a log line as text or JSON, as the logger writes them.
+func (l *Logger) format(now time.Time, level int, msg string, fields []interface{}) string {
+	if l.json {
+		return formatLogJSON(now, level, msg, fields)
+	}
+	return formatLogText(now, level, msg, fields)
+}
+
+// formatLogText formats a log line as text, quoting any value that has
+// spaces, quotes or equals signs in it.
+func formatLogText(now time.Time, level int, msg string, fields []interface{}) string {
+	parts := []string{now.Format(time.RFC3339), LOG_LEVEL_NAMES[level], msg}
+	for i := 0; i+1 < len(fields); i += 2 {
+		value := fmt.Sprint(fields[i+1])
+		if value == "" || strings.ContainsAny(value, " \t\r\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		parts = append(parts, fmt.Sprintf("%v=%s", fields[i], value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatLogJSON formats a log line as a JSON object, with the time, level
+// and message first, then the fields in order.  Errors are written as
+// their messages.
+func formatLogJSON(now time.Time, level int, msg string, fields []interface{}) string {
+	var buf bytes.Buffer
+	names := []interface{}{"time", "level", "msg"}
+	values := []interface{}{now.Format(time.RFC3339), LOG_LEVEL_NAMES[level], msg}
+	for i := 0; i+1 < len(fields); i += 2 {
+		value := fields[i+1]
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		names = append(names, fmt.Sprint(fields[i]))
+		values = append(values, value)
+	}
+	buf.WriteString("{")
+	for i := range names {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		name, _ := json.Marshal(names[i])
+		value, err := json.Marshal(values[i])
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(values[i]))
+		}
+		buf.Write(name)
+		buf.WriteString(":")
+		buf.Write(value)
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+// writerLogOutput writes log lines to a writer, e.g. stderr or a file.
+type writerLogOutput struct {
+	w io.Writer
+}
+
+func (o *writerLogOutput) WriteLog(level int, now time.Time, line string) error {
+	_, err := io.WriteString(o.w, line+"\n")
+	return err
+}
+
+// fileLogOutput appends log lines to a file, rotating it as it's limited
+// to.
+type fileLogOutput struct {
+	file *rotatingFile
+}
+
+func (o *fileLogOutput) WriteLog(level int, now time.Time, line string) error {
+	return o.file.Write(now, []byte(line+"\n"))
+}
+
+// syslogLogOutput sends log lines to syslog, at the facility of a syslog
+// sink and the severity of each line's level.
+type syslogLogOutput struct {
+	syslog *SyslogSink
+}
+
+func (o *syslogLogOutput) WriteLog(level int, now time.Time, line string) error {
+	priority := o.syslog.priority&^7 | LOG_SYSLOG_SEVERITIES[level]
+	return o.syslog.write(priority, now, line)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatLog(t *testing.T) {
+	now := time.Unix(1500000000, 0).UTC()
+	fields := []interface{}{"output", "graphite", "error", errors.New("connection refused")}
+
+	text := formatLogText(now, LOG_ERROR, "Error sending report", fields)
+	expected := `2017-07-14T02:40:00Z error Error sending report output=graphite error="connection refused"`
+	if text != expected {
+		t.Errorf("Expected %q, got %q\n", expected, text)
+	}
+
+	json := formatLogJSON(now, LOG_ERROR, "Error sending report", fields)
+	expected = `{"time":"2017-07-14T02:40:00Z","level":"error","msg":"Error sending report",` +
+		`"output":"graphite","error":"connection refused"}`
+	if json != expected {
+		t.Errorf("Expected %q, got %q\n", expected, json)
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLogger(&writerLogOutput{&buf}, LOG_WARN, false)
+	l.Debug("debug")
+	l.Info("info")
+	l.Warn("warn")
+	l.Error("error")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " warn warn") ||
+		!strings.HasSuffix(lines[1], " error error") {
+		t.Errorf("Expected only warnings and errors, got %q\n", buf.String())
+	}
+}
+
+func TestLoggerFileRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcsauna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mcsauna.log")
+
+	config, err := NewConfig([]byte(`{"log_output": "file", "log_file": "` + path + `",
+		"log_max_bytes": 100, "log_retain": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLoggerFromConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	/* Each line is 35 bytes, so two fit in a file, and only one rotated
+	 * file is kept
+	 */
+	for i := 0; i < 5; i++ {
+		l.Info("Line", "i", i)
+	}
+	for file, expected := range map[string][]string{
+		path:        {"i=4"},
+		path + ".1": {"i=2", "i=3"},
+		path + ".2": {},
+	} {
+		lines := strings.Fields(readFile(file))
+		found := []string{}
+		for _, field := range lines {
+			if strings.HasPrefix(field, "i=") {
+				found = append(found, field)
+			}
+		}
+		if strings.Join(found, " ") != strings.Join(expected, " ") {
+			t.Errorf("Expected %v in %s, got %q\n", expected, file, readFile(file))
+		}
+	}
+
+	if _, err := NewConfig([]byte(`{"log_max_bytes": 100}`)); err == nil {
+		t.Errorf("Expected an error rotating logs that aren't written to a file\n")
+	}
+}
+
+func TestLoggerFileRotationFailing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcsauna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mcsauna.log")
+	/* The oldest rotated file can't be removed to make room */
+	os.MkdirAll(filepath.Join(path+".1", "busy"), 0755)
+
+	config, err := NewConfig([]byte(`{"log_output": "file", "log_file": "` + path + `",
+		"log_max_bytes": 100, "log_retain": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLoggerFromConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		l.Info("Line", "i", i)
+	}
+
+	/* Every line is kept, and the failure is logged once */
+	logged := readFile(path)
+	for i := 0; i < 5; i++ {
+		if !strings.Contains(logged, fmt.Sprintf(" info Line i=%d\n", i)) {
+			t.Errorf("Expected line %d to be logged, got %q\n", i, logged)
+		}
+	}
+	if failures := strings.Count(logged, "Error rotating log file"); failures != 1 {
+		t.Errorf("Expected the rotation error to be logged once, got %q\n", logged)
+	}
+
+	if _, err := NewConfig([]byte(`{"log_output": "file", "log_file": "/var/log/mcsauna.log",
+		"log_max_age": 86400, "user": "nobody", "chroot": "/var/empty"}`)); err == nil {
+		t.Errorf("Expected an error rotating logs in a chroot\n")
+	}
+}
@@ -2,208 +2,648 @@ package main
 
 import (
 	"container/heap"
-	"flag"
 	"fmt"
-	"github.com/google/gopacket"
-	"github.com/google/gopacket/pcap"
 	"io/ioutil"
+	"os"
+	"strings"
 	"time"
 )
 
 const CAPTURE_SIZE = 9000
 
 // startReportingLoop starts a loop that will periodically output statistics
-// on the hottest keys, and optionally, errors that occured in parsing.
-func startReportingLoop(config Config, hot_keys *HotKeyPool, errors *HotKeyPool) {
-	sleep_duration := time.Duration(config.Interval) * time.Second
-	time.Sleep(sleep_duration)
+// on the hottest keys, and optionally, errors that occured in parsing.  When
+// told to stop, with the reason why, it makes a last report, of what has
+// been counted so far, along with a summary of the whole run, and waits for
+// it to be sent before returning.
+func startReportingLoop(config Config, regexp_keys *RegexpKeys, stats *Stats, capture *CaptureCounter,
+	stop <-chan string, reload <-chan Config, alive <-chan bool) error {
+	sleep_duration := time.Duration(config.Interval)
+	movers := NewTopMovers()
+	deltas := NewKeyDeltas()
+	hostname, _ := os.Hostname()
+	prefix := expandPrefix(config.Prefix, hostname, config.Interface)
+	format, err := newConfigFormat(config)
+	if err != nil {
+		return exitError(EXIT_CONFIG, "Config error: %w", err)
+	}
+	listeners := startListeners(config, prefix, format)
+	sinks := newSinks(config, stats.Self, hostname, prefix, format, listeners)
+	schedule := NewSchedule(time.Now(), sleep_duration)
+	run_summary := NewRunSummary(time.Now(), stats.Window == nil && stats.Decayed == nil)
+	// ... pinged twice as often as systemd expects, between reports, so a
+	// ... report that wedges stops the pings too
+	var watchdog <-chan time.Time
+	watchdog_interval := watchdogInterval()
+	if watchdog_interval > 0 {
+		ticker := time.NewTicker(watchdog_interval / 2)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
 	for {
+		reason := ""
+		select {
+		case <-time.After(schedule.Due().Sub(time.Now())):
+		case <-watchdog:
+			/* Only ping if capture is alive too */
+			select {
+			case <-alive:
+				if err := sdNotify("WATCHDOG=1"); err != nil {
+					logger.Warn("Error pinging the systemd watchdog", "error", err)
+				}
+			case <-time.After(watchdog_interval / 4):
+				logger.Warn("Capture isn't responding, not pinging the systemd watchdog")
+			}
+			continue
+		case reason = <-stop:
+		case new_config := <-reload:
+			/* Switch to the new config from the next report on */
+			new_format, err := newConfigFormat(new_config)
+			if err != nil {
+				logger.Error("Not reloading config", "error", err)
+				continue
+			}
+			config, format = new_config, new_format
+			sinks.Close(FLUSH_TIMEOUT)
+			prefix = expandPrefix(config.Prefix, hostname, config.Interface)
+			sinks = newSinks(config, stats.Self, hostname, prefix, format, listeners)
+			schedule.SetInterval(time.Duration(config.Interval))
+			continue
+		}
 		st := time.Now()
-		rotated_keys := hot_keys.Rotate()
-		top_keys := rotated_keys.GetTopKeys()
-		rotated_errors := errors.Rotate()
-		top_errors := rotated_errors.GetTopKeys()
+		rotated := stats.Rotate()
+		run_summary.Add(rotated)
+		top_keys := rotated.HotKeys.GetTopKeys()
 
-		// Build output
-		output := ""
+		// Build report
+		report := NewReport(st)
 		/* Show keys */
-		i := 0
-		for {
-			if top_keys.Len() == 0 {
-				break
+		/* Check if we've reached the specified key limit, but only if
+		 * the user didn't specify regular expressions to match on. */
+		limit := config.NumItemsToReport
+		if regexp_keys.Len() != 0 {
+			limit = -1
+		}
+		reported_keys := popTopKeys(top_keys, limit, config.MinHits)
+		if config.Deltas {
+			formatDeltas(report, metricName(prefix, "deltas"), deltas, reported_keys)
+		} else {
+			formatKeys(report, metricName(prefix, "keys"), "key", reported_keys)
+		}
+		if config.ReportMix {
+			formatKeyMix(report, metricName(prefix, "mix"), reported_keys,
+				rotated.ClassKeys)
+		}
+		if config.ReportClients {
+			formatKeyClients(report, metricName(prefix, "clients"), reported_keys,
+				rotated.KeyClients)
+		}
+		if config.KeyExamples > 0 {
+			formatKeyExamples(report, metricName(prefix, "examples"), reported_keys,
+				rotated.KeyExamples)
+		}
+		for _, proxy := range rotated.ProxyKeys.Tags() {
+			formatTopKeys(report,
+				metricName(prefix, "proxies").Label("proxy", proxy).Append("keys"), "key",
+				rotated.ProxyKeys.Get(proxy).GetTopKeys(), limit, config.MinHits)
+		}
+		for _, server := range rotated.ServerKeys.Tags() {
+			formatTopKeys(report,
+				metricName(prefix, "servers").Label("server", server).Append("keys"), "key",
+				rotated.ServerKeys.Get(server).GetTopKeys(), limit, config.MinHits)
+		}
+		for _, cmd := range rotated.CommandKeys.Tags() {
+			formatTopKeys(report,
+				metricName(prefix, "commands").Label("command", cmd).Append("keys"), "key",
+				rotated.CommandKeys.Get(cmd).GetTopKeys(), limit, config.MinHits)
+		}
+		/* Show the keys heating up fastest */
+		if config.ReportMovers {
+			absolute, relative := movers.Update(*rotated.HotKeys.GetTopKeys())
+			formatTopKeys(report, metricName(prefix, "risers.absolute"), "key", absolute,
+				config.NumItemsToReport, config.MinHits)
+			formatTopKeys(report, metricName(prefix, "risers.relative"), "key", relative,
+				config.NumItemsToReport, 0)
+		}
+		/* Show keys matched by more than one regexp */
+		if config.ReportRegexpConflicts {
+			formatTopKeys(report, metricName(prefix, "regexp_conflicts"), "regexps",
+				rotated.RegexpConflicts.GetTopKeys(), -1, 0)
+		}
+		/* Show the regexps that took longest to match */
+		if config.ProfileRegexps {
+			formatRegexpCost(report, metricName(prefix, "regexps"),
+				rotated.RegexpCost, config.NumItemsToReport)
+		}
+		/* Show how many keys each regexp matched */
+		if config.ReportRegexpMatches {
+			formatRegexpMatches(report, metricName(prefix, "regexps"), regexp_keys.Names(),
+				rotated.RegexpMatches)
+			report.Count(metricName(prefix, "unmatched"), rotated.Errors.GetHits("match_error"))
+		}
+		/* Show the keys with the sharpest bursts */
+		if config.ReportBurstiness {
+			formatBurstiness(report, metricName(prefix, "burst"), rotated.Burst,
+				time.Duration(config.Interval), config.NumItemsToReport)
+		}
+		/* Show how many keys each get asks for */
+		if config.ReportFanout {
+			formatFanout(report, metricName(prefix, "fanout"), rotated.Fanout, config.FanoutBuckets)
+		}
+		/* Show totals for all traffic */
+		if config.ReportSummary {
+			formatSummary(report, metricName(prefix, "summary"),
+				rotated.Summary, rotated.SummaryDistinct)
+		}
+		/* Show how skewed the workload is */
+		if config.ReportDistribution {
+			formatDistribution(report, metricName(prefix, "distribution"),
+				NewHitDistribution(*rotated.HotKeys.GetTopKeys()))
+		}
+		/* Show keys by bytes on the wire */
+		if config.RankByBytes {
+			formatTopKeys(report, metricName(prefix, "bytes"), "key",
+				rotated.KeyBytes.GetTopKeys(), limit, 0)
+		}
+		/* Show distinct keys */
+		if config.TrackCardinality {
+			report.Gauge(metricName(prefix, "cardinality.keys"),
+				float64(rotated.Cardinality.Count()))
+			for _, group := range rotated.Cardinality.Groups() {
+				report.Gauge(metricName(prefix, "cardinality.groups").Label("group", group),
+					float64(rotated.Cardinality.GroupCount(group)))
 			}
-
-			/* Check if we've reached the specified key limit, but only if
-			 * the user didn't specify regular expressions to match on. */
-			if len(config.Regexps) == 0 && i >= config.NumItemsToReport {
-				break
+		}
+		/* Show how many keys were only seen once */
+		if config.ReportOneHitWonders {
+			report.Gauge(metricName(prefix, "one_hit_wonders.ratio"),
+				rotated.OneHitWonders.Ratio())
+			for _, group := range rotated.OneHitWonders.Groups() {
+				report.Gauge(metricName(prefix, "one_hit_wonders.groups").
+					Label("group", group).Append("ratio"),
+					rotated.OneHitWonders.GroupRatio(group))
 			}
-
-			key := heap.Pop(top_keys)
-			output += fmt.Sprintf("mcsauna.keys.%s %d\n", key.(*Key).Name, key.(*Key).Hits)
-
-			i += 1
 		}
-		/* Show errors */
-		if config.ShowErrors {
-			for top_errors.Len() > 0 {
-				err := heap.Pop(top_errors)
-				output += fmt.Sprintf(
-					"mcsauna.errors.%s %d\n", err.(*Key).Name, err.(*Key).Hits)
+		/* Show namespaces, and suggest regexps for them */
+		if config.DiscoverNamespaces {
+			top_namespaces := rotated.Namespaces.GetTopNamespaces()
+			namespaces := []string{}
+			for top_namespaces.Len() > 0 && len(namespaces) < config.NumItemsToReport {
+				namespace := heap.Pop(top_namespaces).(*Key)
+				namespaces = append(namespaces, namespace.Name)
+				report.Count(metricName(prefix, "namespaces").Label("namespace", namespace.Name),
+					namespace.Hits)
 			}
+			if config.NamespaceSuggestionsFile != "" {
+				suggestions, err := formatNamespaceSuggestions(namespaces)
+				if err == nil {
+					err = ioutil.WriteFile(config.NamespaceSuggestionsFile,
+						suggestions, 0666)
+				}
+				if err != nil {
+					logger.Error("Error writing namespace suggestions",
+						"file", config.NamespaceSuggestionsFile, "error", err)
+				}
+			}
+		}
+		/* Show latencies, slowest first */
+		if config.TrackLatency {
+			formatLatencies(report, metricName(prefix, "latency.commands"), "command",
+				rotated.CommandLatency, -1)
+			formatLatencies(report, metricName(prefix, "latency.keys"), "key",
+				rotated.KeyLatency, config.NumItemsToReport)
+		}
+		/* Show alerts */
+		if len(config.Alerts) > 0 {
+			formatAlerts(report, metricName(prefix, "alerts"),
+				evaluateAlerts(config.Alerts, rotated.HotKeys, rotated.CommandKeys))
+		}
+		/* Show commands parsed, in place of keys */
+		if config.DryRun {
+			formatTopKeys(report, metricName(prefix, "parsed"), "command",
+				rotated.Commands.GetTopKeys(), -1, 0)
+		}
+		/* Show errors */
+		if config.ShowErrors || config.ErrorsOnly || config.DryRun {
+			formatTopKeys(report, metricName(prefix, "errors"), "",
+				rotated.Errors.GetTopKeys(), -1, config.MinHits)
+			formatTopKeys(report, metricName(prefix, "tolerated"), "",
+				rotated.Tolerated.GetTopKeys(), -1, config.MinHits)
 		}
+		for _, client := range rotated.ClientErrors.Tags() {
+			formatTopKeys(report,
+				metricName(prefix, "errors.clients").Label("client", client), "",
+				rotated.ClientErrors.Get(client).GetTopKeys(), -1, config.MinHits)
+		}
+		/* Show self-metrics */
+		formatSelf(report, metricName(prefix, "self"), rotated, capture)
+		report.Gauge(metricName(prefix, "self").Append("skew_ms"),
+			float64(st.Sub(schedule.Due())/time.Millisecond))
 
-		// Write to stdout
-		if !config.Quiet {
-			fmt.Print(output)
+		/* On the way out, summarize the whole run and wait for the last
+		 * report to be sent */
+		if reason != "" {
+			sdNotify("STOPPING=1")
+			formatRunSummary(report, metricName(prefix, "run"), run_summary, st)
+			sinks.Flush(report, FLUSH_TIMEOUT)
+			logger.Info("Stopped", "reason", reason)
+			return nil
 		}
 
-		// Write to file
-		if config.OutputFile != "" {
-			err := ioutil.WriteFile(config.OutputFile, []byte(output), 0666)
-			if err != nil {
-				panic(err)
-			}
+		// Send to stdout, the output file, Graphite and so on
+		sinks.Send(report)
+
+		/* Skip any reports we're too late for, rather than drifting */
+		if overruns := schedule.Advance(time.Now()); overruns > 0 {
+			stats.Self.AddN("interval_overruns", overruns)
+			logger.Warn("Reporting took longer than the interval",
+				"elapsed", time.Now().Sub(st), "skipped", overruns)
 		}
+	}
+}
 
-		elapsed := time.Now().Sub(st)
-		time.Sleep(sleep_duration - elapsed)
+// newConfigFormat returns the format reports are written in, by config.
+func newConfigFormat(config Config) (*ReportFormat, error) {
+	style, err := NewTextStyle(config.OutputSeparator, config.OutputLabels,
+		config.OutputEscape)
+	if err != nil {
+		return nil, err
 	}
+	return NewReportFormat(config.Format, config.Template, config.Timestamps, style)
 }
 
-func main() {
-	config_file := flag.String("c", "", "config file")
-	interval := flag.Int("n", 0, "reporting interval (seconds, default 5)")
-	network_interface := flag.String("i", "", "capture interface (default any)")
-	port := flag.Int("p", 0, "capture port (default 11211)")
-	num_items_to_report := flag.Int("r", 0, "number of items to report (default 20)")
-	quiet := flag.Bool("q", false, "suppress stdout output (default false)")
-	output_file := flag.String("w", "", "file to write output to")
-	show_errors := flag.Bool("e", true, "show errors in parsing as a metric")
-	flag.Parse()
+// startListeners starts the sinks that serve reports to whoever connects,
+// or to the terminal, which are kept across reloads, returning them by name.
+func startListeners(config Config, prefix string, format *ReportFormat) map[string]Sink {
+	listeners := map[string]Sink{}
+	if config.Top {
+		top := NewTopSink(os.Stdout, prefix)
+		go func() {
+			if err := top.Run(os.Stdin); err != nil {
+				logger.Fatal("Error running top", "error", err)
+			}
+			os.Exit(EXIT_OK)
+		}()
+		listeners["top"] = top
+	}
+	if config.SocketPath != "" {
+		socket := NewSocketSink(format)
+		go func() {
+			err := socket.ListenAndServe(config.SocketPath)
+			logger.Fatal("Error serving socket", "path", config.SocketPath, "error", err)
+		}()
+		listeners["socket"] = socket
+	}
+	if config.GRPCAddress != "" {
+		grpc := NewGRPCSink(config.ReportHistory)
+		go func() {
+			err := grpc.ListenAndServeTLS(config.GRPCAddress,
+				config.GRPCCertFile, config.GRPCKeyFile)
+			logger.Fatal("Error serving gRPC", "address", config.GRPCAddress, "error", err)
+		}()
+		listeners["grpc"] = grpc
+	}
+	if config.PrometheusAddress != "" {
+		prometheus := NewPrometheusSink(config.Cumulative)
+		go func() {
+			err := prometheus.ListenAndServe(config.PrometheusAddress)
+			logger.Fatal("Error serving Prometheus", "address", config.PrometheusAddress, "error", err)
+		}()
+		listeners["prometheus"] = prometheus
+	}
+	if config.APIAddress != "" {
+		api := NewAPISink(prefix, config.ReportHistory)
+		go func() {
+			err := api.ListenAndServe(config.APIAddress)
+			logger.Fatal("Error serving API", "address", config.APIAddress, "error", err)
+		}()
+		listeners["api"] = api
+	}
+	if config.ExpvarAddress != "" {
+		expvar := NewExpvarSink(metricName(prefix, "self").String())
+		go func() {
+			err := expvar.ListenAndServe(config.ExpvarAddress)
+			logger.Fatal("Error serving expvar", "address", config.ExpvarAddress, "error", err)
+		}()
+		listeners["expvar"] = expvar
+	}
+	return listeners
+}
 
-	// Parse Config
-	var config Config
-	var err error
-	if *config_file != "" {
-		config_data, _ := ioutil.ReadFile(*config_file)
-		config, err = NewConfig(config_data)
-		if err != nil {
-			panic(err)
+// newSinks returns the sinks reports are sent to by config, including any
+// of listeners, as started by startListeners.
+func newSinks(config Config, self *HotKeyPool, hostname string, prefix string, format *ReportFormat,
+	listeners map[string]Sink) *Sinks {
+	sinks := NewSinks(self)
+	if config.ErrorsFile != "" || config.ErrorsOutputs != nil {
+		is_error := errorMetrics(prefix)
+		if config.ErrorsFile != "" {
+			sinks.Add("errors_file", NewFilteredSink(NewAppendingFileSink(config.ErrorsFile,
+				format, false, 0, 0, 0), is_error))
 		}
-	} else {
-		config, err = NewConfig([]byte{})
+		sinks.Exclude(is_error, config.ErrorsOutputs)
 	}
-
-	// Parse CLI Args
-	if *interval != 0 {
-		config.Interval = *interval
+	if top, ok := listeners["top"]; ok {
+		sinks.Add("top", top)
+	} else if !config.Quiet {
+		sinks.Add("stdout", NewWriterSink(os.Stdout, format))
+	}
+	if config.OutputFile != "" && config.OutputAppend {
+		sinks.Add("file", NewAppendingFileSink(config.OutputFile, format,
+			config.OutputGzip, int64(config.OutputMaxBytes),
+			time.Duration(config.OutputMaxAge)*time.Second, config.OutputRetain))
+	} else if config.OutputFile != "" {
+		sinks.Add("file", NewFileSink(config.OutputFile, format, config.OutputGzip))
+	}
+	if config.GraphiteAddress != "" {
+		sinks.Add("graphite", NewGraphiteSink(config.GraphiteAddress,
+			config.GraphiteBufferBytes))
+	}
+	if config.StatsdAddress != "" && config.Dogstatsd {
+		sinks.Add("statsd", NewDogstatsdSink(config.StatsdProtocol, config.StatsdAddress,
+			config.StatsdPrefix, config.StatsdPacketBytes, config.StatsdTags))
+	} else if config.StatsdAddress != "" {
+		sinks.Add("statsd", NewStatsdSink(config.StatsdProtocol, config.StatsdAddress,
+			config.StatsdPrefix, config.StatsdPacketBytes))
+	}
+	if config.InfluxFile != "" || config.InfluxURL != "" {
+		sinks.Add("influx", NewInfluxSink(config.InfluxFile, config.InfluxURL))
+	}
+	if config.OTLPEndpoint != "" {
+		sinks.Add("otlp", NewOTLPSink(config.OTLPEndpoint, config.OTLPServiceName,
+			config.Cumulative))
+	}
+	if config.Syslog {
+		sinks.Add("syslog", NewSyslogSink(config.SyslogNetwork, config.SyslogAddress,
+			config.SyslogFacility, config.SyslogSeverity, format))
+	}
+	if len(config.KafkaBrokers) > 0 {
+		sinks.Add("kafka", NewKafkaSink(config.KafkaBrokers, config.KafkaTopic))
 	}
-	if *network_interface != "" {
-		config.Interface = *network_interface
+	if config.NATSURL != "" {
+		sinks.Add("nats", NewNATSSink(config.NATSURL,
+			expandPrefix(config.NATSSubject, hostname, config.Interface), hostname))
 	}
-	if *port != 0 {
-		config.Port = *port
+	if config.MemcachedReportAddress != "" {
+		sinks.Add("memcached", NewMemcachedSink(config.MemcachedReportAddress,
+			expandPrefix(config.MemcachedReportKey, hostname, config.Interface),
+			config.MemcachedReportTTL))
 	}
-	if *num_items_to_report != 0 {
-		config.NumItemsToReport = *num_items_to_report
+	if config.WebhookURL != "" {
+		sinks.Add("webhook", NewWebhookSink(config.WebhookURL, config.WebhookHeaders,
+			config.WebhookRetries, time.Duration(config.WebhookTimeout)*time.Second))
 	}
-	if *quiet != false {
-		config.Quiet = *quiet
+	if config.SlackWebhookURL != "" {
+		sinks.Add("slack", NewAlertNotifier(NewSlackChannel(config.SlackWebhookURL, hostname),
+			time.Duration(config.AlertCooldown)*time.Second))
 	}
-	if *output_file != "" {
-		config.OutputFile = *output_file
+	if config.PagerDutyRoutingKey != "" {
+		sinks.Add("pagerduty", NewAlertNotifier(NewPagerDutyChannel(config.PagerDutyRoutingKey,
+			config.PagerDutySeverity, hostname), time.Duration(config.AlertCooldown)*time.Second))
 	}
-	if *show_errors != true {
-		config.ShowErrors = *show_errors
+	if config.SQLiteFile != "" {
+		sinks.Add("sqlite", NewSQLiteSink(config.SQLiteFile,
+			time.Duration(config.SQLiteRetention)*time.Second))
 	}
+	if config.ClickHouseURL != "" {
+		sinks.Add("clickhouse", NewClickHouseSink(config.ClickHouseURL, config.ClickHouseTable,
+			hostname, config.ClickHouseBatch))
+	}
+	if config.ParquetDir != "" {
+		sinks.Add("parquet", NewParquetSink(config.ParquetDir, hostname,
+			time.Duration(config.ParquetRotate)*time.Second))
+	}
+	if config.S3Bucket != "" {
+		sinks.Add("s3", NewS3Sink(config.S3Bucket, config.S3Prefix, hostname, config.S3Region,
+			config.S3Endpoint, config.S3AccessKeyID, config.S3SecretAccessKey))
+	}
+	for _, name := range []string{"socket", "grpc", "prometheus", "api", "expvar"} {
+		if listener, ok := listeners[name]; ok {
+			sinks.Add(name, listener)
+		}
+	}
+	return sinks
+}
+
+// startSlideLoop starts a loop that moves hot keys into the sliding window
+// or decayed scores every period.
+func startSlideLoop(period time.Duration, stats *Stats) {
+	ticker := time.NewTicker(period)
+	for range ticker.C {
+		stats.Slide()
+	}
+}
+
+// formatTopKeys adds up to limit keys from top_keys to report, hottest
+// first, skipping any with fewer than min_hits hits.  A negative limit means
+// no limit.
+func formatTopKeys(report *Report, name MetricName, label string, top_keys *KeyHeap, limit int, min_hits int) {
+	formatKeys(report, name, label, popTopKeys(top_keys, limit, min_hits))
+}
+
+// expandPrefix substitutes the host name and capture interface for
+// "{hostname}" and "{interface}" in a metric prefix.  Dots in the host name
+// are replaced with underscores, so that it is a single part of the name.
+func expandPrefix(prefix string, hostname string, iface string) string {
+	return strings.NewReplacer(
+		"{hostname}", strings.Replace(hostname, ".", "_", -1),
+		"{interface}", iface,
+	).Replace(prefix)
+}
+
+// metricName returns the name of the metric at path under prefix, if any.
+func metricName(prefix string, path string) MetricName {
+	if prefix == "" {
+		return NewMetricName(path)
+	}
+	return NewMetricName(prefix + "." + path)
+}
 
-	// Build Regexps
-	regexp_keys := NewRegexpKeys()
-	for _, re := range config.Regexps {
-		regexp_key, err := NewRegexpKey(re.Re, re.Name)
-		if err != nil {
-			panic(err)
+// popTopKeys pops up to limit keys from top_keys, hottest first, stopping at
+// the first with fewer than min_hits hits.  A negative limit means no limit.
+func popTopKeys(top_keys *KeyHeap, limit int, min_hits int) []*Key {
+	keys := []*Key{}
+	for i := 0; top_keys.Len() > 0 && (limit < 0 || i < limit); i++ {
+		key := heap.Pop(top_keys).(*Key)
+		if key.Hits < min_hits {
+			break
 		}
-		regexp_keys.Add(regexp_key)
+		keys = append(keys, key)
 	}
+	return keys
+}
 
-	hot_keys := NewHotKeyPool()
-	errors := NewHotKeyPool()
+// errorMetrics returns a function returning whether a metric, named under
+// prefix, is for errors, or tolerated deviations, in parsing.
+func errorMetrics(prefix string) func(m *Metric) bool {
+	families := []string{
+		metricName(prefix, "errors").Family() + ".",
+		metricName(prefix, "tolerated").Family() + ".",
+	}
+	return func(m *Metric) bool {
+		family := m.Name.Family()
+		for _, f := range families {
+			if strings.HasPrefix(family, f) {
+				return true
+			}
+		}
+		return false
+	}
+}
 
-	// Setup pcap
-	handle, err := pcap.OpenLive(config.Interface, CAPTURE_SIZE, true, pcap.BlockForever)
-	if err != nil {
-		panic(err)
+// formatKeys adds the hits for each of keys, labelling the keys with label,
+// or leaving them as part of the name of each metric if it is empty.
+func formatKeys(report *Report, name MetricName, label string, keys []*Key) {
+	for _, key := range keys {
+		if label == "" {
+			report.Count(name.Append(key.Name), key.Hits)
+		} else {
+			report.Count(name.Label(label, key.Name), key.Hits)
+		}
 	}
-	filter := fmt.Sprintf("tcp and dst port %d", config.Port)
-	err = handle.SetBPFFilter(filter)
-	if err != nil {
-		panic(err)
+}
+
+// formatSummary adds the totals counted for the interval, and the number
+// of distinct keys.
+func formatSummary(report *Report, name MetricName, summary *HotKeyPool, distinct *CardinalityPool) {
+	for _, total := range []string{"commands", "keys", "reads", "writes", "deletes", "bytes"} {
+		report.Count(name.Append(total), summary.GetHits(total))
 	}
-	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+	report.Gauge(name.Append("distinct_keys"), float64(distinct.Count()))
+}
 
-	go startReportingLoop(config, hot_keys, errors)
+// formatKeyMix adds how many of the hits for each of keys came from each
+// class of command in classes.
+func formatKeyMix(report *Report, name MetricName, keys []*Key, classes *TaggedHotKeyPool) {
+	for _, key := range keys {
+		for _, class := range classes.Tags() {
+			report.Count(name.Label("key", key.Name).Label("class", class),
+				classes.Get(class).GetHits(key.Name))
+		}
+	}
+}
 
-	// Grab a packet
-	var (
-		payload []byte
-		keys    []string
-		cmd_err int
-	)
-	for packet := range packetSource.Packets() {
-		app_data := packet.ApplicationLayer()
-		if app_data == nil {
-			continue
+// formatKeyClients adds the number of distinct clients that requested each
+// of keys.
+func formatKeyClients(report *Report, name MetricName, keys []*Key, clients *KeyClients) {
+	for _, key := range keys {
+		report.Gauge(name.Label("key", key.Name), float64(clients.Count(key.Name)))
+	}
+}
+
+// formatDeltas adds the keys that entered and departed since the last
+// interval, with their hits, and the hits by which those in both rose or
+// fell.  Rises and falls are kept apart so that no value is negative.
+func formatDeltas(report *Report, name MetricName, deltas *KeyDeltas, keys []*Key) {
+	entered, departed, rose, fell := deltas.Update(keys)
+	changes := []string{"entered", "departed", "rose", "fell"}
+	for i, changed := range []*KeyHeap{entered, departed, rose, fell} {
+		for _, key := range popTopKeys(changed, -1, 0) {
+			report.Gauge(name.Append(changes[i]).Label("key", key.Name), float64(key.Hits))
 		}
-		payload = app_data.Payload()
+	}
+}
 
-		// Process data
-		prev_payload_len := 0
-		for len(payload) > 0 {
-			_, keys, payload, cmd_err = parseCommand(payload)
+// formatKeyExamples adds the hits on the raw keys remembered for each of
+// keys, hottest first.
+func formatKeyExamples(report *Report, name MetricName, keys []*Key, examples *KeyExamples) {
+	for _, key := range keys {
+		formatKeys(report, name.Label("key", key.Name), "example",
+			popTopKeys(examples.GetExamples(key.Name), -1, 0))
+	}
+}
 
-			// ... We keep track of the payload length to make sure we don't end
-			// ... up in an infinite loop if one of the processors repeatedly
-			// ... sends us the same remainder.  This should never happen, but
-			// ... if it does, it would be better to move on to the next packet
-			// ... rather than spin CPU doing nothing.
-			if len(payload) == prev_payload_len {
-				break
-			}
-			prev_payload_len = len(payload)
-
-			if cmd_err == ERR_NONE {
-
-				// Raw key
-				if len(config.Regexps) == 0 {
-					hot_keys.Add(keys)
-				} else {
-
-					// Regex
-					matches := []string{}
-					match_errors := []string{}
-					for _, key := range keys {
-						matched_regex, err := regexp_keys.Match(key)
-						if err != nil {
-							match_errors = append(match_errors, "match_error")
-
-							// The user has requested that we also show keys that
-							// weren't matched at all, probably for debugging.
-							if config.ShowUnmatched {
-								matches = append(matches, key)
-							}
-
-						} else {
-							matches = append(matches, matched_regex)
-						}
-					}
-					hot_keys.Add(matches)
-					errors.Add(match_errors)
-				}
-			} else {
-				errors.Add([]string{ERR_TO_STAT[cmd_err]})
-			}
+// formatDistribution adds the percentiles and Gini coefficient of a
+// distribution of hits.
+func formatDistribution(report *Report, name MetricName, d HitDistribution) {
+	report.Gauge(name.Append("p50"), float64(d.P50))
+	report.Gauge(name.Append("p90"), float64(d.P90))
+	report.Gauge(name.Append("p99"), float64(d.P99))
+	report.Gauge(name.Append("gini"), d.Gini)
+}
+
+// formatLatencies adds the mean and max latency of up to limit names in
+// pool, slowest first, labelled with label.  A negative limit means no
+// limit.
+func formatLatencies(report *Report, name MetricName, label string, pool *LatencyPool, limit int) {
+	slowest := pool.GetSlowest()
+	for i := 0; slowest.Len() > 0 && (limit < 0 || i < limit); i++ {
+		slow := heap.Pop(slowest).(*Key).Name
+		latency := pool.Get(slow)
+		report.Gauge(name.Label(label, slow).Append("mean_us"),
+			float64(latency.Mean()/time.Microsecond))
+		report.Gauge(name.Label(label, slow).Append("max_us"),
+			float64(latency.Max/time.Microsecond))
+	}
+}
+
+// formatBurstiness adds the peak hits in a second, and the ratio of that
+// to the average rate over the interval, of up to limit names in pool,
+// burstiest first.
+func formatBurstiness(report *Report, name MetricName, pool *BurstPool, interval time.Duration, limit int) {
+	burstiest := pool.GetBurstiest()
+	for i := 0; burstiest.Len() > 0 && i < limit; i++ {
+		key := heap.Pop(burstiest).(*Key).Name
+		burst := pool.Get(key)
+		report.Gauge(name.Label("key", key).Append("peak"), float64(burst.Peak))
+		report.Gauge(name.Label("key", key).Append("ratio"), burst.Ratio(interval))
+	}
+}
+
+// formatRegexpMatches adds the number of keys matched by each of the named
+// regexps, including those that matched none.
+func formatRegexpMatches(report *Report, name MetricName, regexps []string, matches *HotKeyPool) {
+	for _, regexp_name := range regexps {
+		report.Count(name.Label("regexp", regexp_name).Append("matches"),
+			matches.GetHits(regexp_name))
+	}
+}
+
+// formatRegexpCost adds the number of match attempts and the time spent on
+// them for up to limit regexps in pool, costliest first.
+func formatRegexpCost(report *Report, name MetricName, pool *LatencyPool, limit int) {
+	costliest := pool.GetCostliest()
+	for i := 0; costliest.Len() > 0 && i < limit; i++ {
+		regexp_name := heap.Pop(costliest).(*Key).Name
+		cost := pool.Get(regexp_name)
+		report.Count(name.Label("regexp", regexp_name).Append("attempts"), cost.Count)
+		report.Count(name.Label("regexp", regexp_name).Append("total_us"),
+			int(cost.Total/time.Microsecond))
+		report.Gauge(name.Label("regexp", regexp_name).Append("mean_ns"),
+			float64(cost.Mean()/time.Nanosecond))
+	}
+}
+
+// captureFilter returns the BPF filter for the packets we want to capture:
+// requests to each configured port, and their responses if we're tracking
+// latency.
+func captureFilter(config Config) string {
+	direction := "dst port"
+	if config.TrackLatency {
+		direction = "port"
+	}
+	filter := fmt.Sprintf("%s %d", direction, config.Port)
+	for _, port := range config.Ports {
+		filter += fmt.Sprintf(" or %s %d", direction, port)
+	}
+	return fmt.Sprintf("tcp and (%s)", filter)
+}
+
+func main() {
+	name, args := parseSubcommand(os.Args[1:])
+	command, ok := COMMANDS[name]
+	if !ok {
+		if name != "help" {
+			fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", name)
+		}
+		usage()
+		os.Exit(EXIT_USAGE)
+	}
+	if err := command.Run(args); err != nil {
+		daemonStarted(err)
+		if message := err.Error(); message != "" {
+			fmt.Fprintln(os.Stderr, "mcsauna:", message)
 		}
+		os.Exit(exitStatus(err))
 	}
 }
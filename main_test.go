@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCaptureFilter(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"ports": [11212]}`))
+	if filter := captureFilter(config); filter != "tcp and (dst port 11211 or dst port 11212)" {
+		t.Errorf("Unexpected filter %q\n", filter)
+	}
+}
+
+func TestExpandPrefix(t *testing.T) {
+	prefix := expandPrefix("mcsauna.{hostname}.{interface}", "web1.example.com", "eth0")
+	if prefix != "mcsauna.web1_example_com.eth0" {
+		t.Errorf("Unexpected prefix %q\n", prefix)
+	}
+	if name := metricName("", "keys").String(); name != "keys" {
+		t.Errorf("Expected no prefix, got %q\n", name)
+	}
+	if name := metricName(prefix, "keys").String(); name != "mcsauna.web1_example_com.eth0.keys" {
+		t.Errorf("Unexpected name %q\n", name)
+	}
+}
+
+func TestFormatDistribution(t *testing.T) {
+	report := NewReport(time.Now())
+	formatDistribution(report, NewMetricName("mcsauna.distribution"), HitDistribution{1, 4, 37, 0.8125})
+	output := report.String()
+	expected := "mcsauna.distribution.p50 1\nmcsauna.distribution.p90 4\n" +
+		"mcsauna.distribution.p99 37\nmcsauna.distribution.gini 0.812\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q\n", expected, output)
+	}
+}
+
+func TestFormatKeyMix(t *testing.T) {
+	classes := NewTaggedHotKeyPool()
+	classes.Add("reads", []string{"foo", "foo", "bar"})
+	classes.Add("writes", []string{"foo"})
+
+	report := NewReport(time.Now())
+	formatKeyMix(report, NewMetricName("mcsauna.mix"), []*Key{&Key{"foo", 3}, &Key{"bar", 1}}, classes)
+	output := report.String()
+	expected := "mcsauna.mix.foo.reads 2\nmcsauna.mix.foo.writes 1\n" +
+		"mcsauna.mix.bar.reads 1\nmcsauna.mix.bar.writes 0\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q\n", expected, output)
+	}
+}
+
+func TestFormatTopKeysMinHits(t *testing.T) {
+	h := NewHotKeyPool()
+	h.Add([]string{"foo", "foo", "foo", "bar", "bar", "baz"})
+
+	report := NewReport(time.Now())
+	formatTopKeys(report, NewMetricName("mcsauna.keys"), "key", h.GetTopKeys(), -1, 2)
+	output := report.String()
+	expected := "mcsauna.keys.foo 3\nmcsauna.keys.bar 2\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q\n", expected, output)
+	}
+}
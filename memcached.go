@@ -13,47 +13,93 @@ const (
 	ERR_TRUNCATED
 	ERR_INCOMPLETE_CMD
 	ERR_BAD_BYTES
+	ERR_BAD_PROXY_HEADER
+	ERR_ENCRYPTED
+	ERR_BAD_RESPONSE
 )
 
+/* Parser modes.  Strict mode rejects anything that doesn't conform to the
+ * protocol, while lenient mode tolerates common deviations from it. */
+const (
+	PARSE_STRICT = iota
+	PARSE_LENIENT
+)
+
+var PARSE_MODES = map[string]int{
+	"strict":  PARSE_STRICT,
+	"lenient": PARSE_LENIENT,
+}
+
+/* Deviations from the protocol that are tolerated in lenient mode.  These
+ * are flags, since a single command may have more than one of them. */
+const (
+	TOLERATED_WHITESPACE = 1 << iota
+	TOLERATED_BARE_NEWLINE
+	TOLERATED_UNKNOWN_CMD
+	TOLERATED_EXTRA_FIELDS
+)
+
+var TOLERATED_TO_STAT = map[int]string{
+	TOLERATED_WHITESPACE:   "whitespace",
+	TOLERATED_BARE_NEWLINE: "bare_newline",
+	TOLERATED_UNKNOWN_CMD:  "unknown_cmd",
+	TOLERATED_EXTRA_FIELDS: "extra_fields",
+}
+
+// toleratedStats returns the stat names for each deviation in tolerated.
+func toleratedStats(tolerated int) []string {
+	stats := []string{}
+	for flag, stat := range TOLERATED_TO_STAT {
+		if tolerated&flag != 0 {
+			stats = append(stats, stat)
+		}
+	}
+	return stats
+}
+
 /* A map to translate errors that may arise to the name of the stat that
  * should be reported back when the error occurs. An entry should be added
  * for all non-none errors that can be returned. */
 var ERR_TO_STAT = map[int]string{
-	ERR_NO_CMD:         "no_cmd",
-	ERR_INVALID_CMD:    "invalid_cmd",
-	ERR_TRUNCATED:      "truncated",
-	ERR_INCOMPLETE_CMD: "incomplete_cmd",
-	ERR_BAD_BYTES:      "bad_bytes",
+	ERR_NO_CMD:           "no_cmd",
+	ERR_INVALID_CMD:      "invalid_cmd",
+	ERR_TRUNCATED:        "truncated",
+	ERR_INCOMPLETE_CMD:   "incomplete_cmd",
+	ERR_BAD_BYTES:        "bad_bytes",
+	ERR_BAD_PROXY_HEADER: "bad_proxy_header",
+	ERR_ENCRYPTED:        "encrypted",
+	ERR_BAD_RESPONSE:     "bad_response",
 }
 
-// processSingleKeyNoData processes a "get", "incr", or "decr" command, all
-// of which only allow for a single key to be passed and have no value field.
+// processSingleKeyNoData processes an "incr", "decr" or "delete" command,
+// all of which only allow for a single key to be passed and have no value
+// field.
 //
-// On the wire, "get" looks like:
+// On the wire, "incr" and "decr" look like:
 //
-//     get key\r\n
+//     cmd key value [noreply]\r\n
 //
-// And "incr" and "decr" look like:
+// and "delete" looks like:
 //
-//     cmd key value [noreply]\r\n
+//     delete key [noreply]\r\n
 //
 // Where "noreply" is an optional field that indicates whether the server
 // should return a response.
-func processSingleKeyNoData(first_line string, remainder []byte) (keys []string, processed_remainder []byte, cmd_err int) {
+func processSingleKeyNoData(first_line string, remainder []byte, mode int) (keys []string, data_consumed int, cmd_err int, tolerated int) {
 
 	// Get the key
 	// ... the command should at least consist of "cmd foo", where "foo" is the key
 	split_data := strings.Split(first_line, " ")
 	if len(split_data) <= 1 {
-		return []string{}, remainder, ERR_INCOMPLETE_CMD
+		return []string{}, 0, ERR_INCOMPLETE_CMD, 0
 	}
 	key := split_data[1]
 	if key == "" {
-		return []string{}, remainder, ERR_INCOMPLETE_CMD
+		return []string{}, 0, ERR_INCOMPLETE_CMD, 0
 	}
 
 	// Return parsed data
-	return []string{key}, remainder, ERR_NONE
+	return []string{key}, 0, ERR_NONE, 0
 }
 
 // processSingleKeyWithData processes a "set", "add", "replace", "append", or
@@ -67,12 +113,17 @@ func processSingleKeyNoData(first_line string, remainder []byte) (keys []string,
 //
 // Where "noreply" is an optional field that indicates whether the server
 // should return a response.
-func processSingleKeyWithData(first_line string, remainder []byte) (keys []string, processed_remainder []byte, cmd_err int) {
+//
+// In lenient mode, extra trailing fields and a data block terminated by a
+// bare "\n" are tolerated.
+func processSingleKeyWithData(first_line string, remainder []byte, mode int) (keys []string, data_consumed int, cmd_err int, tolerated int) {
 
 	// Get the key
 	split_data := strings.Split(first_line, " ")
-	if len(split_data) != 5 && len(split_data) != 6 {
-		return []string{}, remainder, ERR_INCOMPLETE_CMD
+	if len(split_data) > 6 && mode == PARSE_LENIENT {
+		tolerated |= TOLERATED_EXTRA_FIELDS
+	} else if len(split_data) != 5 && len(split_data) != 6 {
+		return []string{}, 0, ERR_INCOMPLETE_CMD, 0
 	}
 	key, bytes_str := split_data[1], split_data[4]
 
@@ -81,44 +132,60 @@ func processSingleKeyWithData(first_line string, remainder []byte) (keys []strin
 	// ... the max memcached object size is 1MB, so a 32 bit int will suffice
 	bitSize := 32
 	bytes, err := strconv.ParseInt(bytes_str, base, bitSize)
-	if err != nil {
-		return []string{}, []byte{}, ERR_INVALID_CMD
+	if err != nil || bytes < 0 {
+		// ... we can't know where the data block ends, so the rest of the
+		// ... data is lost
+		return []string{}, len(remainder), ERR_INVALID_CMD, 0
 	}
 
 	// Make sure we got a full command
 	// ... bytes + 2 to account for trailing "\r\n"
 	next_command_idx := bytes + 2
+	if mode == PARSE_LENIENT && int64(len(remainder)) > bytes &&
+		remainder[bytes] == '\n' {
+		next_command_idx = bytes + 1
+		tolerated |= TOLERATED_BARE_NEWLINE
+	}
 	if int64(len(remainder)) < next_command_idx {
-		return []string{}, []byte{}, ERR_TRUNCATED
+		return []string{}, 0, ERR_TRUNCATED, 0
 	}
 
 	// Return parsed data
-	return []string{key}, remainder[next_command_idx:], ERR_NONE
+	return []string{key}, int(next_command_idx), ERR_NONE, tolerated
 
 }
 
-// processMultiKeyNoData processes a "gets" command, which allows for
-// multiple keys and has no value field.
+// processMultiKeyNoData processes a "get" or "gets" command, both of which
+// allow for multiple keys and have no value field.
 //
-// On the wire, "gets" looks like:
+// On the wire, these commands look like:
 //
-//     gets key1 key2 key3\r\n
-func processMultiKeyNoData(first_line string, remainder []byte) (keys []string, processed_remainder []byte, cmd_err int) {
+//     cmd key1 key2 key3\r\n
+//
+// Proxies such as twemproxy split multigets up by server, so each server
+// sees a fragment of the original keys, often pipelined with other requests.
+func processMultiKeyNoData(first_line string, remainder []byte, mode int) (keys []string, data_consumed int, cmd_err int, tolerated int) {
 
 	// Get the key(s)
 	// ... the command should at least consist of "cmd foo", where "foo" is the key
 	split_data := strings.Split(first_line, " ")
-	if len(split_data) <= 1 {
-		return []string{}, remainder, ERR_INCOMPLETE_CMD
+	if len(split_data) <= 1 || split_data[1] == "" {
+		return []string{}, 0, ERR_INCOMPLETE_CMD, 0
 	}
 	keys = split_data[1:]
 
 	// Return parsed data
-	return keys, remainder, ERR_NONE
+	return keys, 0, ERR_NONE, 0
 }
 
-var CMD_PROCESSORS = map[string]func(first_line string, remainder []byte) (keys []string, processed_remainder []byte, cmd_err int){
-	"get":     processSingleKeyNoData,
+// cmdProcessor extracts the keys from a single command, given its first line
+// (without the trailing newline) and everything that followed it.  It
+// returns how many bytes of what followed the first line belong to the
+// command.
+type cmdProcessor func(first_line string, remainder []byte, mode int) (keys []string, data_consumed int, cmd_err int, tolerated int)
+
+var CMD_PROCESSORS = map[string]cmdProcessor{
+	"get":     processMultiKeyNoData,
 	"gets":    processMultiKeyNoData,
 	"set":     processSingleKeyWithData,
 	"add":     processSingleKeyWithData,
@@ -127,33 +194,208 @@ var CMD_PROCESSORS = map[string]func(first_line string, remainder []byte) (keys
 	"prepend": processSingleKeyWithData,
 	"incr":    processSingleKeyNoData,
 	"decr":    processSingleKeyNoData,
+	"delete":  processSingleKeyNoData,
+}
+
+// STORAGE_COMMANDS are the commands that carry a value.
+var STORAGE_COMMANDS = map[string]bool{
+	"set":     true,
+	"add":     true,
+	"replace": true,
+	"append":  true,
+	"prepend": true,
+}
+
+// COMMAND_SECTIONS translates variants of a command to the command they
+// are reported under in per-command sections, e.g. the binary protocol's
+// quiet variants, and "gets" along with "get".
+var COMMAND_SECTIONS = map[string]string{
+	"gets":     "get",
+	"getq":     "get",
+	"getk":     "get",
+	"getkq":    "get",
+	"setq":     "set",
+	"addq":     "add",
+	"replaceq": "replace",
+	"appendq":  "append",
+	"prependq": "prepend",
+	"deleteq":  "delete",
+	"incrq":    "incr",
+	"decrq":    "decr",
+	"gatq":     "gat",
+	"gatk":     "gat",
+	"gatkq":    "gat",
+}
+
+// COMMAND_CLASSES translates commands, after translating their variants
+// with COMMAND_SECTIONS, to the class of command they are reported under
+// when breaking down each key's hits.
+var COMMAND_CLASSES = map[string]string{
+	"get":     "reads",
+	"gat":     "reads",
+	"set":     "writes",
+	"add":     "writes",
+	"replace": "writes",
+	"append":  "writes",
+	"prepend": "writes",
+	"incr":    "writes",
+	"decr":    "writes",
+	"touch":   "writes",
+	"delete":  "deletes",
+}
+
+// commandSection returns the command that cmd is reported under in
+// per-command sections.
+func commandSection(cmd string) string {
+	if section, ok := COMMAND_SECTIONS[cmd]; ok {
+		return section
+	}
+	return cmd
 }
 
 // parseCommand parses a command and list of keys the command is operating on from
-// a sequence of application-level data bytes.
-func parseCommand(app_data []byte) (cmd string, keys []string, remainder []byte, cmd_err int) {
+// a sequence of application-level data bytes.  It returns the number of
+// bytes the command took up, so the caller can move on to the next one.
+//
+// A truncated command consumes nothing, since it can be retried when more
+// data arrives.  Other errors consume as much as we can be sure is bad,
+// which may be all of app_data if we can't tell where the next command
+// starts.
+func parseCommand(app_data []byte) (cmd string, keys []string, consumed int, cmd_err int) {
+	cmd, keys, consumed, cmd_err, _ = parseCommandWithMode(app_data, PARSE_STRICT)
+	return cmd, keys, consumed, cmd_err
+}
+
+// parseCommandWithMode is parseCommand with a choice of parser mode.  In
+// lenient mode, runs of whitespace, lines ending in a bare "\n", and
+// commands we don't know about are tolerated rather than being treated as
+// errors; which of these were encountered is returned as flags in tolerated.
+func parseCommandWithMode(app_data []byte, mode int) (cmd string, keys []string, consumed int, cmd_err int, tolerated int) {
 
 	// Parse out the command
+	// ... without a newline, this may just be the start of a command that
+	// ... was cut off at the end of a segment
 	space_i := bytes.IndexByte(app_data, byte(' '))
-	if space_i == -1 {
-		return "", []string{}, []byte{}, ERR_NO_CMD
+	if space_i == -1 && bytes.IndexByte(app_data, byte('\n')) == -1 {
+		return "", []string{}, 0, ERR_TRUNCATED, 0
+	} else if space_i == -1 {
+		return "", []string{}, len(app_data), ERR_NO_CMD, 0
 	}
 
 	// Find the first newline
 	newline_i := bytes.Index(app_data, []byte("\r\n"))
+	next_line_i := newline_i + 2
+	if mode == PARSE_LENIENT {
+		newline_i = bytes.IndexByte(app_data, byte('\n'))
+		next_line_i = newline_i + 1
+		if newline_i > 0 && app_data[newline_i-1] == '\r' {
+			newline_i -= 1
+		} else if newline_i != -1 {
+			tolerated |= TOLERATED_BARE_NEWLINE
+		}
+	}
 	if newline_i == -1 {
-		return "", []string{}, []byte{}, ERR_TRUNCATED
+		return "", []string{}, 0, ERR_TRUNCATED, 0
+	}
+	first_line := string(app_data[:newline_i])
+	if mode == PARSE_LENIENT {
+		normalized := strings.Join(strings.Fields(first_line), " ")
+		if normalized != first_line {
+			tolerated |= TOLERATED_WHITESPACE
+			first_line = normalized
+		}
 	}
 
 	// Validate command
-	first_line := string(app_data[:newline_i])
 	split_data := strings.Split(first_line, " ")
 	cmd = split_data[0]
 	if fn, ok := CMD_PROCESSORS[cmd]; ok {
-		keys, remainder, cmd_err = fn(first_line, app_data[newline_i+2:])
+		var data_consumed, cmd_tolerated int
+		keys, data_consumed, cmd_err, cmd_tolerated = fn(first_line, app_data[next_line_i:], mode)
+		if cmd_err == ERR_TRUNCATED {
+			return cmd, []string{}, 0, cmd_err, 0
+		}
+		consumed = next_line_i + data_consumed
+		tolerated |= cmd_tolerated
+	} else if mode == PARSE_LENIENT && cmd != "" {
+		// ... assume a vendor extension we don't know how to get keys out
+		// ... of, and skip over it
+		return cmd, []string{}, next_line_i, ERR_NONE,
+			tolerated | TOLERATED_UNKNOWN_CMD
 	} else {
-		return "", []string{}, []byte{}, ERR_INVALID_CMD
+		return "", []string{}, len(app_data), ERR_INVALID_CMD, 0
+	}
+
+	if cmd_err != ERR_NONE {
+		tolerated = 0
+	}
+	return cmd, keys, consumed, cmd_err, tolerated
+}
+
+// isNoReply returns whether the command at the start of app_data asked the
+// server not to send a response.
+func isNoReply(app_data []byte) bool {
+	newline_i := bytes.IndexByte(app_data, byte('\n'))
+	if newline_i == -1 {
+		return false
+	}
+	split_data := strings.Fields(string(app_data[:newline_i]))
+	return len(split_data) > 0 && split_data[len(split_data)-1] == "noreply"
+}
+
+// valueBytes returns the declared length of the value carried by the command
+// at the start of app_data, or 0 if it doesn't carry one.
+func valueBytes(app_data []byte) int {
+	newline_i := bytes.IndexByte(app_data, byte('\n'))
+	if newline_i == -1 {
+		return 0
+	}
+	split_data := strings.Fields(string(app_data[:newline_i]))
+	if len(split_data) < 5 || !STORAGE_COMMANDS[split_data[0]] {
+		return 0
+	}
+	value_bytes, err := strconv.Atoi(split_data[4])
+	if err != nil || value_bytes < 0 {
+		return 0
 	}
+	return value_bytes
+}
 
-	return cmd, keys, remainder, cmd_err
+// parseResponse parses a single response from a sequence of application-level
+// data bytes sent by the server, returning the number of bytes it took up.
+//
+// Retrieval commands are answered with zero or more values followed by an
+// "END" line:
+//
+//     VALUE key flags bytes [cas]\r\n
+//     <data block of `bytes` length>\r\n
+//     END\r\n
+//
+// Every other command is answered with a single line.
+func parseResponse(app_data []byte) (consumed int, cmd_err int) {
+	for {
+		newline_i := bytes.Index(app_data[consumed:], []byte("\r\n"))
+		if newline_i == -1 {
+			return 0, ERR_TRUNCATED
+		}
+		line := string(app_data[consumed : consumed+newline_i])
+		consumed += newline_i + 2
+		if !strings.HasPrefix(line, "VALUE ") {
+			return consumed, ERR_NONE
+		}
+
+		// Skip over the value
+		split_data := strings.Split(line, " ")
+		if len(split_data) != 4 && len(split_data) != 5 {
+			return len(app_data), ERR_BAD_RESPONSE
+		}
+		value_bytes, err := strconv.ParseInt(split_data[3], 10, 32)
+		if err != nil || value_bytes < 0 {
+			return len(app_data), ERR_BAD_RESPONSE
+		}
+		if int64(len(app_data)-consumed) < value_bytes+2 {
+			return 0, ERR_TRUNCATED
+		}
+		consumed += int(value_bytes) + 2
+	}
 }
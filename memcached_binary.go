@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/binary"
+)
+
+const (
+	BINARY_REQUEST_MAGIC  = 0x80
+	BINARY_RESPONSE_MAGIC = 0x81
+	BINARY_HEADER_LEN     = 24
+)
+
+/* The protocols a connection may speak.  PROTOCOL_UNKNOWN doubles as the
+ * "auto" setting, where the protocol is detected per connection. */
+const (
+	PROTOCOL_UNKNOWN = iota
+	PROTOCOL_ASCII
+	PROTOCOL_BINARY
+	PROTOCOL_TLS
+)
+
+var PROTOCOLS = map[string]int{
+	"auto":   PROTOCOL_UNKNOWN,
+	"ascii":  PROTOCOL_ASCII,
+	"binary": PROTOCOL_BINARY,
+	"tls":    PROTOCOL_TLS,
+}
+
+// detectProtocol guesses the protocol from the first bytes sent on a
+// connection.  Binary requests always start with the request magic byte,
+// and TLS connections with a handshake record, neither of which can start
+// an ASCII command.
+func detectProtocol(payload []byte) int {
+	if len(payload) == 0 {
+		return PROTOCOL_UNKNOWN
+	}
+	if payload[0] == BINARY_REQUEST_MAGIC || payload[0] == BINARY_RESPONSE_MAGIC {
+		return PROTOCOL_BINARY
+	}
+	if payload[0] == TLS_HANDSHAKE && len(payload) > 1 && payload[1] == 3 {
+		return PROTOCOL_TLS
+	}
+	return PROTOCOL_ASCII
+}
+
+// BINARY_OPCODES translates binary protocol opcodes to command names.  Quiet
+// variants get their own names, so they can be told apart in per-command
+// reporting.
+var BINARY_OPCODES = map[byte]string{
+	0x00: "get",
+	0x01: "set",
+	0x02: "add",
+	0x03: "replace",
+	0x04: "delete",
+	0x05: "incr",
+	0x06: "decr",
+	0x07: "quit",
+	0x08: "flush",
+	0x09: "getq",
+	0x0a: "noop",
+	0x0b: "version",
+	0x0c: "getk",
+	0x0d: "getkq",
+	0x0e: "append",
+	0x0f: "prepend",
+	0x10: "stat",
+	0x11: "setq",
+	0x12: "addq",
+	0x13: "replaceq",
+	0x14: "deleteq",
+	0x15: "incrq",
+	0x16: "decrq",
+	0x17: "quitq",
+	0x18: "flushq",
+	0x19: "appendq",
+	0x1a: "prependq",
+	0x1b: "verbosity",
+	0x1c: "touch",
+	0x1d: "gat",
+	0x1e: "gatq",
+	0x20: "sasl_list_mechs",
+	0x21: "sasl_auth",
+	0x22: "sasl_step",
+	0x23: "gatk",
+	0x24: "gatkq",
+}
+
+// BINARY_SASL_OPCODES are the authentication opcodes.  These use the key
+// field to carry the SASL mechanism name rather than a cache key, and their
+// value carries credentials, so they are skipped rather than reported.
+var BINARY_SASL_OPCODES = map[byte]bool{
+	0x20: true,
+	0x21: true,
+	0x22: true,
+}
+
+// parseBinaryCommand parses a command and the key it is operating on from a
+// sequence of binary protocol request bytes, returning the number of bytes
+// the request took up.
+//
+// On the wire, every binary request starts with a fixed 24 byte header:
+//
+//     magic (1) opcode (1) key length (2) extras length (1) data type (1)
+//     vbucket (2) total body length (4) opaque (4) cas (8)
+//
+// followed by a body of extras, then the key, then the value.
+func parseBinaryCommand(app_data []byte) (cmd string, keys []string, consumed int, cmd_err int) {
+
+	// Make sure we have the full header
+	if len(app_data) > 0 && app_data[0] != BINARY_REQUEST_MAGIC {
+		// ... without a valid header we have no idea where the next
+		// ... request starts, so the rest of the packet is lost
+		return "", []string{}, len(app_data), ERR_BAD_BYTES
+	}
+	if len(app_data) < BINARY_HEADER_LEN {
+		return "", []string{}, 0, ERR_TRUNCATED
+	}
+	opcode := app_data[1]
+	key_len := int(binary.BigEndian.Uint16(app_data[2:4]))
+	extras_len := int(app_data[4])
+	body_len := int64(binary.BigEndian.Uint32(app_data[8:12]))
+
+	// Make sure we have the full body
+	if int64(key_len+extras_len) > body_len {
+		return "", []string{}, len(app_data), ERR_BAD_BYTES
+	}
+	next_command_idx := BINARY_HEADER_LEN + body_len
+	if int64(len(app_data)) < next_command_idx {
+		return "", []string{}, 0, ERR_TRUNCATED
+	}
+	consumed = int(next_command_idx)
+
+	// Validate command
+	cmd, ok := BINARY_OPCODES[opcode]
+	if !ok {
+		return "", []string{}, consumed, ERR_INVALID_CMD
+	}
+
+	// Return parsed data
+	if key_len == 0 || BINARY_SASL_OPCODES[opcode] {
+		return cmd, []string{}, consumed, ERR_NONE
+	}
+	key_start := BINARY_HEADER_LEN + extras_len
+	key := string(app_data[key_start : key_start+key_len])
+	return cmd, []string{key}, consumed, ERR_NONE
+}
+
+// binaryOpaque returns the opaque field of a binary request or response,
+// which the server copies from each request into its response.
+func binaryOpaque(app_data []byte) uint32 {
+	if len(app_data) < BINARY_HEADER_LEN {
+		return 0
+	}
+	return binary.BigEndian.Uint32(app_data[12:16])
+}
+
+// binaryValueBytes returns the length of the value carried by a binary
+// request, or 0 if it doesn't carry one.
+func binaryValueBytes(app_data []byte) int {
+	if len(app_data) < BINARY_HEADER_LEN {
+		return 0
+	}
+	key_len := int(binary.BigEndian.Uint16(app_data[2:4]))
+	extras_len := int(app_data[4])
+	body_len := int(binary.BigEndian.Uint32(app_data[8:12]))
+	if body_len < key_len+extras_len {
+		return 0
+	}
+	return body_len - key_len - extras_len
+}
+
+// parseBinaryResponse parses a single response from a sequence of binary
+// protocol bytes sent by the server.  Responses share the request header
+// layout, with the status in place of the vbucket.
+func parseBinaryResponse(app_data []byte) (opaque uint32, consumed int, cmd_err int) {
+	if len(app_data) > 0 && app_data[0] != BINARY_RESPONSE_MAGIC {
+		return 0, len(app_data), ERR_BAD_RESPONSE
+	}
+	if len(app_data) < BINARY_HEADER_LEN {
+		return 0, 0, ERR_TRUNCATED
+	}
+	body_len := int64(binary.BigEndian.Uint32(app_data[8:12]))
+	next_response_idx := BINARY_HEADER_LEN + body_len
+	if int64(len(app_data)) < next_response_idx {
+		return 0, 0, ERR_TRUNCATED
+	}
+	return binaryOpaque(app_data), int(next_response_idx), ERR_NONE
+}
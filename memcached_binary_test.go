@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// binaryRequest builds a binary protocol request with the given opcode,
+// extras, key and value.
+func binaryRequest(opcode byte, extras []byte, key string, value []byte) []byte {
+	header := make([]byte, BINARY_HEADER_LEN)
+	header[0] = BINARY_REQUEST_MAGIC
+	header[1] = opcode
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(key)))
+	header[4] = byte(len(extras))
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(extras)+len(key)+len(value)))
+
+	request := append(header, extras...)
+	request = append(request, []byte(key)...)
+	return append(request, value...)
+}
+
+type ParseBinaryCommandTest struct {
+	RawData   []byte
+	Cmd       string
+	Keys      []string
+	Remainder []byte
+	CmdErr    int
+}
+
+var PARSE_BINARY_COMMAND_TEST_TABLE = []ParseBinaryCommandTest{
+	ParseBinaryCommandTest{binaryRequest(0x00, nil, "foo", nil), "get", []string{"foo"}, []byte{}, ERR_NONE},
+	ParseBinaryCommandTest{binaryRequest(0x01, make([]byte, 8), "foo", []byte("abc")), "set", []string{"foo"}, []byte{}, ERR_NONE},
+	ParseBinaryCommandTest{binaryRequest(0x0a, nil, "", nil), "noop", []string{}, []byte{}, ERR_NONE},
+	ParseBinaryCommandTest{append(binaryRequest(0x0d, nil, "foo", nil), binaryRequest(0x00, nil, "bar", nil)...),
+		"getkq", []string{"foo"}, binaryRequest(0x00, nil, "bar", nil), ERR_NONE},
+	ParseBinaryCommandTest{append(binaryRequest(0x21, nil, "PLAIN", []byte("\x00user\x00secret")), binaryRequest(0x00, nil, "foo", nil)...),
+		"sasl_auth", []string{}, binaryRequest(0x00, nil, "foo", nil), ERR_NONE},
+	ParseBinaryCommandTest{binaryRequest(0x22, nil, "PLAIN", []byte("step")), "sasl_step", []string{}, []byte{}, ERR_NONE},
+	ParseBinaryCommandTest{binaryRequest(0xfe, nil, "foo", nil), "", []string{}, []byte{}, ERR_INVALID_CMD},
+	ParseBinaryCommandTest{[]byte("get foo\r\n"), "", []string{}, []byte{}, ERR_BAD_BYTES},
+	// ... test various truncation levels
+	ParseBinaryCommandTest{binaryRequest(0x00, nil, "foo", nil)[:10], "", []string{}, []byte{}, ERR_TRUNCATED},
+	ParseBinaryCommandTest{binaryRequest(0x00, nil, "foo", nil)[:25], "", []string{}, []byte{}, ERR_TRUNCATED},
+}
+
+func TestParseBinaryCommand(t *testing.T) {
+	for test_i, test := range PARSE_BINARY_COMMAND_TEST_TABLE {
+		cmd, keys, consumed, cmd_err := parseBinaryCommand(test.RawData)
+		remainder := test.RawData[consumed:]
+		if cmd_err == ERR_TRUNCATED {
+			remainder = []byte{}
+		}
+
+		if test.Cmd != cmd {
+			t.Errorf("Test %d: expected cmd %s, got %s\n", test_i, test.Cmd, cmd)
+		}
+		if !stringsEqual(test.Keys, keys) {
+			t.Errorf("Test %d: expected keys %v, got %v\n", test_i, test.Keys, keys)
+		}
+		if !bytes.Equal(test.Remainder, remainder) {
+			t.Errorf("Test %d: expected remainder %v, got %v\n",
+				test_i, test.Remainder, remainder)
+		}
+		if test.CmdErr != cmd_err {
+			t.Errorf("Test %d: expected cmd err %d, got %d\n",
+				test_i, test.CmdErr, cmd_err)
+		}
+	}
+}
+
+func TestDetectProtocol(t *testing.T) {
+	if p := detectProtocol(binaryRequest(0x00, nil, "foo", nil)); p != PROTOCOL_BINARY {
+		t.Errorf("Expected binary protocol, got %d\n", p)
+	}
+	if p := detectProtocol([]byte("get foo\r\n")); p != PROTOCOL_ASCII {
+		t.Errorf("Expected ascii protocol, got %d\n", p)
+	}
+	if p := detectProtocol([]byte{}); p != PROTOCOL_UNKNOWN {
+		t.Errorf("Expected unknown protocol, got %d\n", p)
+	}
+}
+
+func TestBinaryValueBytes(t *testing.T) {
+	if n := binaryValueBytes(binaryRequest(0x01, make([]byte, 8), "foo", []byte("abc"))); n != 3 {
+		t.Errorf("Expected 3 value bytes for set, got %d\n", n)
+	}
+	if n := binaryValueBytes(binaryRequest(0x00, nil, "foo", nil)); n != 0 {
+		t.Errorf("Expected no value bytes for get, got %d\n", n)
+	}
+}
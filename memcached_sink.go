@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const MEMCACHED_SINK_TIMEOUT = 5 * time.Second
+
+// The longest key memcached accepts
+const MEMCACHED_MAX_KEY_BYTES = 250
+
+// MemcachedSink stores the latest report, as JSON, in memcached itself,
+// under a single key, so that anything with a memcached client can fetch
+// it.  Each report replaces the last, and expires after ttl seconds, if
+// set.
+type MemcachedSink struct {
+	address string
+	key     string
+	ttl     int
+
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewMemcachedSink returns a sink storing reports under key in the
+// memcached at address.  No connection is made until the first report is
+// sent.
+func NewMemcachedSink(address string, key string, ttl int) *MemcachedSink {
+	return &MemcachedSink{address: address, key: key, ttl: ttl}
+}
+
+// validMemcachedKey returns whether memcached accepts a key: no longer than
+// 250 bytes, without spaces or control characters.
+func validMemcachedKey(key string) bool {
+	if key == "" || len(key) > MEMCACHED_MAX_KEY_BYTES {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 127 {
+			return false
+		}
+	}
+	return true
+}
+
+// Send stores a report.  If it can't be, the connection is dropped, and
+// made again for the next report.
+func (s *MemcachedSink) Send(report *Report) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout("tcp", s.address, MEMCACHED_SINK_TIMEOUT)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+		s.reader = bufio.NewReader(conn)
+	}
+	err := s.set(report.JSON())
+	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *MemcachedSink) set(value string) error {
+	s.conn.SetDeadline(time.Now().Add(MEMCACHED_SINK_TIMEOUT))
+	_, err := fmt.Fprintf(s.conn, "set %s 0 %d %d\r\n%s\r\n", s.key, s.ttl, len(value), value)
+	if err != nil {
+		return err
+	}
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		return err
+	} else if line = strings.TrimRight(line, "\r\n"); line != "STORED" {
+		return fmt.Errorf("memcached responded %q", line)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMemcached accepts connections, sending each set command to commands,
+// with its value, and answering each with response.
+func fakeMemcached(listener net.Listener, response string, commands chan string) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				fields := strings.Fields(line)
+				var size int
+				fmt.Sscan(fields[4], &size)
+				value := make([]byte, size+2)
+				io.ReadFull(r, value)
+				commands <- strings.Join(fields, " ") + " " + string(value[:size])
+				fmt.Fprint(conn, response+"\r\n")
+			}
+		}(conn)
+	}
+}
+
+func TestMemcachedSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	commands := make(chan string, 2)
+	go fakeMemcached(listener, "STORED", commands)
+
+	sink := NewMemcachedSink(listener.Addr().String(), "mcsauna:report:cache1", 60)
+	report := NewReport(time.Unix(1500000000, 0))
+	report.Count(NewMetricName("mcsauna.keys").Label("key", "foo"), 3)
+	for i := 0; i < 2; i++ {
+		if err := sink.Send(report); err != nil {
+			t.Fatal(err)
+		}
+		expected := fmt.Sprintf("set mcsauna:report:cache1 0 60 %d %s", len(report.JSON()), report.JSON())
+		if command := <-commands; command != expected {
+			t.Errorf("Expected %q, got %q\n", expected, command)
+		}
+	}
+}
+
+func TestMemcachedSinkError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	commands := make(chan string, 1)
+	go fakeMemcached(listener, "SERVER_ERROR object too large for cache", commands)
+
+	sink := NewMemcachedSink(listener.Addr().String(), "mcsauna:report", 0)
+	err = sink.Send(NewReport(time.Unix(1500000000, 0)))
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("Expected memcached's error, got %v\n", err)
+	}
+}
+
+func TestValidMemcachedKey(t *testing.T) {
+	expected := map[string]bool{
+		"mcsauna:report:{hostname}": true,
+		"":                          false,
+		"has space":                 false,
+		"has\nnewline":              false,
+		strings.Repeat("k", 250):    true,
+		strings.Repeat("k", 251):    false,
+	}
+	for key, valid := range expected {
+		if validMemcachedKey(key) != valid {
+			t.Errorf("Expected %q valid to be %v\n", key, valid)
+		}
+	}
+}
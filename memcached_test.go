@@ -24,7 +24,10 @@ var PARSE_COMMAND_TEST_TABLE = []ParseCommandTest{
 	ParseCommandTest{[]byte("get\r\n"), "", []string{}, []byte{}, ERR_NO_CMD},
 	ParseCommandTest{[]byte("incr foo 1\r\n"), "incr", []string{"foo"}, []byte{}, ERR_NONE},
 	ParseCommandTest{[]byte("decr foo 1\r\n"), "decr", []string{"foo"}, []byte{}, ERR_NONE},
+	ParseCommandTest{[]byte("delete foo\r\n"), "delete", []string{"foo"}, []byte{}, ERR_NONE},
+	ParseCommandTest{[]byte("delete foo noreply\r\n"), "delete", []string{"foo"}, []byte{}, ERR_NONE},
 	// ... test various truncation levels
+	ParseCommandTest{[]byte("get"), "", []string{}, []byte{}, ERR_TRUNCATED},
 	ParseCommandTest{[]byte("get foo"), "", []string{}, []byte{}, ERR_TRUNCATED},
 	ParseCommandTest{[]byte("add foo 2 44 1"), "", []string{}, []byte{}, ERR_TRUNCATED},
 	ParseCommandTest{[]byte("add foo 2 44 1\r"), "", []string{}, []byte{}, ERR_TRUNCATED},
@@ -36,12 +39,20 @@ var PARSE_COMMAND_TEST_TABLE = []ParseCommandTest{
 	// Multiple Commands Per Packet Tests
 	ParseCommandTest{[]byte("get foo\r\nget bar\r\n"), "get", []string{"foo"}, []byte("get bar\r\n"), ERR_NONE},
 	ParseCommandTest{[]byte("set foo 0 0 3\r\nabc\r\nget bar\r\n"), "set", []string{"foo"}, []byte("get bar\r\n"), ERR_NONE},
+
+	// Multiget Tests
+	ParseCommandTest{[]byte("get foo bar baz\r\n"), "get", []string{"foo", "bar", "baz"}, []byte{}, ERR_NONE},
+	ParseCommandTest{[]byte("gets foo bar\r\n"), "gets", []string{"foo", "bar"}, []byte{}, ERR_NONE},
+	// ... twemproxy-style fragments of a multiget, pipelined with a set
+	ParseCommandTest{[]byte("get k1 k4\r\nset k2 0 0 1 noreply\r\na\r\nget k3\r\n"), "get", []string{"k1", "k4"},
+		[]byte("set k2 0 0 1 noreply\r\na\r\nget k3\r\n"), ERR_NONE},
 }
 
 func TestParseCommand(t *testing.T) {
 	for test_i, test := range PARSE_COMMAND_TEST_TABLE {
 		t.Logf(" -> parseCommand(%q)\n", test.RawData)
-		cmd, keys, remainder, cmd_err := parseCommand(test.RawData)
+		cmd, keys, consumed, cmd_err := parseCommand(test.RawData)
+		remainder := test.RawData[consumed:]
 		t.Logf(" <- %v %v %v\n", cmd, keys, cmd_err)
 
 		// Verify Command
@@ -64,6 +75,14 @@ func TestParseCommand(t *testing.T) {
 		}
 
 		// Verify Remainder
+		// ... a truncated command consumes nothing, so it can be retried
+		if cmd_err == ERR_TRUNCATED {
+			remainder = []byte{}
+			if consumed != 0 {
+				t.Errorf("Test %d: expected truncated command to consume nothing, got %d\n",
+					test_i, consumed)
+			}
+		}
 		if !bytes.Equal(test.Remainder, remainder) {
 			t.Errorf("Test %d: expected remainder %v, got %v\n",
 				test_i, test.Remainder, remainder)
@@ -76,3 +95,105 @@ func TestParseCommand(t *testing.T) {
 		}
 	}
 }
+
+type LenientParseCommandTest struct {
+	RawData   []byte
+	Cmd       string
+	Keys      []string
+	Remainder []byte
+	CmdErr    int
+	Tolerated int
+}
+
+var LENIENT_PARSE_COMMAND_TEST_TABLE = []LenientParseCommandTest{
+	LenientParseCommandTest{[]byte("get bar\r\n"), "get", []string{"bar"}, []byte{}, ERR_NONE, 0},
+	LenientParseCommandTest{[]byte("get  bar \r\n"), "get", []string{"bar"}, []byte{}, ERR_NONE, TOLERATED_WHITESPACE},
+	LenientParseCommandTest{[]byte("gets\tfoo  bar\r\n"), "gets", []string{"foo", "bar"}, []byte{}, ERR_NONE, TOLERATED_WHITESPACE},
+	LenientParseCommandTest{[]byte("get bar\nget foo\n"), "get", []string{"bar"}, []byte("get foo\n"), ERR_NONE, TOLERATED_BARE_NEWLINE},
+	LenientParseCommandTest{[]byte("set foo 0 0 3\nabc\n"), "set", []string{"foo"}, []byte{}, ERR_NONE, TOLERATED_BARE_NEWLINE},
+	LenientParseCommandTest{[]byte("set foo 0 0 3 noreply x\r\nabc\r\n"), "set", []string{"foo"}, []byte{}, ERR_NONE, TOLERATED_EXTRA_FIELDS},
+	LenientParseCommandTest{[]byte("mn foo\r\nget bar\r\n"), "mn", []string{}, []byte("get bar\r\n"), ERR_NONE, TOLERATED_UNKNOWN_CMD},
+	LenientParseCommandTest{[]byte("get foo"), "", []string{}, []byte{}, ERR_TRUNCATED, 0},
+	LenientParseCommandTest{[]byte("set foo 0 0 3\r\nab"), "set", []string{}, []byte{}, ERR_TRUNCATED, 0},
+}
+
+func TestParseCommandLenient(t *testing.T) {
+	for test_i, test := range LENIENT_PARSE_COMMAND_TEST_TABLE {
+		cmd, keys, consumed, cmd_err, tolerated := parseCommandWithMode(test.RawData, PARSE_LENIENT)
+		remainder := test.RawData[consumed:]
+		if cmd_err == ERR_TRUNCATED {
+			remainder = []byte{}
+		}
+
+		if test.Cmd != cmd {
+			t.Errorf("Test %d: expected cmd %s, got %s\n", test_i, test.Cmd, cmd)
+		}
+		if !stringsEqual(test.Keys, keys) {
+			t.Errorf("Test %d: expected keys %v, got %v\n", test_i, test.Keys, keys)
+		}
+		if !bytes.Equal(test.Remainder, remainder) {
+			t.Errorf("Test %d: expected remainder %q, got %q\n",
+				test_i, test.Remainder, remainder)
+		}
+		if test.CmdErr != cmd_err {
+			t.Errorf("Test %d: expected cmd err %d, got %d\n",
+				test_i, test.CmdErr, cmd_err)
+		}
+		if test.Tolerated != tolerated {
+			t.Errorf("Test %d: expected tolerated %d, got %d\n",
+				test_i, test.Tolerated, tolerated)
+		}
+	}
+}
+
+type ParseResponseTest struct {
+	RawData   []byte
+	Remainder []byte
+	CmdErr    int
+}
+
+var PARSE_RESPONSE_TEST_TABLE = []ParseResponseTest{
+	ParseResponseTest{[]byte("STORED\r\n"), []byte{}, ERR_NONE},
+	ParseResponseTest{[]byte("END\r\nSTORED\r\n"), []byte("STORED\r\n"), ERR_NONE},
+	ParseResponseTest{[]byte("VALUE foo 0 3\r\nabc\r\nEND\r\n"), []byte{}, ERR_NONE},
+	ParseResponseTest{[]byte("VALUE foo 0 3 12\r\nabc\r\nVALUE bar 0 1 13\r\nd\r\nEND\r\nDELETED\r\n"), []byte("DELETED\r\n"), ERR_NONE},
+	ParseResponseTest{[]byte("VALUE foo 0 3\r\nabc\r\n"), []byte{}, ERR_TRUNCATED},
+	ParseResponseTest{[]byte("VALUE foo 0 3\r\nab"), []byte{}, ERR_TRUNCATED},
+	ParseResponseTest{[]byte("VALUE foo 0 x\r\nabc\r\nEND\r\n"), []byte{}, ERR_BAD_RESPONSE},
+}
+
+func TestParseResponse(t *testing.T) {
+	for test_i, test := range PARSE_RESPONSE_TEST_TABLE {
+		consumed, cmd_err := parseResponse(test.RawData)
+		remainder := test.RawData[consumed:]
+		if cmd_err == ERR_TRUNCATED {
+			remainder = []byte{}
+		}
+		if !bytes.Equal(test.Remainder, remainder) {
+			t.Errorf("Test %d: expected remainder %q, got %q\n",
+				test_i, test.Remainder, remainder)
+		}
+		if test.CmdErr != cmd_err {
+			t.Errorf("Test %d: expected cmd err %d, got %d\n",
+				test_i, test.CmdErr, cmd_err)
+		}
+	}
+}
+
+func TestIsNoReply(t *testing.T) {
+	if !isNoReply([]byte("set foo 0 0 3 noreply\r\nabc\r\n")) {
+		t.Errorf("Expected noreply to be detected\n")
+	}
+	if isNoReply([]byte("set foo 0 0 3\r\nnoreply\r\n")) {
+		t.Errorf("Expected noreply in the data block to be ignored\n")
+	}
+}
+
+func TestValueBytes(t *testing.T) {
+	if n := valueBytes([]byte("set foo 0 0 3 noreply\r\nabc\r\n")); n != 3 {
+		t.Errorf("Expected 3 value bytes for set, got %d\n", n)
+	}
+	if n := valueBytes([]byte("get foo bar baz qux\r\n")); n != 0 {
+		t.Errorf("Expected no value bytes for get, got %d\n", n)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"container/heap"
+)
+
+// TopMovers finds the keys whose hits have risen the most since the last
+// interval, to catch keys while they are still heating up.
+type TopMovers struct {
+	// Map of keys to hits in the last interval
+	previous map[string]int
+}
+
+func NewTopMovers() *TopMovers {
+	return &TopMovers{previous: make(map[string]int)}
+}
+
+// Update compares keys with the last interval, returning KeyHeaps of the
+// keys whose hits rose, ordered by absolute rise, and by rise as a
+// percentage of their previous hits.  Keys that weren't seen in the last
+// interval are treated as having had one hit.  keys are then remembered for
+// the next interval.
+func (m *TopMovers) Update(keys []*Key) (absolute *KeyHeap, relative *KeyHeap) {
+	absolute, relative = &KeyHeap{}, &KeyHeap{}
+	current := make(map[string]int, len(keys))
+	for _, key := range keys {
+		current[key.Name] = key.Hits
+
+		previous := m.previous[key.Name]
+		rise := key.Hits - previous
+		if rise <= 0 {
+			continue
+		}
+		if previous == 0 {
+			previous = 1
+		}
+		*absolute = append(*absolute, &Key{key.Name, rise})
+		*relative = append(*relative, &Key{key.Name, rise * 100 / previous})
+	}
+	m.previous = current
+
+	heap.Init(absolute)
+	heap.Init(relative)
+	return absolute, relative
+}
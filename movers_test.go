@@ -0,0 +1,31 @@
+package main
+
+import (
+	"container/heap"
+	"testing"
+)
+
+func TestTopMovers(t *testing.T) {
+	m := NewTopMovers()
+	m.Update([]*Key{&Key{"foo", 100}, &Key{"bar", 2}, &Key{"baz", 50}})
+	absolute, relative := m.Update([]*Key{&Key{"foo", 130}, &Key{"bar", 20}, &Key{"baz", 10}, &Key{"qux", 3}})
+
+	expected_absolute := []Key{Key{"foo", 30}, Key{"bar", 18}, Key{"qux", 3}}
+	expected_relative := []Key{Key{"bar", 900}, Key{"qux", 300}, Key{"foo", 30}}
+	for name, test := range map[string]struct {
+		actual   *KeyHeap
+		expected []Key
+	}{"absolute": {absolute, expected_absolute}, "relative": {relative, expected_relative}} {
+		if test.actual.Len() != len(test.expected) {
+			t.Errorf("Expected %d %s risers, got %d\n", len(test.expected), name, test.actual.Len())
+			continue
+		}
+		for _, key := range test.expected {
+			popped_key := heap.Pop(test.actual).(*Key)
+			if key.Name != popped_key.Name || key.Hits != popped_key.Hits {
+				t.Errorf("Expected %s riser %s by %d, got %s by %d\n",
+					name, key.Name, key.Hits, popped_key.Name, popped_key.Hits)
+			}
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// Characters that separate the parts of a key
+	NAMESPACE_DELIMITERS = ":_/.|#-"
+
+	// Keys are split into at most this many parts, with everything after
+	// the last folded into a single "*"
+	NAMESPACE_MAX_DEPTH = 5
+
+	// When a part of the tree has more than this many different next
+	// parts, they are assumed to be variable (e.g. IDs) and merged into "*"
+	NAMESPACE_MAX_CHILDREN = 50
+)
+
+// namespaceNode is a node in a NamespaceTree.  Each node is reached by a
+// token, i.e. a part of a key along with the delimiter that follows it.
+type namespaceNode struct {
+	// Hits for keys ending at this node
+	hits int
+
+	children map[string]*namespaceNode
+
+	// Whether the children of this node have been merged into "*"
+	variable bool
+}
+
+func newNamespaceNode() *namespaceNode {
+	return &namespaceNode{children: make(map[string]*namespaceNode)}
+}
+
+// child returns the child reached by token, creating it if needed.
+func (n *namespaceNode) child(token string) *namespaceNode {
+	if n.variable {
+		token = variableToken(token)
+	}
+	if child, ok := n.children[token]; ok {
+		return child
+	}
+
+	if len(n.children) >= NAMESPACE_MAX_CHILDREN {
+		n.variable = true
+		children := n.children
+		n.children = make(map[string]*namespaceNode)
+		for old_token, old_child := range children {
+			n.child(old_token).merge(old_child)
+		}
+		return n.child(token)
+	}
+	child := newNamespaceNode()
+	n.children[token] = child
+	return child
+}
+
+// merge adds the hits of other, and its children, to n.
+func (n *namespaceNode) merge(other *namespaceNode) {
+	n.hits += other.hits
+	for token, child := range other.children {
+		n.child(token).merge(child)
+	}
+}
+
+// formatNamespaceSuggestions returns a "regexps" config, in json, that
+// would group keys by each of namespaces.
+func formatNamespaceSuggestions(namespaces []string) ([]byte, error) {
+	suggestions := struct {
+		Regexps []RegexpConfig `json:"regexps"`
+	}{[]RegexpConfig{}}
+	for _, namespace := range namespaces {
+		suggestions.Regexps = append(suggestions.Regexps, RegexpConfig{
+			Name: namespaceName(namespace),
+			Re:   namespaceRegexp(namespace),
+		})
+	}
+	return json.MarshalIndent(suggestions, "", "    ")
+}
+
+// NamespaceTree groups keys by their structure, to suggest how they could be
+// aggregated.  Keys are split into parts on common delimiters, and parts
+// that are numeric, or that vary too much to be worth telling apart, are
+// replaced with "*", so that e.g. "user:1234:profile" is counted under the
+// namespace "user:*:profile".
+type NamespaceTree struct {
+	Lock sync.Mutex
+
+	root *namespaceNode
+}
+
+func NewNamespaceTree() *NamespaceTree {
+	t := &NamespaceTree{}
+	t.root = newNamespaceNode()
+	return t
+}
+
+// Add adds a hit for each of keys to the namespaces they fall under.
+func (t *NamespaceTree) Add(keys []string) {
+	t.Lock.Lock()
+	defer t.Lock.Unlock()
+
+	for _, key := range keys {
+		node := t.root
+		for _, token := range namespaceTokens(key) {
+			node = node.child(token)
+		}
+		node.hits += 1
+	}
+}
+
+// GetTopNamespaces returns a KeyHeap of namespaces, ordered by hits,
+// descending.
+func (t *NamespaceTree) GetTopNamespaces() *KeyHeap {
+	t.Lock.Lock()
+	defer t.Lock.Unlock()
+
+	top_namespaces := &KeyHeap{}
+	var walk func(prefix string, node *namespaceNode)
+	walk = func(prefix string, node *namespaceNode) {
+		if node.hits > 0 {
+			*top_namespaces = append(*top_namespaces, &Key{prefix, node.hits})
+		}
+		for token, child := range node.children {
+			walk(prefix+token, child)
+		}
+	}
+	walk("", t.root)
+	heap.Init(top_namespaces)
+	return top_namespaces
+}
+
+// Rotate clears the data on the existing NamespaceTree, returning a new tree
+// containing the old data.
+func (t *NamespaceTree) Rotate() *NamespaceTree {
+	t.Lock.Lock()
+	defer t.Lock.Unlock()
+
+	// Clone existing
+	new_namespace_tree := &NamespaceTree{}
+	new_namespace_tree.root = t.root
+
+	// Clear existing values
+	t.root = newNamespaceNode()
+	return new_namespace_tree
+}
+
+// namespaceTokens splits key into parts, each along with the delimiter that
+// follows it.  Numeric parts are replaced with "*".
+func namespaceTokens(key string) []string {
+	tokens := []string{}
+	for len(key) > 0 {
+		if len(tokens) == NAMESPACE_MAX_DEPTH-1 {
+			return append(tokens, "*")
+		}
+		end := strings.IndexAny(key, NAMESPACE_DELIMITERS) + 1
+		if end == 0 {
+			end = len(key)
+		}
+		token := key[:end]
+		key = key[end:]
+		if isDigits(strings.TrimRight(token, NAMESPACE_DELIMITERS)) {
+			token = variableToken(token)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// variableToken replaces the part of token before its delimiter with "*".
+func variableToken(token string) string {
+	if strings.HasSuffix(token, "*") {
+		return token
+	}
+	if i := len(token) - 1; i >= 0 && strings.IndexByte(NAMESPACE_DELIMITERS, token[i]) != -1 {
+		return "*" + token[i:]
+	}
+	return "*"
+}
+
+// namespaceName returns a name for a namespace, suitable for use in the
+// "regexps" config.
+func namespaceName(namespace string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		} else if r == '*' {
+			return -1
+		}
+		return '_'
+	}, namespace)
+	for strings.Contains(name, "__") {
+		name = strings.Replace(name, "__", "_", -1)
+	}
+	return strings.Trim(name, "_")
+}
+
+// namespaceRegexp returns a regular expression matching the keys in a
+// namespace, suitable for use in the "regexps" config.  Each "*" matches up
+// to the next delimiter, or everything if there isn't one.
+func namespaceRegexp(namespace string) string {
+	re := "^"
+	for i, part := range strings.Split(namespace, "*") {
+		if i > 0 {
+			if len(part) > 0 {
+				re += "[^" + regexp.QuoteMeta(part[:1]) + "]+"
+			} else {
+				re += ".+"
+			}
+		}
+		re += regexp.QuoteMeta(part)
+	}
+	return re + "$"
+}
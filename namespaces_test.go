@@ -0,0 +1,65 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+func TestNamespaceTokens(t *testing.T) {
+	tokens := namespaceTokens("user:1234:profile")
+	if !stringsEqual(tokens, []string{"user:", "*:", "profile"}) {
+		t.Errorf("Unexpected tokens %v\n", tokens)
+	}
+	tokens = namespaceTokens("a_b_c_d_e_f")
+	if !stringsEqual(tokens, []string{"a_", "b_", "c_", "d_", "*"}) {
+		t.Errorf("Expected depth to be capped, got %v\n", tokens)
+	}
+}
+
+func TestNamespaceTree(t *testing.T) {
+	tree := NewNamespaceTree()
+	for i := 0; i < 200; i++ {
+		tree.Add([]string{
+			fmt.Sprintf("user:%d:profile", i),
+			fmt.Sprintf("session:%x", i*7919),
+		})
+	}
+	tree.Add([]string{"config"})
+
+	rotated := tree.Rotate()
+	top_namespaces := rotated.GetTopNamespaces()
+	expected := []Key{Key{"session:*", 200}, Key{"user:*:profile", 200}, Key{"config", 1}}
+	if top_namespaces.Len() != len(expected) {
+		t.Fatalf("Expected %d namespaces, got %d\n", len(expected), top_namespaces.Len())
+	}
+	seen := map[string]int{}
+	for top_namespaces.Len() > 0 {
+		namespace := heap.Pop(top_namespaces).(*Key)
+		seen[namespace.Name] = namespace.Hits
+	}
+	for _, namespace := range expected {
+		if seen[namespace.Name] != namespace.Hits {
+			t.Errorf("Expected %d hits for %s, got %d\n",
+				namespace.Hits, namespace.Name, seen[namespace.Name])
+		}
+	}
+	if tree.GetTopNamespaces().Len() != 0 {
+		t.Errorf("Expected rotated tree to be cleared\n")
+	}
+}
+
+func TestNamespaceSuggestions(t *testing.T) {
+	if name := namespaceName("user:*:profile"); name != "user_profile" {
+		t.Errorf("Expected name user_profile, got %s\n", name)
+	}
+	re := regexp.MustCompile(namespaceRegexp("user:*:profile"))
+	if !re.MatchString("user:1234:profile") || re.MatchString("user:1:2:profile") {
+		t.Errorf("Unexpected matches for %s\n", re)
+	}
+	re = regexp.MustCompile(namespaceRegexp("session:*"))
+	if !re.MatchString("session:abc:def") {
+		t.Errorf("Expected trailing * to match everything\n")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	NATS_TIMEOUT      = 10 * time.Second
+	NATS_DEFAULT_PORT = "4222"
+)
+
+// NATSSink publishes each report, as JSON, to a NATS subject.  It speaks
+// just enough of the NATS client protocol to connect, with a user and
+// password or a token if the URL has them, and publish, following each
+// report with a PING so that the server's PONG confirms it was accepted.
+type NATSSink struct {
+	address  string
+	user     *url.Userinfo
+	subject  string
+	hostname string
+
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// The largest payload the server accepts, from its INFO
+	max_payload int
+}
+
+// natsInfo is the part of the INFO a NATS server sends on connecting that
+// we use.
+type natsInfo struct {
+	MaxPayload  int  `json:"max_payload"`
+	TLSRequired bool `json:"tls_required"`
+}
+
+// NewNATSSink returns a sink publishing to subject on the server at
+// server_url, e.g. "nats://token@nats:4222".  No connection is made until
+// the first report is sent.  The URL must already have been checked with
+// parseNATSURL.
+func NewNATSSink(server_url string, subject string, hostname string) *NATSSink {
+	u, _ := parseNATSURL(server_url)
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), NATS_DEFAULT_PORT)
+	}
+	return &NATSSink{address: address, user: u.User, subject: subject, hostname: hostname}
+}
+
+// parseNATSURL parses a NATS server URL, which must be "nats://" or have
+// no scheme at all.
+func parseNATSURL(server_url string) (*url.URL, error) {
+	if !strings.Contains(server_url, "://") {
+		server_url = "nats://" + server_url
+	}
+	u, err := url.Parse(server_url)
+	if err != nil {
+		return nil, err
+	} else if u.Scheme != "nats" || u.Hostname() == "" {
+		return nil, fmt.Errorf("Not a NATS server URL: %s", server_url)
+	}
+	return u, nil
+}
+
+// Send publishes a report.  If it can't be, the connection is dropped, and
+// made again for the next report.
+func (n *NATSSink) Send(report *Report) error {
+	if n.conn == nil {
+		if err := n.connect(); err != nil {
+			return err
+		}
+	}
+	payload := report.JSON()
+	if n.max_payload > 0 && len(payload) > n.max_payload {
+		return fmt.Errorf("Report of %d bytes is larger than the NATS server's max_payload of %d",
+			len(payload), n.max_payload)
+	}
+	err := n.publish(payload)
+	if err != nil {
+		n.conn.Close()
+		n.conn = nil
+	}
+	return err
+}
+
+// connect connects to the server, reads its INFO and sends our CONNECT.
+func (n *NATSSink) connect() error {
+	conn, err := net.DialTimeout("tcp", n.address, NATS_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	n.conn = conn
+	n.reader = bufio.NewReader(conn)
+	if err := n.handshake(); err != nil {
+		conn.Close()
+		n.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (n *NATSSink) handshake() error {
+	n.conn.SetDeadline(time.Now().Add(NATS_TIMEOUT))
+	line, err := n.readLine()
+	if err != nil {
+		return err
+	} else if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("Expected INFO from NATS server, got %q", line)
+	}
+	var info natsInfo
+	if err := json.Unmarshal([]byte(line[len("INFO "):]), &info); err != nil {
+		return err
+	} else if info.TLSRequired {
+		return errors.New("NATS server requires TLS, which isn't supported")
+	}
+	n.max_payload = info.MaxPayload
+
+	options := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "mcsauna@" + n.hostname,
+		"lang":     "go",
+		"version":  "0",
+	}
+	if password, ok := n.user.Password(); ok {
+		options["user"] = n.user.Username()
+		options["pass"] = password
+	} else if n.user != nil {
+		options["auth_token"] = n.user.Username()
+	}
+	connect, _ := json.Marshal(options)
+	_, err = fmt.Fprintf(n.conn, "CONNECT %s\r\n", connect)
+	return err
+}
+
+// publish publishes a payload to our subject, then waits for the PONG to a
+// PING, answering any PINGs from the server meanwhile.
+func (n *NATSSink) publish(payload string) error {
+	n.conn.SetDeadline(time.Now().Add(NATS_TIMEOUT))
+	_, err := fmt.Fprintf(n.conn, "PUB %s %d\r\n%s\r\nPING\r\n", n.subject, len(payload), payload)
+	if err != nil {
+		return err
+	}
+	for {
+		line, err := n.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := fmt.Fprint(n.conn, "PONG\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS server error: %s", strings.TrimSpace(line[len("-ERR"):]))
+		}
+	}
+}
+
+func (n *NATSSink) readLine() (string, error) {
+	line, err := n.reader.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATSServer accepts connections, sending each CONNECT's options to
+// connects and each message published to messages as "<subject> <payload>".
+// It pings each client before answering its first PING, and answers PINGs
+// after the first with err, if set.
+func fakeNATSServer(listener net.Listener, err string, connects chan map[string]interface{}, messages chan string) {
+	for {
+		conn, accept_err := listener.Accept()
+		if accept_err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			fmt.Fprint(conn, `INFO {"server_id":"test","max_payload":1048576}`+"\r\n")
+			r := bufio.NewReader(conn)
+			pinged := false
+			for {
+				line, read_err := r.ReadString('\n')
+				if read_err != nil {
+					return
+				}
+				fields := strings.Fields(line)
+				switch fields[0] {
+				case "CONNECT":
+					options := map[string]interface{}{}
+					json.Unmarshal([]byte(line[len("CONNECT "):]), &options)
+					connects <- options
+				case "PUB":
+					var size int
+					fmt.Sscan(fields[2], &size)
+					payload := make([]byte, size+2)
+					io.ReadFull(r, payload)
+					messages <- fields[1] + " " + string(payload[:size])
+				case "PING":
+					if !pinged {
+						fmt.Fprint(conn, "PING\r\n")
+						pinged = true
+					} else if err != "" {
+						fmt.Fprint(conn, "-ERR '"+err+"'\r\n")
+						return
+					}
+					fmt.Fprint(conn, "PONG\r\n")
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestNATSSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	connects := make(chan map[string]interface{}, 1)
+	messages := make(chan string, 1)
+	go fakeNATSServer(listener, "", connects, messages)
+
+	sink := NewNATSSink("nats://user:secret@"+listener.Addr().String(),
+		"mcsauna.reports.cache1", "cache1")
+	report := NewReport(time.Unix(1500000000, 0))
+	report.Count(NewMetricName("mcsauna.keys").Label("key", "foo"), 3)
+	for i := 0; i < 2; i++ {
+		if err := sink.Send(report); err != nil {
+			t.Fatal(err)
+		}
+		if message := <-messages; message != "mcsauna.reports.cache1 "+report.JSON() {
+			t.Errorf("Unexpected message %q\n", message)
+		}
+	}
+	options := <-connects
+	if options["user"] != "user" || options["pass"] != "secret" || options["name"] != "mcsauna@cache1" {
+		t.Errorf("Unexpected CONNECT options %v\n", options)
+	}
+	select {
+	case <-connects:
+		t.Errorf("Expected a single connection\n")
+	default:
+	}
+}
+
+func TestNATSSinkError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	connects := make(chan map[string]interface{}, 2)
+	messages := make(chan string, 2)
+	go fakeNATSServer(listener, "Permissions Violation", connects, messages)
+
+	sink := NewNATSSink("token@"+listener.Addr().String(), "mcsauna", "cache1")
+	report := NewReport(time.Unix(1500000000, 0))
+	if err := sink.Send(report); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(report); err == nil || !strings.Contains(err.Error(), "Permissions Violation") {
+		t.Errorf("Expected the server's error, got %v\n", err)
+	}
+	if options := <-connects; options["auth_token"] != "token" {
+		t.Errorf("Expected a token, got %v\n", options)
+	}
+}
+
+func TestNATSConfig(t *testing.T) {
+	valid := []string{
+		`{"nats_url": "nats://nats:4222", "nats_subject": "mcsauna"}`,
+		`{"nats_url": "nats", "nats_subject": "mcsauna.{hostname}"}`,
+	}
+	for _, config := range valid {
+		if _, err := NewConfig([]byte(config)); err != nil {
+			t.Errorf("Unexpected error for %s: %v\n", config, err)
+		}
+	}
+	invalid := []string{
+		`{"nats_url": "http://nats:4222", "nats_subject": "mcsauna"}`,
+		`{"nats_url": "nats://nats:4222"}`,
+		`{"nats_url": "nats://nats:4222", "nats_subject": "mcsauna reports"}`,
+	}
+	for _, config := range invalid {
+		if _, err := NewConfig([]byte(config)); err == nil {
+			t.Errorf("Expected error for %s\n", config)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Where PagerDuty events are sent
+const PAGERDUTY_EVENTS_URL = "https://events.pagerduty.com/v2/enqueue"
+
+// Posts to Slack or PagerDuty time out after this long
+const NOTIFY_TIMEOUT = 10 * time.Second
+
+var PAGERDUTY_SEVERITIES = []string{"critical", "error", "warning", "info"}
+
+// alertChannel is somewhere people are notified of alerts, e.g. Slack.
+type alertChannel interface {
+	Trigger(alert *Alert) error
+	Resolve(alert *Alert) error
+}
+
+// AlertNotifier notifies a channel of the alerts raised in each report,
+// and of them resolving.  An alert is only notified again, while it holds
+// or if it is raised again, once cooldown has passed since it was last
+// notified, so a key that stays hot doesn't page every interval.
+type AlertNotifier struct {
+	channel  alertChannel
+	cooldown time.Duration
+
+	// Alerts notified that haven't resolved, and when each alert was last
+	// notified, by ID, until it has resolved and its cooldown has passed
+	open     map[string]*Alert
+	notified map[string]time.Time
+}
+
+func NewAlertNotifier(channel alertChannel, cooldown time.Duration) *AlertNotifier {
+	return &AlertNotifier{
+		channel:  channel,
+		cooldown: cooldown,
+		open:     map[string]*Alert{},
+		notified: map[string]time.Time{},
+	}
+}
+
+// Send notifies the channel of alerts raised in a report, and of any open
+// alerts not in it as resolved.  An alert that fails to be notified is
+// tried again with the next report.
+func (n *AlertNotifier) Send(report *Report) error {
+	var first_err error
+	raised := map[string]bool{}
+	for _, alert := range report.Alerts {
+		id := alert.ID()
+		if raised[id] {
+			continue
+		}
+		raised[id] = true
+		if last, ok := n.notified[id]; ok && report.Time.Sub(last) < n.cooldown {
+			continue
+		}
+		if err := n.channel.Trigger(alert); err != nil {
+			if first_err == nil {
+				first_err = err
+			}
+			continue
+		}
+		n.open[id] = alert
+		n.notified[id] = report.Time
+	}
+	for id, alert := range n.open {
+		if raised[id] {
+			continue
+		}
+		if err := n.channel.Resolve(alert); err != nil {
+			if first_err == nil {
+				first_err = err
+			}
+			continue
+		}
+		delete(n.open, id)
+	}
+	// ... forgotten once they can be notified again, so alerts on keys
+	// ... that come and go don't pile up
+	for id, last := range n.notified {
+		if n.open[id] == nil && report.Time.Sub(last) >= n.cooldown {
+			delete(n.notified, id)
+		}
+	}
+	return first_err
+}
+
+// SlackChannel posts alerts to a Slack incoming webhook.
+type SlackChannel struct {
+	url      string
+	hostname string
+	client   *http.Client
+}
+
+func NewSlackChannel(url string, hostname string) *SlackChannel {
+	return &SlackChannel{
+		url:      url,
+		hostname: hostname,
+		client:   &http.Client{Timeout: NOTIFY_TIMEOUT},
+	}
+}
+
+func (s *SlackChannel) Trigger(alert *Alert) error {
+	return postJSON(s.client, s.url, map[string]string{
+		"text": fmt.Sprintf(":rotating_light: %s on %s", alert.Summary(), s.hostname),
+	})
+}
+
+func (s *SlackChannel) Resolve(alert *Alert) error {
+	return postJSON(s.client, s.url, map[string]string{
+		"text": fmt.Sprintf(":white_check_mark: Resolved: %s on %s", alert.Summary(), s.hostname),
+	})
+}
+
+// PagerDutyChannel sends alerts to the PagerDuty Events API, as events
+// for the service with routing_key.  Each alert is its own incident,
+// which is resolved along with the alert.
+type PagerDutyChannel struct {
+	url         string
+	routing_key string
+	severity    string
+	hostname    string
+	client      *http.Client
+}
+
+func NewPagerDutyChannel(routing_key string, severity string, hostname string) *PagerDutyChannel {
+	return &PagerDutyChannel{
+		url:         PAGERDUTY_EVENTS_URL,
+		routing_key: routing_key,
+		severity:    severity,
+		hostname:    hostname,
+		client:      &http.Client{Timeout: NOTIFY_TIMEOUT},
+	}
+}
+
+func (p *PagerDutyChannel) Trigger(alert *Alert) error {
+	event := p.event("trigger", alert)
+	event["payload"] = map[string]interface{}{
+		"summary":  alert.Summary() + " on " + p.hostname,
+		"source":   p.hostname,
+		"severity": p.severity,
+		"custom_details": map[string]interface{}{
+			"rule":    alert.Rule,
+			"key":     alert.Key,
+			"command": alert.Command,
+			"hits":    alert.Hits,
+			"share":   alert.Share,
+		},
+	}
+	return postJSON(p.client, p.url, event)
+}
+
+func (p *PagerDutyChannel) Resolve(alert *Alert) error {
+	return postJSON(p.client, p.url, p.event("resolve", alert))
+}
+
+// event returns an event for an alert, which PagerDuty deduplicates by the
+// host and the alert's ID.
+func (p *PagerDutyChannel) event(action string, alert *Alert) map[string]interface{} {
+	return map[string]interface{}{
+		"routing_key":  p.routing_key,
+		"event_action": action,
+		"dedup_key":    p.hostname + "/" + alert.ID(),
+	}
+}
+
+// postJSON posts a value, as JSON, to url.
+func postJSON(client *http.Client, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s responded %s", url, resp.Status)
+	}
+	return nil
+}
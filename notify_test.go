@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testChannel records the IDs of alerts it's notified of.
+type testChannel struct {
+	notified []string
+}
+
+func (c *testChannel) Trigger(alert *Alert) error {
+	c.notified = append(c.notified, "trigger "+alert.ID())
+	return nil
+}
+
+func (c *testChannel) Resolve(alert *Alert) error {
+	c.notified = append(c.notified, "resolve "+alert.ID())
+	return nil
+}
+
+func TestAlertNotifierCooldown(t *testing.T) {
+	channel := &testChannel{}
+	notifier := NewAlertNotifier(channel, time.Hour)
+	hot := &Alert{Rule: "hot", Key: "foo", Hits: 10}
+	now := time.Unix(1500000000, 0)
+
+	/* foo is hot for two reports, cools off, and is hot again within the
+	 * cooldown, then is still hot once the cooldown has passed */
+	reports := [][]*Alert{{hot, hot}, {hot}, {}, {hot}, {hot}}
+	times := []time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute, time.Hour}
+	for i, alerts := range reports {
+		report := NewReport(now.Add(times[i]))
+		report.Alerts = alerts
+		if err := notifier.Send(report); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{"trigger hot//foo", "resolve hot//foo", "trigger hot//foo"}
+	if len(channel.notified) != len(expected) {
+		t.Fatalf("Expected %v, got %v\n", expected, channel.notified)
+	}
+	for i := range expected {
+		if channel.notified[i] != expected[i] {
+			t.Errorf("Expected %v, got %v\n", expected, channel.notified)
+		}
+	}
+}
+
+func TestAlertNotifierForgets(t *testing.T) {
+	channel := &testChannel{}
+	notifier := NewAlertNotifier(channel, time.Hour)
+	now := time.Unix(1500000000, 0)
+
+	/* Alerts on a new key each report are only remembered until they've
+	 * resolved and their cooldown has passed */
+	for i := 0; i < 120; i++ {
+		report := NewReport(now.Add(time.Duration(i) * time.Minute))
+		report.Alerts = []*Alert{{Rule: "hot", Key: fmt.Sprintf("key_%d", i), Hits: 10}}
+		if err := notifier.Send(report); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(notifier.notified) != 60 || len(notifier.open) != 1 {
+		t.Errorf("Expected 60 alerts remembered and 1 open, got %d and %d\n",
+			len(notifier.notified), len(notifier.open))
+	}
+}
+
+func TestPagerDutyChannel(t *testing.T) {
+	events := []map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&event)
+		events = append(events, event)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	channel := NewPagerDutyChannel("abc", "warning", "web1")
+	channel.url = server.URL
+	alert := &Alert{Rule: "hot_get", Key: "foo", Command: "get", Hits: 60, Share: 0.6}
+	if err := channel.Trigger(alert); err != nil {
+		t.Fatal(err)
+	}
+	if err := channel.Resolve(alert); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d\n", len(events))
+	}
+	for i, action := range []string{"trigger", "resolve"} {
+		if events[i]["event_action"] != action || events[i]["dedup_key"] != "web1/hot_get/get/foo" {
+			t.Errorf("Expected a %s event for web1/hot_get/get/foo, got %v\n", action, events[i])
+		}
+	}
+	payload, _ := events[0]["payload"].(map[string]interface{})
+	expected := `hot_get: key "foo" had 60 get hits (60.0% of all) in an interval on web1`
+	if payload["summary"] != expected {
+		t.Errorf("Expected summary %q, got %q\n", expected, payload["summary"])
+	}
+}
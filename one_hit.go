@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// OneHitWonders tracks how many of the distinct keys seen are only seen
+// once, overall and by group.  A high proportion of one-hit wonders usually
+// means keys are being built with something that changes on every request,
+// e.g. a timestamp, defeating the cache.
+//
+// Unlike CardinalityPool, every key is held on to, so this is exact but
+// takes memory in proportion to the number of distinct keys.
+type OneHitWonders struct {
+	Lock sync.Mutex
+
+	// Map of group names to hits by key.  Keys not in a group are under "".
+	groups map[string]map[string]int
+}
+
+func NewOneHitWonders() *OneHitWonders {
+	o := &OneHitWonders{}
+	o.groups = make(map[string]map[string]int)
+	return o
+}
+
+// Add adds a hit for key, in group if it isn't empty.
+func (o *OneHitWonders) Add(key string, group string) {
+	o.Lock.Lock()
+	defer o.Lock.Unlock()
+
+	keys, ok := o.groups[group]
+	if !ok {
+		keys = make(map[string]int)
+		o.groups[group] = keys
+	}
+	keys[key] += 1
+}
+
+// Ratio returns the fraction of distinct keys that were seen exactly once.
+func (o *OneHitWonders) Ratio() float64 {
+	o.Lock.Lock()
+	defer o.Lock.Unlock()
+
+	once, distinct := 0, 0
+	for _, keys := range o.groups {
+		group_once, group_distinct := oneHitCounts(keys)
+		once += group_once
+		distinct += group_distinct
+	}
+	return oneHitRatio(once, distinct)
+}
+
+// GroupRatio returns the fraction of distinct keys in group that were seen
+// exactly once.
+func (o *OneHitWonders) GroupRatio(group string) float64 {
+	o.Lock.Lock()
+	defer o.Lock.Unlock()
+
+	return oneHitRatio(oneHitCounts(o.groups[group]))
+}
+
+// Groups returns every group that has been added to, sorted.
+func (o *OneHitWonders) Groups() []string {
+	o.Lock.Lock()
+	defer o.Lock.Unlock()
+
+	groups := make([]string, 0, len(o.groups))
+	for group := range o.groups {
+		if group != "" {
+			groups = append(groups, group)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// Rotate clears the data on the existing OneHitWonders, returning a new one
+// containing the old data.
+func (o *OneHitWonders) Rotate() *OneHitWonders {
+	o.Lock.Lock()
+	defer o.Lock.Unlock()
+
+	// Clone existing
+	new_one_hit_wonders := &OneHitWonders{}
+	new_one_hit_wonders.groups = o.groups
+
+	// Clear existing values
+	o.groups = make(map[string]map[string]int)
+	return new_one_hit_wonders
+}
+
+// oneHitCounts returns the number of keys with exactly one hit, and the
+// number of keys.
+func oneHitCounts(keys map[string]int) (int, int) {
+	once := 0
+	for _, hits := range keys {
+		if hits == 1 {
+			once += 1
+		}
+	}
+	return once, len(keys)
+}
+
+func oneHitRatio(once int, distinct int) float64 {
+	if distinct == 0 {
+		return 0
+	}
+	return float64(once) / float64(distinct)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestOneHitWonders(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"report_one_hit_wonders": true, "regexps": [{"name": "user", "re": "^user_"}]}`))
+	regexp_keys, _ := NewRegexpKeysFromConfig(config.Regexps)
+	stats := NewStats(config)
+	processor := NewProcessor(config, regexp_keys, stats)
+
+	processor.countKeys([]string{"user_1", "user_2", "user_1", "other", "other", "another"}, 0)
+
+	rotated := stats.Rotate()
+	if ratio := rotated.OneHitWonders.Ratio(); ratio != 0.5 {
+		t.Errorf("Expected half of keys to be seen once, got %v\n", ratio)
+	}
+	if ratio := rotated.OneHitWonders.GroupRatio("user"); ratio != 0.5 {
+		t.Errorf("Expected half of user keys to be seen once, got %v\n", ratio)
+	}
+	if ratio := rotated.OneHitWonders.GroupRatio("missing"); ratio != 0 {
+		t.Errorf("Expected no ratio for a missing group, got %v\n", ratio)
+	}
+	if !stringsEqual(rotated.OneHitWonders.Groups(), []string{"user"}) {
+		t.Errorf("Expected only the user group, got %v\n", rotated.OneHitWonders.Groups())
+	}
+	if ratio := stats.OneHitWonders.Ratio(); ratio != 0 {
+		t.Errorf("Expected rotated pool to be cleared, got %v\n", ratio)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const OTLP_TIMEOUT = 10 * time.Second
+
+// OTLP aggregation temporalities
+const (
+	OTLP_DELTA      = 1
+	OTLP_CUMULATIVE = 2
+)
+
+// OTLPSink exports reports to an OpenTelemetry collector, using OTLP over
+// HTTP with JSON encoding.  Metrics are attributed to a resource identified
+// by the host name and a service name.
+type OTLPSink struct {
+	url    string
+	client *http.Client
+
+	resource otlpResource
+
+	// Whether counts keep increasing across intervals rather than resetting
+	cumulative bool
+
+	// When counts started being counted from: when we started if
+	// cumulative, or the end of the last interval if not
+	start time.Time
+}
+
+// NewOTLPSink returns a sink posting to url, e.g.
+// "http://localhost:4318/v1/metrics".
+func NewOTLPSink(url string, service string, cumulative bool) *OTLPSink {
+	host, _ := os.Hostname()
+	return &OTLPSink{
+		url:    url,
+		client: &http.Client{Timeout: OTLP_TIMEOUT},
+		resource: otlpResource{[]otlpAttribute{
+			otlpAttribute{"host.name", otlpValue{host}},
+			otlpAttribute{"service.name", otlpValue{service}},
+		}},
+		cumulative: cumulative,
+		start:      time.Now(),
+	}
+}
+
+func (s *OTLPSink) Send(report *Report) error {
+	body, err := json.Marshal(formatOTLP(report, s.resource, s.start, s.cumulative))
+	if err != nil {
+		return err
+	}
+	if !s.cumulative {
+		s.start = report.Time
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP collector responded %s", resp.Status)
+	}
+	return nil
+}
+
+// The subset of the OTLP metrics data model, as JSON, that we use
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+// Integers are 64 bit, so are written as strings
+type otlpDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsInt             *string         `json:"asInt,omitempty"`
+	AsDouble          *float64        `json:"asDouble,omitempty"`
+}
+
+// formatOTLP converts a report to an OTLP export request, with a metric for
+// each family of metric names, and the rest of each name as attributes.
+// Counts are monotonic sums since start, and everything else is a gauge.
+func formatOTLP(report *Report, resource otlpResource, start time.Time, cumulative bool) otlpRequest {
+	temporality := OTLP_DELTA
+	if cumulative {
+		temporality = OTLP_CUMULATIVE
+	}
+	now := strconv.FormatInt(report.Time.UnixNano(), 10)
+
+	metrics := []otlpMetric{}
+	families := make(map[string]int)
+	for _, m := range report.Metrics {
+		family := m.Name.Family()
+		i, ok := families[family]
+		if !ok {
+			i = len(metrics)
+			families[family] = i
+			metric := otlpMetric{Name: family}
+			if m.Counter {
+				metric.Sum = &otlpSum{
+					AggregationTemporality: temporality,
+					IsMonotonic:            true,
+				}
+			} else {
+				metric.Gauge = &otlpGauge{}
+			}
+			metrics = append(metrics, metric)
+		}
+
+		point := otlpDataPoint{TimeUnixNano: now}
+		for _, label := range m.Name.Labels() {
+			point.Attributes = append(point.Attributes,
+				otlpAttribute{label.Label, otlpValue{label.Value}})
+		}
+		if metrics[i].Sum != nil {
+			point.StartTimeUnixNano = strconv.FormatInt(start.UnixNano(), 10)
+			value := strconv.FormatInt(int64(m.Value), 10)
+			point.AsInt = &value
+			metrics[i].Sum.DataPoints = append(metrics[i].Sum.DataPoints, point)
+		} else {
+			value := m.Value
+			point.AsDouble = &value
+			metrics[i].Gauge.DataPoints = append(metrics[i].Gauge.DataPoints, point)
+		}
+	}
+
+	return otlpRequest{[]otlpResourceMetrics{otlpResourceMetrics{
+		Resource: resource,
+		ScopeMetrics: []otlpScopeMetrics{otlpScopeMetrics{
+			Scope:   otlpScope{"mcsauna"},
+			Metrics: metrics,
+		}},
+	}}}
+}
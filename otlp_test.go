@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatOTLP(t *testing.T) {
+	report := NewReport(time.Unix(1500000010, 0))
+	report.Count(NewMetricName("mcsauna.keys").Label("key", "foo"), 3)
+	report.Count(NewMetricName("mcsauna.keys").Label("key", "bar"), 0)
+	report.Gauge(NewMetricName("mcsauna.distribution.gini"), 0.25)
+	resource := otlpResource{[]otlpAttribute{otlpAttribute{"service.name", otlpValue{"mcsauna"}}}}
+
+	request := formatOTLP(report, resource, time.Unix(1500000000, 0), false)
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"resourceMetrics":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"mcsauna"}}]},` +
+		`"scopeMetrics":[{"scope":{"name":"mcsauna"},"metrics":[` +
+		`{"name":"mcsauna.keys","sum":{"dataPoints":[` +
+		`{"attributes":[{"key":"key","value":{"stringValue":"foo"}}],"startTimeUnixNano":"1500000000000000000","timeUnixNano":"1500000010000000000","asInt":"3"},` +
+		`{"attributes":[{"key":"key","value":{"stringValue":"bar"}}],"startTimeUnixNano":"1500000000000000000","timeUnixNano":"1500000010000000000","asInt":"0"}` +
+		`],"aggregationTemporality":1,"isMonotonic":true}},` +
+		`{"name":"mcsauna.distribution.gini","gauge":{"dataPoints":[{"timeUnixNano":"1500000010000000000","asDouble":0.25}]}}` +
+		`]}]}]}`
+	if string(body) != expected {
+		t.Errorf("Expected %s, got %s\n", expected, body)
+	}
+}
+
+func TestOTLPSink(t *testing.T) {
+	posted := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		posted = append(posted, string(body))
+	}))
+	defer server.Close()
+
+	sink := NewOTLPSink(server.URL+"/v1/metrics", "mcsauna", false)
+	for i := 1; i <= 2; i++ {
+		report := NewReport(time.Unix(1500000000+int64(i)*5, 0))
+		report.Count(NewMetricName("mcsauna.keys").Label("key", "foo"), i)
+		if err := sink.Send(report); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(posted) != 2 || !strings.Contains(posted[0], `"service.name","value":{"stringValue":"mcsauna"}`) {
+		t.Fatalf("Expected 2 requests with the service name, got %q\n", posted)
+	}
+	// ... each interval's counts start where the last left off
+	if !strings.Contains(posted[1], `"startTimeUnixNano":"1500000005000000000"`) {
+		t.Errorf("Expected second interval to start at the end of the first, got %s\n", posted[1])
+	}
+}
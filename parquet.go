@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const PARQUET_MAGIC = "PAR1"
+
+// Parquet physical types, converted types, encodings and page types
+const (
+	PARQUET_INT64      = 2
+	PARQUET_DOUBLE     = 5
+	PARQUET_BYTE_ARRAY = 6
+
+	PARQUET_UTF8             = 0
+	PARQUET_TIMESTAMP_MILLIS = 9
+
+	PARQUET_PLAIN = 0
+	PARQUET_RLE   = 3
+
+	PARQUET_DATA_PAGE = 0
+)
+
+// Thrift compact protocol types
+const (
+	THRIFT_I32    = 5
+	THRIFT_I64    = 6
+	THRIFT_BINARY = 8
+	THRIFT_LIST   = 9
+	THRIFT_STRUCT = 12
+)
+
+// parquetColumn is a column of the rows written: its name, physical type,
+// converted type (or -1 for none), and how to get its value from a metric.
+type parquetColumn struct {
+	name      string
+	kind      int
+	converted int
+	value     func(row *parquetRow) interface{}
+}
+
+// parquetRow is a row per metric, as in SQLite, with the host it came from.
+type parquetRow struct {
+	time    time.Time
+	host    string
+	name    string
+	key     string
+	command string
+	value   float64
+}
+
+var PARQUET_COLUMNS = []parquetColumn{
+	{"time", PARQUET_INT64, PARQUET_TIMESTAMP_MILLIS,
+		func(r *parquetRow) interface{} { return r.time.UnixNano() / int64(time.Millisecond) }},
+	{"host", PARQUET_BYTE_ARRAY, PARQUET_UTF8, func(r *parquetRow) interface{} { return r.host }},
+	{"name", PARQUET_BYTE_ARRAY, PARQUET_UTF8, func(r *parquetRow) interface{} { return r.name }},
+	{"key", PARQUET_BYTE_ARRAY, PARQUET_UTF8, func(r *parquetRow) interface{} { return r.key }},
+	{"command", PARQUET_BYTE_ARRAY, PARQUET_UTF8, func(r *parquetRow) interface{} { return r.command }},
+	{"value", PARQUET_DOUBLE, -1, func(r *parquetRow) interface{} { return r.value }},
+}
+
+// ParquetSink writes reports to Parquet files in a directory, a row group
+// per report, starting a new file every rotate.  Files are written with a
+// ".tmp" suffix, which is dropped once they are finished, so anything
+// reading "*.parquet" only ever sees whole files.  The file being written
+// is finished when mcsauna exits.
+type ParquetSink struct {
+	dir    string
+	host   string
+	rotate time.Duration
+
+	// The file being written, if any, when it was started, where in it the
+	// next row group goes, and the metadata of those written so far
+	f          *os.File
+	started    time.Time
+	offset     int64
+	row_groups []*parquetRowGroup
+}
+
+type parquetRowGroup struct {
+	num_rows int
+	columns  []*parquetColumnChunk
+}
+
+type parquetColumnChunk struct {
+	offset int64
+	size   int
+}
+
+func NewParquetSink(dir string, host string, rotate time.Duration) *ParquetSink {
+	return &ParquetSink{dir: dir, host: host, rotate: rotate}
+}
+
+// Send writes a report as a row group, first finishing the file being
+// written if it is due to be rotated.  If the report can't be written, the
+// file is abandoned.
+func (s *ParquetSink) Send(report *Report) error {
+	if s.f != nil && !report.Time.Before(s.started.Add(s.rotate)) {
+		if err := s.Close(); err != nil {
+			return err
+		}
+	}
+	if len(report.Metrics) == 0 {
+		return nil
+	}
+	if s.f == nil {
+		path := filepath.Join(s.dir, fmt.Sprintf("mcsauna-%s-%s.parquet.tmp",
+			s.host, report.Time.UTC().Format("20060102T150405Z")))
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		s.f, s.started, s.offset, s.row_groups = f, report.Time, 0, nil
+		if err := s.write([]byte(PARQUET_MAGIC)); err != nil {
+			return s.abandon(err)
+		}
+	}
+
+	rows := make([]*parquetRow, len(report.Metrics))
+	for i, m := range report.Metrics {
+		name, key, command := m.Name.SplitKeyCommand()
+		rows[i] = &parquetRow{report.Time, s.host, name.String(), key, command, m.Value}
+	}
+	row_group := &parquetRowGroup{num_rows: len(rows)}
+	for _, column := range PARQUET_COLUMNS {
+		chunk := parquetColumnData(column, rows)
+		row_group.columns = append(row_group.columns,
+			&parquetColumnChunk{offset: s.offset, size: len(chunk)})
+		if err := s.write(chunk); err != nil {
+			return s.abandon(err)
+		}
+	}
+	s.row_groups = append(s.row_groups, row_group)
+	return nil
+}
+
+// Close finishes the file being written, if any, writing its footer and
+// dropping its ".tmp" suffix.
+func (s *ParquetSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	footer := parquetFooter(s.row_groups)
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(footer)))
+	footer = append(append(footer, length...), PARQUET_MAGIC...)
+	if err := s.write(footer); err != nil {
+		return s.abandon(err)
+	}
+	tmp := s.f.Name()
+	err := s.f.Close()
+	s.f = nil
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, tmp[:len(tmp)-len(".tmp")])
+}
+
+func (s *ParquetSink) write(data []byte) error {
+	n, err := s.f.Write(data)
+	s.offset += int64(n)
+	return err
+}
+
+// abandon closes and removes the file being written, after an error.
+func (s *ParquetSink) abandon(err error) error {
+	s.f.Close()
+	os.Remove(s.f.Name())
+	s.f = nil
+	return err
+}
+
+// parquetColumnData returns a column chunk of a single uncompressed data
+// page, holding a column of rows in plain encoding.  The columns are all
+// required, so there are no repetition or definition levels.
+func parquetColumnData(column parquetColumn, rows []*parquetRow) []byte {
+	var page bytes.Buffer
+	for _, row := range rows {
+		switch v := column.value(row).(type) {
+		case int64:
+			binary.Write(&page, binary.LittleEndian, v)
+		case float64:
+			binary.Write(&page, binary.LittleEndian, math.Float64bits(v))
+		case string:
+			binary.Write(&page, binary.LittleEndian, uint32(len(v)))
+			page.WriteString(v)
+		}
+	}
+
+	header := &thriftEncoder{}
+	header.I32(1, PARQUET_DATA_PAGE)
+	header.I32(2, int32(page.Len()))
+	header.I32(3, int32(page.Len()))
+	header.Struct(5)
+	header.I32(1, int32(len(rows)))
+	header.I32(2, PARQUET_PLAIN)
+	header.I32(3, PARQUET_RLE)
+	header.I32(4, PARQUET_RLE)
+	header.End()
+	header.End()
+	return append(header.Bytes(), page.Bytes()...)
+}
+
+// parquetFooter returns the FileMetaData of a file of row groups.
+func parquetFooter(row_groups []*parquetRowGroup) []byte {
+	num_rows := 0
+	for _, row_group := range row_groups {
+		num_rows += row_group.num_rows
+	}
+
+	e := &thriftEncoder{}
+	e.I32(1, 1)
+	e.List(2, THRIFT_STRUCT, len(PARQUET_COLUMNS)+1)
+	e.Begin()
+	e.Binary(4, []byte("schema"))
+	e.I32(5, int32(len(PARQUET_COLUMNS)))
+	e.End()
+	for _, column := range PARQUET_COLUMNS {
+		e.Begin()
+		e.I32(1, int32(column.kind))
+		e.I32(3, 0) // required
+		e.Binary(4, []byte(column.name))
+		if column.converted >= 0 {
+			e.I32(6, int32(column.converted))
+		}
+		e.End()
+	}
+	e.I64(3, int64(num_rows))
+	e.List(4, THRIFT_STRUCT, len(row_groups))
+	for _, row_group := range row_groups {
+		total_size := 0
+		e.Begin()
+		e.List(1, THRIFT_STRUCT, len(row_group.columns))
+		for i, chunk := range row_group.columns {
+			column := PARQUET_COLUMNS[i]
+			total_size += chunk.size
+			e.Begin()
+			e.I64(2, chunk.offset)
+			e.Struct(3)
+			e.I32(1, int32(column.kind))
+			e.List(2, THRIFT_I32, 2)
+			e.varint(zigzag(PARQUET_PLAIN))
+			e.varint(zigzag(PARQUET_RLE))
+			e.List(3, THRIFT_BINARY, 1)
+			e.binary([]byte(column.name))
+			e.I32(4, 0) // uncompressed
+			e.I64(5, int64(row_group.num_rows))
+			e.I64(6, int64(chunk.size))
+			e.I64(7, int64(chunk.size))
+			e.I64(9, chunk.offset)
+			e.End()
+			e.End()
+		}
+		e.I64(2, int64(total_size))
+		e.I64(3, int64(row_group.num_rows))
+		e.End()
+	}
+	e.Binary(6, []byte("mcsauna"))
+	e.End()
+	return e.Bytes()
+}
+
+// thriftEncoder writes structs in the Thrift compact protocol, in which
+// each field's id is written as the difference from the last field's in
+// the same struct.  Fields are written into the outermost struct, with
+// Struct or Begin starting a nested one and End ending one.
+type thriftEncoder struct {
+	bytes.Buffer
+
+	// The id of the last field written in each struct being written,
+	// innermost last
+	last_ids []int
+}
+
+func (e *thriftEncoder) field(id int, kind int) {
+	if len(e.last_ids) == 0 {
+		e.last_ids = []int{0}
+	}
+	last := &e.last_ids[len(e.last_ids)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		e.WriteByte(byte(delta<<4 | kind))
+	} else {
+		e.WriteByte(byte(kind))
+		e.varint(zigzag(int64(id)))
+	}
+	*last = id
+}
+
+func (e *thriftEncoder) varint(v uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	e.Write(buf[:binary.PutUvarint(buf, v)])
+}
+
+func (e *thriftEncoder) binary(value []byte) {
+	e.varint(uint64(len(value)))
+	e.Write(value)
+}
+
+func (e *thriftEncoder) I32(id int, v int32) {
+	e.field(id, THRIFT_I32)
+	e.varint(zigzag(int64(v)))
+}
+
+func (e *thriftEncoder) I64(id int, v int64) {
+	e.field(id, THRIFT_I64)
+	e.varint(zigzag(v))
+}
+
+func (e *thriftEncoder) Binary(id int, value []byte) {
+	e.field(id, THRIFT_BINARY)
+	e.binary(value)
+}
+
+// List starts a list field of size elements of a type, which are then
+// written without field headers; structs with Begin and End.
+func (e *thriftEncoder) List(id int, kind int, size int) {
+	e.field(id, THRIFT_LIST)
+	if size < 15 {
+		e.WriteByte(byte(size<<4 | kind))
+	} else {
+		e.WriteByte(byte(0xf0 | kind))
+		e.varint(uint64(size))
+	}
+}
+
+// Struct starts a struct field.
+func (e *thriftEncoder) Struct(id int) {
+	e.field(id, THRIFT_STRUCT)
+	e.Begin()
+}
+
+// Begin starts a struct, e.g. as an element of a list.
+func (e *thriftEncoder) Begin() {
+	if len(e.last_ids) == 0 {
+		e.last_ids = []int{0}
+	}
+	e.last_ids = append(e.last_ids, 0)
+}
+
+// End ends the innermost struct.
+func (e *thriftEncoder) End() {
+	e.WriteByte(0)
+	if len(e.last_ids) > 0 {
+		e.last_ids = e.last_ids[:len(e.last_ids)-1]
+	}
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
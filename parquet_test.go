@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// thriftDecoder reads Thrift compact protocol structs into maps of field
+// ids to values, with lists as slices.
+type thriftDecoder struct {
+	data []byte
+}
+
+func (d *thriftDecoder) varint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *thriftDecoder) value(kind int) interface{} {
+	switch kind {
+	case THRIFT_I32, THRIFT_I64:
+		v := d.varint()
+		return int64(v>>1) ^ -int64(v&1)
+	case THRIFT_BINARY:
+		n := int(d.varint())
+		b := string(d.data[:n])
+		d.data = d.data[n:]
+		return b
+	case THRIFT_LIST:
+		header := int(d.data[0])
+		d.data = d.data[1:]
+		size := header >> 4
+		if size == 15 {
+			size = int(d.varint())
+		}
+		list := []interface{}{}
+		for i := 0; i < size; i++ {
+			list = append(list, d.value(header&15))
+		}
+		return list
+	case THRIFT_STRUCT:
+		fields := map[int]interface{}{}
+		last := 0
+		for {
+			header := int(d.data[0])
+			d.data = d.data[1:]
+			if header == 0 {
+				return fields
+			}
+			id := last + header>>4
+			if header>>4 == 0 {
+				id = int(d.value(THRIFT_I32).(int64))
+			}
+			fields[id] = d.value(header & 15)
+			last = id
+		}
+	}
+	panic("unexpected thrift type")
+}
+
+func TestParquetSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcsauna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	/* Two reports go in the first file, and one in the second, which is
+	 * finished by closing the sink */
+	sink := NewParquetSink(dir, "cache1", time.Minute)
+	now := time.Unix(1500000000, 0)
+	for i, hits := range []int{1, 2, 3} {
+		if err := sink.Send(testReport(now.Add(time.Duration(i)*40*time.Second), hits)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.parquet")); len(files) != 1 {
+		t.Errorf("Expected one finished file before closing, got %v\n", files)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 2 {
+		t.Fatalf("Expected two finished files, got %v\n", files)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "mcsauna-cache1-20170714T024000Z.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[:4]) != PARQUET_MAGIC || string(data[len(data)-4:]) != PARQUET_MAGIC {
+		t.Fatalf("Expected the file to start and end with %s\n", PARQUET_MAGIC)
+	}
+	length := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := (&thriftDecoder{data[len(data)-8-length : len(data)-8]}).value(THRIFT_STRUCT).(map[int]interface{})
+	if rows := footer[3].(int64); rows != 2 {
+		t.Errorf("Expected 2 rows, got %d\n", rows)
+	}
+	names := []string{}
+	for _, element := range footer[2].([]interface{}) {
+		names = append(names, element.(map[int]interface{})[4].(string))
+	}
+	if expected := []string{"schema", "time", "host", "name", "key", "command", "value"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected columns %v, got %v\n", expected, names)
+	}
+
+	/* Read the values of the "value" column of the second row group */
+	row_groups := footer[4].([]interface{})
+	if len(row_groups) != 2 {
+		t.Fatalf("Expected 2 row groups, got %d\n", len(row_groups))
+	}
+	columns := row_groups[1].(map[int]interface{})[1].([]interface{})
+	offset := columns[5].(map[int]interface{})[2].(int64)
+	d := &thriftDecoder{data[offset:]}
+	header := d.value(THRIFT_STRUCT).(map[int]interface{})
+	if num_values := header[5].(map[int]interface{})[1].(int64); num_values != 1 {
+		t.Errorf("Expected 1 value, got %d\n", num_values)
+	}
+	if value := binary.LittleEndian.Uint64(d.data); value != 0x4000000000000000 {
+		t.Errorf("Expected 2.0, got %x\n", value)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// lookupUser returns the uid, gid and groups to switch to for user_name, a
+// name or uid, and group_name, a name or gid, if set, or otherwise the
+// user's own group.
+func lookupUser(user_name string, group_name string) (*syscall.Credential, error) {
+	u, err := user.Lookup(user_name)
+	if _, is_unknown := err.(user.UnknownUserError); is_unknown {
+		u, err = user.LookupId(user_name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("no such user '%s'", user_name)
+	}
+	credential := &syscall.Credential{}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user '%s' has a uid that isn't a number, '%s'", user_name, u.Uid)
+	}
+	credential.Uid = uint32(uid)
+	gid_text := u.Gid
+	if group_name != "" {
+		g, err := user.LookupGroup(group_name)
+		if _, is_unknown := err.(user.UnknownGroupError); is_unknown {
+			g, err = user.LookupGroupId(group_name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("no such group '%s'", group_name)
+		}
+		gid_text = g.Gid
+	}
+	gid, err := strconv.ParseUint(gid_text, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user '%s' has a gid that isn't a number, '%s'", user_name, gid_text)
+	}
+	credential.Gid = uint32(gid)
+
+	group_ids, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("can't list the groups of user '%s': %v", user_name, err)
+	}
+	credential.Groups = []uint32{credential.Gid}
+	for _, group_id := range group_ids {
+		if gid, err := strconv.ParseUint(group_id, 10, 32); err == nil && uint32(gid) != credential.Gid {
+			credential.Groups = append(credential.Groups, uint32(gid))
+		}
+	}
+	return credential, nil
+}
+
+// pidFileRemovable returns whether the user with credential can remove the
+// pidfile at path, written by root, from its directory.  Only the mode of the
+// directory is checked, not any ACLs.
+func pidFileRemovable(path string, credential *syscall.Credential) bool {
+	if credential.Uid == 0 {
+		return true
+	}
+	info, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	// ... in a sticky directory, like /tmp, only the owner of the directory
+	// ... can remove a file of root's
+	if info.Mode()&os.ModeSticky != 0 && stat.Uid != credential.Uid {
+		return false
+	}
+	perm := uint32(info.Mode().Perm())
+	if stat.Uid == credential.Uid {
+		perm >>= 6
+	} else {
+		for _, gid := range credential.Groups {
+			if stat.Gid == gid {
+				perm >>= 3
+				break
+			}
+		}
+	}
+	// ... written to and searched
+	return perm&3 == 3
+}
+
+// dropPrivileges changes mcsauna's root directory to chroot, if set, then
+// switches to user_name and group_name, as lookupUser finds them, if
+// user_name is set.  The capture handle, and any files already open, stay
+// open.  It fails unless root's privileges are gone for good afterwards,
+// and, before dropping them, unless the user can remove pid_file, if set,
+// when mcsauna stops.
+func dropPrivileges(user_name string, group_name string, chroot string, pid_file string) error {
+	if user_name == "" {
+		return nil
+	}
+	// ... before chrooting, while the user and group databases are there
+	credential, err := lookupUser(user_name, group_name)
+	if err != nil {
+		return exitError(EXIT_CONFIG, "Config error: %w", err)
+	}
+	if os.Geteuid() != 0 {
+		if uint32(os.Geteuid()) == credential.Uid && chroot == "" {
+			// ... already, e.g. with capabilities to capture as that user
+			return nil
+		}
+		return exitError(EXIT_PERMISSION, "can't switch to user %s without running as root", user_name)
+	}
+	if pid_file != "" && !pidFileRemovable(pid_file, credential) {
+		return exitError(EXIT_CONFIG, "Config error: user %s must be able to write to %s, "+
+			"to remove the pidfile when mcsauna stops", user_name, filepath.Dir(pid_file))
+	}
+
+	if chroot != "" {
+		if err := syscall.Chroot(chroot); err != nil {
+			return exitError(EXIT_PERMISSION, "can't chroot to %s: %w", chroot, err)
+		}
+		if err := os.Chdir("/"); err != nil {
+			return exitError(EXIT_PERMISSION, "can't chroot to %s: %w", chroot, err)
+		}
+	}
+	// ... groups first, as only root can change them
+	groups := make([]int, len(credential.Groups))
+	for i, gid := range credential.Groups {
+		groups[i] = int(gid)
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return exitError(EXIT_PERMISSION, "can't switch to the groups of user %s: %w", user_name, err)
+	}
+	if err := syscall.Setgid(int(credential.Gid)); err != nil {
+		return exitError(EXIT_PERMISSION, "can't switch to gid %d: %w", credential.Gid, err)
+	}
+	if err := syscall.Setuid(int(credential.Uid)); err != nil {
+		return exitError(EXIT_PERMISSION, "can't switch to user %s: %w", user_name, err)
+	}
+	if credential.Uid != 0 && syscall.Setuid(0) == nil {
+		return exitError(EXIT_PERMISSION, "switched to user %s, but could switch back to root", user_name)
+	}
+	logger.Info("Dropped privileges", "user", user_name, "uid", credential.Uid,
+		"gid", credential.Gid, "chroot", chroot)
+	return nil
+}
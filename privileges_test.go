@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestLookupUser(t *testing.T) {
+	/* By name or uid, with the user's group, or the one given */
+	for _, test := range [][2]string{{"root", ""}, {"0", ""}, {"root", "root"}, {"0", "0"}} {
+		credential, err := lookupUser(test[0], test[1])
+		if err != nil {
+			t.Errorf("Expected user %s and group '%s' to be found, got %v\n", test[0], test[1], err)
+		} else if credential.Uid != 0 || credential.Gid != 0 || credential.Groups[0] != 0 {
+			t.Errorf("Expected uid and gid 0 for user %s and group '%s', got %+v\n",
+				test[0], test[1], credential)
+		}
+	}
+	if _, err := lookupUser("no-such-mcsauna-user", ""); err == nil {
+		t.Errorf("Expected an error for an unknown user\n")
+	}
+	if _, err := lookupUser("root", "no-such-mcsauna-group"); err == nil {
+		t.Errorf("Expected an error for an unknown group\n")
+	}
+}
+
+func TestPrivilegesConfig(t *testing.T) {
+	if _, err := NewConfig([]byte(`{"chroot": "/var/empty"}`)); err == nil {
+		t.Errorf("Expected an error for a chroot without a user\n")
+	}
+	if _, err := NewConfig([]byte(`{"user": "nobody", "chroot": "/var/empty"}`)); err != nil {
+		t.Errorf("Expected a user and chroot to be valid, got %v\n", err)
+	}
+	if _, err := NewConfig([]byte(`{"user": "nobody", "chroot": "/var/empty", "pidfile": "/run/mcsauna.pid"}`)); err == nil {
+		t.Errorf("Expected an error for a pidfile with a chroot\n")
+	}
+}
+
+func TestPidFileRemovable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcsauna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mcsauna.pid")
+	info, _ := os.Stat(dir)
+	owner := info.Sys().(*syscall.Stat_t)
+	user := &syscall.Credential{Uid: owner.Uid + 1, Gid: owner.Gid + 1, Groups: []uint32{owner.Gid + 1}}
+
+	tests := []struct {
+		mode       os.FileMode
+		credential *syscall.Credential
+		removable  bool
+	}{
+		{0755, &syscall.Credential{Uid: owner.Uid, Gid: owner.Gid}, true},
+		{0755, user, false},
+		{0777, user, true},
+		/* Anyone can write to a sticky directory, but not remove root's
+		 * files from it */
+		{0777 | os.ModeSticky, user, false},
+		{0775, &syscall.Credential{Uid: user.Uid, Gid: owner.Gid, Groups: []uint32{owner.Gid}}, true},
+	}
+	for _, test := range tests {
+		os.Chmod(dir, test.mode)
+		if removable := pidFileRemovable(path, test.credential); removable != test.removable {
+			t.Errorf("Expected %v for mode %v and uid %d, got %v\n",
+				test.removable, test.mode, test.credential.Uid, removable)
+		}
+	}
+}
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Processor turns captured packets into counts of hot keys and errors.  It
+// is driven from the capture loop and is not safe for concurrent use,
+// although the pools it counts into are.
+type Processor struct {
+	config      Config
+	regexp_keys *RegexpKeys
+	stats       *Stats
+
+	// Optional, may be nil
+	error_dumper *ErrorDumper
+	tls_key_log  *TLSKeyLog
+	key_filter   *KeyFilter
+
+	conns      *ConnTable
+	parse_mode int
+	protocol   int
+
+	// Commands reporting hot keys in their own section
+	command_sections map[string]bool
+
+	// Commands whose keys are counted, or nil for all commands
+	commands map[string]bool
+
+	// Ports that memcached servers are listening on
+	ports map[int]bool
+}
+
+func NewProcessor(config Config, regexp_keys *RegexpKeys, stats *Stats) *Processor {
+	conns := NewConnTable(time.Duration(config.ConnExpiry) * time.Second)
+	conns.UseProxyClientIP = config.UseProxyClientIP
+	command_sections := make(map[string]bool)
+	for _, cmd := range config.CommandSections {
+		command_sections[cmd] = true
+	}
+	var commands map[string]bool
+	if len(config.Commands) != 0 {
+		commands = make(map[string]bool)
+		for _, cmd := range config.Commands {
+			commands[cmd] = true
+		}
+	}
+	ports := map[int]bool{config.Port: true}
+	for _, port := range config.Ports {
+		ports[port] = true
+	}
+	return &Processor{
+		config:      config,
+		regexp_keys: regexp_keys,
+		stats:       stats,
+		conns:       conns,
+		parse_mode:  PARSE_MODES[config.ParserMode],
+		protocol:    PROTOCOLS[config.Protocol],
+
+		command_sections: command_sections,
+		commands:         commands,
+		ports:            ports,
+	}
+}
+
+// Reconfigure switches the processor to a new config, keeping the
+// connections it is following, and where it dumps errors and logs TLS keys.
+// Like ProcessPacket, it must only be called from the capture loop.
+func (p *Processor) Reconfigure(config Config) error {
+	var key_filter *KeyFilter
+	if len(config.Include) != 0 || len(config.Exclude) != 0 {
+		var err error
+		if key_filter, err = NewKeyFilter(config.Include, config.Exclude); err != nil {
+			return err
+		}
+	}
+	reconfigured := NewProcessor(config, p.regexp_keys, p.stats)
+	reconfigured.conns = p.conns
+	reconfigured.conns.UseProxyClientIP = config.UseProxyClientIP
+	reconfigured.conns.expiry = time.Duration(config.ConnExpiry) * time.Second
+	reconfigured.error_dumper = p.error_dumper
+	reconfigured.tls_key_log = p.tls_key_log
+	reconfigured.key_filter = key_filter
+	*p = *reconfigured
+	return nil
+}
+
+// ProcessPacket counts the keys in all of the commands carried by a packet.
+func (p *Processor) ProcessPacket(packet gopacket.Packet) {
+	p.stats.Self.AddN("packets_processed", 1)
+	now := packet.Metadata().Timestamp
+	if dropped := p.conns.Expire(now); dropped > 0 {
+		p.stats.Errors.AddN(ERR_TO_STAT[ERR_TRUNCATED], dropped)
+	}
+
+	net_layer := packet.NetworkLayer()
+	tcp, ok := packet.TransportLayer().(*layers.TCP)
+	if net_layer == nil || !ok {
+		return
+	}
+	conn_key := ConnKey{net_layer.NetworkFlow(), tcp.TransportFlow()}
+	closing := tcp.FIN || tcp.RST
+	if closing {
+		// ... once the segment's own payload, which may complete a held
+		// ... command, has been parsed, counting anything still pending
+		defer func() {
+			if dropped := p.conns.Close(conn_key); dropped > 0 {
+				p.countErrors(conn_key, ERR_TRUNCATED, dropped)
+			}
+		}()
+	}
+
+	app_data := packet.ApplicationLayer()
+	if app_data == nil {
+		return
+	}
+	next_seq := tcp.Seq + uint32(len(app_data.Payload()))
+	payload, dropped := p.conns.Reassemble(conn_key, tcp.Seq, app_data.Payload(), now)
+	if dropped > 0 {
+		p.countErrors(conn_key, ERR_TRUNCATED, dropped)
+	}
+
+	// Responses are only captured when pairing them with requests
+	if p.ports[int(tcp.SrcPort)] {
+		if p.config.TrackLatency {
+			partial := p.processResponses(conn_key, payload, now)
+			if len(partial) > 0 {
+				p.hold(conn_key, next_seq, partial, closing, now)
+			}
+		}
+		return
+	}
+
+	// Skip over any PROXY protocol header at the start of the connection
+	payload, cmd_err := p.conns.StripProxyHeader(conn_key, payload)
+	if cmd_err == ERR_TRUNCATED {
+		p.hold(conn_key, next_seq, payload, closing, now)
+		return
+	} else if cmd_err != ERR_NONE {
+		p.countErrors(conn_key, cmd_err, 1)
+	}
+
+	protocol := p.protocol
+	if protocol == PROTOCOL_UNKNOWN {
+		protocol = p.conns.Protocol(conn_key, payload)
+	}
+
+	// Process data
+	var partial []byte
+	if protocol == PROTOCOL_TLS {
+		partial = p.processTLSRecords(conn_key, payload, now)
+	} else {
+		partial = p.processCommands(conn_key, protocol, payload, now)
+	}
+	if len(partial) > 0 {
+		p.hold(conn_key, next_seq, partial, closing, now)
+	}
+}
+
+// countErrors counts n errors in parsing on a connection, and, in
+// errors-only mode, which client they came from.
+func (p *Processor) countErrors(conn_key ConnKey, cmd_err int, n int) {
+	p.stats.Errors.AddN(ERR_TO_STAT[cmd_err], n)
+	if p.config.ErrorsOnly {
+		errs := make([]string, n)
+		for i := range errs {
+			errs[i] = ERR_TO_STAT[cmd_err]
+		}
+		p.stats.ClientErrors.Add(clientName(p.conns.ClientIP(conn_key)), errs)
+	}
+}
+
+// hold keeps a command cut off at the end of a segment, so that it can be
+// retried once the next segment on the connection arrives, rather than
+// reporting it as truncated.
+func (p *Processor) hold(conn_key ConnKey, next_seq uint32, partial []byte, closing bool, now time.Time) {
+	if closing || !p.conns.Hold(conn_key, next_seq, partial, now) {
+		p.countErrors(conn_key, ERR_TRUNCATED, 1)
+	}
+}
+
+// processCommands counts the keys in every command in payload.  If the last
+// command is truncated, the data from its start onwards is returned so that
+// it can be retried when more data arrives.
+func (p *Processor) processCommands(conn_key ConnKey, protocol int, payload []byte, now time.Time) (partial []byte) {
+	var (
+		cmd       string
+		keys      []string
+		consumed  int
+		cmd_err   int
+		tolerance int
+	)
+
+	for len(payload) > 0 {
+		if protocol == PROTOCOL_BINARY {
+			cmd, keys, consumed, cmd_err = parseBinaryCommand(payload)
+			tolerance = 0
+		} else {
+			cmd, keys, consumed, cmd_err, tolerance = parseCommandWithMode(payload, p.parse_mode)
+		}
+
+		if cmd_err == ERR_TRUNCATED {
+			return payload
+		}
+
+		// ... Every command must consume something, or we could end up in
+		// ... an infinite loop if a parser repeatedly hands back the same
+		// ... data.  This should never happen, but if it does, it would be
+		// ... better to move on to the next packet rather than spin CPU
+		// ... doing nothing.
+		if consumed <= 0 || consumed > len(payload) {
+			p.stats.Self.AddN("parse_bailouts", 1)
+			return nil
+		}
+		cmd_data := payload[:consumed]
+		payload = payload[consumed:]
+
+		if cmd_err == ERR_NONE {
+			p.stats.Self.AddN("commands_parsed", 1)
+			if tolerance != 0 {
+				p.stats.Tolerated.Add(toleratedStats(tolerance))
+			}
+			if p.config.ReportSummary {
+				p.summarize(cmd, keys, len(cmd_data))
+			}
+			if p.config.TrackLatency && protocol != PROTOCOL_BINARY && tolerance&TOLERATED_UNKNOWN_CMD != 0 {
+				// ... answered with any number of lines, for all we know
+				p.conns.StopPairing(conn_key)
+			}
+			if p.config.ErrorsOnly {
+				continue
+			}
+			if p.config.DryRun {
+				p.stats.Commands.Add([]string{cmd})
+				continue
+			}
+			if p.config.ReportFanout && COMMAND_CLASSES[commandSection(cmd)] == "reads" {
+				p.stats.Fanout.Add([]string{fanoutBucket(p.config.FanoutBuckets, len(keys))})
+			}
+			if p.commands != nil && !p.commands[cmd] {
+				// ... still timed, but not counted
+				keys = []string{}
+			} else if p.key_filter != nil {
+				keys = p.key_filter.Filter(keys)
+			}
+			if p.config.DedupeKeys {
+				keys = dedupeKeys(keys)
+			}
+			value_bytes := 0
+			if p.config.RankByBytes {
+				if protocol == PROTOCOL_BINARY {
+					value_bytes = binaryValueBytes(cmd_data)
+				} else {
+					value_bytes = valueBytes(cmd_data)
+				}
+			}
+			names := p.countKeys(keys, value_bytes)
+			if p.config.ReportBurstiness {
+				p.stats.Burst.Add(names, now)
+			}
+			if p.config.DiscoverNamespaces {
+				p.stats.Namespaces.Add(keys)
+			}
+			if proxy, ok := p.config.Proxies[conn_key.Net.Src().String()]; ok {
+				p.stats.ProxyKeys.Add(proxy, names)
+			}
+			if p.config.ReportClients {
+				p.stats.KeyClients.Add(names, p.conns.ClientIP(conn_key))
+			}
+			if p.config.PerServer {
+				p.stats.ServerKeys.Add(serverName(conn_key), names)
+			}
+			section := commandSection(cmd)
+			if p.command_sections[section] {
+				p.stats.CommandKeys.Add(section, names)
+			}
+			if class, ok := COMMAND_CLASSES[section]; ok && p.config.ReportMix {
+				p.stats.ClassKeys.Add(class, names)
+			}
+
+			// ... remember the request so its response can be timed
+			if p.config.TrackLatency && (protocol == PROTOCOL_BINARY || !isNoReply(cmd_data)) {
+				p.conns.PushRequest(conn_key, pendingRequest{
+					cmd:    cmd,
+					names:  names,
+					opaque: binaryOpaque(cmd_data),
+					sent:   now,
+				})
+			}
+		} else {
+			p.countErrors(conn_key, cmd_err, 1)
+			if p.config.TrackLatency && protocol != PROTOCOL_BINARY {
+				// ... still answered, e.g. "version" or "stats", with
+				// ... ERROR, or a line of its own
+				p.conns.StopPairing(conn_key)
+			}
+			if p.error_dumper != nil {
+				err := p.error_dumper.Dump(now, cmd_err, conn_key, cmd_data)
+				if err != nil {
+					logger.Error("Error dumping unparseable payload",
+						"file", p.config.DebugErrorsFile, "error", err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// processTLSRecords decrypts each complete record in payload and counts the
+// keys in the commands they carry.  If the last record is incomplete, the
+// data from its start onwards is returned so that it can be retried when
+// more data arrives.
+func (p *Processor) processTLSRecords(conn_key ConnKey, payload []byte, now time.Time) (partial []byte) {
+	stream := p.conns.TLSStream(conn_key)
+	for len(payload) > 0 {
+		if len(payload) < TLS_RECORD_HEADER_LEN {
+			return payload
+		}
+		record_len := TLS_RECORD_HEADER_LEN + int(binary.BigEndian.Uint16(payload[3:5]))
+		if len(payload) < record_len {
+			return payload
+		}
+		record := payload[:record_len]
+		payload = payload[record_len:]
+
+		unsupported := stream.unsupported
+		plaintext, cmd_err := stream.Decrypt(record, p.tls_key_log, now)
+		if stream.unsupported && !unsupported {
+			p.stats.Self.AddN("tls_unsupported", 1)
+		}
+		if cmd_err != ERR_NONE {
+			p.countErrors(conn_key, cmd_err, 1)
+			continue
+		}
+		if len(plaintext) == 0 {
+			continue
+		}
+
+		// ... commands can span records just like they can span segments
+		data := append(stream.pending, plaintext...)
+		stream.pending = nil
+		if stream.protocol == PROTOCOL_UNKNOWN {
+			stream.protocol = detectProtocol(data)
+		}
+		tail := p.processCommands(conn_key, stream.protocol, data, now)
+		if len(tail) > MAX_PENDING_BYTES {
+			p.countErrors(conn_key, ERR_TRUNCATED, 1)
+		} else if len(tail) > 0 {
+			stream.pending = append([]byte{}, tail...)
+		}
+	}
+	return nil
+}
+
+// processResponses pairs each complete response in payload with the request
+// it answers, recording how long the request took.  If the last response is
+// truncated, the data from its start onwards is returned so that it can be
+// retried when more data arrives.
+func (p *Processor) processResponses(conn_key ConnKey, payload []byte, now time.Time) (partial []byte) {
+	protocol := p.conns.Protocol(conn_key, payload)
+	if protocol == PROTOCOL_TLS {
+		return nil
+	}
+	request_key := conn_key.Reverse()
+
+	var (
+		req      pendingRequest
+		ok       bool
+		opaque   uint32
+		consumed int
+		cmd_err  int
+	)
+	for len(payload) > 0 {
+		if protocol == PROTOCOL_BINARY {
+			opaque, consumed, cmd_err = parseBinaryResponse(payload)
+		} else {
+			consumed, cmd_err = parseResponse(payload)
+		}
+		if cmd_err == ERR_TRUNCATED {
+			return payload
+		} else if cmd_err != ERR_NONE {
+			p.countErrors(request_key, cmd_err, 1)
+			return nil
+		}
+		if consumed <= 0 || consumed > len(payload) {
+			p.stats.Self.AddN("parse_bailouts", 1)
+			return nil
+		}
+		payload = payload[consumed:]
+
+		if protocol == PROTOCOL_BINARY {
+			req, ok = p.conns.PopRequestByOpaque(request_key, opaque)
+		} else {
+			req, ok = p.conns.PopRequest(request_key)
+		}
+		if ok {
+			latency := now.Sub(req.sent)
+			p.stats.CommandLatency.Add([]string{req.cmd}, latency)
+			p.stats.KeyLatency.Add(req.names, latency)
+		}
+	}
+	return nil
+}
+
+// serverName returns the name a connection's server is reported under: its
+// address and port, with dots and colons replaced so as not to add levels
+// to the metric name.
+func serverName(conn_key ConnKey) string {
+	name := conn_key.Net.Dst().String() + "_" + conn_key.Transport.Dst().String()
+	return strings.NewReplacer(".", "_", ":", "_").Replace(name)
+}
+
+// clientName returns a client's IP address as a name for metrics.
+func clientName(client_ip string) string {
+	return strings.NewReplacer(".", "_", ":", "_").Replace(client_ip)
+}
+
+// dedupeKeys returns keys with any repeats removed, e.g. so that
+// "get foo foo" counts one hit for foo.  keys is returned as is if there
+// are no repeats.
+func dedupeKeys(keys []string) []string {
+	if len(keys) < 2 {
+		return keys
+	}
+	seen := make(map[string]bool, len(keys))
+	deduped := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			deduped = append(deduped, key)
+		}
+	}
+	return deduped
+}
+
+// summarize adds a command, its keys and its size in bytes to the totals
+// for the interval.
+func (p *Processor) summarize(cmd string, keys []string, size int) {
+	p.stats.Summary.AddN("commands", 1)
+	p.stats.Summary.AddN("keys", len(keys))
+	p.stats.Summary.AddN("bytes", size)
+	if class, ok := COMMAND_CLASSES[commandSection(cmd)]; ok {
+		p.stats.Summary.AddN(class, 1)
+	}
+	for _, key := range keys {
+		p.stats.SummaryDistinct.Add(key, "")
+	}
+}
+
+// countKeys adds keys to the hot key pool, grouping them by regular
+// expression if any were configured.  It returns the names the keys were
+// counted under.
+//
+// When ranking by bytes, each key is also counted as taking up its own
+// length plus value_bytes on the wire.
+func (p *Processor) countKeys(keys []string, value_bytes int) []string {
+
+	// Raw key, or rolled up key
+	if p.regexp_keys.Len() == 0 {
+		names := keys
+		if p.config.KeyDelimiter != "" || p.config.HashKeys {
+			names = make([]string, len(keys))
+			for i, key := range keys {
+				names[i] = key
+				if p.config.KeyDelimiter != "" {
+					names[i] = rollupKey(names[i], p.config.KeyDelimiter, p.config.KeySegments)
+				}
+				if p.config.HashKeys {
+					names[i] = hashKey(names[i], p.config.HashKeysPrefixDelimiter)
+				}
+			}
+		}
+		p.stats.HotKeys.Add(names)
+		for i, key := range keys {
+			if p.config.RankByBytes {
+				p.stats.KeyBytes.AddN(names[i], len(key)+value_bytes)
+			}
+			if p.config.TrackCardinality {
+				p.stats.Cardinality.Add(key, "")
+			}
+			if p.config.ReportOneHitWonders {
+				group := ""
+				if p.config.KeyDelimiter != "" {
+					group = names[i]
+				}
+				p.stats.OneHitWonders.Add(key, group)
+			}
+			if p.config.KeyExamples > 0 {
+				p.stats.KeyExamples.Add(names[i], key)
+			}
+		}
+		return names
+	}
+
+	// Regex
+	matches := []string{}
+	match_errors := []string{}
+	for _, key := range keys {
+		var matched_regex string
+		var err error
+		if p.config.ReportRegexpConflicts {
+			var shadowed []string
+			matched_regex, shadowed, err = p.regexp_keys.MatchAll(key)
+			for _, name := range shadowed {
+				p.stats.RegexpConflicts.Add([]string{matched_regex + "." + name})
+			}
+		} else {
+			matched_regex, err = p.regexp_keys.Match(key)
+		}
+		if err != nil {
+			match_errors = append(match_errors, "match_error")
+
+			// The user has requested that we also show keys that
+			// weren't matched at all, probably for debugging.
+			if p.config.ShowUnmatched {
+				name := key
+				if p.config.HashKeys {
+					name = hashKey(key, p.config.HashKeysPrefixDelimiter)
+				}
+				matches = append(matches, name)
+				if p.config.RankByBytes {
+					p.stats.KeyBytes.AddN(name, len(key)+value_bytes)
+				}
+			}
+
+		} else {
+			matches = append(matches, matched_regex)
+			if p.config.RankByBytes {
+				p.stats.KeyBytes.AddN(matched_regex, len(key)+value_bytes)
+			}
+			if p.config.KeyExamples > 0 {
+				p.stats.KeyExamples.Add(matched_regex, key)
+			}
+		}
+		if p.config.TrackCardinality {
+			p.stats.Cardinality.Add(key, matched_regex)
+		}
+		if p.config.ReportOneHitWonders {
+			p.stats.OneHitWonders.Add(key, matched_regex)
+		}
+	}
+	p.stats.HotKeys.Add(matches)
+	p.stats.Errors.Add(match_errors)
+	return matches
+}
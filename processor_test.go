@@ -0,0 +1,499 @@
+package main
+
+import (
+	"container/heap"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// testPacket builds a TCP packet between 10.0.0.1:src_port and
+// 10.0.0.2:dst_port carrying payload.
+func testPacket(t *testing.T, src_port int, dst_port int, seq uint32, payload []byte, ts time.Time) gopacket.Packet {
+	return testFlaggedPacket(t, src_port, dst_port, seq, payload, ts, false)
+}
+
+// testFlaggedPacket builds a packet as testPacket does, with FIN set if fin
+// is.
+func testFlaggedPacket(t *testing.T, src_port int, dst_port int, seq uint32, payload []byte, ts time.Time, fin bool) gopacket.Packet {
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    []byte{10, 0, 0, 1},
+		DstIP:    []byte{10, 0, 0, 2},
+	}
+	if src_port == 11211 {
+		ip.SrcIP, ip.DstIP = ip.DstIP, ip.SrcIP
+	}
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(src_port),
+		DstPort: layers.TCPPort(dst_port),
+		Seq:     seq,
+		ACK:     true,
+		FIN:     fin,
+		Window:  65535,
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	err := gopacket.SerializeLayers(buf, opts, ip, tcp, gopacket.Payload(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+	packet.Metadata().Timestamp = ts
+	return packet
+}
+
+func TestProcessorSplitCommand(t *testing.T) {
+	config, _ := NewConfig([]byte{})
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+	now := time.Now()
+
+	processor.ProcessPacket(testPacket(t, 40000, 11211, 1, []byte("get foo\r\nget ba"), now))
+	processor.ProcessPacket(testPacket(t, 40000, 11211, 16, []byte("r\r\n"), now))
+
+	for _, key := range []string{"foo", "bar"} {
+		if hits := stats.HotKeys.GetHits(key); hits != 1 {
+			t.Errorf("Expected 1 hit for %s, got %d\n", key, hits)
+		}
+	}
+	if hits := stats.Errors.GetHits(ERR_TO_STAT[ERR_TRUNCATED]); hits != 0 {
+		t.Errorf("Expected no truncation errors, got %d\n", hits)
+	}
+}
+
+func TestProcessorSplitCommandClosing(t *testing.T) {
+	config, _ := NewConfig([]byte{})
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+	now := time.Now()
+
+	/* The FIN segment finishes the held command, and nothing is left */
+	processor.ProcessPacket(testPacket(t, 40000, 11211, 1, []byte("get foo\r\nget ba"), now))
+	processor.ProcessPacket(testFlaggedPacket(t, 40000, 11211, 16, []byte("r\r\n"), now, true))
+
+	for _, key := range []string{"foo", "bar"} {
+		if hits := stats.HotKeys.GetHits(key); hits != 1 {
+			t.Errorf("Expected 1 hit for %s, got %d\n", key, hits)
+		}
+	}
+	if hits := stats.Errors.GetHits(ERR_TO_STAT[ERR_TRUNCATED]); hits != 0 {
+		t.Errorf("Expected no truncation errors, got %d\n", hits)
+	}
+	if conns := processor.conns.Len(); conns != 0 {
+		t.Errorf("Expected the closed connection to be forgotten, got %d\n", conns)
+	}
+
+	/* ...but a command the FIN segment leaves unfinished is truncated */
+	processor.ProcessPacket(testPacket(t, 40001, 11211, 1, []byte("get ba"), now))
+	processor.ProcessPacket(testFlaggedPacket(t, 40001, 11211, 7, []byte("z"), now, true))
+	if hits := stats.Errors.GetHits(ERR_TO_STAT[ERR_TRUNCATED]); hits != 1 {
+		t.Errorf("Expected 1 truncation error, got %d\n", hits)
+	}
+}
+
+func TestProcessorLatency(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"track_latency": true}`))
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+	now := time.Now()
+
+	processor.ProcessPacket(testPacket(t, 40000, 11211, 1,
+		[]byte("get foo\r\nset bar 0 0 1 noreply\r\na\r\nset baz 0 0 1\r\nb\r\n"), now))
+	processor.ProcessPacket(testPacket(t, 11211, 40000, 1,
+		[]byte("VALUE foo 0 1\r\nc\r\nEND\r\n"), now.Add(2*time.Millisecond)))
+	processor.ProcessPacket(testPacket(t, 11211, 40000, 24,
+		[]byte("STORED\r\n"), now.Add(5*time.Millisecond)))
+
+	expected := map[string]time.Duration{
+		"foo": 2 * time.Millisecond,
+		"bar": 0,
+		"baz": 5 * time.Millisecond,
+	}
+	for key, expected_latency := range expected {
+		if latency := stats.KeyLatency.Get(key); latency.Max != expected_latency {
+			t.Errorf("Expected %s to have latency %v, got %v\n", key, expected_latency, latency.Max)
+		}
+	}
+	if latency := stats.CommandLatency.Get("set"); latency.Count != 1 {
+		t.Errorf("Expected 1 timed set, got %d\n", latency.Count)
+	}
+}
+
+func TestProcessorLatencyUnpaired(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"track_latency": true}`))
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+	now := time.Now()
+
+	/* The server answers a request that can't be parsed, so a later
+	 * response can't be paired with its request, and isn't timed */
+	processor.ProcessPacket(testPacket(t, 40000, 11211, 1, []byte("version\r\n"), now))
+	processor.ProcessPacket(testPacket(t, 40000, 11211, 10, []byte("get foo\r\n"), now))
+	processor.ProcessPacket(testPacket(t, 11211, 40000, 1,
+		[]byte("VERSION 1.6.21\r\n"), now.Add(2*time.Millisecond)))
+	processor.ProcessPacket(testPacket(t, 11211, 40000, 17,
+		[]byte("VALUE foo 0 1\r\nc\r\nEND\r\n"), now.Add(5*time.Millisecond)))
+
+	if latency := stats.CommandLatency.Get("get"); latency.Count != 0 {
+		t.Errorf("Expected no timed gets, got %d with max %v\n", latency.Count, latency.Max)
+	}
+	if hits := stats.HotKeys.GetHits("foo"); hits != 1 {
+		t.Errorf("Expected foo to still be counted, got %d hits\n", hits)
+	}
+}
+
+func TestProcessorTwemproxy(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"proxies": {"10.0.0.1": "twem1"}}`))
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+	now := time.Now()
+
+	// ... twemproxy pipelines fragments of multigets from many clients,
+	// ... with no regard for where segments end
+	stream := []byte("get k1 k4 k7\r\nget k2\r\nset k3 0 0 5 noreply\r\nhello\r\nget k1 k2\r\n")
+	processor.ProcessPacket(testPacket(t, 40000, 11211, 1, stream[:17], now))
+	processor.ProcessPacket(testPacket(t, 40000, 11211, 18, stream[17:45], now))
+	processor.ProcessPacket(testPacket(t, 40000, 11211, 46, stream[45:], now))
+
+	expected := map[string]int{"k1": 2, "k2": 2, "k3": 1, "k4": 1, "k7": 1}
+	for key, hits := range expected {
+		if actual := stats.HotKeys.GetHits(key); actual != hits {
+			t.Errorf("Expected %d hits for %s, got %d\n", hits, key, actual)
+		}
+		if actual := stats.ProxyKeys.Get("twem1").GetHits(key); actual != hits {
+			t.Errorf("Expected %d hits for %s via twem1, got %d\n", hits, key, actual)
+		}
+	}
+	if stats.Errors.GetTopKeys().Len() != 0 {
+		t.Errorf("Expected no errors\n")
+	}
+}
+
+func TestProcessorBailsOutWithoutProgress(t *testing.T) {
+	// ... a broken processor that hands back the whole command unconsumed
+	CMD_PROCESSORS["stall"] = func(first_line string, remainder []byte, mode int) ([]string, int, int, int) {
+		return []string{}, -len(first_line) - 2, ERR_NONE, 0
+	}
+	defer delete(CMD_PROCESSORS, "stall")
+
+	config, _ := NewConfig([]byte{})
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+
+	partial := processor.processCommands(testConnKey(1), PROTOCOL_ASCII,
+		[]byte("get foo\r\nstall foo\r\nget bar\r\n"), time.Now())
+	if len(partial) != 0 {
+		t.Errorf("Expected nothing to be held, got %q\n", partial)
+	}
+	if hits := stats.Self.GetHits("parse_bailouts"); hits != 1 {
+		t.Errorf("Expected 1 bailout, got %d\n", hits)
+	}
+	if hits := stats.HotKeys.GetHits("foo"); hits != 1 {
+		t.Errorf("Expected keys before the bailout to be counted, got %d\n", hits)
+	}
+}
+
+func TestProcessorCommandSections(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"command_sections": ["get", "set", "delete"]}`))
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+
+	processor.processCommands(testConnKey(1), PROTOCOL_ASCII,
+		[]byte("get foo bar\r\ngets foo\r\nset baz 0 0 1\r\na\r\ndelete foo\r\nincr bar 1\r\n"), time.Now())
+
+	expected := map[string]map[string]int{
+		"get":    {"foo": 2, "bar": 1},
+		"set":    {"baz": 1},
+		"delete": {"foo": 1},
+	}
+	for cmd, keys := range expected {
+		for key, hits := range keys {
+			if actual := stats.CommandKeys.Get(cmd).GetHits(key); actual != hits {
+				t.Errorf("Expected %d hits for %s in %s, got %d\n", hits, key, cmd, actual)
+			}
+		}
+	}
+	if !stringsEqual(stats.CommandKeys.Tags(), []string{"delete", "get", "set"}) {
+		t.Errorf("Expected sections for get, set and delete only, got %v\n", stats.CommandKeys.Tags())
+	}
+}
+
+func TestProcessorRankByBytes(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"rank_by_bytes": true}`))
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+
+	processor.processCommands(testConnKey(1), PROTOCOL_ASCII,
+		[]byte("get foo foo\r\nset bar 0 0 10\r\n0123456789\r\n"), time.Now())
+
+	top_keys := stats.KeyBytes.GetTopKeys()
+	for _, expected := range []Key{Key{"bar", 13}, Key{"foo", 6}} {
+		key := heap.Pop(top_keys).(*Key)
+		if key.Name != expected.Name || key.Hits != expected.Hits {
+			t.Errorf("Expected %s with %d bytes, got %s with %d\n",
+				expected.Name, expected.Hits, key.Name, key.Hits)
+		}
+	}
+}
+
+func TestProcessorPerServer(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"ports": [11212], "per_server": true}`))
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+	now := time.Now()
+
+	processor.ProcessPacket(testPacket(t, 40000, 11211, 1, []byte("get foo\r\n"), now))
+	processor.ProcessPacket(testPacket(t, 40001, 11212, 1, []byte("get foo bar\r\n"), now))
+
+	if !stringsEqual(stats.ServerKeys.Tags(), []string{"10_0_0_2_11211", "10_0_0_2_11212"}) {
+		t.Fatalf("Expected a section for each server, got %v\n", stats.ServerKeys.Tags())
+	}
+	if hits := stats.ServerKeys.Get("10_0_0_2_11212").GetHits("bar"); hits != 1 {
+		t.Errorf("Expected 1 hit for bar on 11212, got %d\n", hits)
+	}
+	if hits := stats.HotKeys.GetHits("foo"); hits != 2 {
+		t.Errorf("Expected 2 hits for foo overall, got %d\n", hits)
+	}
+}
+
+func TestProcessorRollup(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"key_delimiter": ":"}`))
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+
+	names := processor.countKeys([]string{"user:1:profile", "user:2:profile", "user:3:posts"}, 0)
+	if !stringsEqual(names, []string{"user:*:profile", "user:*:profile", "user:*:posts"}) {
+		t.Errorf("Unexpected names %v\n", names)
+	}
+	if hits := stats.HotKeys.GetHits("user:*:profile"); hits != 2 {
+		t.Errorf("Expected 2 hits for user:*:profile, got %d\n", hits)
+	}
+
+	_, err := NewConfig([]byte(`{"key_delimiter": ":", "regexps": [{"name": "foo", "re": "^foo"}]}`))
+	if err == nil {
+		t.Errorf("Expected error for key_delimiter with regexps\n")
+	}
+}
+
+func TestProcessorKeyExamples(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"key_delimiter": ":", "key_examples": 1}`))
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+
+	processor.countKeys([]string{"user:1:profile", "user:2:profile", "user:3:posts"}, 0)
+	if examples := popTopKeys(stats.KeyExamples.GetExamples("user:*:profile"), -1, 0); len(examples) != 1 ||
+		examples[0].Name != "user:1:profile" {
+		t.Errorf("Expected user:1:profile as the example, got %v\n", examples)
+	}
+}
+
+func TestProcessorReconfigure(t *testing.T) {
+	config, _ := NewConfig([]byte(`{}`))
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+	conns := processor.conns
+
+	config, _ = NewConfig([]byte(`{"key_delimiter": ":", "exclude": [{"prefix": "post:"}]}`))
+	if err := processor.Reconfigure(config); err != nil {
+		t.Fatal(err)
+	}
+	if processor.conns != conns {
+		t.Errorf("Expected connections to be kept\n")
+	}
+	processor.processCommands(testConnKey(1), PROTOCOL_ASCII,
+		[]byte("get user:1\r\nget post:1\r\n"), time.Now())
+	if hits := stats.HotKeys.GetHits("user:*"); hits != 1 {
+		t.Errorf("Expected keys to be rolled up, got %d hits\n", hits)
+	}
+	if hits := stats.HotKeys.GetHits("post:*"); hits != 0 {
+		t.Errorf("Expected keys to be excluded, got %d hits\n", hits)
+	}
+}
+
+func TestProcessorKeyMix(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"report_mix": true}`))
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+
+	processor.processCommands(testConnKey(1), PROTOCOL_ASCII,
+		[]byte("get foo\r\ngets foo\r\nset foo 0 0 1\r\na\r\ndelete foo\r\n"), time.Now())
+
+	expected := map[string]int{"reads": 2, "writes": 1, "deletes": 1}
+	for class, hits := range expected {
+		if actual := stats.ClassKeys.Get(class).GetHits("foo"); actual != hits {
+			t.Errorf("Expected %d %s for foo, got %d\n", hits, class, actual)
+		}
+	}
+}
+
+func TestProcessorKeyClients(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"report_clients": true}`))
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+	now := time.Now()
+
+	// ... the same client on two connections counts once
+	processor.ProcessPacket(testPacket(t, 40000, 11211, 1, []byte("get foo bar\r\n"), now))
+	processor.ProcessPacket(testPacket(t, 40001, 11211, 1, []byte("get foo\r\n"), now))
+
+	rotated := stats.Rotate()
+	if count := rotated.KeyClients.Count("foo"); count != 1 {
+		t.Errorf("Expected 1 client for foo, got %d\n", count)
+	}
+	report := NewReport(time.Now())
+	formatKeyClients(report, NewMetricName("mcsauna.clients"), []*Key{&Key{"foo", 2}, &Key{"baz", 0}}, rotated.KeyClients)
+	output := report.String()
+	if output != "mcsauna.clients.foo 1\nmcsauna.clients.baz 0\n" {
+		t.Errorf("Unexpected output %q\n", output)
+	}
+}
+
+func TestProcessorKeyFilter(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"exclude": [{"prefix": "session:"}]}`))
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+	processor.key_filter, _ = NewKeyFilter(config.Include, config.Exclude)
+
+	processor.processCommands(testConnKey(1), PROTOCOL_ASCII,
+		[]byte("get foo session:1\r\nget session:2\r\n"), time.Now())
+
+	if hits := stats.HotKeys.GetHits("foo"); hits != 1 {
+		t.Errorf("Expected 1 hit for foo, got %d\n", hits)
+	}
+	if keys := stats.HotKeys.GetTopKeys(); keys.Len() != 1 {
+		t.Errorf("Expected session keys to be dropped, got %d keys\n", keys.Len())
+	}
+
+	_, err := NewConfig([]byte(`{"include": [{"prefix": "a", "exact": "b"}]}`))
+	if err == nil {
+		t.Errorf("Expected error for rule with both prefix and exact\n")
+	}
+}
+
+func TestProcessorCommands(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"commands": ["get", "gets"]}`))
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+
+	processor.processCommands(testConnKey(1), PROTOCOL_ASCII,
+		[]byte("get foo\r\ngets foo\r\nset bar 0 0 1\r\na\r\ndelete foo\r\n"), time.Now())
+
+	if hits := stats.HotKeys.GetHits("foo"); hits != 2 {
+		t.Errorf("Expected 2 hits for foo, got %d\n", hits)
+	}
+	if hits := stats.HotKeys.GetHits("bar"); hits != 0 {
+		t.Errorf("Expected sets not to be counted, got %d hits for bar\n", hits)
+	}
+}
+
+func TestProcessorFanout(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"report_fanout": true}`))
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+
+	processor.processCommands(testConnKey(1), PROTOCOL_ASCII,
+		[]byte("get a\r\ngets a b c\r\nset a 0 0 1\r\na\r\n"), time.Now())
+
+	expected := map[string]int{"le_1": 1, "le_4": 1}
+	for bucket, hits := range expected {
+		if actual := stats.Fanout.GetHits(bucket); actual != hits {
+			t.Errorf("Expected %d requests in %s, got %d\n", hits, bucket, actual)
+		}
+	}
+	if stats.Fanout.GetTopKeys().Len() != 2 {
+		t.Errorf("Expected sets not to be counted\n")
+	}
+}
+
+func TestProcessorDedupeKeys(t *testing.T) {
+	for _, dedupe := range []bool{false, true} {
+		config, _ := NewConfig([]byte{})
+		config.DedupeKeys = dedupe
+		stats := NewStats(config)
+		processor := NewProcessor(config, NewRegexpKeys(), stats)
+
+		processor.processCommands(testConnKey(1), PROTOCOL_ASCII,
+			[]byte("get foo bar foo foo\r\nget foo\r\n"), time.Now())
+
+		expected := 4
+		if dedupe {
+			expected = 2
+		}
+		if hits := stats.HotKeys.GetHits("foo"); hits != expected {
+			t.Errorf("Expected %d hits for foo with dedupe %v, got %d\n", expected, dedupe, hits)
+		}
+		if hits := stats.HotKeys.GetHits("bar"); hits != 1 {
+			t.Errorf("Expected 1 hit for bar with dedupe %v, got %d\n", dedupe, hits)
+		}
+	}
+}
+
+func TestProcessorSummary(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"report_summary": true, "commands": ["get"]}`))
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+
+	processor.processCommands(testConnKey(1), PROTOCOL_ASCII,
+		[]byte("get foo bar\r\nset baz 0 0 1\r\na\r\ndelete foo\r\n"), time.Now())
+
+	/* Commands not counted as hot keys are still counted in the totals */
+	expected := map[string]int{
+		"commands": 3, "keys": 4, "reads": 1, "writes": 1, "deletes": 1, "bytes": 43,
+	}
+	for total, n := range expected {
+		if actual := stats.Summary.GetHits(total); actual != n {
+			t.Errorf("Expected %d %s, got %d\n", n, total, actual)
+		}
+	}
+	if distinct := stats.SummaryDistinct.Count(); distinct != 3 {
+		t.Errorf("Expected 3 distinct keys, got %d\n", distinct)
+	}
+}
+
+func TestProcessorErrorsOnly(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"errors_only": true}`))
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+
+	processor.processCommands(testConnKey(1), PROTOCOL_ASCII,
+		[]byte("get foo\r\nbogus\r\nget bar\r\n"), time.Now())
+
+	/* Keys aren't counted, but errors are, by client */
+	if top_keys := stats.HotKeys.GetTopKeys(); top_keys.Len() != 0 {
+		t.Errorf("Expected no keys to be counted, got %d\n", top_keys.Len())
+	}
+	client := stats.ClientErrors.Get("10_0_0_1")
+	if client == nil || client.GetHits("invalid_cmd") != 1 {
+		t.Errorf("Expected an invalid_cmd error from 10_0_0_1\n")
+	}
+}
+
+func TestProcessorDryRun(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"dry_run": true}`))
+	stats := NewStats(config)
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+
+	processor.processCommands(testConnKey(1), PROTOCOL_ASCII,
+		[]byte("get foo\r\nget bar baz\r\ndelete foo\r\nbogus\r\n"), time.Now())
+
+	/* Keys aren't counted, but commands and errors are */
+	if top_keys := stats.HotKeys.GetTopKeys(); top_keys.Len() != 0 {
+		t.Errorf("Expected no keys to be counted, got %d\n", top_keys.Len())
+	}
+	if hits := stats.Commands.GetHits("get"); hits != 2 {
+		t.Errorf("Expected 2 gets, got %d\n", hits)
+	}
+	if hits := stats.Commands.GetHits("delete"); hits != 1 {
+		t.Errorf("Expected 1 delete, got %d\n", hits)
+	}
+	if hits := stats.Errors.GetHits("no_cmd"); hits != 1 {
+		t.Errorf("Expected 1 no_cmd error, got %d\n", hits)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Characters that can't appear in Prometheus metric names
+var PROMETHEUS_INVALID = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// PrometheusSink serves the most recent report over HTTP, at /metrics, in
+// the Prometheus text exposition format.
+type PrometheusSink struct {
+	Lock sync.Mutex
+
+	report *Report
+
+	// Whether counts keep increasing across intervals, so can be exposed as
+	// Prometheus counters rather than gauges
+	cumulative bool
+}
+
+func NewPrometheusSink(cumulative bool) *PrometheusSink {
+	return &PrometheusSink{cumulative: cumulative}
+}
+
+// ListenAndServe serves reports on address until it fails.
+func (p *PrometheusSink) ListenAndServe(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", p)
+	return http.ListenAndServe(address, mux)
+}
+
+// Send replaces the report being served.
+func (p *PrometheusSink) Send(report *Report) error {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+	p.report = report
+	return nil
+}
+
+func (p *PrometheusSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.Lock.Lock()
+	report := p.report
+	p.Lock.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if report != nil {
+		io.WriteString(w, formatPrometheus(report, p.cumulative))
+	}
+}
+
+// formatPrometheus formats a report in the Prometheus text exposition
+// format, with one metric for each family of metric names, and the rest of
+// each name as labels.  Counts are only exposed as counters if cumulative.
+func formatPrometheus(report *Report, cumulative bool) string {
+	families := []string{}
+	metrics := make(map[string][]*Metric)
+	for _, m := range report.Metrics {
+		family := m.Name.Family()
+		if _, ok := metrics[family]; !ok {
+			families = append(families, family)
+		}
+		metrics[family] = append(metrics[family], m)
+	}
+
+	output := ""
+	for _, family := range families {
+		name := PROMETHEUS_INVALID.ReplaceAllString(family, "_")
+		metric_type := "gauge"
+		if cumulative && metrics[family][0].Counter {
+			metric_type = "counter"
+		}
+		output += fmt.Sprintf("# TYPE %s %s\n", name, metric_type)
+		for _, m := range metrics[family] {
+			output += name + prometheusLabels(m.Name.Labels()) + " " + m.FormatValue() + "\n"
+		}
+	}
+	return output
+}
+
+// prometheusLabels formats labels as "{label="value",...}", or returns an
+// empty string if there are none.
+func prometheusLabels(labels []MetricPart) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	formatted := make([]string, len(labels))
+	for i, label := range labels {
+		formatted[i] = fmt.Sprintf(`%s="%s"`,
+			PROMETHEUS_INVALID.ReplaceAllString(label.Label, "_"),
+			escaper.Replace(label.Value))
+	}
+	return "{" + strings.Join(formatted, ",") + "}"
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testPrometheusReport() *Report {
+	report := NewReport(time.Now())
+	report.Count(NewMetricName("mcsauna.keys").Label("key", "foo"), 3)
+	report.Count(NewMetricName("mcsauna.commands").Label("command", "get").Append("keys").
+		Label("key", `say "hi"`), 2)
+	report.Count(NewMetricName("mcsauna.keys").Label("key", "bar"), 1)
+	report.Gauge(NewMetricName("mcsauna.distribution.gini"), 0.25)
+	return report
+}
+
+func TestFormatPrometheus(t *testing.T) {
+	expected := "# TYPE mcsauna_keys gauge\n" +
+		"mcsauna_keys{key=\"foo\"} 3\n" +
+		"mcsauna_keys{key=\"bar\"} 1\n" +
+		"# TYPE mcsauna_commands_keys gauge\n" +
+		"mcsauna_commands_keys{command=\"get\",key=\"say \\\"hi\\\"\"} 2\n" +
+		"# TYPE mcsauna_distribution_gini gauge\n" +
+		"mcsauna_distribution_gini 0.250\n"
+	if output := formatPrometheus(testPrometheusReport(), false); output != expected {
+		t.Errorf("Expected %q, got %q\n", expected, output)
+	}
+
+	// ... counts are only counters if they don't reset every interval
+	expected = "# TYPE mcsauna_keys counter\n" +
+		"mcsauna_keys{key=\"foo\"} 3\n" +
+		"mcsauna_keys{key=\"bar\"} 1\n" +
+		"# TYPE mcsauna_commands_keys counter\n" +
+		"mcsauna_commands_keys{command=\"get\",key=\"say \\\"hi\\\"\"} 2\n" +
+		"# TYPE mcsauna_distribution_gini gauge\n" +
+		"mcsauna_distribution_gini 0.250\n"
+	if output := formatPrometheus(testPrometheusReport(), true); output != expected {
+		t.Errorf("Expected %q, got %q\n", expected, output)
+	}
+}
+
+func TestPrometheusSink(t *testing.T) {
+	sink := NewPrometheusSink(false)
+	server := httptest.NewServer(sink)
+	defer server.Close()
+
+	sink.Send(testPrometheusReport())
+	resp, err := server.Client().Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != formatPrometheus(testPrometheusReport(), false) {
+		t.Errorf("Unexpected response %q\n", body)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+)
+
+const (
+	// A v1 header is at most 107 bytes, including the trailing "\r\n".
+	PROXY_V1_MAX_LEN = 107
+	PROXY_V2_MIN_LEN = 16
+)
+
+var (
+	PROXY_V1_SIGNATURE = []byte("PROXY ")
+	PROXY_V2_SIGNATURE = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// parseProxyHeader looks for a HAProxy PROXY protocol header at the start of
+// a connection's data.  If one is present, it returns the length of the
+// header and, where the header carries one, the address of the real client.
+// A header_len of 0 with ERR_NONE means there was no header.
+//
+// A v1 header looks like:
+//
+//     PROXY TCP4 192.168.0.1 192.168.0.11 56324 11211\r\n
+//
+// And a v2 header is a fixed 12 byte signature followed by:
+//
+//     version/command (1) family/transport (1) address length (2) addresses
+func parseProxyHeader(payload []byte) (client_ip net.IP, header_len int, cmd_err int) {
+	if bytes.HasPrefix(payload, PROXY_V1_SIGNATURE) {
+		return parseProxyHeaderV1(payload)
+	}
+	if bytes.HasPrefix(payload, PROXY_V2_SIGNATURE) {
+		return parseProxyHeaderV2(payload)
+	}
+
+	// ... we may only have the first few bytes of a header
+	if len(payload) < len(PROXY_V2_SIGNATURE) &&
+		(bytes.HasPrefix(PROXY_V1_SIGNATURE, payload) ||
+			bytes.HasPrefix(PROXY_V2_SIGNATURE, payload)) {
+		return nil, 0, ERR_TRUNCATED
+	}
+	return nil, 0, ERR_NONE
+}
+
+func parseProxyHeaderV1(payload []byte) (client_ip net.IP, header_len int, cmd_err int) {
+	newline_i := bytes.Index(payload, []byte("\r\n"))
+	if newline_i == -1 {
+		if len(payload) < PROXY_V1_MAX_LEN {
+			return nil, 0, ERR_TRUNCATED
+		}
+		return nil, 0, ERR_BAD_PROXY_HEADER
+	}
+	header_len = newline_i + 2
+
+	// ... "PROXY UNKNOWN" is allowed to be followed by anything at all
+	split_data := strings.Split(string(payload[:newline_i]), " ")
+	if len(split_data) >= 2 && split_data[1] == "UNKNOWN" {
+		return nil, header_len, ERR_NONE
+	}
+	if len(split_data) != 6 {
+		return nil, header_len, ERR_BAD_PROXY_HEADER
+	}
+	client_ip = net.ParseIP(split_data[2])
+	if client_ip == nil {
+		return nil, header_len, ERR_BAD_PROXY_HEADER
+	}
+	return client_ip, header_len, ERR_NONE
+}
+
+func parseProxyHeaderV2(payload []byte) (client_ip net.IP, header_len int, cmd_err int) {
+	if len(payload) < PROXY_V2_MIN_LEN {
+		return nil, 0, ERR_TRUNCATED
+	}
+	version_command, family := payload[12], payload[13]
+	addr_len := int(binary.BigEndian.Uint16(payload[14:16]))
+	header_len = PROXY_V2_MIN_LEN + addr_len
+	if len(payload) < header_len {
+		return nil, 0, ERR_TRUNCATED
+	}
+	if version_command>>4 != 2 {
+		return nil, header_len, ERR_BAD_PROXY_HEADER
+	}
+
+	// ... LOCAL connections (health checks) carry no client address
+	if version_command&0x0f == 0 {
+		return nil, header_len, ERR_NONE
+	}
+	addrs := payload[PROXY_V2_MIN_LEN:header_len]
+	switch family >> 4 {
+	case 1: // AF_INET
+		if len(addrs) < 12 {
+			return nil, header_len, ERR_BAD_PROXY_HEADER
+		}
+		client_ip = net.IP(append([]byte{}, addrs[:4]...))
+	case 2: // AF_INET6
+		if len(addrs) < 36 {
+			return nil, header_len, ERR_BAD_PROXY_HEADER
+		}
+		client_ip = net.IP(append([]byte{}, addrs[:16]...))
+	}
+	return client_ip, header_len, ERR_NONE
+}
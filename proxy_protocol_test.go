@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+)
+
+type ProxyHeaderTest struct {
+	RawData   []byte
+	ClientIP  string
+	HeaderLen int
+	CmdErr    int
+}
+
+var PROXY_HEADER_TEST_TABLE = []ProxyHeaderTest{
+	ProxyHeaderTest{[]byte("get foo\r\n"), "", 0, ERR_NONE},
+	ProxyHeaderTest{[]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 11211\r\nget foo\r\n"), "192.168.0.1", 49, ERR_NONE},
+	ProxyHeaderTest{[]byte("PROXY TCP6 ::1 ::1 56324 11211\r\n"), "::1", 32, ERR_NONE},
+	ProxyHeaderTest{[]byte("PROXY UNKNOWN\r\nget foo\r\n"), "", 15, ERR_NONE},
+	ProxyHeaderTest{[]byte("PROXY TCP4 192.168.0.1\r\n"), "", 24, ERR_BAD_PROXY_HEADER},
+	ProxyHeaderTest{[]byte("PROXY TCP4 192.168.0.1 192.168.0.11"), "", 0, ERR_TRUNCATED},
+	ProxyHeaderTest{[]byte("PRO"), "", 0, ERR_TRUNCATED},
+	ProxyHeaderTest{append([]byte("\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c"),
+		192, 168, 0, 1, 192, 168, 0, 11, 0xdc, 0x04, 0x2b, 0xcb), "192.168.0.1", 28, ERR_NONE},
+	ProxyHeaderTest{[]byte("\r\n\r\n\x00\r\nQUIT\n\x20\x00\x00\x00"), "", 16, ERR_NONE},
+	ProxyHeaderTest{[]byte("\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c\xc0"), "", 0, ERR_TRUNCATED},
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	for test_i, test := range PROXY_HEADER_TEST_TABLE {
+		client_ip, header_len, cmd_err := parseProxyHeader(test.RawData)
+
+		ip_str := ""
+		if client_ip != nil {
+			ip_str = client_ip.String()
+		}
+		if test.ClientIP != ip_str {
+			t.Errorf("Test %d: expected client ip %s, got %s\n", test_i, test.ClientIP, ip_str)
+		}
+		if test.HeaderLen != header_len {
+			t.Errorf("Test %d: expected header length %d, got %d\n",
+				test_i, test.HeaderLen, header_len)
+		}
+		if test.CmdErr != cmd_err {
+			t.Errorf("Test %d: expected cmd err %d, got %d\n",
+				test_i, test.CmdErr, cmd_err)
+		}
+	}
+}
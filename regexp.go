@@ -2,15 +2,36 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 )
 
+// References to capture groups in a name, e.g. "{shard}" or "{1}"
+var CAPTURE_REFERENCE = regexp.MustCompile(`\{(\w+)\}`)
+
 type RegexpKey struct {
 	OriginalRegexp string
 	CompiledRegexp *regexp.Regexp
 	Name           string
+	Priority       int
+
+	// Whether Name references capture groups
+	template bool
+
+	// Name with any capture references replaced by the names of the groups,
+	// for reporting on the regexp itself
+	profile_name string
 }
 
+// NewRegexpKey compiles re, to group the keys it matches under name.  name
+// may reference capture groups in re, by name or number, in braces, so that
+// one regexp can group keys under a family of names: with a regexp of
+// "^user_(?P<shard>\d+)_" and a name of "users.shard_{shard}", "user_3_foo"
+// is grouped under "users.shard_3".
 func NewRegexpKey(re string, name string) (regexp_key *RegexpKey, err error) {
 	r := &RegexpKey{}
 	compiled_regexp, err := regexp.Compile(re)
@@ -20,28 +41,180 @@ func NewRegexpKey(re string, name string) (regexp_key *RegexpKey, err error) {
 	r.OriginalRegexp = re
 	r.CompiledRegexp = compiled_regexp
 	r.Name = name
+
+	// Make sure any capture groups referenced exist
+	for _, reference := range CAPTURE_REFERENCE.FindAllStringSubmatch(name, -1) {
+		if r.captureIndex(reference[1]) == -1 {
+			return r, fmt.Errorf(
+				"Regexp '%s' has no capture group '%s'.", re, reference[1])
+		}
+		r.template = true
+	}
+	r.profile_name = CAPTURE_REFERENCE.ReplaceAllString(name, "$1")
 	return r, nil
 }
 
+// captureIndex returns the index of the capture group with the given name
+// or number, or -1 if there isn't one.
+func (r *RegexpKey) captureIndex(group string) int {
+	if i, err := strconv.Atoi(group); err == nil {
+		if i >= 0 && i <= r.CompiledRegexp.NumSubexp() {
+			return i
+		}
+		return -1
+	}
+	return r.CompiledRegexp.SubexpIndex(group)
+}
+
+// match returns the name key is grouped under, if it matches.
+func (r *RegexpKey) match(key string) (string, bool) {
+	if !r.template {
+		return r.Name, r.CompiledRegexp.Match([]byte(key))
+	}
+	captures := r.CompiledRegexp.FindStringSubmatch(key)
+	if captures == nil {
+		return "", false
+	}
+	return CAPTURE_REFERENCE.ReplaceAllStringFunc(r.Name, func(reference string) string {
+		return captures[r.captureIndex(reference[1:len(reference)-1])]
+	}), true
+}
+
+// RegexpKeys is the set of regexps keys are grouped by.  It is safe for
+// concurrent use, so that the regexps can be replaced while keys are being
+// matched.
 type RegexpKeys struct {
+	Lock sync.RWMutex
+
 	regexp_keys []*RegexpKey
+
+	// If set, the time taken by every attempt to match a key is recorded
+	// here against the regexp
+	Profile *LatencyPool
+
+	// If set, every key matched is counted here against the regexp that
+	// matched it first
+	Matches *HotKeyPool
 }
 
 func NewRegexpKeys() *RegexpKeys {
 	return &RegexpKeys{}
 }
 
+// NewRegexpKeysFromConfig compiles each of the regexps in configs.
+func NewRegexpKeysFromConfig(configs []RegexpConfig) (*RegexpKeys, error) {
+	regexp_keys := NewRegexpKeys()
+	for _, re := range configs {
+		regexp_key, err := NewRegexpKey(re.Re, re.Name)
+		if err != nil {
+			return nil, err
+		}
+		regexp_key.Priority = re.Priority
+		regexp_keys.Add(regexp_key)
+	}
+	return regexp_keys, nil
+}
+
+// Add adds a regexp after any others with the same or higher priority.
 func (r *RegexpKeys) Add(regexp_key *RegexpKey) {
-	r.regexp_keys = append(r.regexp_keys, regexp_key)
+	r.Lock.Lock()
+	defer r.Lock.Unlock()
+
+	i := sort.Search(len(r.regexp_keys), func(i int) bool {
+		return r.regexp_keys[i].Priority < regexp_key.Priority
+	})
+	r.regexp_keys = append(r.regexp_keys, nil)
+	copy(r.regexp_keys[i+1:], r.regexp_keys[i:])
+	r.regexp_keys[i] = regexp_key
 }
 
-// Match finds the first regexp that a key matches and returns either its
-// associated name, or the original regex string used in its compilation
+// Replace replaces the regexps with those in other.
+func (r *RegexpKeys) Replace(other *RegexpKeys) {
+	other.Lock.RLock()
+	regexp_keys := other.regexp_keys
+	other.Lock.RUnlock()
+
+	r.Lock.Lock()
+	defer r.Lock.Unlock()
+	r.regexp_keys = regexp_keys
+}
+
+func (r *RegexpKeys) Len() int {
+	r.Lock.RLock()
+	defer r.Lock.RUnlock()
+	return len(r.regexp_keys)
+}
+
+// Names returns the name of each regexp, with any capture references
+// replaced by the names of the groups, in order of priority.
+func (r *RegexpKeys) Names() []string {
+	r.Lock.RLock()
+	defer r.Lock.RUnlock()
+
+	names := []string{}
+	seen := map[string]bool{}
+	for _, re := range r.regexp_keys {
+		if !seen[re.profile_name] {
+			names = append(names, re.profile_name)
+			seen[re.profile_name] = true
+		}
+	}
+	return names
+}
+
+// matched counts a key matched by re, if counting matches.
+func (r *RegexpKeys) matched(re *RegexpKey) {
+	if r.Matches != nil {
+		r.Matches.AddN(re.profile_name, 1)
+	}
+}
+
+// try matches key against re, timing it if profiling.
+func (r *RegexpKeys) try(re *RegexpKey, key string) (string, bool) {
+	if r.Profile == nil {
+		return re.match(key)
+	}
+	st := time.Now()
+	name, ok := re.match(key)
+	r.Profile.Add([]string{re.profile_name}, time.Now().Sub(st))
+	return name, ok
+}
+
+// Match finds the first regexp, in order of priority, that a key matches
+// and returns its associated name.
 func (r *RegexpKeys) Match(key string) (string, error) {
+	r.Lock.RLock()
+	defer r.Lock.RUnlock()
+
 	for _, re := range r.regexp_keys {
-		if re.CompiledRegexp.Match([]byte(key)) {
-			return re.Name, nil
+		if name, ok := r.try(re, key); ok {
+			r.matched(re)
+			return name, nil
 		}
 	}
 	return "", errors.New("Could not match key to regex.")
 }
+
+// MatchAll is Match, but carries on trying the rest of the regexps after the
+// first match, returning the names of any others that matched too.
+func (r *RegexpKeys) MatchAll(key string) (string, []string, error) {
+	r.Lock.RLock()
+	defer r.Lock.RUnlock()
+
+	matched := ""
+	shadowed := []string{}
+	for _, re := range r.regexp_keys {
+		if name, ok := r.try(re, key); !ok {
+			continue
+		} else if matched == "" {
+			r.matched(re)
+			matched = name
+		} else {
+			shadowed = append(shadowed, name)
+		}
+	}
+	if matched == "" {
+		return "", shadowed, errors.New("Could not match key to regex.")
+	}
+	return matched, shadowed, nil
+}
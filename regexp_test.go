@@ -31,3 +31,123 @@ func TestRegexp(t *testing.T) {
 		}
 	}
 }
+
+type RegexpTemplateTest struct {
+	Regexp   string
+	Name     string
+	Key      string
+	Expected string
+}
+
+var REGEXP_TEMPLATE_TEST_CASES = []RegexpTemplateTest{
+	RegexpTemplateTest{`^user_(?P<shard>\d+)_`, "users.shard_{shard}", "user_3_foo", "users.shard_3"},
+	RegexpTemplateTest{`^(\w+):(\d+)$`, "{1}.by_id", "post:123", "post.by_id"},
+	RegexpTemplateTest{`^user_(?P<shard>\d+)?x`, "users.shard_{shard}", "user_x", "users.shard_"},
+}
+
+func TestRegexpTemplate(t *testing.T) {
+	for _, test := range REGEXP_TEMPLATE_TEST_CASES {
+		regexp_keys := NewRegexpKeys()
+		regexp_key, err := NewRegexpKey(test.Regexp, test.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		regexp_keys.Add(regexp_key)
+
+		match, err := regexp_keys.Match(test.Key)
+		if err != nil || match != test.Expected {
+			t.Errorf("Expected %s to match as %s, got %s\n", test.Key, test.Expected, match)
+		}
+	}
+
+	if _, err := NewRegexpKey(`^user_(\d+)_`, "users.{shard}"); err == nil {
+		t.Errorf("Expected error for reference to missing capture group\n")
+	}
+	if _, err := NewRegexpKey(`^user_(\d+)_`, "users.{2}"); err == nil {
+		t.Errorf("Expected error for reference to missing numbered capture group\n")
+	}
+}
+
+func TestRegexpPriority(t *testing.T) {
+	regexp_keys, err := NewRegexpKeysFromConfig([]RegexpConfig{
+		RegexpConfig{Name: "users", Re: "^user_"},
+		RegexpConfig{Name: "everything", Re: ".", Priority: -1},
+		RegexpConfig{Name: "admins", Re: "^user_admin_", Priority: 1},
+		RegexpConfig{Name: "user_ids", Re: "^user_[0-9]+"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"user_admin_1": "admins",
+		"user_1":       "users",
+		"post_1":       "everything",
+	}
+	for key, name := range expected {
+		if match, err := regexp_keys.Match(key); err != nil || match != name {
+			t.Errorf("Expected %s to match as %s, got %s\n", key, name, match)
+		}
+	}
+
+	match, shadowed, err := regexp_keys.MatchAll("user_1")
+	if err != nil || match != "users" {
+		t.Errorf("Expected user_1 to match as users, got %s\n", match)
+	}
+	if !stringsEqual(shadowed, []string{"user_ids", "everything"}) {
+		t.Errorf("Expected user_1 to also match user_ids and everything, got %v\n", shadowed)
+	}
+	if _, _, err := regexp_keys.MatchAll(""); err == nil {
+		t.Errorf("Expected error for unmatched key\n")
+	}
+}
+
+func TestRegexpProfile(t *testing.T) {
+	regexp_keys, err := NewRegexpKeysFromConfig([]RegexpConfig{
+		RegexpConfig{Name: "users.{1}", Re: "^user_([0-9]+)"},
+		RegexpConfig{Name: "everything", Re: "."},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	regexp_keys.Profile = NewLatencyPool()
+
+	for _, key := range []string{"user_1", "user_2", "post_1"} {
+		regexp_keys.Match(key)
+	}
+
+	expected := map[string]int{"users.1": 3, "everything": 1}
+	for name, attempts := range expected {
+		if cost := regexp_keys.Profile.Get(name); cost.Count != attempts {
+			t.Errorf("Expected %d attempts for %s, got %d\n", attempts, name, cost.Count)
+		}
+	}
+}
+
+func TestRegexpMatches(t *testing.T) {
+	regexp_keys, err := NewRegexpKeysFromConfig([]RegexpConfig{
+		RegexpConfig{Name: "users.{1}", Re: "^user_([0-9]+)"},
+		RegexpConfig{Name: "posts", Re: "^post_"},
+		RegexpConfig{Name: "comments", Re: "^comment_"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	regexp_keys.Matches = NewHotKeyPool()
+
+	for _, key := range []string{"user_1", "user_2", "post_1", "foo"} {
+		regexp_keys.Match(key)
+	}
+
+	/* Regexps that matched nothing are counted too */
+	expected := map[string]int{"users.1": 2, "posts": 1, "comments": 0}
+	names := regexp_keys.Names()
+	if len(names) != len(expected) {
+		t.Errorf("Expected %d regexps, got %v\n", len(expected), names)
+	}
+	for _, name := range names {
+		if hits := regexp_keys.Matches.GetHits(name); hits != expected[name] {
+			t.Errorf("Expected %d matches for %s, got %d\n", expected[name], name, hits)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+)
+
+// Config fields that can't be changed by reloading the config, because the
+// counts, listeners or files they set up are kept across reloads
+var RELOAD_RESTART_FIELDS = []string{
+	"interface", "run_duration", "top",
+	"counter", "sketch_width", "sketch_depth", "sketch_candidates", "space_saving_counters",
+	"hot_key_shards", "max_keys", "max_key_bytes", "sliding_window", "sliding_window_granularity",
+	"decay_half_life", "cumulative", "key_examples", "profile_regexps", "report_regexp_matches",
+	"debug_errors_file", "debug_errors_bytes", "debug_errors_per_second", "tls_key_log_file",
+	"socket_path", "grpc_address", "grpc_cert_file", "grpc_key_file", "prometheus_address",
+	"api_address", "expvar_address", "report_history",
+	"log_level", "log_format", "log_output", "log_file", "log_max_bytes", "log_max_age", "log_retain",
+	"daemon", "pidfile",
+	"user", "group", "chroot",
+}
+
+// reloadConfig checks that the regexps and filters in new_config compile,
+// then returns it with any fields that can't be reloaded kept as they were
+// in config, and the names of those that had changed.
+func reloadConfig(config Config, new_config Config) (Config, []string, error) {
+	if _, err := NewRegexpKeysFromConfig(new_config.Regexps); err != nil {
+		return config, nil, err
+	}
+	if _, err := NewKeyFilter(new_config.Include, new_config.Exclude); err != nil {
+		return config, nil, err
+	}
+
+	fields := append([]string{}, RELOAD_RESTART_FIELDS...)
+	if config.Top || config.APIAddress != "" || config.ExpvarAddress != "" {
+		// ... which name metrics under the prefix they started with
+		fields = append(fields, "prefix")
+	}
+	if config.SocketPath != "" {
+		// ... which writes reports in the format it started with
+		fields = append(fields, "format", "template", "timestamps",
+			"output_separator", "output_labels", "output_escape")
+	}
+	old_fields, new_fields := configFields(config), configFields(new_config)
+	kept := map[string]interface{}{}
+	changed := []string{}
+	for _, field := range fields {
+		if !reflect.DeepEqual(old_fields[field], new_fields[field]) {
+			kept[field] = old_fields[field]
+			changed = append(changed, field)
+		}
+	}
+	data, _ := json.Marshal(kept)
+	if err := json.Unmarshal(data, &new_config); err != nil {
+		return config, nil, err
+	}
+	return new_config, changed, nil
+}
+
+// configFields returns the fields of a config by their JSON names.
+func configFields(config Config) map[string]interface{} {
+	data, _ := json.Marshal(config)
+	fields := map[string]interface{}{}
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// startReloadLoop starts a loop that reloads config_file whenever we
+// receive a SIGHUP, without interrupting capture or losing counts.  The
+// capture filter is set with set_filter, the regexps replaced, and the new
+// config, with apply_flags applied to it, sent on each of reloads.  If the
+// new config is invalid, the old one is kept.
+func startReloadLoop(config_file string, apply_flags func(config Config) (Config, error), config Config,
+	regexp_keys *RegexpKeys, set_filter func(filter string) error, reloads ...chan<- Config) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		new_config, err := ReadConfig(config_file)
+		if err != nil {
+			logger.Warn("Not reloading config", "error", err)
+			continue
+		}
+		if new_config, err = apply_flags(new_config); err != nil {
+			logger.Warn("Not reloading config", "error", err)
+			continue
+		}
+		new_config, changed, err := reloadConfig(config, new_config)
+		if err != nil {
+			logger.Warn("Not reloading config", "error", err)
+			continue
+		}
+		if filter := captureFilter(new_config); filter != captureFilter(config) {
+			if err := set_filter(filter); err != nil {
+				logger.Warn("Not reloading config", "filter", filter, "error", err)
+				continue
+			}
+		}
+		new_regexp_keys, _ := NewRegexpKeysFromConfig(new_config.Regexps)
+		regexp_keys.Replace(new_regexp_keys)
+		for _, reload := range reloads {
+			reload <- new_config
+		}
+		config = new_config
+
+		logger.Info("Reloaded config", "file", config_file)
+		if len(changed) > 0 {
+			logger.Warn("Restart to apply changes", "fields", strings.Join(changed, ", "))
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReloadConfig(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"interval": 5, "max_keys": 1000, "api_address": ":8080"}`))
+	new_config, _ := NewConfig([]byte(`{
+		"interval": 10,
+		"max_keys": 2000,
+		"prefix": "cache",
+		"regexps": [{"name": "foo", "re": "^foo"}],
+		"exclude": [{"prefix": "bar"}]
+	}`))
+
+	/* Fields that need a restart keep their old values, including the API,
+	 * and so the prefix it names metrics under */
+	reloaded, changed, err := reloadConfig(config, new_config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changed, []string{"max_keys", "api_address", "prefix"}) {
+		t.Errorf("Unexpected fields needing a restart %v\n", changed)
+	}
+	if reloaded.Interval != Duration(10*time.Second) || len(reloaded.Regexps) != 1 || len(reloaded.Exclude) != 1 {
+		t.Errorf("Expected the new config to be reloaded, got %+v\n", reloaded)
+	}
+	if reloaded.MaxKeys != 1000 || reloaded.APIAddress != ":8080" || reloaded.Prefix != "mcsauna" {
+		t.Errorf("Expected fields needing a restart to be kept, got %+v\n", reloaded)
+	}
+
+	/* A config with a bad regexp isn't reloaded */
+	new_config.Regexps = []RegexpConfig{{Name: "bar", Re: "^(bar"}}
+	if _, _, err := reloadConfig(config, new_config); err == nil {
+		t.Errorf("Expected error reloading invalid regexp\n")
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Formats reports can be output in
+var FORMATS = map[string]bool{
+	"text":   true,
+	"json":   true,
+	"ndjson": true,
+	"csv":    true,
+}
+
+// Ways timestamps can be added to each line of text output, if at all
+var TIMESTAMP_FORMATS = map[string]bool{
+	"":        true,
+	"epoch":   true,
+	"rfc3339": true,
+}
+
+// MetricPart is part of the name of a metric: either a fixed part of the
+// path, e.g. "mcsauna.keys", or a labelled value, e.g. a key.
+type MetricPart struct {
+	// Empty for fixed parts
+	Label string
+	Value string
+}
+
+// MetricName is the name of a metric, which can be written either as a
+// dotted path, e.g. "mcsauna.commands.get.keys.foo", or as a family with
+// labels, e.g. "mcsauna.commands.keys" with a command of "get" and a key of
+// "foo", for outputs that support them.
+type MetricName []MetricPart
+
+func NewMetricName(path string) MetricName {
+	return MetricName{MetricPart{"", path}}
+}
+
+// Append returns the name with path added to the end.
+func (n MetricName) Append(path string) MetricName {
+	return append(n[:len(n):len(n)], MetricPart{"", path})
+}
+
+// Label returns the name with a labelled value added to the end.
+func (n MetricName) Label(label string, value string) MetricName {
+	return append(n[:len(n):len(n)], MetricPart{label, value})
+}
+
+// String returns the name as a dotted path.
+func (n MetricName) String() string {
+	values := make([]string, len(n))
+	for i, part := range n {
+		values[i] = part.Value
+	}
+	return strings.Join(values, ".")
+}
+
+// Family returns the name as a dotted path, leaving out labelled values.
+func (n MetricName) Family() string {
+	paths := []string{}
+	for _, part := range n {
+		if part.Label == "" {
+			paths = append(paths, part.Value)
+		}
+	}
+	return strings.Join(paths, ".")
+}
+
+// SplitKeyCommand returns the key and command in the name, if any, and the
+// rest of the name without them.
+func (n MetricName) SplitKeyCommand() (MetricName, string, string) {
+	name := MetricName{}
+	key, command := "", ""
+	for _, part := range n {
+		switch part.Label {
+		case "key":
+			key = part.Value
+		case "command":
+			command = part.Value
+		default:
+			name = append(name, part)
+		}
+	}
+	return name, key, command
+}
+
+// Labels returns the labelled values in the name.
+func (n MetricName) Labels() []MetricPart {
+	labels := []MetricPart{}
+	for _, part := range n {
+		if part.Label != "" {
+			labels = append(labels, part)
+		}
+	}
+	return labels
+}
+
+// Metric is a single line of a report.
+type Metric struct {
+	Name  MetricName
+	Value float64
+
+	// Whether Value counts something that happened during the interval,
+	// e.g. hits, rather than measuring something, e.g. a latency or ratio
+	Counter bool
+}
+
+// FormatValue formats the value of a metric, as an integer if it is a whole
+// number, or to three decimal places if not.
+func (m *Metric) FormatValue() string {
+	if m.Value == math.Trunc(m.Value) {
+		return fmt.Sprintf("%d", int64(m.Value))
+	}
+	return fmt.Sprintf("%.3f", m.Value)
+}
+
+// Report is everything reported on for an interval, in the order it is
+// output.
+type Report struct {
+	// When the interval ended
+	Time time.Time
+
+	Metrics []*Metric
+
+	// Alerts raised over the interval, also reported as metrics
+	Alerts []*Alert
+}
+
+func NewReport(now time.Time) *Report {
+	return &Report{Time: now, Metrics: []*Metric{}}
+}
+
+// Count adds a metric counting something that happened during the
+// interval.
+func (r *Report) Count(name MetricName, n int) {
+	r.Metrics = append(r.Metrics, &Metric{name, float64(n), true})
+}
+
+// Gauge adds a metric measuring something over the interval.
+func (r *Report) Gauge(name MetricName, value float64) {
+	r.Metrics = append(r.Metrics, &Metric{name, value, false})
+}
+
+// String formats the report as lines of "<metric> <value>".
+func (r *Report) String() string {
+	return r.Text("", TextStyle{})
+}
+
+// Text formats the report as lines of "<metric> <value>", followed by the
+// time of the report, if timestamps is "epoch" or "rfc3339", in style.
+func (r *Report) Text(timestamps string, style TextStyle) string {
+	separator := style.Separator
+	if separator == "" {
+		separator = " "
+	}
+	suffix := ""
+	switch timestamps {
+	case "epoch":
+		suffix = fmt.Sprintf("%s%d", separator, r.Time.Unix())
+	case "rfc3339":
+		suffix = separator + r.Time.Format(time.RFC3339)
+	}
+	output := ""
+	for _, m := range r.Metrics {
+		output += style.name(m.Name, separator) + separator + m.FormatValue() + suffix + "\n"
+	}
+	return output
+}
+
+// Ways of escaping labelled values, e.g. keys, in text output
+var TEXT_ESCAPES = map[string]bool{
+	"none":       true,
+	"underscore": true,
+	"percent":    true,
+}
+
+// TextStyle is how the fields of each line of text output are laid out.
+// The zero value is the default, "<metric> <value>".
+type TextStyle struct {
+	// Between the metric and its value, and the time if there is one
+	Separator string
+
+	// Whether labelled values, e.g. keys, are fields of their own, after
+	// the name of the metric without them, rather than part of its name
+	SplitLabels bool
+
+	// How characters in labelled values that would break up a line are
+	// escaped, one of TEXT_ESCAPES: not at all, by replacing them with
+	// underscores, or by percent-encoding them
+	Escape string
+}
+
+// NewTextStyle returns the style with separator "space" or "tab", labels
+// "joined" into the name of each metric or as separate "fields", and
+// escape one of TEXT_ESCAPES.
+func NewTextStyle(separator string, labels string, escape string) (TextStyle, error) {
+	style := TextStyle{SplitLabels: labels == "fields", Escape: escape}
+	switch separator {
+	case "space":
+		style.Separator = " "
+	case "tab":
+		style.Separator = "\t"
+	default:
+		return style, fmt.Errorf("Unknown separator '%s'", separator)
+	}
+	if labels != "joined" && labels != "fields" {
+		return style, fmt.Errorf("Unknown way of laying out labels '%s'", labels)
+	} else if !TEXT_ESCAPES[escape] {
+		return style, fmt.Errorf("Unknown escaping '%s'", escape)
+	}
+	return style, nil
+}
+
+// name formats the name of a metric, escaping labelled values.
+func (s TextStyle) name(name MetricName, separator string) string {
+	if s.Escape != "" && s.Escape != "none" {
+		// ... dots only break up a line if labelled values are part of the
+		// ... name
+		special := separator + " \t\r\n"
+		if !s.SplitLabels {
+			special += "."
+		}
+		escaped := make(MetricName, len(name))
+		for i, part := range name {
+			escaped[i] = part
+			if part.Label != "" {
+				escaped[i].Value = escapeText(part.Value, special, s.Escape)
+			}
+		}
+		name = escaped
+	}
+	if s.SplitLabels {
+		return strings.Join(append([]string{name.Family()}, labelValues(name)...), separator)
+	}
+	return name.String()
+}
+
+// labelValues returns the labelled values in a name, in order.
+func labelValues(name MetricName) []string {
+	values := []string{}
+	for _, label := range name.Labels() {
+		values = append(values, label.Value)
+	}
+	return values
+}
+
+// escapeText escapes any of the characters in special in value, with an
+// underscore or by percent-encoding.  When percent-encoding, percent signs
+// are escaped too, so that the value can be decoded.
+func escapeText(value string, special string, escape string) string {
+	if escape == "percent" {
+		special += "%"
+	}
+	if !strings.ContainsAny(value, special) {
+		return value
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(value); i++ {
+		if strings.IndexByte(special, value[i]) < 0 {
+			buf.WriteByte(value[i])
+		} else if escape == "underscore" {
+			buf.WriteByte('_')
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", value[i])
+		}
+	}
+	return buf.String()
+}
+
+// Object returns the metric as a JSON-friendly object, with its family
+// name, its labels, and its value.
+func (m *Metric) Object() map[string]interface{} {
+	object := map[string]interface{}{
+		"name":  m.Name.Family(),
+		"value": m.Value,
+	}
+	for _, label := range m.Name.Labels() {
+		object[label.Label] = label.Value
+	}
+	return object
+}
+
+// JSON formats the report as a single JSON object, of the time, the version
+// of mcsauna and a list of metrics.
+func (r *Report) JSON() string {
+	metrics := make([]map[string]interface{}, len(r.Metrics))
+	for i, m := range r.Metrics {
+		metrics[i] = m.Object()
+	}
+	output, _ := json.Marshal(map[string]interface{}{
+		"timestamp": r.Time.Format(time.RFC3339),
+		"version":   VERSION,
+		"metrics":   metrics,
+	})
+	return string(output) + "\n"
+}
+
+// NDJSON formats the report as a JSON object per metric, one to a line,
+// each including the time.
+func (r *Report) NDJSON() string {
+	output := ""
+	timestamp := r.Time.Format(time.RFC3339)
+	for _, m := range r.Metrics {
+		object := m.Object()
+		object["timestamp"] = timestamp
+		line, _ := json.Marshal(object)
+		output += string(line) + "\n"
+	}
+	return output
+}
+
+// CSV formats the report as CSV, with a header, and a row per metric of
+// the time, the name of the metric, the key and command it is for (if any)
+// and its value.  Any other labels are left in the name.
+func (r *Report) CSV() string {
+	output := &bytes.Buffer{}
+	w := csv.NewWriter(output)
+	w.Write([]string{"timestamp", "name", "key", "command", "value"})
+	timestamp := r.Time.Format(time.RFC3339)
+	for _, m := range r.Metrics {
+		name, key, command := m.Name.SplitKeyCommand()
+		w.Write([]string{timestamp, name.String(), key, command, m.FormatValue()})
+	}
+	w.Flush()
+	return output.String()
+}
+
+// Format formats the report as text, "json", "ndjson" or "csv".
+func (r *Report) Format(format string) string {
+	switch format {
+	case "csv":
+		return r.CSV()
+	case "json":
+		return r.JSON()
+	case "ndjson":
+		return r.NDJSON()
+	}
+	return r.String()
+}
+
+// TemplateLine is what a line template is executed against, for each
+// metric in a report.
+type TemplateLine struct {
+	// The full dotted name, e.g. "mcsauna.commands.get.keys.foo", and the
+	// name without labels, e.g. "mcsauna.commands.keys"
+	Name   string
+	Family string
+
+	// The key and command the metric is for, if any, and all its labels
+	Key     string
+	Command string
+	Labels  map[string]string
+
+	// The value, formatted as in text output, and as a whole number
+	Value string
+	Hits  int
+
+	// The time of the report, and the same as a Unix timestamp
+	Time      time.Time
+	Timestamp int64
+}
+
+// newTemplateLine returns what a line template is executed against for a
+// metric reported at now.
+func newTemplateLine(m *Metric, now time.Time) *TemplateLine {
+	line := &TemplateLine{
+		Name:      m.Name.String(),
+		Family:    m.Name.Family(),
+		Labels:    map[string]string{},
+		Value:     m.FormatValue(),
+		Hits:      int(m.Value),
+		Time:      now,
+		Timestamp: now.Unix(),
+	}
+	for _, label := range m.Name.Labels() {
+		line.Labels[label.Label] = label.Value
+	}
+	line.Key = line.Labels["key"]
+	line.Command = line.Labels["command"]
+	return line
+}
+
+// Template formats the report by executing t for each metric, one to a
+// line.
+func (r *Report) Template(t *template.Template) (string, error) {
+	output := &bytes.Buffer{}
+	for _, m := range r.Metrics {
+		if err := t.Execute(output, newTemplateLine(m, r.Time)); err != nil {
+			return "", err
+		}
+		output.WriteString("\n")
+	}
+	return output.String(), nil
+}
+
+// ReportFormat is how reports are output: in one of FORMATS, or with a
+// template for each line.
+type ReportFormat struct {
+	name     string
+	template *template.Template
+
+	// How to timestamp lines of text output, one of TIMESTAMP_FORMATS, and
+	// lay them out
+	timestamps string
+	style      TextStyle
+}
+
+// NewReportFormat returns the format name, one of FORMATS, or, if
+// line_template is set, a format executing it for each line.  The template
+// is tried out on an example line, so that e.g. references to fields that
+// don't exist are caught.  Lines of text output are timestamped as
+// timestamps, one of TIMESTAMP_FORMATS, and laid out in style.
+func NewReportFormat(name string, line_template string, timestamps string, style TextStyle) (*ReportFormat, error) {
+	if _, ok := FORMATS[name]; !ok {
+		return nil, fmt.Errorf("Unknown format '%s'", name)
+	} else if _, ok := TIMESTAMP_FORMATS[timestamps]; !ok {
+		return nil, fmt.Errorf("Unknown timestamp format '%s'", timestamps)
+	} else if line_template == "" {
+		return &ReportFormat{name: name, timestamps: timestamps, style: style}, nil
+	}
+	t, err := template.New("line").Parse(line_template)
+	if err != nil {
+		return nil, err
+	}
+	example := NewReport(time.Now())
+	example.Count(NewMetricName("mcsauna.keys").Label("key", "foo"), 1)
+	if _, err := example.Template(t); err != nil {
+		return nil, err
+	}
+	return &ReportFormat{name: name, template: t}, nil
+}
+
+func (f *ReportFormat) Format(r *Report) (string, error) {
+	if f.template != nil {
+		return r.Template(f.template)
+	} else if f.name == "text" {
+		return r.Text(f.timestamps, f.style), nil
+	}
+	return r.Format(f.name), nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func testReport(now time.Time, hits int) *Report {
+	report := NewReport(now)
+	report.Count(NewMetricName("mcsauna.keys").Label("key", "foo"), hits)
+	return report
+}
+
+func TestMetricName(t *testing.T) {
+	base := NewMetricName("mcsauna.commands").Label("command", "get")
+	keys := base.Append("keys").Label("key", "foo.bar")
+	latency := base.Append("mean_us")
+
+	if name := keys.String(); name != "mcsauna.commands.get.keys.foo.bar" {
+		t.Errorf("Unexpected name %s\n", name)
+	}
+	if family := keys.Family(); family != "mcsauna.commands.keys" {
+		t.Errorf("Unexpected family %s\n", family)
+	}
+	if labels := keys.Labels(); len(labels) != 2 || labels[1] != (MetricPart{"key", "foo.bar"}) {
+		t.Errorf("Unexpected labels %v\n", labels)
+	}
+	// ... names built from the same base don't share parts
+	if name := latency.String(); name != "mcsauna.commands.get.mean_us" {
+		t.Errorf("Unexpected name %s\n", name)
+	}
+}
+
+func TestReportFormat(t *testing.T) {
+	report := NewReport(time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC))
+	report.Count(NewMetricName("mcsauna.commands").Label("command", "get").Append("keys").
+		Label("key", "foo"), 3)
+	report.Gauge(NewMetricName("mcsauna.distribution.gini"), 0.25)
+
+	expected := map[string]string{
+		"text": "mcsauna.commands.get.keys.foo 3\nmcsauna.distribution.gini 0.250\n",
+		"json": `{"metrics":[{"command":"get","key":"foo","name":"mcsauna.commands.keys","value":3},` +
+			`{"name":"mcsauna.distribution.gini","value":0.25}],"timestamp":"2017-07-14T02:40:00Z","version":"dev"}` + "\n",
+		"ndjson": `{"command":"get","key":"foo","name":"mcsauna.commands.keys","timestamp":"2017-07-14T02:40:00Z","value":3}` + "\n" +
+			`{"name":"mcsauna.distribution.gini","timestamp":"2017-07-14T02:40:00Z","value":0.25}` + "\n",
+		"csv": "timestamp,name,key,command,value\n" +
+			"2017-07-14T02:40:00Z,mcsauna.commands.keys,foo,get,3\n" +
+			"2017-07-14T02:40:00Z,mcsauna.distribution.gini,,,0.250\n",
+	}
+	for format, output := range expected {
+		if actual := report.Format(format); actual != output {
+			t.Errorf("Expected %s output %q, got %q\n", format, output, actual)
+		}
+	}
+}
+
+func TestReportTimestamps(t *testing.T) {
+	report := NewReport(time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC))
+	report.Count(NewMetricName("mcsauna.keys").Label("key", "foo"), 3)
+
+	expected := map[string]string{
+		"":        "mcsauna.keys.foo 3\n",
+		"epoch":   "mcsauna.keys.foo 3 1500000000\n",
+		"rfc3339": "mcsauna.keys.foo 3 2017-07-14T02:40:00Z\n",
+	}
+	for timestamps, output := range expected {
+		format, err := NewReportFormat("text", "", timestamps, TextStyle{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual, _ := format.Format(report); actual != output {
+			t.Errorf("Expected %q with timestamps %q, got %q\n", output, timestamps, actual)
+		}
+	}
+	if _, err := NewConfig([]byte(`{"timestamps": "unix"}`)); err == nil {
+		t.Errorf("Expected an error for an unknown timestamp format\n")
+	}
+}
+
+func TestReportTemplate(t *testing.T) {
+	report := NewReport(time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC))
+	report.Count(NewMetricName("mcsauna.commands").Label("command", "get").Append("keys").
+		Label("key", "foo"), 3)
+	report.Gauge(NewMetricName("mcsauna.distribution.gini"), 0.25)
+
+	format, err := NewReportFormat("text",
+		`{{if .Key}}hot.{{.Command}}.{{.Key}} {{.Hits}}{{else}}{{.Name}} {{.Value}}{{end}} {{.Timestamp}}`, "",
+		TextStyle{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "hot.get.foo 3 1500000000\nmcsauna.distribution.gini 0.250 1500000000\n"
+	if output, err := format.Format(report); err != nil {
+		t.Error(err)
+	} else if output != expected {
+		t.Errorf("Expected %q, got %q\n", expected, output)
+	}
+
+	if _, err := NewReportFormat("text", "{{.Nonexistent}}", "", TextStyle{}); err == nil {
+		t.Errorf("Expected an error for a template referencing a missing field\n")
+	}
+	if _, err := NewConfig([]byte(`{"template": "{{.Key}}", "format": "csv"}`)); err == nil {
+		t.Errorf("Expected an error for a template with a format\n")
+	}
+}
+
+func TestReportTextStyle(t *testing.T) {
+	report := NewReport(time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC))
+	report.Count(NewMetricName("mcsauna.commands").Label("command", "get").Append("keys").
+		Label("key", "user.1 %"), 3)
+
+	cases := []struct {
+		separator, labels, escape string
+		expected                  string
+	}{
+		{"space", "joined", "none", "mcsauna.commands.get.keys.user.1 % 3\n"},
+		{"space", "joined", "underscore", "mcsauna.commands.get.keys.user_1_% 3\n"},
+		{"tab", "fields", "percent", "mcsauna.commands.keys\tget\tuser.1%20%25\t3\n"},
+		{"tab", "fields", "none", "mcsauna.commands.keys\tget\tuser.1 %\t3\n"},
+	}
+	for _, c := range cases {
+		style, err := NewTextStyle(c.separator, c.labels, c.escape)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output := report.Text("", style); output != c.expected {
+			t.Errorf("Expected %q, got %q\n", c.expected, output)
+		}
+	}
+	if _, err := NewConfig([]byte(`{"output_separator": "comma"}`)); err == nil {
+		t.Errorf("Expected an error for an unknown separator\n")
+	}
+}
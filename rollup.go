@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+)
+
+// rollupKey groups key with similar keys, without needing regexps: it is
+// split on delimiter, segments that are all digits (usually IDs) are
+// replaced with "*", and only the first num_segments segments are kept, or
+// all of them if num_segments is zero.
+//
+// For example, with a delimiter of ":", "user:1234:profile" is rolled up to
+// "user:*:profile", or "user:*" when keeping two segments.
+func rollupKey(key string, delimiter string, num_segments int) string {
+	segments := strings.Split(key, delimiter)
+	if num_segments > 0 && len(segments) > num_segments {
+		segments = segments[:num_segments]
+	}
+	for i, segment := range segments {
+		if isDigits(segment) {
+			segments[i] = "*"
+		}
+	}
+	return strings.Join(segments, delimiter)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
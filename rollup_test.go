@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+)
+
+type RollupKeyTest struct {
+	Key         string
+	Delimiter   string
+	NumSegments int
+	Expected    string
+}
+
+var ROLLUP_KEY_TEST_TABLE = []RollupKeyTest{
+	RollupKeyTest{"user:1234:profile", ":", 0, "user:*:profile"},
+	RollupKeyTest{"user:1234:profile", ":", 2, "user:*"},
+	RollupKeyTest{"user:1234:profile", ":", 5, "user:*:profile"},
+	RollupKeyTest{"Foo_12_bar", "_", 0, "Foo_*_bar"},
+	RollupKeyTest{"foo", ":", 1, "foo"},
+	RollupKeyTest{"foo::1", ":", 0, "foo::*"},
+}
+
+func TestRollupKey(t *testing.T) {
+	for test_i, test := range ROLLUP_KEY_TEST_TABLE {
+		actual := rollupKey(test.Key, test.Delimiter, test.NumSegments)
+		if actual != test.Expected {
+			t.Errorf("Test %d: expected %s to roll up to %s, got %s\n",
+				test_i, test.Key, test.Expected, actual)
+		}
+	}
+}
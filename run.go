@@ -0,0 +1,55 @@
+package main
+
+import (
+	"time"
+)
+
+// How many of the hottest keys a run summary keeps track of, to find the
+// hottest key over the whole run
+const RUN_SUMMARY_KEYS = 1000
+
+// Reports still being sent when mcsauna exits are given this long to
+// finish
+const FLUSH_TIMEOUT = 10 * time.Second
+
+// RunSummary totals up a whole run of mcsauna, across reports, for a
+// summary when it exits.
+type RunSummary struct {
+	start    time.Time
+	packets  int
+	commands int
+
+	// Hits for the hottest keys, or nil if keys aren't counted afresh
+	// each interval, e.g. with a sliding window, so can't be summed
+	keys *HotKeyPool
+}
+
+func NewRunSummary(start time.Time, sum_keys bool) *RunSummary {
+	summary := &RunSummary{start: start}
+	if sum_keys {
+		summary.keys = newHotKeyPool(func() keyCounter {
+			return newSpaceSavingCounter(RUN_SUMMARY_KEYS)
+		}, 1)
+	}
+	return summary
+}
+
+// Add adds what was counted over an interval.
+func (s *RunSummary) Add(rotated *Stats) {
+	s.packets += rotated.Self.GetHits("packets_processed")
+	s.commands += rotated.Self.GetHits("commands_parsed")
+	if s.keys != nil {
+		s.keys.Merge(rotated.HotKeys)
+	}
+}
+
+// formatRunSummary adds how long mcsauna ran for until now, the packets
+// and commands it processed, and the hottest key over the whole run.
+func formatRunSummary(report *Report, name MetricName, summary *RunSummary, now time.Time) {
+	report.Gauge(name.Append("seconds"), now.Sub(summary.start).Seconds())
+	report.Count(name.Append("packets"), summary.packets)
+	report.Count(name.Append("commands"), summary.commands)
+	if summary.keys != nil {
+		formatTopKeys(report, name.Append("top_key"), "key", summary.keys.GetTopKeys(), 1, 1)
+	}
+}
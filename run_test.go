@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRunSummary(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	summary := NewRunSummary(start, true)
+	config, _ := NewConfig([]byte{})
+	stats := NewStats(config)
+	for _, keys := range [][]string{{"foo", "bar"}, {"bar", "bar"}} {
+		stats.HotKeys.Add(keys)
+		stats.Self.AddN("packets_processed", 1)
+		stats.Self.AddN("commands_parsed", len(keys))
+		summary.Add(stats.Rotate())
+	}
+
+	report := NewReport(start)
+	formatRunSummary(report, NewMetricName("mcsauna.run"), summary, start.Add(90*time.Second))
+	expected := "mcsauna.run.seconds 90\nmcsauna.run.packets 2\nmcsauna.run.commands 4\n" +
+		"mcsauna.run.top_key.bar 3\n"
+	if output := report.String(); output != expected {
+		t.Errorf("Expected %q, got %q\n", expected, output)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	S3_TIMEOUT = 30 * time.Second
+
+	// Where EC2 instances get credentials for their IAM role, and how long
+	// to wait for it off EC2
+	S3_METADATA_URL     = "http://169.254.169.254"
+	S3_METADATA_TIMEOUT = time.Second
+
+	// Credentials from instance metadata are fetched again this long
+	// before they expire
+	S3_CREDENTIALS_REFRESH = 5 * time.Minute
+
+	S3_TIME_FORMAT = "20060102T150405Z"
+)
+
+// s3Credentials are AWS credentials, or HMAC keys for GCS.  Those that
+// don't expire have a zero expiration.
+type s3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// S3Sink uploads each report, as JSON, to an object in an S3 bucket, or
+// any object store with an S3-compatible API, e.g. GCS, named
+// "<prefix><host>/<time>.json".  Requests are signed with AWS Signature
+// Version 4, with the first credentials found of those configured, those
+// in the environment, those in the shared credentials file, and those of
+// the instance's IAM role.
+type S3Sink struct {
+	bucket   string
+	prefix   string
+	host     string
+	region   string
+	endpoint string
+	client   *http.Client
+
+	// Credentials from the config, if any, and otherwise the last found
+	static       *s3Credentials
+	credentials  *s3Credentials
+	metadata_url string
+}
+
+// NewS3Sink returns a sink uploading to bucket, in region, at endpoint,
+// e.g. "https://storage.googleapis.com", or at S3 itself if endpoint is
+// "".  The credentials are used if access_key_id is set.
+func NewS3Sink(bucket string, prefix string, host string, region string, endpoint string,
+	access_key_id string, secret_access_key string) *S3Sink {
+	s := &S3Sink{
+		bucket:       bucket,
+		prefix:       prefix,
+		host:         host,
+		region:       region,
+		endpoint:     endpoint,
+		client:       &http.Client{Timeout: S3_TIMEOUT},
+		metadata_url: S3_METADATA_URL,
+	}
+	if access_key_id != "" {
+		s.static = &s3Credentials{AccessKeyID: access_key_id, SecretAccessKey: secret_access_key}
+	}
+	return s
+}
+
+func (s *S3Sink) Send(report *Report) error {
+	credentials, err := s.getCredentials(report.Time)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s%s/%s.json", s.prefix, s.host,
+		report.Time.UTC().Format(time.RFC3339))
+	body := []byte(report.JSON())
+	req, err := http.NewRequest("PUT", s.objectURL(key), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signS3Request(req, body, credentials, s.region, time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusForbidden && s.static == nil {
+			// ... maybe expired, so look for credentials again next time
+			s.credentials = nil
+		}
+		return fmt.Errorf("Object store responded %s: %s", resp.Status,
+			strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// objectURL returns the URL of an object: virtual-hosted style on S3, and
+// path style elsewhere, which every S3-compatible store supports.
+func (s *S3Sink) objectURL(key string) string {
+	path := "/" + s3Escape(key)
+	if s.endpoint == "" {
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com%s", s.bucket, s.region, path)
+	}
+	return strings.TrimRight(s.endpoint, "/") + "/" + s3Escape(s.bucket) + path
+}
+
+// getCredentials returns the configured credentials, or the first found in
+// the environment, the shared credentials file and instance metadata,
+// looking again once any that expire are about to.
+func (s *S3Sink) getCredentials(now time.Time) (*s3Credentials, error) {
+	if s.static != nil {
+		return s.static, nil
+	}
+	if c := s.credentials; c != nil &&
+		(c.Expiration.IsZero() || now.Add(S3_CREDENTIALS_REFRESH).Before(c.Expiration)) {
+		return c, nil
+	}
+	if c := s3EnvCredentials(); c != nil {
+		s.credentials = c
+	} else if c, err := s3SharedCredentials(); err != nil {
+		return nil, err
+	} else if c != nil {
+		s.credentials = c
+	} else if c, err := s3MetadataCredentials(s.metadata_url); err != nil {
+		return nil, fmt.Errorf("No object store credentials found: %v", err)
+	} else {
+		s.credentials = c
+	}
+	return s.credentials, nil
+}
+
+// s3EnvCredentials returns credentials from the standard AWS environment
+// variables, if set.
+func s3EnvCredentials() *s3Credentials {
+	if os.Getenv("AWS_ACCESS_KEY_ID") == "" {
+		return nil
+	}
+	return &s3Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// s3SharedCredentials returns credentials from the AWS_PROFILE (or
+// "default") profile of the shared credentials file,
+// AWS_SHARED_CREDENTIALS_FILE (or ~/.aws/credentials), if it has them.
+func s3SharedCredentials() (*s3Credentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		path = filepath.Join(os.Getenv("HOME"), ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+		} else if i := strings.Index(line, "="); i > 0 && section == profile {
+			values[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	} else if values["aws_access_key_id"] == "" {
+		return nil, nil
+	}
+	return &s3Credentials{
+		AccessKeyID:     values["aws_access_key_id"],
+		SecretAccessKey: values["aws_secret_access_key"],
+		SessionToken:    values["aws_session_token"],
+	}, nil
+}
+
+// s3MetadataCredentials returns the credentials of the instance's IAM
+// role, from the EC2 instance metadata service at base_url, using a
+// session token (IMDSv2).
+func s3MetadataCredentials(base_url string) (*s3Credentials, error) {
+	client := &http.Client{Timeout: S3_METADATA_TIMEOUT}
+	request := func(method string, path string, header string, value string) ([]byte, error) {
+		req, err := http.NewRequest(method, base_url+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(header, value)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Instance metadata responded %s", resp.Status)
+		}
+		return ioutil.ReadAll(resp.Body)
+	}
+
+	token, err := request("PUT", "/latest/api/token", "X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	if err != nil {
+		return nil, err
+	}
+	const path = "/latest/meta-data/iam/security-credentials/"
+	roles, err := request("GET", path, "X-aws-ec2-metadata-token", string(token))
+	if err != nil {
+		return nil, err
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if role == "" {
+		return nil, errors.New("Instance has no IAM role")
+	}
+	data, err := request("GET", path+role, "X-aws-ec2-metadata-token", string(token))
+	if err != nil {
+		return nil, err
+	}
+	var c struct {
+		AccessKeyId     string
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &s3Credentials{c.AccessKeyId, c.SecretAccessKey, c.Token, c.Expiration}, nil
+}
+
+// signS3Request signs a request to S3 with AWS Signature Version 4,
+// covering the host, the body, and every header already set.
+func signS3Request(req *http.Request, body []byte, c *s3Credentials, region string, now time.Time) {
+	timestamp := now.UTC().Format(S3_TIME_FORMAT)
+	date := timestamp[:8]
+	payload_hash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", timestamp)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload_hash[:]))
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonical_headers := ""
+	for _, name := range names {
+		canonical_headers += name + ":" + headers[name] + "\n"
+	}
+	signed_headers := strings.Join(names, ";")
+
+	canonical_request := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonical_headers,
+		signed_headers,
+		hex.EncodeToString(payload_hash[:]),
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	request_hash := sha256.Sum256([]byte(canonical_request))
+	string_to_sign := "AWS4-HMAC-SHA256\n" + timestamp + "\n" + scope + "\n" +
+		hex.EncodeToString(request_hash[:])
+
+	key := []byte("AWS4" + c.SecretAccessKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, string_to_sign))
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKeyID, scope, signed_headers, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3CanonicalQuery returns a query string sorted and escaped as Signature
+// Version 4 needs.
+func s3CanonicalQuery(query url.Values) string {
+	params := []string{}
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, s3Escape(name)+"="+s3Escape(value))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// s3Escape percent-encodes everything but unreserved characters and
+// slashes, as S3 expects of object keys.
+func s3Escape(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
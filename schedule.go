@@ -0,0 +1,41 @@
+package main
+
+import (
+	"time"
+)
+
+// Schedule is when reports are due: every interval from when it started,
+// however long each report takes, so that intervals don't drift.
+type Schedule struct {
+	interval time.Duration
+	due      time.Time
+}
+
+func NewSchedule(start time.Time, interval time.Duration) *Schedule {
+	return &Schedule{interval: interval, due: start.Add(interval)}
+}
+
+// Due returns when the next report is due.
+func (s *Schedule) Due() time.Time {
+	return s.due
+}
+
+// SetInterval changes the interval, starting with the report due next,
+// which becomes due an interval after the last.
+func (s *Schedule) SetInterval(interval time.Duration) {
+	s.due = s.due.Add(interval - s.interval)
+	s.interval = interval
+}
+
+// Advance moves on to the next report due after now, once the last one is
+// done, returning how many were skipped over because the last one overran
+// into their intervals.
+func (s *Schedule) Advance(now time.Time) int {
+	s.due = s.due.Add(s.interval)
+	if s.due.After(now) {
+		return 0
+	}
+	overruns := int(now.Sub(s.due)/s.interval) + 1
+	s.due = s.due.Add(time.Duration(overruns) * s.interval)
+	return overruns
+}
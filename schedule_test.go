@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	schedule := NewSchedule(start, 5*time.Second)
+
+	/* A report that finishes in time keeps to the schedule, however late it
+	 * started */
+	if overruns := schedule.Advance(start.Add(9 * time.Second)); overruns != 0 {
+		t.Errorf("Expected no overruns, got %d\n", overruns)
+	}
+	if due := schedule.Due(); !due.Equal(start.Add(10 * time.Second)) {
+		t.Errorf("Expected the next report due at 10s, got %v\n", due.Sub(start))
+	}
+
+	/* One that overruns into the next two intervals skips their reports */
+	if overruns := schedule.Advance(start.Add(21 * time.Second)); overruns != 2 {
+		t.Errorf("Expected 2 overruns, got %d\n", overruns)
+	}
+	if due := schedule.Due(); !due.Equal(start.Add(25 * time.Second)) {
+		t.Errorf("Expected the next report due at 25s, got %v\n", due.Sub(start))
+	}
+
+	/* A shorter interval brings the next report forward, from the last */
+	schedule.SetInterval(2 * time.Second)
+	if due := schedule.Due(); !due.Equal(start.Add(22 * time.Second)) {
+		t.Errorf("Expected the next report due at 22s, got %v\n", due.Sub(start))
+	}
+}
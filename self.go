@@ -0,0 +1,62 @@
+package main
+
+import (
+	"runtime"
+)
+
+// CaptureCounter counts the packets the capture has received, and dropped
+// for want of buffer space, since it was last asked.
+type CaptureCounter struct {
+	// Returns the totals received and dropped since capture started
+	totals func() (int, int, error)
+
+	received int
+	dropped  int
+}
+
+func NewCaptureCounter(totals func() (int, int, error)) *CaptureCounter {
+	return &CaptureCounter{totals: totals}
+}
+
+// Count returns the packets received and dropped since it was last called.
+func (c *CaptureCounter) Count() (int, int, error) {
+	received, dropped, err := c.totals()
+	if err != nil {
+		return 0, 0, err
+	}
+	new_received, new_dropped := received-c.received, dropped-c.dropped
+	c.received, c.dropped = received, dropped
+	return new_received, new_dropped, nil
+}
+
+// formatSelf adds mcsauna's own health: what it has counted about itself
+// over the interval, e.g. packets processed, along with the packets
+// captured and dropped, parse errors, the number of distinct keys being
+// counted, goroutines, and memory in use.  Comparing packets captured,
+// dropped and processed tells traffic stopping apart from mcsauna falling
+// behind.  The version and commit running are reported as labels on a
+// build metric of 1, to line changes in behaviour up with deploys.
+func formatSelf(report *Report, name MetricName, rotated *Stats, capture *CaptureCounter) {
+	if capture != nil {
+		if received, dropped, err := capture.Count(); err == nil {
+			rotated.Self.AddN("packets_captured", received)
+			rotated.Self.AddN("packets_dropped", dropped)
+		} else {
+			logger.Warn("Error getting capture stats", "error", err)
+		}
+	}
+	parse_errors := 0
+	for _, err := range popTopKeys(rotated.Errors.GetTopKeys(), -1, 0) {
+		parse_errors += err.Hits
+	}
+	rotated.Self.AddN("parse_errors", parse_errors)
+	formatTopKeys(report, name, "", rotated.Self.GetTopKeys(), -1, 0)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	report.Gauge(name.Append("keys"), float64(rotated.HotKeys.GetTopKeys().Len()))
+	report.Gauge(name.Append("goroutines"), float64(runtime.NumGoroutine()))
+	report.Gauge(name.Append("heap_bytes"), float64(mem.HeapAlloc))
+	report.Gauge(name.Append("sys_bytes"), float64(mem.Sys))
+	report.Gauge(name.Append("build").Label("version", VERSION).Label("commit", COMMIT), 1)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatSelf(t *testing.T) {
+	config, _ := NewConfig([]byte{})
+	stats := NewStats(config)
+	stats.Self.AddN("packets_processed", 90)
+	stats.Errors.AddN("truncated", 2)
+	stats.Errors.AddN("invalid_cmd", 1)
+	stats.HotKeys.Add([]string{"foo", "bar", "foo"})
+
+	/* The capture's totals keep growing, but only what's new is counted */
+	totals := [][2]int{{50, 1}, {150, 11}}
+	capture := NewCaptureCounter(func() (int, int, error) {
+		received, dropped := totals[0][0], totals[0][1]
+		totals = totals[1:]
+		return received, dropped, nil
+	})
+	capture.Count()
+
+	report := NewReport(time.Now())
+	formatSelf(report, NewMetricName("mcsauna.self"), stats.Rotate(), capture)
+	output := report.String()
+	for _, line := range []string{
+		"mcsauna.self.packets_captured 100\n",
+		"mcsauna.self.packets_processed 90\n",
+		"mcsauna.self.packets_dropped 10\n",
+		"mcsauna.self.parse_errors 3\n",
+		"mcsauna.self.keys 2\n",
+		"mcsauna.self.build.dev.unknown 1\n",
+	} {
+		if !strings.Contains(output, line) {
+			t.Errorf("Expected %q in %q\n", line, output)
+		}
+	}
+	if !strings.Contains(output, "mcsauna.self.goroutines ") || !strings.Contains(output, "mcsauna.self.heap_bytes ") {
+		t.Errorf("Expected runtime stats in %q\n", output)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Sink is somewhere reports are sent, e.g. stdout or Graphite.
+type Sink interface {
+	Send(report *Report) error
+}
+
+// WriterSink writes each report, in the given format, to a writer.
+type WriterSink struct {
+	w      io.Writer
+	format *ReportFormat
+}
+
+func NewWriterSink(w io.Writer, format *ReportFormat) *WriterSink {
+	return &WriterSink{w: w, format: format}
+}
+
+func (s *WriterSink) Send(report *Report) error {
+	output, err := s.format.Format(report)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(s.w, output)
+	return err
+}
+
+// FilteredSink sends a sink only the metrics in each report that keep
+// returns true for.
+type FilteredSink struct {
+	sink Sink
+	keep func(m *Metric) bool
+}
+
+func NewFilteredSink(sink Sink, keep func(m *Metric) bool) *FilteredSink {
+	return &FilteredSink{sink: sink, keep: keep}
+}
+
+func (s *FilteredSink) Send(report *Report) error {
+	filtered := &Report{Time: report.Time, Metrics: []*Metric{}, Alerts: report.Alerts}
+	for _, m := range report.Metrics {
+		if s.keep(m) {
+			filtered.Metrics = append(filtered.Metrics, m)
+		}
+	}
+	return s.sink.Send(filtered)
+}
+
+// Dropped passes on the count of reports dropped by the sink, if it counts
+// them.
+func (s *FilteredSink) Dropped() int {
+	if dropping, ok := s.sink.(droppingSink); ok {
+		return dropping.Dropped()
+	}
+	return 0
+}
+
+// Close passes on closing the sink, if it needs closing.
+func (s *FilteredSink) Close() error {
+	if closing, ok := s.sink.(closingSink); ok {
+		return closing.Close()
+	}
+	return nil
+}
+
+// Sinks sends each report to any number of sinks, each in its own
+// goroutine, so that one sink failing, or being slow, doesn't hold up the
+// others.  A sink still sending the last report when the next is ready
+// misses the next one.
+//
+// Errors are logged, and counted in self-metrics as "<name>_errors", and
+// reports missed as "<name>_dropped", along with any dropped by the sink
+// itself, if it counts them.
+type Sinks struct {
+	workers []*sinkWorker
+	self    *HotKeyPool
+
+	// Metrics not sent to sinks other than those named in exclude_except
+	exclude        func(m *Metric) bool
+	exclude_except map[string]bool
+}
+
+type sinkWorker struct {
+	name    string
+	sink    Sink
+	reports chan *Report
+
+	// Closed once the worker has stopped, after a flush
+	done chan bool
+}
+
+// droppingSink is a sink that drops reports, e.g. from a full buffer, and
+// counts them.
+type droppingSink interface {
+	Dropped() int
+}
+
+// closingSink is a sink with something to do after the last report, e.g.
+// sending reports it has been holding on to.
+type closingSink interface {
+	Close() error
+}
+
+func NewSinks(self *HotKeyPool) *Sinks {
+	return &Sinks{self: self}
+}
+
+// Exclude stops metrics that exclude returns true for being sent to sinks
+// added afterwards, other than those named in except.
+func (s *Sinks) Exclude(exclude func(m *Metric) bool, except []string) {
+	s.exclude = exclude
+	s.exclude_except = map[string]bool{}
+	for _, name := range except {
+		s.exclude_except[name] = true
+	}
+}
+
+// Add starts sending reports to sink, reporting on it under name.
+func (s *Sinks) Add(name string, sink Sink) {
+	if exclude := s.exclude; exclude != nil && !s.exclude_except[name] {
+		sink = NewFilteredSink(sink, func(m *Metric) bool { return !exclude(m) })
+	}
+	worker := &sinkWorker{
+		name:    name,
+		sink:    sink,
+		reports: make(chan *Report, 1),
+		done:    make(chan bool),
+	}
+	s.workers = append(s.workers, worker)
+	go s.run(worker)
+}
+
+// Send queues a report for every sink, without waiting for any of them.
+func (s *Sinks) Send(report *Report) {
+	for _, worker := range s.workers {
+		select {
+		case worker.reports <- report:
+		default:
+			logger.Debug("Dropped report", "output", worker.name)
+			s.self.AddN(worker.name+"_dropped", 1)
+		}
+	}
+}
+
+// Flush sends a last report to every sink, waiting for any still sending
+// the one before, then waits for them all to finish sending it, and to
+// close if they need closing.  Sinks that haven't finished within timeout are given up on.  No more reports
+// can be sent afterwards.
+func (s *Sinks) Flush(report *Report, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for _, worker := range s.workers {
+		select {
+		case worker.reports <- report:
+		case <-time.After(deadline.Sub(time.Now())):
+			logger.Warn("Timed out flushing last report", "output", worker.name)
+		}
+		close(worker.reports)
+	}
+	s.wait(deadline)
+}
+
+// Close waits for every sink to finish sending any report it's on, and to
+// close if it needs closing, without sending another, e.g. to replace the
+// sinks.  As with Flush, no more reports can be sent afterwards.
+func (s *Sinks) Close(timeout time.Duration) {
+	for _, worker := range s.workers {
+		close(worker.reports)
+	}
+	s.wait(time.Now().Add(timeout))
+}
+
+// wait waits, until deadline, for every worker to stop.
+func (s *Sinks) wait(deadline time.Time) {
+	for _, worker := range s.workers {
+		select {
+		case <-worker.done:
+		case <-time.After(deadline.Sub(time.Now())):
+			logger.Warn("Timed out flushing last report", "output", worker.name)
+		}
+	}
+}
+
+func (s *Sinks) run(worker *sinkWorker) {
+	defer close(worker.done)
+	for report := range worker.reports {
+		if err := sendRecovered(worker.sink, report); err != nil {
+			logger.Error("Error sending report", "output", worker.name, "error", err)
+			s.self.AddN(worker.name+"_errors", 1)
+		}
+		if dropping, ok := worker.sink.(droppingSink); ok {
+			s.self.AddN(worker.name+"_dropped", dropping.Dropped())
+		}
+	}
+	if closing, ok := worker.sink.(closingSink); ok {
+		if err := closing.Close(); err != nil {
+			logger.Error("Error closing output", "output", worker.name, "error", err)
+		}
+	}
+}
+
+// sendRecovered sends a report to a sink, turning a panic into an error.
+func sendRecovered(sink Sink, report *Report) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return sink.Send(report)
+}
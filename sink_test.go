@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testSink sends each report it's sent to reports, after signalling
+// started and waiting for release if set, then panics with err.
+type testSink struct {
+	reports chan *Report
+	started chan bool
+	release chan bool
+	err     error
+}
+
+func newTestSink(err error) *testSink {
+	return &testSink{
+		reports: make(chan *Report, 10),
+		started: make(chan bool, 10),
+		err:     err,
+	}
+}
+
+func (s *testSink) Send(report *Report) error {
+	s.started <- true
+	if s.release != nil {
+		<-s.release
+	}
+	s.reports <- report
+	if s.err != nil {
+		panic(s.err)
+	}
+	return s.err
+}
+
+func TestSinksIndependent(t *testing.T) {
+	self := NewHotKeyPool()
+	sinks := NewSinks(self)
+	failing := newTestSink(errors.New("down"))
+	blocked := newTestSink(nil)
+	blocked.release = make(chan bool)
+	working := newTestSink(nil)
+	sinks.Add("failing", failing)
+	sinks.Add("blocked", blocked)
+	sinks.Add("working", working)
+
+	/* The blocked sink is still sending the first report when the next
+	 * two are ready, so should miss the third */
+	reports := []*Report{NewReport(time.Now()), NewReport(time.Now()), NewReport(time.Now())}
+	for i, report := range reports {
+		sinks.Send(report)
+		if i == 0 {
+			<-blocked.started
+		}
+		<-failing.reports
+		if received := <-working.reports; received != report {
+			t.Errorf("Expected working sink to receive every report\n")
+		}
+	}
+	blocked.release <- true
+	blocked.release <- true
+	if <-blocked.reports != reports[0] || <-blocked.reports != reports[1] {
+		t.Errorf("Expected blocked sink to receive the first two reports\n")
+	}
+
+	if hits := self.GetHits("blocked_dropped"); hits != 1 {
+		t.Errorf("Expected 1 report dropped by blocked sink, got %d\n", hits)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for self.GetHits("failing_errors") != 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if hits := self.GetHits("failing_errors"); hits != 3 {
+		t.Errorf("Expected 3 errors from failing sink, got %d\n", hits)
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	report := NewReport(time.Now())
+	report.Count(NewMetricName("mcsauna.keys").Label("key", "foo"), 3)
+	if err := NewWriterSink(&buf, &ReportFormat{name: "text"}).Send(report); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != report.String() {
+		t.Errorf("Expected %q, got %q\n", report.String(), buf.String())
+	}
+}
+
+func TestSinksExclude(t *testing.T) {
+	sinks := NewSinks(NewHotKeyPool())
+	is_error := errorMetrics("mcsauna")
+	errors_only := newTestSink(nil)
+	sinks.Add("errors_file", NewFilteredSink(errors_only, is_error))
+	sinks.Exclude(is_error, []string{"stdout"})
+	stdout := newTestSink(nil)
+	graphite := newTestSink(nil)
+	sinks.Add("stdout", stdout)
+	sinks.Add("graphite", graphite)
+
+	report := NewReport(time.Now())
+	report.Count(NewMetricName("mcsauna.keys").Label("key", "foo"), 3)
+	report.Count(NewMetricName("mcsauna.errors.truncated"), 1)
+	report.Count(NewMetricName("mcsauna.tolerated.bad_crlf"), 2)
+	sinks.Send(report)
+
+	expected := map[*testSink]int{errors_only: 2, stdout: 3, graphite: 1}
+	for sink, metrics := range expected {
+		if received := <-sink.reports; len(received.Metrics) != metrics {
+			t.Errorf("Expected %d metrics, got %q\n", metrics, received.String())
+		}
+	}
+}
+
+func TestSinksFlush(t *testing.T) {
+	sinks := NewSinks(NewHotKeyPool())
+	blocked := newTestSink(nil)
+	blocked.release = make(chan bool)
+	sinks.Add("blocked", blocked)
+
+	/* The last report isn't dropped, even though the sink is still
+	 * sending the one before */
+	first, last := NewReport(time.Now()), NewReport(time.Now())
+	sinks.Send(first)
+	<-blocked.started
+	go func() {
+		blocked.release <- true
+		blocked.release <- true
+	}()
+	sinks.Flush(last, 5*time.Second)
+	if <-blocked.reports != first || <-blocked.reports != last {
+		t.Errorf("Expected both reports to be sent\n")
+	}
+}
+
+// closedSink records when it's closed.
+type closedSink struct {
+	*testSink
+	closed bool
+}
+
+func (s *closedSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestSinksClose(t *testing.T) {
+	sinks := NewSinks(NewHotKeyPool())
+	sink := &closedSink{testSink: newTestSink(nil)}
+	sinks.Add("closed", sink)
+
+	/* The report being sent is finished, but no other is sent */
+	report := NewReport(time.Now())
+	sinks.Send(report)
+	sinks.Close(5 * time.Second)
+	if len(sink.reports) != 1 || <-sink.reports != report {
+		t.Errorf("Expected only the report sent to be sent\n")
+	}
+	if !sink.closed {
+		t.Errorf("Expected the sink to be closed\n")
+	}
+}
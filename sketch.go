@@ -0,0 +1,119 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+)
+
+// countMinCounter estimates hit counts with a count-min sketch, which uses
+// the same amount of memory however many distinct keys are seen.  Estimates
+// are never too low, and are too high by at most a small fraction of the
+// total hits, with high probability.
+//
+// A sketch can't list the keys it has counted, so the keys with the highest
+// estimates are kept alongside it as candidates for reporting.
+type countMinCounter struct {
+	width uint32
+	table [][]uint32
+
+	// Min-heap of candidates, so the coldest can be replaced by a hotter key
+	candidates     candidateHeap
+	candidate_keys map[string]*candidate
+	max_candidates int
+}
+
+type candidate struct {
+	key   string
+	hits  int
+	index int
+}
+
+type candidateHeap []*candidate
+
+func (h candidateHeap) Len() int { return len(h) }
+
+func (h candidateHeap) Less(i, j int) bool { return h[i].hits < h[j].hits }
+
+func (h candidateHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *candidateHeap) Push(x interface{}) {
+	c := x.(*candidate)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *candidateHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
+
+func newCountMinCounter(width int, depth int, max_candidates int) *countMinCounter {
+	c := &countMinCounter{
+		width:          uint32(width),
+		table:          make([][]uint32, depth),
+		candidate_keys: make(map[string]*candidate),
+		max_candidates: max_candidates,
+	}
+	for i := range c.table {
+		c.table[i] = make([]uint32, width)
+	}
+	return c
+}
+
+func (c *countMinCounter) Incr(key string, n int) {
+	h1, h2 := keyHashes(key)
+	estimate := uint32(math.MaxUint32)
+	for i, row := range c.table {
+		j := (h1 + uint32(i)*h2) % c.width
+		row[j] += uint32(n)
+		if row[j] < estimate {
+			estimate = row[j]
+		}
+	}
+	hits := int(estimate)
+
+	if cand, ok := c.candidate_keys[key]; ok {
+		cand.hits = hits
+		heap.Fix(&c.candidates, cand.index)
+	} else if len(c.candidates) < c.max_candidates {
+		cand := &candidate{key: key, hits: hits}
+		heap.Push(&c.candidates, cand)
+		c.candidate_keys[key] = cand
+	} else if len(c.candidates) > 0 && hits > c.candidates[0].hits {
+		coldest := c.candidates[0]
+		delete(c.candidate_keys, coldest.key)
+		coldest.key, coldest.hits = key, hits
+		c.candidate_keys[key] = coldest
+		heap.Fix(&c.candidates, 0)
+	}
+}
+
+func (c *countMinCounter) Hits(key string) int {
+	if cand, ok := c.candidate_keys[key]; ok {
+		return cand.hits
+	}
+	h1, h2 := keyHashes(key)
+	estimate := uint32(math.MaxUint32)
+	for i, row := range c.table {
+		if hits := row[(h1+uint32(i)*h2)%c.width]; hits < estimate {
+			estimate = hits
+		}
+	}
+	return int(estimate)
+}
+
+// Keys returns the candidates only.
+func (c *countMinCounter) Keys() []*Key {
+	keys := make([]*Key, 0, len(c.candidates))
+	for _, cand := range c.candidates {
+		keys = append(keys, &Key{cand.key, cand.hits})
+	}
+	return keys
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"testing"
+)
+
+func TestCountMinCounter(t *testing.T) {
+	h := newHotKeyPool(func() keyCounter { return newCountMinCounter(1024, 4, 3) }, 1)
+	for i := 0; i < 100; i++ {
+		h.Add([]string{"foo", "foo", "foo", "bar", "bar", fmt.Sprintf("noise_%d", i)})
+	}
+	h.AddN("baz", 50)
+
+	top_keys := h.GetTopKeys()
+	if top_keys.Len() != 3 {
+		t.Fatalf("Expected 3 candidates, got %d\n", top_keys.Len())
+	}
+	expected_keys := []Key{
+		Key{"foo", 300},
+		Key{"bar", 200},
+		Key{"baz", 50},
+	}
+	for _, key := range expected_keys {
+		popped_key := heap.Pop(top_keys).(*Key)
+		if key.Name != popped_key.Name {
+			t.Errorf("Expected top key %v, got %v\n", key.Name, popped_key.Name)
+		}
+		if popped_key.Hits < key.Hits || popped_key.Hits > key.Hits+10 {
+			t.Errorf("Expected key %s to have about %d hits, got %d\n",
+				key.Name, key.Hits, popped_key.Hits)
+		}
+	}
+
+	// ... keys that aren't candidates are still estimated
+	if hits := h.GetHits("noise_1"); hits < 1 {
+		t.Errorf("Expected at least 1 hit for noise_1, got %d\n", hits)
+	}
+
+	rotated := h.Rotate()
+	if hits := h.GetHits("foo"); hits != 0 {
+		t.Errorf("Expected rotated pool to be cleared, got %d hits for foo\n", hits)
+	}
+	if hits := rotated.GetHits("foo"); hits < 300 {
+		t.Errorf("Expected at least 300 hits for foo after rotation, got %d\n", hits)
+	}
+}
+
+func TestCountMinConfig(t *testing.T) {
+	config, err := NewConfig([]byte(`{"counter": "count_min", "sketch_candidates": 2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := NewStats(config)
+	stats.HotKeys.Add([]string{"foo", "bar", "baz", "baz"})
+	if n := stats.HotKeys.GetTopKeys().Len(); n != 2 {
+		t.Errorf("Expected 2 candidates, got %d\n", n)
+	}
+
+	/* Shards share the width, and a sketch is only made once counted in */
+	config, _ = NewConfig([]byte(`{"counter": "count_min", "sketch_width": 1000, "hot_key_shards": 3}`))
+	stats = NewStats(config)
+	stats.HotKeys.Add([]string{"foo"})
+	for _, shard := range stats.KeyBytes.shards {
+		if shard.counter != nil {
+			t.Errorf("Expected no sketch for bytes by key, unranked\n")
+		}
+	}
+	width := 0
+	for _, shard := range stats.HotKeys.shards {
+		if shard.counter != nil {
+			width += int(shard.counter.(*countMinCounter).width)
+		}
+	}
+	if width != 334 {
+		t.Errorf("Expected one shard's sketch, a third of the width, got a width of %d\n", width)
+	}
+	if rotated := stats.Rotate(); rotated.HotKeys.GetHits("foo") != 1 || stats.HotKeys.shards[0].counter != nil {
+		t.Errorf("Expected rotating to hand over the sketch, and not make another\n")
+	}
+
+	_, err = NewConfig([]byte(`{"counter": "count_min", "sketch_width": 0}`))
+	if err == nil {
+		t.Errorf("Expected error for zero sketch width\n")
+	}
+	_, err = NewConfig([]byte(`{"counter": "approximate"}`))
+	if err == nil {
+		t.Errorf("Expected error for unknown counter\n")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"strconv"
+	"time"
+)
+
+// Sniffer looks for memcached commands in TCP traffic on any port, for
+// generate-config to suggest a config from: the port they are sent to, and
+// regexps for the namespaces of the keys in them.
+type Sniffer struct {
+	// Commands parsed, by the port they were sent to
+	Ports *HotKeyPool
+
+	Namespaces *NamespaceTree
+}
+
+func NewSniffer() *Sniffer {
+	return &Sniffer{Ports: NewHotKeyPool(), Namespaces: NewNamespaceTree()}
+}
+
+// Add counts the commands at the start of a packet's payload, up to the
+// first that can't be parsed.  Segments aren't reassembled, so commands
+// split across them are missed, which doesn't matter for suggestions.
+func (s *Sniffer) Add(packet gopacket.Packet) {
+	tcp, ok := packet.TransportLayer().(*layers.TCP)
+	app_data := packet.ApplicationLayer()
+	if !ok || app_data == nil {
+		return
+	}
+	port := strconv.Itoa(int(tcp.DstPort))
+	payload := app_data.Payload()
+	for len(payload) > 0 {
+		var (
+			keys     []string
+			consumed int
+			cmd_err  int
+		)
+		// ... responses, and other protocols, don't parse as commands
+		if payload[0] == BINARY_REQUEST_MAGIC {
+			_, keys, consumed, cmd_err = parseBinaryCommand(payload)
+		} else {
+			_, keys, consumed, cmd_err = parseCommand(payload)
+		}
+		if cmd_err != ERR_NONE || consumed <= 0 || consumed > len(payload) {
+			return
+		}
+		payload = payload[consumed:]
+		s.Ports.AddN(port, 1)
+		s.Namespaces.Add(keys)
+	}
+}
+
+// Commands returns the number of commands found.
+func (s *Sniffer) Commands() int {
+	commands := 0
+	for _, port := range popTopKeys(s.Ports.GetTopKeys(), -1, 0) {
+		commands += port.Hits
+	}
+	return commands
+}
+
+// Port returns the port the most commands were sent to, or false if none
+// were found.
+func (s *Sniffer) Port() (int, bool) {
+	ports := popTopKeys(s.Ports.GetTopKeys(), 1, 0)
+	if len(ports) == 0 {
+		return 0, false
+	}
+	port, _ := strconv.Atoi(ports[0].Name)
+	return port, true
+}
+
+// Regexps returns regexps for up to limit of the busiest namespaces.
+func (s *Sniffer) Regexps(limit int) []RegexpConfig {
+	regexps := []RegexpConfig{}
+	for _, namespace := range popTopKeys(s.Namespaces.GetTopNamespaces(), limit, 0) {
+		regexps = append(regexps, RegexpConfig{
+			Name: namespaceName(namespace.Name),
+			Re:   namespaceRegexp(namespace.Name),
+		})
+	}
+	return regexps
+}
+
+// sniffTraffic captures all TCP traffic on iface for duration, looking for
+// memcached commands in it.
+func sniffTraffic(iface string, duration time.Duration) (*Sniffer, error) {
+	if err := checkCaptureCapabilities(); err != nil {
+		return nil, err
+	}
+	// ... reads time out, so that the handle can be closed promptly
+	handle, err := pcap.OpenLive(iface, CAPTURE_SIZE, true, time.Second)
+	if err != nil {
+		return nil, captureError(iface, err)
+	}
+	defer handle.Close()
+	if err := handle.SetBPFFilter("tcp"); err != nil {
+		return nil, exitError(EXIT_CAPTURE, "can't set the capture filter 'tcp': %w", err)
+	}
+
+	sniffer := NewSniffer()
+	packets := gopacket.NewPacketSource(handle, handle.LinkType()).Packets()
+	deadline := time.After(duration)
+	for {
+		select {
+		case packet, ok := <-packets:
+			if !ok {
+				return sniffer, nil
+			}
+			sniffer.Add(packet)
+		case <-deadline:
+			return sniffer, nil
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSniffer(t *testing.T) {
+	sniffer := NewSniffer()
+	now := time.Now()
+	for i, payload := range []string{
+		"get user:1:profile user:2:profile\r\n",
+		"get user:3:profile\r\n",
+		"VALUE user:3:profile 0 1\r\nx\r\nEND\r\n",
+		"GET / HTTP/1.1\r\n",
+	} {
+		sniffer.Add(testPacket(t, 40000+i, 11212, 1, []byte(payload), now))
+	}
+	sniffer.Add(testPacket(t, 40000, 6379, 1, []byte("get session:1\r\n"), now))
+
+	if commands := sniffer.Commands(); commands != 3 {
+		t.Errorf("Expected 3 commands, got %d\n", commands)
+	}
+	if port, ok := sniffer.Port(); !ok || port != 11212 {
+		t.Errorf("Expected port 11212, got %d %v\n", port, ok)
+	}
+	expected := []RegexpConfig{{Name: namespaceName("user:*:profile"), Re: namespaceRegexp("user:*:profile")}}
+	if regexps := sniffer.Regexps(1); !reflect.DeepEqual(regexps, expected) {
+		t.Errorf("Expected %v, got %v\n", expected, regexps)
+	}
+
+	if _, ok := NewSniffer().Port(); ok {
+		t.Errorf("Expected no port without commands\n")
+	}
+}
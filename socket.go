@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Clients that can't take a report within this long are disconnected
+const SOCKET_WRITE_TIMEOUT = time.Second
+
+// SocketSink streams reports, in the output format, to every client
+// connected to a Unix socket, from when they connect until they disconnect.
+type SocketSink struct {
+	Lock sync.Mutex
+
+	format  *ReportFormat
+	clients map[net.Conn]bool
+}
+
+func NewSocketSink(format *ReportFormat) *SocketSink {
+	return &SocketSink{format: format, clients: map[net.Conn]bool{}}
+}
+
+// ListenAndServe listens on a Unix socket at path, replacing any left
+// behind by a previous run, and accepts clients until it fails.
+func (s *SocketSink) ListenAndServe(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts clients from listener until it fails.
+func (s *SocketSink) Serve(listener net.Listener) error {
+	defer listener.Close()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		s.Lock.Lock()
+		s.clients[conn] = true
+		s.Lock.Unlock()
+	}
+}
+
+// Send writes a report to every client, disconnecting any that fail to
+// take it in time.
+func (s *SocketSink) Send(report *Report) error {
+	output, err := s.format.Format(report)
+	if err != nil {
+		return err
+	}
+
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+	for conn := range s.clients {
+		conn.SetWriteDeadline(time.Now().Add(SOCKET_WRITE_TIMEOUT))
+		if _, err := conn.Write([]byte(output)); err != nil {
+			conn.Close()
+			delete(s.clients, conn)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSocketSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcsauna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mcsauna.sock")
+
+	sink := NewSocketSink(&ReportFormat{name: "text"})
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go sink.Serve(listener)
+	defer listener.Close()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		sink.Lock.Lock()
+		connected := len(sink.clients)
+		sink.Lock.Unlock()
+		if connected == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, hits := range []int{1, 2} {
+		if err := sink.Send(testReport(time.Now(), hits)); err != nil {
+			t.Fatal(err)
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := testReport(time.Now(), hits).String(); line != expected {
+			t.Errorf("Expected %q, got %q\n", expected, line)
+		}
+	}
+
+	/* A client that has gone away is dropped */
+	conn.Close()
+	for i := 0; i < 10 && len(sink.clients) > 0; i++ {
+		sink.Send(testReport(time.Now(), 3))
+	}
+	if len(sink.clients) != 0 {
+		t.Errorf("Expected the disconnected client to be dropped\n")
+	}
+}
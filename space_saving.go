@@ -0,0 +1,62 @@
+package main
+
+import (
+	"container/heap"
+)
+
+// spaceSavingCounter counts hits with the Space-Saving algorithm, which
+// keeps a fixed number of counters.  When a key without a counter is seen
+// and none are free, it takes over the counter with the fewest hits,
+// inheriting its count.
+//
+// Counts are never too low, and are too high by at most the count the key
+// inherited.  Any key with more hits than total hits / number of counters
+// is guaranteed to have a counter.
+type spaceSavingCounter struct {
+	// Min-heap of counters, so the coldest can be taken over
+	counters     candidateHeap
+	counter_keys map[string]*candidate
+	max_counters int
+}
+
+func newSpaceSavingCounter(max_counters int) *spaceSavingCounter {
+	return &spaceSavingCounter{
+		counter_keys: make(map[string]*candidate),
+		max_counters: max_counters,
+	}
+}
+
+func (c *spaceSavingCounter) Incr(key string, n int) {
+	if cand, ok := c.counter_keys[key]; ok {
+		cand.hits += n
+		heap.Fix(&c.counters, cand.index)
+	} else if len(c.counters) < c.max_counters {
+		cand := &candidate{key: key, hits: n}
+		heap.Push(&c.counters, cand)
+		c.counter_keys[key] = cand
+	} else if len(c.counters) > 0 {
+		coldest := c.counters[0]
+		delete(c.counter_keys, coldest.key)
+		coldest.key = key
+		coldest.hits += n
+		c.counter_keys[key] = coldest
+		heap.Fix(&c.counters, 0)
+	}
+}
+
+// Hits returns 0 for keys without a counter, although they may have been
+// seen as many times as the coldest key that does have one.
+func (c *spaceSavingCounter) Hits(key string) int {
+	if cand, ok := c.counter_keys[key]; ok {
+		return cand.hits
+	}
+	return 0
+}
+
+func (c *spaceSavingCounter) Keys() []*Key {
+	keys := make([]*Key, 0, len(c.counters))
+	for _, cand := range c.counters {
+		keys = append(keys, &Key{cand.key, cand.hits})
+	}
+	return keys
+}
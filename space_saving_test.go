@@ -0,0 +1,55 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"testing"
+)
+
+func TestSpaceSavingCounter(t *testing.T) {
+	h := newHotKeyPool(func() keyCounter { return newSpaceSavingCounter(3) }, 1)
+	for i := 0; i < 100; i++ {
+		h.Add([]string{"foo", "foo", "foo", "bar", "bar", fmt.Sprintf("noise_%d", i)})
+	}
+
+	top_keys := h.GetTopKeys()
+	if top_keys.Len() != 3 {
+		t.Fatalf("Expected 3 counters, got %d\n", top_keys.Len())
+	}
+	for _, key := range []Key{Key{"foo", 300}, Key{"bar", 200}} {
+		popped_key := heap.Pop(top_keys).(*Key)
+		if key.Name != popped_key.Name {
+			t.Errorf("Expected top key %v, got %v\n", key.Name, popped_key.Name)
+		}
+		if key.Hits != popped_key.Hits {
+			t.Errorf("Expected key %s to have %d hits, got %d\n",
+				key.Name, key.Hits, popped_key.Hits)
+		}
+	}
+
+	// ... each noise key takes over the last counter from the one before
+	noise := heap.Pop(top_keys).(*Key)
+	if noise.Name != "noise_99" || noise.Hits != 100 {
+		t.Errorf("Expected noise_99 to have 100 hits, got %v %d\n", noise.Name, noise.Hits)
+	}
+	if hits := h.GetHits("noise_1"); hits != 0 {
+		t.Errorf("Expected no hits for forgotten key, got %d\n", hits)
+	}
+}
+
+func TestSpaceSavingConfig(t *testing.T) {
+	config, err := NewConfig([]byte(`{"counter": "space_saving", "space_saving_counters": 2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := NewStats(config)
+	stats.HotKeys.Add([]string{"foo", "bar", "baz", "baz"})
+	if n := stats.HotKeys.GetTopKeys().Len(); n != 2 {
+		t.Errorf("Expected 2 counters, got %d\n", n)
+	}
+
+	_, err = NewConfig([]byte(`{"counter": "space_saving", "space_saving_counters": -1}`))
+	if err == nil {
+		t.Errorf("Expected error for negative counters\n")
+	}
+}
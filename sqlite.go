@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// The database/sql driver used for SQLite, which is only linked in when
+// built with "-tags sqlite", as it needs cgo
+const SQLITE_DRIVER = "sqlite3"
+
+var SQLITE_SCHEMA = []string{
+	`CREATE TABLE IF NOT EXISTS metrics (
+		time INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		key TEXT NOT NULL,
+		command TEXT NOT NULL,
+		value REAL NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS metrics_time ON metrics (time)`,
+	`CREATE INDEX IF NOT EXISTS metrics_key ON metrics (key, time)`,
+}
+
+// SQLiteSink records every report in a SQLite database, a row per metric
+// of the time (in seconds since the epoch), the name of the metric, the key
+// and command it is for (if any) and its value, as in CSV output.  Rows
+// older than the retention period, if set, are deleted as reports are
+// added.
+type SQLiteSink struct {
+	path      string
+	retention time.Duration
+	db        *sql.DB
+}
+
+// NewSQLiteSink returns a sink recording reports in the database at path,
+// which is created if need be when the first report is sent.
+func NewSQLiteSink(path string, retention time.Duration) *SQLiteSink {
+	return &SQLiteSink{path: path, retention: retention}
+}
+
+// sqliteAvailable returns whether mcsauna was built with SQLite support.
+func sqliteAvailable() bool {
+	for _, driver := range sql.Drivers() {
+		if driver == SQLITE_DRIVER {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SQLiteSink) Send(report *Report) error {
+	if s.db == nil {
+		db, err := sql.Open(SQLITE_DRIVER, s.path)
+		if err != nil {
+			return err
+		}
+		for _, statement := range SQLITE_SCHEMA {
+			if _, err := db.Exec(statement); err != nil {
+				db.Close()
+				return err
+			}
+		}
+		s.db = db
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	insert, err := tx.Prepare(
+		"INSERT INTO metrics (time, name, key, command, value) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer insert.Close()
+	for _, row := range sqliteRows(report) {
+		if _, err := insert.Exec(row...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if s.retention > 0 {
+		_, err := tx.Exec("DELETE FROM metrics WHERE time < ?",
+			report.Time.Add(-s.retention).Unix())
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// sqliteRows returns the values to insert for each metric in a report.
+func sqliteRows(report *Report) [][]interface{} {
+	rows := make([][]interface{}, len(report.Metrics))
+	for i, m := range report.Metrics {
+		name, key, command := m.Name.SplitKeyCommand()
+		rows[i] = []interface{}{report.Time.Unix(), name.String(), key, command, m.Value}
+	}
+	return rows
+}
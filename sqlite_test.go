@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSQLiteRows(t *testing.T) {
+	report := NewReport(time.Unix(1500000000, 0))
+	report.Count(NewMetricName("mcsauna.commands").Label("command", "get").Append("keys").
+		Label("key", "foo"), 3)
+	report.Gauge(NewMetricName("mcsauna.distribution.gini"), 0.25)
+
+	expected := [][]interface{}{
+		{int64(1500000000), "mcsauna.commands.keys", "foo", "get", 3.0},
+		{int64(1500000000), "mcsauna.distribution.gini", "", "", 0.25},
+	}
+	if rows := sqliteRows(report); !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Expected %v, got %v\n", expected, rows)
+	}
+}
+
+func TestSQLiteConfig(t *testing.T) {
+	_, err := NewConfig([]byte(`{"sqlite_file": "/tmp/mcsauna.db"}`))
+	if sqliteAvailable() && err != nil {
+		t.Errorf("Unexpected error %v\n", err)
+	} else if !sqliteAvailable() && err == nil {
+		t.Errorf("Expected an error without SQLite support\n")
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"time"
+)
+
+// Stats holds everything the capture loop counts between reports.
+type Stats struct {
+	HotKeys   *HotKeyPool
+	Errors    *HotKeyPool
+	Tolerated *HotKeyPool
+
+	// Errors in parsing by client, by error.  This is only populated in
+	// errors-only mode.
+	ClientErrors *TaggedHotKeyPool
+
+	// Counters describing mcsauna itself, rather than the traffic
+	Self *HotKeyPool
+
+	// Commands parsed, by command.  This is only populated in dry-run mode.
+	Commands *HotKeyPool
+
+	// Hot keys for each configured proxy instance, by instance name
+	ProxyKeys *TaggedHotKeyPool
+
+	// Hot keys for each server, by address and port
+	ServerKeys *TaggedHotKeyPool
+
+	// Hot keys for each command reported in its own section, by command
+	CommandKeys *TaggedHotKeyPool
+
+	// Hot keys by class of command, i.e. reads, writes and deletes
+	ClassKeys *TaggedHotKeyPool
+
+	// Bytes on the wire by key, rather than hits.  This is only populated
+	// when ranking by bytes is enabled.
+	KeyBytes *HotKeyPool
+
+	// Keys matched by one regexp that another would also have matched, by
+	// "<winner>.<loser>".  This is only populated when reporting regexp
+	// conflicts is enabled.
+	RegexpConflicts *HotKeyPool
+
+	// Time spent matching keys, by regexp.  This is only populated when
+	// profiling regexps is enabled.
+	RegexpCost *LatencyPool
+
+	// Keys matched by each regexp, by regexp.  This is only populated when
+	// reporting regexp matches is enabled.
+	RegexpMatches *HotKeyPool
+
+	// Hits by key, in one-second buckets.  This is only populated when
+	// reporting burstiness is enabled.
+	Burst *BurstPool
+
+	// Number of get requests, by bucket of the number of keys in each.
+	// This is only populated when reporting fan-out is enabled.
+	Fanout *HotKeyPool
+
+	// Keys seen only once, overall and by regexp group.  This is only
+	// populated when reporting one-hit wonders is enabled.
+	OneHitWonders *OneHitWonders
+
+	// Totals of commands, keys, reads, writes, deletes and request bytes,
+	// and distinct keys, across all traffic.  These are only populated
+	// when reporting a summary is enabled.
+	Summary         *HotKeyPool
+	SummaryDistinct *CardinalityPool
+
+	// Distinct clients requesting each key.  This is only populated when
+	// reporting clients is enabled.
+	KeyClients *KeyClients
+
+	// Some of the raw keys counted under each regexp group or rolled up
+	// key.  This is only populated when key examples are enabled.
+	KeyExamples *KeyExamples
+
+	// Distinct keys seen, overall and by regexp group.  This is only
+	// populated when cardinality tracking is enabled.
+	Cardinality *CardinalityPool
+
+	// Keys grouped by structure.  This is only populated when namespace
+	// discovery is enabled.
+	Namespaces *NamespaceTree
+
+	// Request latencies, by command and by key.  These are only populated
+	// when latency tracking is enabled.
+	CommandLatency *LatencyPool
+	KeyLatency     *LatencyPool
+
+	// When set, hot keys are reported over a sliding window, and HotKeys
+	// only holds the bucket currently being counted.
+	Window *SlidingWindow
+
+	// When set, hot keys are reported by decayed score, and HotKeys only
+	// holds the hits since the scores were last updated.
+	Decayed *DecayedScores
+
+	// When set, hot keys, errors, tolerated deviations and self-metrics
+	// are accumulated here, rather than being reset each interval.
+	Totals *Stats
+
+	// Whether key pools have a memory limit, and so may evict keys
+	capped bool
+}
+
+func NewStats(config Config) *Stats {
+	new_counter := newCounterFunc(config)
+	stats := &Stats{
+		HotKeys:         newHotKeyPool(new_counter, config.HotKeyShards),
+		Errors:          NewHotKeyPool(),
+		Tolerated:       NewHotKeyPool(),
+		Self:            NewHotKeyPool(),
+		Commands:        NewHotKeyPool(),
+		ClientErrors:    NewTaggedHotKeyPool(),
+		ProxyKeys:       newTaggedHotKeyPool(new_counter, config.HotKeyShards),
+		ServerKeys:      newTaggedHotKeyPool(new_counter, config.HotKeyShards),
+		CommandKeys:     newTaggedHotKeyPool(new_counter, config.HotKeyShards),
+		ClassKeys:       newTaggedHotKeyPool(new_counter, config.HotKeyShards),
+		KeyBytes:        newHotKeyPool(new_counter, config.HotKeyShards),
+		RegexpConflicts: NewHotKeyPool(),
+		RegexpCost:      NewLatencyPool(),
+		RegexpMatches:   NewHotKeyPool(),
+		Burst:           NewBurstPool(),
+		Fanout:          NewHotKeyPool(),
+		OneHitWonders:   NewOneHitWonders(),
+		Summary:         NewHotKeyPool(),
+		SummaryDistinct: NewCardinalityPool(),
+		KeyClients:      NewKeyClients(),
+		KeyExamples:     NewKeyExamples(config.KeyExamples),
+		Cardinality:     NewCardinalityPool(),
+		Namespaces:      NewNamespaceTree(),
+		CommandLatency:  NewLatencyPool(),
+		KeyLatency:      NewLatencyPool(),
+	}
+	if config.SlidingWindow > 0 {
+		stats.Window = NewSlidingWindow(
+			config.SlidingWindow / config.SlidingWindowGranularity)
+	}
+	if config.DecayHalfLife > 0 {
+		stats.Decayed = NewDecayedScores(
+			time.Duration(config.DecayHalfLife)*time.Second, DECAY_TICK)
+	}
+	if config.Cumulative {
+		stats.Totals = &Stats{
+			HotKeys:   newHotKeyPool(new_counter, config.HotKeyShards),
+			Errors:    NewHotKeyPool(),
+			Tolerated: NewHotKeyPool(),
+			Self:      NewHotKeyPool(),
+		}
+	}
+	stats.capped = config.MaxKeys > 0 || config.MaxKeyBytes > 0
+	return stats
+}
+
+// Rotate clears the existing Stats, returning a new Stats containing the old
+// data, so that it can be reported on while counting continues.  With a
+// sliding window, hot keys are summed over the window instead, and are left
+// to be cleared as they slide out of it.  Similarly, decayed scores are left
+// to decay, and cumulative counts to keep growing.
+func (s *Stats) Rotate() *Stats {
+	var hot_keys *HotKeyPool
+	if s.Window != nil {
+		hot_keys = s.Window.Sum()
+	} else if s.Decayed != nil {
+		hot_keys = s.Decayed.Snapshot()
+	} else {
+		hot_keys = s.HotKeys.Rotate()
+	}
+	rotated := &Stats{
+		HotKeys:         hot_keys,
+		Errors:          s.Errors.Rotate(),
+		Tolerated:       s.Tolerated.Rotate(),
+		Self:            s.Self.Rotate(),
+		Commands:        s.Commands.Rotate(),
+		ClientErrors:    s.ClientErrors.Rotate(),
+		ProxyKeys:       s.ProxyKeys.Rotate(),
+		ServerKeys:      s.ServerKeys.Rotate(),
+		CommandKeys:     s.CommandKeys.Rotate(),
+		ClassKeys:       s.ClassKeys.Rotate(),
+		KeyBytes:        s.KeyBytes.Rotate(),
+		RegexpConflicts: s.RegexpConflicts.Rotate(),
+		RegexpCost:      s.RegexpCost.Rotate(),
+		RegexpMatches:   s.RegexpMatches.Rotate(),
+		Burst:           s.Burst.Rotate(),
+		Fanout:          s.Fanout.Rotate(),
+		OneHitWonders:   s.OneHitWonders.Rotate(),
+		Summary:         s.Summary.Rotate(),
+		SummaryDistinct: s.SummaryDistinct.Rotate(),
+		KeyClients:      s.KeyClients.Rotate(),
+		KeyExamples:     s.KeyExamples.Rotate(),
+		Cardinality:     s.Cardinality.Rotate(),
+		Namespaces:      s.Namespaces.Rotate(),
+		CommandLatency:  s.CommandLatency.Rotate(),
+		KeyLatency:      s.KeyLatency.Rotate(),
+	}
+	if s.capped {
+		rotated.Self.AddN("evictions", rotated.Evictions())
+	}
+
+	if s.Totals != nil {
+		s.Totals.HotKeys.Merge(rotated.HotKeys)
+		s.Totals.Errors.Merge(rotated.Errors)
+		s.Totals.Tolerated.Merge(rotated.Tolerated)
+		s.Totals.Self.Merge(rotated.Self)
+		rotated.HotKeys = s.Totals.HotKeys
+		rotated.Errors = s.Totals.Errors
+		rotated.Tolerated = s.Totals.Tolerated
+		rotated.Self = s.Totals.Self
+	}
+	return rotated
+}
+
+// Evictions returns the number of keys that have been forgotten to keep
+// within a memory limit, across every pool of keys.
+func (s *Stats) Evictions() int {
+	return s.HotKeys.Evictions() + s.KeyBytes.Evictions() +
+		s.ProxyKeys.Evictions() + s.ServerKeys.Evictions() +
+		s.CommandKeys.Evictions() + s.ClassKeys.Evictions()
+}
+
+// Slide moves the hot keys counted since the last call into the sliding
+// window or the decayed scores, whichever is in use.
+func (s *Stats) Slide() {
+	if s.Window != nil {
+		s.Window.Push(s.HotKeys.Rotate())
+	}
+	if s.Decayed != nil {
+		s.Decayed.Fold(s.HotKeys.Rotate())
+	}
+}
+
+// newCounterFunc returns a function making the kind of counter that config
+// asks hot keys to be counted with.
+func newCounterFunc(config Config) func() keyCounter {
+	switch config.Counter {
+	case "count_min":
+		// ... each key lives in one shard, so the shards share the width
+		// ... between them, keeping the memory used the same
+		width := (config.SketchWidth + config.HotKeyShards - 1) / config.HotKeyShards
+		return func() keyCounter {
+			return newCountMinCounter(width, config.SketchDepth, config.SketchCandidates)
+		}
+	case "space_saving":
+		return func() keyCounter {
+			return newSpaceSavingCounter(config.SpaceSavingCounters)
+		}
+	}
+	if config.MaxKeys > 0 || config.MaxKeyBytes > 0 {
+		// ... the limits are shared between shards, rounding up, as a
+		// ... limit of zero would be no limit at all
+		max_keys := (config.MaxKeys + config.HotKeyShards - 1) / config.HotKeyShards
+		max_bytes := (config.MaxKeyBytes + config.HotKeyShards - 1) / config.HotKeyShards
+		return func() keyCounter {
+			return newCappedCounter(max_keys, max_bytes)
+		}
+	}
+	return newExactCounter
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCumulativeStats(t *testing.T) {
+	config, _ := NewConfig([]byte(`{"cumulative": true}`))
+	stats := NewStats(config)
+
+	stats.HotKeys.Add([]string{"foo", "foo"})
+	stats.Errors.Add([]string{"truncated"})
+	stats.Rotate()
+	stats.HotKeys.Add([]string{"foo", "bar"})
+	rotated := stats.Rotate()
+
+	if hits := rotated.HotKeys.GetHits("foo"); hits != 3 {
+		t.Errorf("Expected 3 cumulative hits for foo, got %d\n", hits)
+	}
+	if hits := rotated.HotKeys.GetHits("bar"); hits != 1 {
+		t.Errorf("Expected 1 cumulative hit for bar, got %d\n", hits)
+	}
+	if hits := rotated.Errors.GetHits("truncated"); hits != 1 {
+		t.Errorf("Expected 1 cumulative truncated error, got %d\n", hits)
+	}
+	if hits := stats.HotKeys.GetHits("foo"); hits != 0 {
+		t.Errorf("Expected live pool to be cleared, got %d\n", hits)
+	}
+
+	_, err := NewConfig([]byte(`{"cumulative": true, "decay_half_life": 60}`))
+	if err == nil {
+		t.Errorf("Expected error for cumulative with decay\n")
+	}
+}
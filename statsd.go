@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+const STATSD_DIAL_TIMEOUT = 5 * time.Second
+
+// Characters that can't appear in a StatsD metric name, e.g. in keys like
+// "user:123", and in a DogStatsD tag
+var (
+	STATSD_NAME_ESCAPER   = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", "\n", "_")
+	DOGSTATSD_TAG_ESCAPER = strings.NewReplacer(",", "_", "|", "_", "#", "_", " ", "_", "\n", "_")
+)
+
+// StatsdSink sends reports to StatsD, over UDP or TCP.  Counters, e.g. key
+// hits, are sent as StatsD counters, and everything else as gauges.
+type StatsdSink struct {
+	network string
+	address string
+	conn    net.Conn
+
+	// Prepended to the name of every metric
+	prefix string
+
+	// Metrics are batched into packets of up to this many bytes, or sent
+	// one to a packet if zero
+	packet_bytes int
+
+	// Whether to send DogStatsD, with labels (keys, commands and so on) as
+	// tags, along with these static tags, rather than in the metric name
+	dogstatsd bool
+	tags      []string
+}
+
+// NewStatsdSink returns a sink sending to StatsD at address over network,
+// either "udp" or "tcp".  No connection is made until the first report is
+// sent.
+func NewStatsdSink(network string, address string, prefix string, packet_bytes int) *StatsdSink {
+	return &StatsdSink{
+		network:      network,
+		address:      address,
+		prefix:       prefix,
+		packet_bytes: packet_bytes,
+	}
+}
+
+// NewDogstatsdSink returns a sink sending to Datadog's DogStatsD, with the
+// given static tags, e.g. "env:prod", added to every metric.
+func NewDogstatsdSink(network string, address string, prefix string, packet_bytes int, tags []string) *StatsdSink {
+	s := NewStatsdSink(network, address, prefix, packet_bytes)
+	s.dogstatsd = true
+	s.tags = tags
+	return s
+}
+
+// Send sends a report.  If sending fails the connection is dropped, to be
+// made again for the next report, and the rest of the report is lost.
+func (s *StatsdSink) Send(report *Report) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.address, STATSD_DIAL_TIMEOUT)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	lines := statsdLines(report, s.prefix)
+	if s.dogstatsd {
+		lines = dogstatsdLines(report, s.prefix, s.tags)
+	}
+	for _, packet := range statsdPackets(lines, s.packet_bytes) {
+		if _, err := s.conn.Write([]byte(packet)); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// statsdLines formats each metric in a report as a StatsD line.
+func statsdLines(report *Report, prefix string) []string {
+	lines := make([]string, len(report.Metrics))
+	for i, m := range report.Metrics {
+		lines[i] = STATSD_NAME_ESCAPER.Replace(prefix+m.Name.String()) + ":" + m.FormatValue() + statsdType(m)
+	}
+	return lines
+}
+
+// dogstatsdLines formats each metric in a report as a DogStatsD line, named
+// for its family and tagged with its labels, then the static tags.
+func dogstatsdLines(report *Report, prefix string, tags []string) []string {
+	lines := make([]string, len(report.Metrics))
+	for i, m := range report.Metrics {
+		metric_tags := []string{}
+		for _, label := range m.Name.Labels() {
+			metric_tags = append(metric_tags,
+				label.Label+":"+DOGSTATSD_TAG_ESCAPER.Replace(label.Value))
+		}
+		metric_tags = append(metric_tags, tags...)
+		line := STATSD_NAME_ESCAPER.Replace(prefix+m.Name.Family()) + ":" + m.FormatValue() + statsdType(m)
+		if len(metric_tags) > 0 {
+			line += "|#" + strings.Join(metric_tags, ",")
+		}
+		lines[i] = line
+	}
+	return lines
+}
+
+// statsdType returns the StatsD type of a metric: a counter if it counts
+// something that happened in the interval, otherwise a gauge.
+func statsdType(m *Metric) string {
+	if m.Counter {
+		return "|c"
+	}
+	return "|g"
+}
+
+// statsdPackets batches lines into packets of up to packet_bytes.  A line
+// longer than packet_bytes gets a packet to itself.
+func statsdPackets(lines []string, packet_bytes int) []string {
+	packets := []string{}
+	packet := ""
+	for _, line := range lines {
+		line += "\n"
+		if packet != "" && len(packet)+len(line) > packet_bytes {
+			packets = append(packets, packet)
+			packet = ""
+		}
+		packet += line
+	}
+	if packet != "" {
+		packets = append(packets, packet)
+	}
+	return packets
+}
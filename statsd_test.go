@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStatsdPackets(t *testing.T) {
+	report := NewReport(time.Now())
+	report.Count(NewMetricName("mcsauna.keys.foo"), 3)
+	report.Count(NewMetricName("mcsauna.keys.bar"), 1)
+	report.Gauge(NewMetricName("mcsauna.distribution.gini"), 0.25)
+
+	packets := statsdPackets(statsdLines(report, ""), 0)
+	expected := []string{
+		"mcsauna.keys.foo:3|c\n",
+		"mcsauna.keys.bar:1|c\n",
+		"mcsauna.distribution.gini:0.250|g\n",
+	}
+	if !stringsEqual(packets, expected) {
+		t.Errorf("Expected %q, got %q\n", expected, packets)
+	}
+
+	packets = statsdPackets(statsdLines(report, "web1."), 60)
+	expected = []string{
+		"web1.mcsauna.keys.foo:3|c\nweb1.mcsauna.keys.bar:1|c\n",
+		"web1.mcsauna.distribution.gini:0.250|g\n",
+	}
+	if !stringsEqual(packets, expected) {
+		t.Errorf("Expected %q, got %q\n", expected, packets)
+	}
+}
+
+func TestStatsdLines(t *testing.T) {
+	report := NewReport(time.Now())
+	report.Count(NewMetricName("mcsauna.keys.user:123|x"), 3)
+	report.Count(NewMetricName("mcsauna.keys.foo"), 2)
+
+	/* Delimiters in keys are replaced, rather than ending the name */
+	lines := statsdLines(report, "web1.")
+	expected := []string{"web1.mcsauna.keys.user_123_x:3|c", "web1.mcsauna.keys.foo:2|c"}
+	if !stringsEqual(lines, expected) {
+		t.Errorf("Expected %q, got %q\n", expected, lines)
+	}
+}
+
+func TestDogstatsdLines(t *testing.T) {
+	report := NewReport(time.Now())
+	report.Count(NewMetricName("mcsauna.keys").Label("key", "foo,bar"), 3)
+	report.Count(NewMetricName("mcsauna.commands").Label("command", "get").Label("key", "foo"), 2)
+	report.Gauge(NewMetricName("mcsauna.distribution.gini"), 0.25)
+
+	lines := dogstatsdLines(report, "", []string{"env:prod"})
+	expected := []string{
+		"mcsauna.keys:3|c|#key:foo_bar,env:prod",
+		"mcsauna.commands:2|c|#command:get,key:foo,env:prod",
+		"mcsauna.distribution.gini:0.250|g|#env:prod",
+	}
+	if !stringsEqual(lines, expected) {
+		t.Errorf("Expected %q, got %q\n", expected, lines)
+	}
+
+	lines = dogstatsdLines(report, "web1.", nil)
+	if lines[2] != "web1.mcsauna.distribution.gini:0.250|g" {
+		t.Errorf("Unexpected line without tags %q\n", lines[2])
+	}
+}
+
+func TestStatsdSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink := NewStatsdSink("udp", conn.LocalAddr().String(), "", 1432)
+	report := NewReport(time.Now())
+	report.Count(NewMetricName("mcsauna.keys.foo"), 3)
+	if err := sink.Send(report); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1432)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet := string(buf[:n]); packet != "mcsauna.keys.foo:3|c\n" {
+		t.Errorf("Unexpected packet %q\n", packet)
+	}
+}
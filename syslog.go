@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+var SYSLOG_FACILITIES = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var SYSLOG_SEVERITIES = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3,
+	"warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// Sockets the local syslog daemon may be listening on
+var SYSLOG_LOCAL_SOCKETS = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+const SYSLOG_DIAL_TIMEOUT = 5 * time.Second
+
+// SyslogSink sends reports to syslog, local or remote, as RFC 5424
+// messages, one for each line of the report in the output format.
+type SyslogSink struct {
+	network string
+	address string
+	conn    net.Conn
+
+	priority int
+	hostname string
+	format   *ReportFormat
+}
+
+// NewSyslogSink returns a sink sending to the syslog daemon at address over
+// network ("udp", "tcp"), or to the local daemon if network is empty.
+// facility and severity must be valid names.
+func NewSyslogSink(network string, address string, facility string, severity string, format *ReportFormat) *SyslogSink {
+	hostname, _ := os.Hostname()
+	return &SyslogSink{
+		network:  network,
+		address:  address,
+		priority: SYSLOG_FACILITIES[facility]*8 + SYSLOG_SEVERITIES[severity],
+		hostname: hostname,
+		format:   format,
+	}
+}
+
+// Send sends a report.  If sending fails the connection is dropped, to be
+// made again for the next report, and the rest of the report is lost.
+func (s *SyslogSink) Send(report *Report) error {
+	output, err := s.format.Format(report)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if line == "" {
+			continue
+		}
+		if err := s.write(s.priority, report.Time, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write sends a single message at priority, connecting first if need be.
+// If sending fails the connection is dropped, to be made again next time.
+func (s *SyslogSink) write(priority int, now time.Time, line string) error {
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	msg := formatSyslog(priority, now, s.hostname, os.Getpid(), line)
+	if s.network == "tcp" {
+		// ... octet-counted framing, per RFC 6587
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+	if _, err := s.conn.Write([]byte(msg)); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *SyslogSink) dial() (net.Conn, error) {
+	if s.network != "" {
+		return net.DialTimeout(s.network, s.address, SYSLOG_DIAL_TIMEOUT)
+	}
+	for _, path := range SYSLOG_LOCAL_SOCKETS {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.Dial(network, path); err == nil {
+				return conn, nil
+			}
+		}
+	}
+	return nil, errors.New("Could not connect to local syslog daemon.")
+}
+
+// formatSyslog formats an RFC 5424 syslog message.
+func formatSyslog(priority int, now time.Time, hostname string, pid int, msg string) string {
+	if hostname == "" {
+		hostname = "-"
+	}
+	return fmt.Sprintf("<%d>1 %s %s mcsauna %d - - %s", priority,
+		now.Format(time.RFC3339), hostname, pid, msg)
+}
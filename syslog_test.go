@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFormatSyslog(t *testing.T) {
+	now := time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC)
+	msg := formatSyslog(3*8+6, now, "cache1", 42, "mcsauna.keys.foo 3")
+	expected := "<30>1 2017-07-14T02:40:00Z cache1 mcsauna 42 - - mcsauna.keys.foo 3"
+	if msg != expected {
+		t.Errorf("Expected %q, got %q\n", expected, msg)
+	}
+}
+
+func TestSyslogSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink := NewSyslogSink("udp", conn.LocalAddr().String(), "local0", "notice", &ReportFormat{name: "text"})
+	sink.hostname = "cache1"
+	report := NewReport(time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC))
+	report.Count(NewMetricName("mcsauna.keys").Label("key", "foo"), 3)
+	report.Count(NewMetricName("mcsauna.keys").Label("key", "bar"), 1)
+	if err := sink.Send(report); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, expected := range []string{"mcsauna.keys.foo 3", "mcsauna.keys.bar 1"} {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := formatSyslog(16*8+5, report.Time, "cache1", os.Getpid(), expected)
+		if string(buf[:n]) != msg {
+			t.Errorf("Expected %q, got %q\n", msg, buf[:n])
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcsauna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	/* Nothing is sent when not started by systemd */
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	os.Unsetenv("NOTIFY_SOCKET")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("Expected no error without a socket, got %v\n", err)
+	}
+
+	os.Setenv("NOTIFY_SOCKET", path)
+	if err := sdNotify("READY=1"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Errorf("Expected READY=1, got '%s' (%v)\n", buf[:n], err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+	tests := []struct {
+		usec     string
+		pid      string
+		interval time.Duration
+	}{
+		{"", "", 0},
+		{"30000000", "", 30 * time.Second},
+		{"30000000", strconv.Itoa(os.Getpid()), 30 * time.Second},
+		/* Meant for another process */
+		{"30000000", "1", 0},
+		{"bogus", "", 0},
+	}
+	for _, test := range tests {
+		os.Setenv("WATCHDOG_USEC", test.usec)
+		os.Setenv("WATCHDOG_PID", test.pid)
+		if interval := watchdogInterval(); interval != test.interval {
+			t.Errorf("Expected %v for WATCHDOG_USEC=%s WATCHDOG_PID=%s, got %v\n",
+				test.interval, test.usec, test.pid, interval)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	TLS_RECORD_HEADER_LEN = 5
+
+	// Record content types
+	TLS_CHANGE_CIPHER_SPEC = 20
+	TLS_ALERT              = 21
+	TLS_HANDSHAKE          = 22
+	TLS_APPLICATION_DATA   = 23
+
+	// Handshake message types
+	TLS_CLIENT_HELLO = 1
+
+	// Key log labels for the client's TLS 1.3 secrets
+	TLS_CLIENT_HANDSHAKE_SECRET = "CLIENT_HANDSHAKE_TRAFFIC_SECRET"
+	TLS_CLIENT_TRAFFIC_SECRET   = "CLIENT_TRAFFIC_SECRET_0"
+)
+
+// TLSKeyLog holds the secrets from a key log file, in the format written by
+// SSLKEYLOGFILE-aware clients:
+//
+//     LABEL <client random in hex> <secret in hex>
+//
+// Clients keep appending to the file as they make new connections, so it is
+// re-read when a secret can't be found.
+type TLSKeyLog struct {
+	path string
+
+	// Map of client randoms (in hex) to labels to secrets
+	secrets map[string]map[string][]byte
+
+	mod_time  time.Time
+	last_load time.Time
+}
+
+func NewTLSKeyLog(path string) (*TLSKeyLog, error) {
+	k := &TLSKeyLog{path: path}
+	return k, k.load()
+}
+
+func (k *TLSKeyLog) load() error {
+	f, err := os.Open(k.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	secrets := make(map[string]map[string][]byte)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		split_data := strings.Fields(scanner.Text())
+		if len(split_data) != 3 || strings.HasPrefix(split_data[0], "#") {
+			continue
+		}
+		label, client_random := split_data[0], strings.ToLower(split_data[1])
+		secret, err := hex.DecodeString(split_data[2])
+		if err != nil {
+			continue
+		}
+		if _, ok := secrets[client_random]; !ok {
+			secrets[client_random] = make(map[string][]byte)
+		}
+		secrets[client_random][label] = secret
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	k.secrets = secrets
+	k.mod_time = info.ModTime()
+	return nil
+}
+
+// Lookup returns the secret logged under label for the connection with the
+// given client random, or nil if there isn't one.  On a miss, the file is
+// re-read if it has changed, but no more than once a second.
+func (k *TLSKeyLog) Lookup(client_random []byte, label string, now time.Time) []byte {
+	if k == nil {
+		return nil
+	}
+	random_hex := hex.EncodeToString(client_random)
+	if secret := k.secrets[random_hex][label]; secret != nil {
+		return secret
+	}
+
+	if now.Sub(k.last_load) < time.Second {
+		return nil
+	}
+	k.last_load = now
+	if info, err := os.Stat(k.path); err != nil || !info.ModTime().After(k.mod_time) {
+		return nil
+	}
+	if k.load() != nil {
+		return nil
+	}
+	return k.secrets[random_hex][label]
+}
+
+// tlsDecrypter decrypts the records sent under a single TLS 1.3 traffic
+// secret.
+type tlsDecrypter struct {
+	aead    cipher.AEAD
+	key_len int
+	iv      []byte
+	seq     uint64
+}
+
+// newTLSDecrypter derives the record key and IV from a traffic secret.  The
+// hash, and so the cipher suite, is inferred from the secret's length; only
+// the AES-GCM suites are supported, so a SHA-256 secret is taken to be for
+// AES-128-GCM, though it may be for ChaCha20-Poly1305.
+func newTLSDecrypter(secret []byte) (*tlsDecrypter, error) {
+	var (
+		new_hash func() hash.Hash
+		key_len  int
+	)
+	switch len(secret) {
+	case sha256.Size:
+		new_hash, key_len = sha256.New, 16
+	case sha512.Size384:
+		new_hash, key_len = sha512.New384, 32
+	default:
+		return nil, errors.New("Unsupported TLS secret length.")
+	}
+
+	key := hkdfExpandLabel(new_hash, secret, "key", key_len)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	iv := hkdfExpandLabel(new_hash, secret, "iv", aead.NonceSize())
+	return &tlsDecrypter{aead: aead, key_len: key_len, iv: iv}, nil
+}
+
+// Open decrypts a record, returning its inner content type and content.
+func (d *tlsDecrypter) Open(record []byte) (content_type byte, content []byte, ok bool) {
+	nonce := make([]byte, len(d.iv))
+	copy(nonce, d.iv)
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], d.seq)
+	for i := range seq {
+		nonce[len(nonce)-8+i] ^= seq[i]
+	}
+
+	header, ciphertext := record[:TLS_RECORD_HEADER_LEN], record[TLS_RECORD_HEADER_LEN:]
+	plaintext, err := d.aead.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return 0, nil, false
+	}
+	d.seq += 1
+
+	// ... the real content type follows the content, then zero padding
+	i := len(plaintext) - 1
+	for i >= 0 && plaintext[i] == 0 {
+		i -= 1
+	}
+	if i < 0 {
+		return 0, nil, false
+	}
+	return plaintext[i], plaintext[:i], true
+}
+
+// hkdfExpandLabel implements HKDF-Expand-Label from RFC 8446, with an empty
+// context.  length must be no more than the hash's output size, which holds
+// for keys and IVs.
+func hkdfExpandLabel(new_hash func() hash.Hash, secret []byte, label string, length int) []byte {
+	full_label := "tls13 " + label
+	info := []byte{byte(length >> 8), byte(length), byte(len(full_label))}
+	info = append(info, full_label...)
+	info = append(info, 0) // ... empty context
+
+	mac := hmac.New(new_hash, secret)
+	mac.Write(info)
+	mac.Write([]byte{1})
+	return mac.Sum(nil)[:length]
+}
+
+// tlsStream tracks decryption of the client side of a TLS connection.
+type tlsStream struct {
+	client_random []byte
+
+	// The client's Finished message is sent under the handshake secret, and
+	// everything after it under the traffic secret.
+	handshake *tlsDecrypter
+	traffic   *tlsDecrypter
+
+	// The protocol spoken inside the tunnel, and any partial command left
+	// at the end of the last record.
+	protocol int
+	pending  []byte
+
+	// Set once nothing in the session can be decrypted with secrets that
+	// were found, as when it uses ChaCha20-Poly1305, until the next hello
+	unsupported bool
+}
+
+// Decrypt processes a single record, returning any application data it
+// carried.  Records that can't be decrypted, whether because the session's
+// secrets aren't in the key log, the session uses an unsupported version, or
+// we joined the connection part way through, are reported as ERR_ENCRYPTED.
+// A session whose first record can't be decrypted with SHA-256 secrets that
+// were found, most likely because it uses ChaCha20-Poly1305 rather than
+// AES-128-GCM, is marked unsupported instead, and its records skipped.
+func (t *tlsStream) Decrypt(record []byte, key_log *TLSKeyLog, now time.Time) ([]byte, int) {
+	switch record[0] {
+	case TLS_HANDSHAKE:
+		// ... the only handshake message the client sends in the clear is
+		// ... its hello, which tells us which secrets to look for
+		const random_start = TLS_RECORD_HEADER_LEN + 4 + 2
+		if len(record) >= random_start+32 && record[TLS_RECORD_HEADER_LEN] == TLS_CLIENT_HELLO {
+			t.client_random = append([]byte{}, record[random_start:random_start+32]...)
+			t.handshake, t.traffic = nil, nil
+			t.unsupported = false
+		}
+		return nil, ERR_NONE
+	case TLS_CHANGE_CIPHER_SPEC:
+		return nil, ERR_NONE
+	}
+	if t.unsupported {
+		return nil, ERR_NONE
+	}
+
+	if t.traffic == nil {
+		if t.client_random == nil {
+			return nil, ERR_ENCRYPTED
+		}
+		secret := key_log.Lookup(t.client_random, TLS_CLIENT_TRAFFIC_SECRET, now)
+		if secret == nil {
+			return nil, ERR_ENCRYPTED
+		}
+		traffic, err := newTLSDecrypter(secret)
+		if err != nil {
+			return nil, ERR_ENCRYPTED
+		}
+		t.traffic = traffic
+		if secret := key_log.Lookup(t.client_random, TLS_CLIENT_HANDSHAKE_SECRET, now); secret != nil {
+			t.handshake, _ = newTLSDecrypter(secret)
+		}
+	}
+
+	if content_type, content, ok := t.traffic.Open(record); ok {
+		if content_type != TLS_APPLICATION_DATA {
+			return nil, ERR_NONE
+		}
+		return content, ERR_NONE
+	}
+	if t.handshake != nil {
+		if _, _, ok := t.handshake.Open(record); ok {
+			return nil, ERR_NONE
+		}
+	}
+	// ... with nothing decrypted yet, the secrets are right, but the
+	// ... cipher suite isn't AES-128-GCM
+	if t.traffic.key_len == 16 && t.traffic.seq == 0 &&
+		(t.handshake == nil || t.handshake.seq == 0) {
+		t.unsupported = true
+		return nil, ERR_NONE
+	}
+	return nil, ERR_ENCRYPTED
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// recordingConn keeps a copy of everything written to the connection.
+type recordingConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.written.Write(b)
+	return c.Conn.Write(b)
+}
+
+// tlsSession runs a TLS 1.3 session that sends data from client to server,
+// returning everything the client put on the wire and its key log.
+func tlsSession(t *testing.T, data []byte) (wire []byte, key_log []byte) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client_conn, server_conn := net.Pipe()
+	go func() {
+		server := tls.Server(server_conn, &tls.Config{
+			Certificates: []tls.Certificate{tls.Certificate{Certificate: [][]byte{cert}, PrivateKey: priv}},
+		})
+		io.Copy(ioutil.Discard, server)
+		server.Close()
+	}()
+
+	recorder := &recordingConn{Conn: client_conn}
+	key_log_buf := &bytes.Buffer{}
+	client := tls.Client(recorder, &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+		KeyLogWriter:       key_log_buf,
+	})
+	if _, err := client.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if client.ConnectionState().CipherSuite != tls.TLS_AES_128_GCM_SHA256 {
+		t.Skip("AES-GCM wasn't negotiated")
+	}
+	client.Close()
+	return recorder.written.Bytes(), key_log_buf.Bytes()
+}
+
+func TestTLSDecryption(t *testing.T) {
+	wire, key_log_data := tlsSession(t, []byte("get foo\r\nset bar 0 0 3\r\nabc\r\n"))
+
+	f, err := ioutil.TempFile("", "mcsauna-keylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(key_log_data)
+	f.Close()
+
+	config, _ := NewConfig([]byte{})
+	stats := NewStats(config)
+	hot_keys, errors := stats.HotKeys, stats.Errors
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+	processor.tls_key_log, err = NewTLSKeyLog(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p := detectProtocol(wire); p != PROTOCOL_TLS {
+		t.Fatalf("Expected TLS protocol, got %d\n", p)
+	}
+
+	// ... feed the stream in two uneven pieces, so a record is split
+	key := testConnKey(1)
+	split := len(wire) - 10
+	partial := processor.processTLSRecords(key, wire[:split], time.Now())
+	partial = processor.processTLSRecords(key, append(partial, wire[split:]...), time.Now())
+	if len(partial) != 0 {
+		t.Errorf("Expected all records to be consumed, %d bytes left\n", len(partial))
+	}
+
+	for _, k := range []string{"foo", "bar"} {
+		if hits := hot_keys.GetHits(k); hits != 1 {
+			t.Errorf("Expected 1 hit for %s, got %d\n", k, hits)
+		}
+	}
+	if hits := errors.GetHits(ERR_TO_STAT[ERR_ENCRYPTED]); hits != 0 {
+		t.Errorf("Expected no undecryptable records, got %d\n", hits)
+	}
+}
+
+func TestTLSUndecryptable(t *testing.T) {
+	wire, _ := tlsSession(t, []byte("get foo\r\n"))
+
+	config, _ := NewConfig([]byte{})
+	stats := NewStats(config)
+	hot_keys, errors := stats.HotKeys, stats.Errors
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+	processor.processTLSRecords(testConnKey(1), wire, time.Now())
+
+	if hits := hot_keys.GetHits("foo"); hits != 0 {
+		t.Errorf("Expected no hits without a key log, got %d\n", hits)
+	}
+	if hits := errors.GetHits(ERR_TO_STAT[ERR_ENCRYPTED]); hits == 0 {
+		t.Errorf("Expected undecryptable records to be reported\n")
+	}
+}
+
+func TestTLSUnsupportedSuite(t *testing.T) {
+	wire, key_log_data := tlsSession(t, []byte("get foo\r\n"))
+
+	/* Secrets of the right length that don't decrypt the session with
+	 * AES-128-GCM, as with a session using ChaCha20-Poly1305 */
+	lines := bytes.Split(bytes.TrimSpace(key_log_data), []byte("\n"))
+	for i, line := range lines {
+		fields := bytes.Fields(line)
+		secret := bytes.Repeat([]byte("ab"), len(fields[2])/2)
+		lines[i] = bytes.Join([][]byte{fields[0], fields[1], secret}, []byte(" "))
+	}
+	f, err := ioutil.TempFile("", "mcsauna-keylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(bytes.Join(lines, []byte("\n")))
+	f.Close()
+
+	config, _ := NewConfig([]byte{})
+	stats := NewStats(config)
+	self, errors := stats.Self, stats.Errors
+	processor := NewProcessor(config, NewRegexpKeys(), stats)
+	processor.tls_key_log, err = NewTLSKeyLog(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	processor.processTLSRecords(testConnKey(1), wire, time.Now())
+
+	if hits := self.GetHits("tls_unsupported"); hits != 1 {
+		t.Errorf("Expected 1 unsupported session, got %d\n", hits)
+	}
+	if hits := errors.GetHits(ERR_TO_STAT[ERR_ENCRYPTED]); hits != 0 {
+		t.Errorf("Expected an unsupported session's records not to be errors, got %d\n", hits)
+	}
+}
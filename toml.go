@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Numbers in TOML, other than hexadecimal, octal and binary integers
+var (
+	TOML_INT_RE   = regexp.MustCompile(`^[-+]?(0|[1-9](_?[0-9])*)$`)
+	TOML_FLOAT_RE = regexp.MustCompile(`^[-+]?(0|[1-9](_?[0-9])*)(\.[0-9](_?[0-9])*)?([eE][-+]?[0-9](_?[0-9])*)?$`)
+)
+
+// tomlParser parses TOML documents.  Dates and times aren't supported,
+// since nothing in the configuration takes one.
+type tomlParser struct {
+	data []byte
+	pos  int
+
+	// Tables defined by a header, which can't be defined again
+	defined map[string]bool
+}
+
+// parseTOML parses a TOML document into maps, slices and scalars, as
+// encoding/json would decode the same document written as JSON.
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	p := &tomlParser{data: data, defined: map[string]bool{}}
+	root := map[string]interface{}{}
+	if err := p.parse(root); err != nil {
+		return nil, fmt.Errorf("Config error: TOML line %d: %s.", p.line(), err)
+	}
+	return root, nil
+}
+
+// startsWith returns whether the data from the parser's position on starts
+// with prefix.
+func (p *tomlParser) startsWith(prefix string) bool {
+	return bytes.HasPrefix(p.data[p.pos:], []byte(prefix))
+}
+
+// line returns the line number, from 1, the parser has reached.
+func (p *tomlParser) line() int {
+	return strings.Count(string(p.data[:p.pos]), "\n") + 1
+}
+
+func (p *tomlParser) parse(root map[string]interface{}) error {
+	table := root
+	for {
+		p.skipSpace(true)
+		if p.pos == len(p.data) {
+			return nil
+		}
+		var err error
+		if p.data[p.pos] == '[' {
+			table, err = p.parseHeader(root)
+		} else {
+			err = p.parseKeyValue(table)
+		}
+		if err != nil {
+			return err
+		}
+
+		// ... each header or key/value pair must be on a line of its own
+		p.skipSpace(false)
+		if p.pos < len(p.data) && p.data[p.pos] != '\n' {
+			return fmt.Errorf("expected the end of the line, got %q", p.data[p.pos])
+		}
+	}
+}
+
+// skipSpace skips past whitespace and comments, and past line breaks too if
+// newlines is set.
+func (p *tomlParser) skipSpace(newlines bool) {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t':
+		case '\r', '\n':
+			if !newlines {
+				if p.data[p.pos] == '\r' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '\n' {
+					p.pos += 1
+					continue
+				}
+				return
+			}
+		case '#':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos += 1
+			}
+			continue
+		default:
+			return
+		}
+		p.pos += 1
+	}
+}
+
+// parseHeader parses a [table] or [[array of tables]] header, returning the
+// table that the key/value pairs that follow go in.
+func (p *tomlParser) parseHeader(root map[string]interface{}) (map[string]interface{}, error) {
+	array := p.pos+1 < len(p.data) && p.data[p.pos+1] == '['
+	if array {
+		p.pos += 2
+	} else {
+		p.pos += 1
+	}
+	keys, err := p.parseKey()
+	if err != nil {
+		return nil, err
+	}
+	close := "]"
+	if array {
+		close = "]]"
+	}
+	if !p.startsWith(close) {
+		return nil, fmt.Errorf("expected '%s'", close)
+	}
+	p.pos += len(close)
+
+	parent, err := tomlDescend(root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+	if array {
+		tables, ok := parent[last].([]interface{})
+		if _, exists := parent[last]; exists && (!ok || !p.defined[tomlPath(keys)+"[]"]) {
+			return nil, fmt.Errorf("%q is already defined", tomlPath(keys))
+		}
+		table := map[string]interface{}{}
+		parent[last] = append(tables, table)
+		p.defined[tomlPath(keys)+"[]"] = true
+		return table, nil
+	}
+
+	if p.defined[tomlPath(keys)] {
+		return nil, fmt.Errorf("table %q is already defined", tomlPath(keys))
+	}
+	p.defined[tomlPath(keys)] = true
+	return tomlDescend(parent, []string{last})
+}
+
+// parseKeyValue parses "key = value" into table.
+func (p *tomlParser) parseKeyValue(table map[string]interface{}) error {
+	keys, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	if p.pos == len(p.data) || p.data[p.pos] != '=' {
+		return fmt.Errorf("expected '=' after %q", tomlPath(keys))
+	}
+	p.pos += 1
+	p.skipSpace(false)
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+	parent, err := tomlDescend(table, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, ok := parent[last]; ok {
+		return fmt.Errorf("%q is already defined", tomlPath(keys))
+	}
+	parent[last] = value
+	return nil
+}
+
+// parseKey parses a bare, quoted or dotted key, and any space after it.
+func (p *tomlParser) parseKey() ([]string, error) {
+	keys := []string{}
+	for {
+		p.skipSpace(false)
+		if p.pos == len(p.data) {
+			return nil, fmt.Errorf("expected a key")
+		}
+		switch c := p.data[p.pos]; {
+		case c == '"' || c == '\'':
+			key, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		default:
+			start := p.pos
+			for p.pos < len(p.data) && tomlBareKeyByte(p.data[p.pos]) {
+				p.pos += 1
+			}
+			if p.pos == start {
+				return nil, fmt.Errorf("expected a key, got %q", c)
+			}
+			keys = append(keys, string(p.data[start:p.pos]))
+		}
+		p.skipSpace(false)
+		if p.pos == len(p.data) || p.data[p.pos] != '.' {
+			return keys, nil
+		}
+		p.pos += 1
+	}
+}
+
+func tomlBareKeyByte(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	if p.pos == len(p.data) {
+		return nil, fmt.Errorf("expected a value")
+	}
+	switch p.data[p.pos] {
+	case '"', '\'':
+		return p.parseString()
+	case '[':
+		return p.parseArray()
+	case '{':
+		return p.parseInlineTable()
+	}
+
+	start := p.pos
+	for p.pos < len(p.data) && strings.IndexByte(" \t\r\n#,]}", p.data[p.pos]) == -1 {
+		p.pos += 1
+	}
+	token := string(p.data[start:p.pos])
+	switch {
+	case token == "true":
+		return true, nil
+	case token == "false":
+		return false, nil
+	case TOML_INT_RE.MatchString(token):
+		return strconv.ParseInt(strings.Replace(token, "_", "", -1), 10, 64)
+	case strings.HasPrefix(token, "0x") || strings.HasPrefix(token, "0o") || strings.HasPrefix(token, "0b"):
+		base := map[byte]int{'x': 16, 'o': 8, 'b': 2}[token[1]]
+		n, err := strconv.ParseInt(strings.Replace(token[2:], "_", "", -1), base, 64)
+		if err != nil || strings.HasPrefix(token[2:], "_") || strings.HasSuffix(token, "_") {
+			return nil, fmt.Errorf("invalid integer %q", token)
+		}
+		return n, nil
+	case TOML_FLOAT_RE.MatchString(token):
+		return strconv.ParseFloat(strings.Replace(token, "_", "", -1), 64)
+	case strings.Contains(token, "inf") || strings.Contains(token, "nan"):
+		return nil, fmt.Errorf("%q can't be used in the configuration", token)
+	case len(token) >= 10 && token[4] == '-' && token[7] == '-':
+		return nil, fmt.Errorf("dates aren't supported")
+	}
+	return nil, fmt.Errorf("invalid value %q", token)
+}
+
+// parseString parses a basic or literal string, on one line or several.
+func (p *tomlParser) parseString() (string, error) {
+	quote := p.data[p.pos]
+	multiline := p.startsWith(strings.Repeat(string(quote), 3))
+	if multiline {
+		p.pos += 3
+		// ... a line break straight after the quotes is trimmed
+		if p.startsWith("\r\n") {
+			p.pos += 2
+		} else if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+			p.pos += 1
+		}
+	} else {
+		p.pos += 1
+	}
+
+	value := []byte{}
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		switch {
+		case multiline && p.startsWith(strings.Repeat(string(quote), 3)):
+			// ... up to two more quotes can end the string
+			p.pos += 3
+			for i := 0; i < 2 && p.pos < len(p.data) && p.data[p.pos] == quote; i++ {
+				value = append(value, quote)
+				p.pos += 1
+			}
+			return string(value), nil
+		case !multiline && c == quote:
+			p.pos += 1
+			return string(value), nil
+		case !multiline && c == '\n':
+			return "", fmt.Errorf("unterminated string")
+		case c == '\\' && quote == '"':
+			p.pos += 1
+			unescaped, err := p.parseEscape(multiline)
+			if err != nil {
+				return "", err
+			}
+			value = append(value, unescaped...)
+			continue
+		default:
+			value = append(value, c)
+		}
+		p.pos += 1
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+// Escapes in basic TOML strings, other than \u and \U
+var TOML_ESCAPES = map[byte]string{
+	'b': "\b", 't': "\t", 'n': "\n", 'f': "\f", 'r': "\r", 'e': "\x1b",
+	'"': "\"", '\\': "\\",
+}
+
+// parseEscape parses the escape after a backslash in a basic string.
+func (p *tomlParser) parseEscape(multiline bool) (string, error) {
+	if p.pos == len(p.data) {
+		return "", fmt.Errorf("unterminated string")
+	}
+	escape := p.data[p.pos]
+	p.pos += 1
+	switch {
+	case escape == 'u' || escape == 'U':
+		digits := 4
+		if escape == 'U' {
+			digits = 8
+		}
+		if p.pos+digits > len(p.data) {
+			return "", fmt.Errorf("invalid escape")
+		}
+		r, err := strconv.ParseUint(string(p.data[p.pos:p.pos+digits]), 16, 32)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape")
+		}
+		p.pos += digits
+		return string(rune(r)), nil
+	case multiline && strings.IndexByte(" \t\r\n", escape) != -1:
+		// ... a backslash at the end of a line trims the line break and
+		// ... any whitespace after it, though not what looks like a comment,
+		// ... which is part of the string
+		p.pos -= 1
+		start := p.pos
+		for p.pos < len(p.data) && strings.IndexByte(" \t\r\n", p.data[p.pos]) != -1 {
+			p.pos += 1
+		}
+		if !strings.Contains(string(p.data[start:p.pos]), "\n") {
+			return "", fmt.Errorf("invalid escape")
+		}
+		return "", nil
+	}
+	unescaped, ok := TOML_ESCAPES[escape]
+	if !ok {
+		return "", fmt.Errorf("invalid escape \\%c", escape)
+	}
+	return unescaped, nil
+}
+
+func (p *tomlParser) parseArray() (interface{}, error) {
+	items := []interface{}{}
+	p.pos += 1
+	for {
+		p.skipSpace(true)
+		if p.pos == len(p.data) {
+			return nil, fmt.Errorf("unterminated array")
+		} else if p.data[p.pos] == ']' {
+			p.pos += 1
+			return items, nil
+		}
+		item, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		p.skipSpace(true)
+		if p.pos < len(p.data) && p.data[p.pos] == ',' {
+			p.pos += 1
+		} else if p.pos == len(p.data) || p.data[p.pos] != ']' {
+			return nil, fmt.Errorf("expected ',' or ']'")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (interface{}, error) {
+	table := map[string]interface{}{}
+	p.pos += 1
+	p.skipSpace(false)
+	if p.pos < len(p.data) && p.data[p.pos] == '}' {
+		p.pos += 1
+		return table, nil
+	}
+	for {
+		if err := p.parseKeyValue(table); err != nil {
+			return nil, err
+		}
+		p.skipSpace(false)
+		if p.pos < len(p.data) && p.data[p.pos] == '}' {
+			p.pos += 1
+			return table, nil
+		} else if p.pos == len(p.data) || p.data[p.pos] != ',' {
+			return nil, fmt.Errorf("expected ',' or '}'")
+		}
+		p.pos += 1
+	}
+}
+
+// tomlDescend returns the table at keys under table, creating any tables
+// that don't exist yet.  Where a key is an array of tables, the last table
+// in it is used.
+func tomlDescend(table map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for i, key := range keys {
+		switch value := table[key].(type) {
+		case nil:
+			next := map[string]interface{}{}
+			table[key] = next
+			table = next
+		case map[string]interface{}:
+			table = value
+		case []interface{}:
+			last, ok := interface{}(nil), false
+			if len(value) > 0 {
+				last = value[len(value)-1]
+			}
+			if table, ok = last.(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("%q isn't a table", tomlPath(keys[:i+1]))
+			}
+		default:
+			return nil, fmt.Errorf("%q isn't a table", tomlPath(keys[:i+1]))
+		}
+	}
+	return table, nil
+}
+
+// tomlPath joins keys into a dotted key, for errors.
+func tomlPath(keys []string) string {
+	return strings.Join(keys, ".")
+}
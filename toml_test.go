@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		toml     string
+		expected map[string]interface{}
+	}{
+		{"", map[string]interface{}{}},
+		{"a = 1_000\nb = -0.5e1\nc = 0xff\nd = true\n", map[string]interface{}{
+			"a": int64(1000), "b": -5.0, "c": int64(255), "d": true,
+		}},
+		{`a = "tab\there \u00e9"` + "\nb = 'C:\\path'\n", map[string]interface{}{
+			"a": "tab\there \u00e9", "b": "C:\\path",
+		}},
+		{"a = \"\"\"\none \\\n  two\"\"\"\nb = '''\nraw\\n'''\n", map[string]interface{}{
+			"a": "one two", "b": "raw\\n",
+		}},
+		/* A line after a line-ending backslash keeps a leading '#' */
+		{"a = \"\"\"\none \\\n  # two\"\"\"\n", map[string]interface{}{
+			"a": "one # two",
+		}},
+		{"a.b = 1\n[c.d]\ne = 2\n[[f]]\ng = 3\n[[f]]\n[f.h]\ni = 4\n", map[string]interface{}{
+			"a": map[string]interface{}{"b": int64(1)},
+			"c": map[string]interface{}{"d": map[string]interface{}{"e": int64(2)}},
+			"f": []interface{}{
+				map[string]interface{}{"g": int64(3)},
+				map[string]interface{}{"h": map[string]interface{}{"i": int64(4)}},
+			},
+		}},
+	}
+	for _, test := range tests {
+		actual, err := parseTOML([]byte(test.toml))
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %v\n", test.toml, err)
+		} else if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Expected %q to parse as %#v, got %#v\n", test.toml, test.expected, actual)
+		}
+	}
+
+	invalid := []string{
+		"a = 1\na = 2", "[a]\n[a]", "a = 1 b = 2", "a = [1, 2", "a = \"unterminated\nb = 1",
+		"a = 1979-05-27", "a = 01", "a = inf", "a = 1\n[a]",
+	}
+	for _, toml := range invalid {
+		if _, err := parseTOML([]byte(toml)); err == nil {
+			t.Errorf("Expected error parsing %q\n", toml)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Ways the top display can be sorted, by the key that selects each
+const (
+	TOP_SORT_HITS    = 'h'
+	TOP_SORT_BYTES   = 'b'
+	TOP_SORT_COMMAND = 'c'
+)
+
+var TOP_SORT_NAMES = map[byte]string{
+	TOP_SORT_HITS:    "hits",
+	TOP_SORT_BYTES:   "bytes",
+	TOP_SORT_COMMAND: "command",
+}
+
+// TopSink shows the hottest keys in the latest report in the terminal,
+// like top, redrawing them as each report comes in.  The display can be
+// sorted by hits, bytes or command, filtered to keys containing a
+// substring, and paused, from the keyboard.
+type TopSink struct {
+	Lock sync.Mutex
+
+	out    io.Writer
+	prefix string
+
+	// The latest report, and the one shown, which differ while paused
+	latest *Report
+	shown  *Report
+
+	sort_by byte
+	filter  string
+	paused  bool
+
+	// Whether the filter is being typed in
+	editing bool
+}
+
+// topRow is a key shown in the top display, with its hits, bytes on the
+// wire (if ranking by bytes), and the command it was hit by most (if
+// reporting on commands).
+type topRow struct {
+	Key     string
+	Hits    int
+	Bytes   int
+	Command string
+
+	command_hits int
+}
+
+// NewTopSink returns a sink drawing to out, for reports with metrics named
+// under prefix.
+func NewTopSink(out io.Writer, prefix string) *TopSink {
+	return &TopSink{out: out, prefix: prefix, sort_by: TOP_SORT_HITS}
+}
+
+func (t *TopSink) Send(report *Report) error {
+	t.Lock.Lock()
+	defer t.Lock.Unlock()
+	t.latest = report
+	if !t.paused {
+		t.shown = report
+	}
+	return t.draw()
+}
+
+// Run puts the terminal into cbreak mode, and handles keys pressed until
+// the user quits, then restores the terminal.
+func (t *TopSink) Run(in *os.File) error {
+	state, err := stty(in, "-g")
+	if err != nil {
+		return err
+	}
+	if _, err := stty(in, "cbreak", "-echo"); err != nil {
+		return err
+	}
+	defer stty(in, strings.TrimSpace(state))
+	fmt.Fprint(t.out, "\033[?25l")
+	defer fmt.Fprint(t.out, "\033[?25h\033[H\033[2J")
+
+	buf := make([]byte, 1)
+	for {
+		if _, err := in.Read(buf); err != nil {
+			return err
+		}
+		t.Lock.Lock()
+		quit := t.handleKey(buf[0])
+		t.draw()
+		t.Lock.Unlock()
+		if quit {
+			return nil
+		}
+	}
+}
+
+// handleKey updates the display for a key pressed, returning whether to
+// quit.
+func (t *TopSink) handleKey(key byte) bool {
+	if t.editing {
+		switch key {
+		case '\r', '\n':
+			t.editing = false
+		case 27: // escape
+			t.editing = false
+			t.filter = ""
+		case 8, 127: // backspace
+			if len(t.filter) > 0 {
+				t.filter = t.filter[:len(t.filter)-1]
+			}
+		default:
+			if key >= ' ' && key < 127 {
+				t.filter += string(key)
+			}
+		}
+		return false
+	}
+
+	switch key {
+	case 'q':
+		return true
+	case 'p', ' ':
+		t.paused = !t.paused
+		t.shown = t.latest
+	case '/':
+		t.editing = true
+		t.filter = ""
+	case TOP_SORT_HITS, TOP_SORT_BYTES, TOP_SORT_COMMAND:
+		t.sort_by = key
+	}
+	return false
+}
+
+// draw redraws the display, to fit the terminal.
+func (t *TopSink) draw() error {
+	rows, cols := terminalSize()
+	_, err := io.WriteString(t.out, "\033[H\033[2J"+t.format(rows, cols))
+	return err
+}
+
+// format formats the display, in at most height lines of width.
+func (t *TopSink) format(height int, width int) string {
+	status := "sort: " + TOP_SORT_NAMES[t.sort_by]
+	if t.editing {
+		status += "  filter: " + t.filter + "_"
+	} else if t.filter != "" {
+		status += "  filter: " + t.filter
+	}
+	if t.paused {
+		status += "  [paused]"
+	}
+	when := "waiting for first report"
+	if t.shown != nil {
+		when = t.shown.Time.Format("15:04:05")
+	}
+	lines := []string{
+		fmt.Sprintf("mcsauna - %s  %s", when, status),
+		"h/b/c: sort by hits/bytes/command  /: filter  p: pause  q: quit",
+		"",
+		fmt.Sprintf("%10s %12s %-10s %s", "HITS", "BYTES", "COMMAND", "KEY"),
+	}
+	if t.shown != nil {
+		for _, row := range sortTopRows(topRows(t.shown, t.prefix, t.filter), t.sort_by) {
+			if len(lines) >= height-1 {
+				break
+			}
+			lines = append(lines,
+				fmt.Sprintf("%10d %12d %-10s %s", row.Hits, row.Bytes, row.Command, row.Key))
+		}
+	}
+	for i, line := range lines {
+		if len(line) > width {
+			lines[i] = line[:width]
+		}
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// topRows returns a row for each key in a report containing filter.
+func topRows(report *Report, prefix string, filter string) []*topRow {
+	keys_family := metricName(prefix, "keys").Family()
+	bytes_family := metricName(prefix, "bytes").Family()
+	commands_family := metricName(prefix, "commands").Append("keys").Family()
+
+	rows := map[string]*topRow{}
+	row := func(key string) *topRow {
+		if _, ok := rows[key]; !ok {
+			rows[key] = &topRow{Key: key}
+		}
+		return rows[key]
+	}
+	for _, m := range report.Metrics {
+		_, key, command := m.Name.SplitKeyCommand()
+		if key == "" || !strings.Contains(key, filter) {
+			continue
+		}
+		switch m.Name.Family() {
+		case keys_family:
+			row(key).Hits = int(m.Value)
+		case bytes_family:
+			row(key).Bytes = int(m.Value)
+		case commands_family:
+			if r := row(key); int(m.Value) > r.command_hits {
+				r.Command = command
+				r.command_hits = int(m.Value)
+			}
+		}
+	}
+
+	sorted := []*topRow{}
+	for _, r := range rows {
+		sorted = append(sorted, r)
+	}
+	return sorted
+}
+
+// sortTopRows sorts rows by hits or bytes, most first, or by command, then
+// by hits, breaking ties by key.
+func sortTopRows(rows []*topRow, sort_by byte) []*topRow {
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		switch {
+		case sort_by == TOP_SORT_BYTES && a.Bytes != b.Bytes:
+			return a.Bytes > b.Bytes
+		case sort_by == TOP_SORT_COMMAND && a.Command != b.Command:
+			return a.Command < b.Command
+		case a.Hits != b.Hits:
+			return a.Hits > b.Hits
+		}
+		return a.Key < b.Key
+	})
+	return rows
+}
+
+// stty runs stty on the terminal in, returning its output.
+func stty(in *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = in
+	output, err := cmd.Output()
+	return string(output), err
+}
+
+// terminalSize returns the number of rows and columns in the terminal, or
+// a standard 24x80 if it can't be found.
+func terminalSize() (int, int) {
+	size, err := stty(os.Stdin, "size")
+	if err != nil {
+		return 24, 80
+	}
+	var rows, cols int
+	if _, err := fmt.Sscan(size, &rows, &cols); err != nil || rows == 0 || cols == 0 {
+		return 24, 80
+	}
+	return rows, cols
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testTopReport(now time.Time) *Report {
+	report := NewReport(now)
+	keys := metricName("mcsauna", "keys")
+	report.Count(keys.Label("key", "foo"), 10)
+	report.Count(keys.Label("key", "bar"), 5)
+	report.Count(keys.Label("key", "food"), 1)
+	report.Count(metricName("mcsauna", "bytes").Label("key", "bar"), 5000)
+	report.Count(metricName("mcsauna", "bytes").Label("key", "foo"), 100)
+	for _, cmd := range []string{"get", "set"} {
+		commands := metricName("mcsauna", "commands").Label("command", cmd).Append("keys")
+		report.Count(commands.Label("key", "foo"), map[string]int{"get": 2, "set": 8}[cmd])
+		report.Count(commands.Label("key", "bar"), map[string]int{"get": 5, "set": 0}[cmd])
+	}
+	return report
+}
+
+// topKeys returns the keys of rows, in order.
+func topKeys(rows []*topRow) []string {
+	keys := []string{}
+	for _, row := range rows {
+		keys = append(keys, row.Key)
+	}
+	return keys
+}
+
+func TestTopRows(t *testing.T) {
+	report := testTopReport(time.Now())
+	expected := map[byte][]string{
+		TOP_SORT_HITS:    {"foo", "bar", "food"},
+		TOP_SORT_BYTES:   {"bar", "foo", "food"},
+		TOP_SORT_COMMAND: {"food", "bar", "foo"},
+	}
+	for sort_by, keys := range expected {
+		rows := sortTopRows(topRows(report, "mcsauna", ""), sort_by)
+		if actual := topKeys(rows); !stringsEqual(actual, keys) {
+			t.Errorf("Expected %v sorted by %s, got %v\n", keys, TOP_SORT_NAMES[sort_by], actual)
+		}
+	}
+
+	rows := sortTopRows(topRows(report, "mcsauna", "foo"), TOP_SORT_HITS)
+	if keys := topKeys(rows); !stringsEqual(keys, []string{"foo", "food"}) {
+		t.Errorf("Expected only keys containing foo, got %v\n", keys)
+	}
+	if rows[0].Command != "set" || rows[0].Bytes != 100 {
+		t.Errorf("Unexpected row %+v\n", rows[0])
+	}
+}
+
+func TestTopKeys(t *testing.T) {
+	top := NewTopSink(&bytes.Buffer{}, "mcsauna")
+	first := testTopReport(time.Now())
+	top.Send(first)
+
+	/* Pausing keeps the report shown until unpaused */
+	top.handleKey('p')
+	second := testTopReport(time.Now())
+	top.Send(second)
+	if top.shown != first {
+		t.Errorf("Expected the first report to be shown while paused\n")
+	}
+	top.handleKey('p')
+	if top.shown != second {
+		t.Errorf("Expected the latest report to be shown after unpausing\n")
+	}
+
+	/* Keys typed while filtering go into the filter */
+	for _, key := range []byte("/fooq\x7f\r") {
+		if top.handleKey(key) {
+			t.Errorf("Unexpected quit while filtering\n")
+		}
+	}
+	if top.filter != "foo" {
+		t.Errorf("Expected filter foo, got %q\n", top.filter)
+	}
+	top.handleKey('b')
+	output := top.format(24, 80)
+	if !strings.Contains(output, "sort: bytes  filter: foo") || strings.Contains(output, " bar\r\n") {
+		t.Errorf("Unexpected output %q\n", output)
+	}
+	if !top.handleKey('q') {
+		t.Errorf("Expected q to quit\n")
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Build metadata, set when building with e.g.
+//
+//	go build -ldflags "-X main.VERSION=1.2.0 -X main.COMMIT=$(git rev-parse --short HEAD) -X main.BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	VERSION    = "dev"
+	COMMIT     = "unknown"
+	BUILD_DATE = "unknown"
+)
+
+// versionString returns the version, commit and build date, for -version.
+func versionString() string {
+	return fmt.Sprintf("mcsauna %s (commit %s, built %s)", VERSION, COMMIT, BUILD_DATE)
+}
+
+func versionCommand(args []string) error {
+	flags := newFlagSet("version")
+	flags.Parse(args)
+
+	fmt.Println(versionString())
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const WEBHOOK_RETRY_DELAY = time.Second
+
+// WebhookSink posts each report, as JSON, to a URL, with any headers
+// configured, e.g. for authorization.  Failed posts are retried, waiting
+// twice as long before each retry, unless the server rejects the report
+// outright with a 4xx response.
+type WebhookSink struct {
+	url     string
+	headers map[string]string
+	retries int
+	client  *http.Client
+
+	// Wait before the first retry
+	retry_delay time.Duration
+}
+
+// NewWebhookSink returns a sink posting to url, retrying each report up
+// to retries times, and giving up on each attempt after timeout.
+func NewWebhookSink(url string, headers map[string]string, retries int, timeout time.Duration) *WebhookSink {
+	return &WebhookSink{
+		url:         url,
+		headers:     headers,
+		retries:     retries,
+		client:      &http.Client{Timeout: timeout},
+		retry_delay: WEBHOOK_RETRY_DELAY,
+	}
+}
+
+func (s *WebhookSink) Send(report *Report) error {
+	body := []byte(report.JSON())
+	delay := s.retry_delay
+	var err error
+	for attempt := 0; attempt <= s.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		var retry bool
+		if retry, err = s.post(body); err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// post makes a single attempt at posting a report, returning whether it is
+// worth trying again if it fails.
+func (s *WebhookSink) post(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for header, value := range s.headers {
+		req.Header.Set(header, value)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := resp.StatusCode/100 != 4 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("Webhook responded %s", resp.Status)
+}
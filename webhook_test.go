@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookSink(t *testing.T) {
+	statuses := []int{http.StatusServiceUnavailable, http.StatusOK}
+	posted := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+			t.Errorf("Unexpected Authorization header %q\n", auth)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		posted = append(posted, string(body))
+		w.WriteHeader(statuses[0])
+		statuses = statuses[1:]
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, map[string]string{"Authorization": "Bearer secret"},
+		3, time.Second)
+	sink.retry_delay = time.Millisecond
+	report := testReport(time.Unix(1500000000, 0), 3)
+	if err := sink.Send(report); err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 2 || posted[1] != report.JSON() {
+		t.Errorf("Expected the report to be posted twice, got %q\n", posted)
+	}
+}
+
+func TestWebhookSinkRejected(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts += 1
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, nil, 3, time.Second)
+	sink.retry_delay = time.Millisecond
+	if err := sink.Send(testReport(time.Unix(1500000000, 0), 3)); err == nil {
+		t.Errorf("Expected an error when the report is rejected\n")
+	}
+	if attempts != 1 {
+		t.Errorf("Expected a rejected report not to be retried, got %d attempts\n", attempts)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+)
+
+// SlidingWindow keeps the hot keys counted in each of a ring of short
+// buckets, so that they can be reported over a window that slides forward a
+// bucket at a time, rather than being reset each interval.  Keys that are
+// hot across a reporting boundary are then not split between reports.
+type SlidingWindow struct {
+	Lock sync.Mutex
+
+	// Ring of buckets, oldest first from next
+	buckets []*HotKeyPool
+	next    int
+}
+
+func NewSlidingWindow(num_buckets int) *SlidingWindow {
+	w := &SlidingWindow{}
+	w.buckets = make([]*HotKeyPool, num_buckets)
+	return w
+}
+
+// Push adds a bucket to the window, replacing the oldest.
+func (w *SlidingWindow) Push(bucket *HotKeyPool) {
+	w.Lock.Lock()
+	defer w.Lock.Unlock()
+
+	w.buckets[w.next] = bucket
+	w.next = (w.next + 1) % len(w.buckets)
+}
+
+// Sum returns a new pool holding the hits from every bucket in the window.
+func (w *SlidingWindow) Sum() *HotKeyPool {
+	w.Lock.Lock()
+	defer w.Lock.Unlock()
+
+	sum := NewHotKeyPool()
+	for _, bucket := range w.buckets {
+		if bucket == nil {
+			continue
+		}
+		top_keys := bucket.GetTopKeys()
+		for _, key := range *top_keys {
+			sum.AddN(key.Name, key.Hits)
+		}
+	}
+	return sum
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	config, err := NewConfig([]byte(`{"sliding_window": 3, "sliding_window_granularity": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := NewStats(config)
+
+	// ... a key that straddles a report boundary is reported whole
+	for _, keys := range [][]string{{"foo"}, {"foo", "bar"}, {"bar"}, {"bar"}} {
+		stats.HotKeys.Add(keys)
+		stats.Slide()
+	}
+	rotated := stats.Rotate()
+	if hits := rotated.HotKeys.GetHits("bar"); hits != 3 {
+		t.Errorf("Expected 3 hits for bar, got %d\n", hits)
+	}
+	if hits := rotated.HotKeys.GetHits("foo"); hits != 1 {
+		t.Errorf("Expected 1 hit for foo once its first bucket slid out, got %d\n", hits)
+	}
+
+	// ... rotating leaves the window alone
+	if hits := stats.Rotate().HotKeys.GetHits("bar"); hits != 3 {
+		t.Errorf("Expected 3 hits for bar after a second rotation, got %d\n", hits)
+	}
+
+	_, err = NewConfig([]byte(`{"sliding_window": 5, "sliding_window_granularity": 2}`))
+	if err == nil {
+		t.Errorf("Expected error for window that isn't a multiple of granularity\n")
+	}
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is a line of a YAML document, with its indentation split off.
+type yamlLine struct {
+	// Line number, from 1
+	number int
+
+	indent int
+	text   string
+}
+
+// yamlParser parses the subset of YAML used for configuration files: block
+// mappings and sequences, flow mappings and sequences on a single line,
+// plain and quoted scalars, and literal and folded block scalars.  Anchors,
+// aliases, tags and multi-line flow collections aren't supported.
+type yamlParser struct {
+	lines []yamlLine
+	next  int
+}
+
+// parseYAML parses a YAML document into maps, slices and scalars, as
+// encoding/json would decode the same document written as JSON.
+func parseYAML(data []byte) (interface{}, error) {
+	p := &yamlParser{}
+	for i, line := range strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n") {
+		if strings.HasPrefix(strings.TrimLeft(line, " "), "\t") && strings.TrimSpace(line) != "" {
+			return nil, fmt.Errorf("Config error: YAML line %d: tabs can't be used for indentation.", i+1)
+		}
+		text := strings.TrimLeft(line, " ")
+		p.lines = append(p.lines, yamlLine{i + 1, len(line) - len(text), text})
+	}
+
+	p.skipBlank()
+	if p.next < len(p.lines) && p.lines[p.next].text == "---" {
+		p.next += 1
+		p.skipBlank()
+	}
+	if p.next == len(p.lines) {
+		return nil, nil
+	}
+	value, err := p.parseNode(p.lines[p.next].indent)
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	if p.next < len(p.lines) && p.lines[p.next].text != "..." {
+		return nil, p.errorf(p.lines[p.next], "unexpected indentation")
+	}
+	return value, nil
+}
+
+func (p *yamlParser) errorf(line yamlLine, format string, args ...interface{}) error {
+	return fmt.Errorf("Config error: YAML line %d: %s.", line.number, fmt.Sprintf(format, args...))
+}
+
+// skipBlank skips past blank lines and comments.
+func (p *yamlParser) skipBlank() {
+	for p.next < len(p.lines) {
+		if text := yamlStripComment(p.lines[p.next].text); text != "" {
+			return
+		}
+		p.next += 1
+	}
+}
+
+// parseNode parses the block collection or scalar starting at the next
+// line, which is indented by indent.
+func (p *yamlParser) parseNode(indent int) (interface{}, error) {
+	line := p.lines[p.next]
+	text := yamlStripComment(line.text)
+	if text == "-" || strings.HasPrefix(text, "- ") {
+		return p.parseSequence(indent)
+	}
+	if _, _, ok, err := yamlSplitKey(text); err != nil {
+		return nil, p.errorf(line, "%s", err)
+	} else if ok {
+		return p.parseMapping(indent)
+	}
+	p.next += 1
+	return p.parseInline(line, text)
+}
+
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	items := []interface{}{}
+	for p.skipBlank(); p.next < len(p.lines); p.skipBlank() {
+		line := p.lines[p.next]
+		text := yamlStripComment(line.text)
+		if line.indent < indent {
+			break
+		} else if line.indent > indent {
+			return nil, p.errorf(line, "unexpected indentation")
+		} else if text != "-" && !strings.HasPrefix(text, "- ") {
+			break
+		}
+
+		rest := strings.TrimLeft(line.text[1:], " ")
+		if yamlStripComment(rest) == "" {
+			// ... the item is on the lines that follow, if any
+			p.next += 1
+			item, err := p.parseChild(indent, false)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+
+		// ... the item starts on the same line, as if it were on a line of
+		// ... its own, indented to where it starts
+		p.lines[p.next] = yamlLine{line.number, line.indent + len(line.text) - len(rest), rest}
+		item, err := p.parseNode(p.lines[p.next].indent)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	mapping := map[string]interface{}{}
+	for p.skipBlank(); p.next < len(p.lines); p.skipBlank() {
+		line := p.lines[p.next]
+		if line.indent < indent {
+			break
+		} else if line.indent > indent {
+			return nil, p.errorf(line, "unexpected indentation")
+		}
+		text := yamlStripComment(line.text)
+		key, value, ok, err := yamlSplitKey(text)
+		if err != nil {
+			return nil, p.errorf(line, "%s", err)
+		} else if !ok {
+			break
+		} else if _, ok := mapping[key]; ok {
+			return nil, p.errorf(line, "duplicate key %q", key)
+		}
+		p.next += 1
+
+		switch {
+		case value == "":
+			// ... a sequence may be indented as far as its key
+			mapping[key], err = p.parseChild(indent, true)
+		case value[0] == '|' || value[0] == '>':
+			mapping[key], err = p.parseBlockScalar(line, value, indent)
+		default:
+			mapping[key], err = p.parseInline(line, value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mapping, nil
+}
+
+// parseChild parses the node on the lines following a sequence entry or
+// key with nothing after it, or returns nil if there isn't one.
+func (p *yamlParser) parseChild(indent int, sequence_at_indent bool) (interface{}, error) {
+	p.skipBlank()
+	if p.next == len(p.lines) {
+		return nil, nil
+	}
+	line := p.lines[p.next]
+	text := yamlStripComment(line.text)
+	if line.indent > indent {
+		return p.parseNode(line.indent)
+	} else if line.indent == indent && sequence_at_indent &&
+		(text == "-" || strings.HasPrefix(text, "- ")) {
+		return p.parseSequence(indent)
+	}
+	return nil, nil
+}
+
+// parseBlockScalar parses a literal (|) or folded (>) block scalar, whose
+// lines follow that of its key, indented further than the key.
+func (p *yamlParser) parseBlockScalar(line yamlLine, header string, indent int) (interface{}, error) {
+	chomp := ""
+	switch strings.TrimSpace(header[1:]) {
+	case "":
+	case "-", "+":
+		chomp = strings.TrimSpace(header[1:])
+	default:
+		return nil, p.errorf(line, "unsupported block scalar header %q", header)
+	}
+
+	lines := []string{}
+	block_indent := -1
+	for ; p.next < len(p.lines); p.next += 1 {
+		l := p.lines[p.next]
+		if l.text == "" {
+			lines = append(lines, "")
+			continue
+		} else if l.indent <= indent {
+			break
+		} else if block_indent == -1 {
+			block_indent = l.indent
+		} else if l.indent < block_indent {
+			return nil, p.errorf(l, "block scalar lines must be indented consistently")
+		}
+		lines = append(lines, strings.Repeat(" ", l.indent-block_indent)+l.text)
+	}
+
+	// ... trailing blank lines are kept or removed by chomping, not folding
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing += 1
+	}
+	value := ""
+	for i, l := range lines {
+		switch {
+		case i == 0:
+		case header[0] == '|' || l == "" ||
+			strings.HasPrefix(l, " ") || strings.HasPrefix(lines[i-1], " "):
+			value += "\n"
+		case lines[i-1] == "":
+			// ... a blank line folds into a line break of its own
+		default:
+			value += " "
+		}
+		value += l
+	}
+	switch {
+	case len(lines) == 0 || chomp == "-":
+	case chomp == "+":
+		value += strings.Repeat("\n", trailing+1)
+	default:
+		value += "\n"
+	}
+	return value, nil
+}
+
+// parseInline parses a flow collection or scalar that must end on line.
+func (p *yamlParser) parseInline(line yamlLine, text string) (interface{}, error) {
+	f := &yamlFlow{text: text}
+	value, err := f.parseValue(false)
+	if err == nil {
+		f.skipSpace()
+		if f.pos < len(f.text) {
+			err = fmt.Errorf("unexpected %q", f.text[f.pos:])
+		}
+	}
+	if err != nil {
+		return nil, p.errorf(line, "%s", err)
+	}
+	return value, nil
+}
+
+// yamlStripComment removes a comment, and any trailing space, from text.  A
+// "#" only starts a comment at the start of text or after a space, and
+// outside quotes.
+func yamlStripComment(text string) string {
+	quote := byte(0)
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote == 0 && c == '#' && (i == 0 || text[i-1] == ' '):
+			return strings.TrimRight(text[:i], " ")
+		case quote == 0 && (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" [{,:", text[i-1]) != -1):
+			quote = c
+		case quote == '"' && c == '\\':
+			i += 1
+		case quote == '\'' && c == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i += 1
+		case c == quote:
+			quote = 0
+		}
+	}
+	return strings.TrimRight(text, " ")
+}
+
+// yamlSplitKey splits "key: value" into its key and value, returning false
+// if text isn't a mapping entry.
+func yamlSplitKey(text string) (string, string, bool, error) {
+	if text == "" || strings.IndexByte("[{|>", text[0]) != -1 {
+		return "", "", false, nil
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		f := &yamlFlow{text: text}
+		key, err := f.parseQuoted()
+		if err != nil {
+			return "", "", false, err
+		}
+		rest := strings.TrimLeft(text[f.pos:], " ")
+		if rest != ":" && !strings.HasPrefix(rest, ": ") {
+			return "", "", false, nil
+		}
+		return key, strings.TrimSpace(rest[1:]), true, nil
+	}
+	i := strings.Index(text+" ", ": ")
+	if i == -1 {
+		return "", "", false, nil
+	}
+	return strings.TrimRight(text[:i], " "), strings.TrimSpace(text[i+1:]), true, nil
+}
+
+// yamlFlow parses flow collections and scalars within a single line.
+type yamlFlow struct {
+	text string
+	pos  int
+}
+
+func (f *yamlFlow) skipSpace() {
+	for f.pos < len(f.text) && f.text[f.pos] == ' ' {
+		f.pos += 1
+	}
+}
+
+// parseValue parses a value, which, in a flow collection, ends at the next
+// ",", "]" or "}".
+func (f *yamlFlow) parseValue(in_flow bool) (interface{}, error) {
+	f.skipSpace()
+	if f.pos == len(f.text) {
+		return nil, nil
+	}
+	switch f.text[f.pos] {
+	case '[':
+		return f.parseSequence()
+	case '{':
+		return f.parseMapping()
+	case '"', '\'':
+		return f.parseQuoted()
+	case '&', '*', '!':
+		return nil, fmt.Errorf("anchors, aliases and tags aren't supported")
+	case '|', '>':
+		return nil, fmt.Errorf("block scalars are only supported as mapping values")
+	}
+	return yamlResolve(f.parsePlain(in_flow)), nil
+}
+
+func (f *yamlFlow) parsePlain(in_flow bool) string {
+	start := f.pos
+	for ; f.pos < len(f.text); f.pos += 1 {
+		if in_flow && strings.IndexByte(",]}", f.text[f.pos]) != -1 {
+			break
+		} else if in_flow && f.text[f.pos] == ':' &&
+			(f.pos+1 == len(f.text) || strings.IndexByte(" ,]}", f.text[f.pos+1]) != -1) {
+			break
+		}
+	}
+	return strings.TrimRight(f.text[start:f.pos], " ")
+}
+
+func (f *yamlFlow) parseQuoted() (string, error) {
+	quote := f.text[f.pos]
+	f.pos += 1
+	value := []byte{}
+	for f.pos < len(f.text) {
+		c := f.text[f.pos]
+		f.pos += 1
+		switch {
+		case c == quote && quote == '\'' && f.pos < len(f.text) && f.text[f.pos] == '\'':
+			value = append(value, '\'')
+			f.pos += 1
+		case c == quote:
+			return string(value), nil
+		case c == '\\' && quote == '"':
+			if f.pos == len(f.text) {
+				return "", fmt.Errorf("unterminated string")
+			}
+			escape := f.text[f.pos]
+			f.pos += 1
+			switch escape {
+			case 'x', 'u', 'U':
+				digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[escape]
+				if f.pos+digits > len(f.text) {
+					return "", fmt.Errorf("invalid escape")
+				}
+				r, err := strconv.ParseUint(f.text[f.pos:f.pos+digits], 16, 32)
+				if err != nil {
+					return "", fmt.Errorf("invalid escape")
+				}
+				value = append(value, string(rune(r))...)
+				f.pos += digits
+			default:
+				unescaped, ok := YAML_ESCAPES[escape]
+				if !ok {
+					return "", fmt.Errorf("invalid escape \\%c", escape)
+				}
+				value = append(value, unescaped...)
+			}
+		default:
+			value = append(value, c)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+// Escapes in double-quoted YAML strings, other than \x, \u and \U
+var YAML_ESCAPES = map[byte]string{
+	'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", '\t': "\t", 'n': "\n",
+	'v': "\v", 'f': "\f", 'r': "\r", 'e': "\x1b", ' ': " ", '"': "\"",
+	'/': "/", '\\': "\\", 'N': "\u0085", '_': "\u00a0", 'L': "\u2028",
+	'P': "\u2029",
+}
+
+func (f *yamlFlow) parseSequence() (interface{}, error) {
+	items := []interface{}{}
+	f.pos += 1
+	for {
+		f.skipSpace()
+		if f.pos == len(f.text) {
+			return nil, fmt.Errorf("unterminated sequence")
+		} else if f.text[f.pos] == ']' {
+			f.pos += 1
+			return items, nil
+		}
+		item, err := f.parseValue(true)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if err := f.parseSeparator(']'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (f *yamlFlow) parseMapping() (interface{}, error) {
+	mapping := map[string]interface{}{}
+	f.pos += 1
+	for {
+		f.skipSpace()
+		if f.pos == len(f.text) {
+			return nil, fmt.Errorf("unterminated mapping")
+		} else if f.text[f.pos] == '}' {
+			f.pos += 1
+			return mapping, nil
+		}
+
+		var key string
+		if c := f.text[f.pos]; c == '"' || c == '\'' {
+			var err error
+			if key, err = f.parseQuoted(); err != nil {
+				return nil, err
+			}
+		} else {
+			key = f.parsePlain(true)
+		}
+		if _, ok := mapping[key]; ok {
+			return nil, fmt.Errorf("duplicate key %q", key)
+		}
+		f.skipSpace()
+		if f.pos < len(f.text) && f.text[f.pos] == ':' {
+			f.pos += 1
+			value, err := f.parseValue(true)
+			if err != nil {
+				return nil, err
+			}
+			mapping[key] = value
+		} else {
+			mapping[key] = nil
+		}
+		if err := f.parseSeparator('}'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseSeparator skips past the "," after an item in a flow collection, if
+// the collection doesn't end with close instead.
+func (f *yamlFlow) parseSeparator(close byte) error {
+	f.skipSpace()
+	if f.pos < len(f.text) && f.text[f.pos] == ',' {
+		f.pos += 1
+		return nil
+	} else if f.pos < len(f.text) && f.text[f.pos] == close {
+		return nil
+	}
+	return fmt.Errorf("expected ',' or '%c'", close)
+}
+
+// Plain scalars that are integers or floats in the YAML core schema
+var (
+	YAML_INT_RE   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	YAML_FLOAT_RE = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+)
+
+// yamlResolve returns the null, boolean, number or string a plain scalar
+// stands for.  Infinity and NaN are left as strings, since they can't be
+// written as JSON.
+func yamlResolve(plain string) interface{} {
+	switch plain {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	var n int64
+	var err error = strconv.ErrSyntax
+	switch {
+	case YAML_INT_RE.MatchString(plain):
+		n, err = strconv.ParseInt(plain, 10, 64)
+	case strings.HasPrefix(plain, "0x"):
+		n, err = strconv.ParseInt(plain[2:], 16, 64)
+	case strings.HasPrefix(plain, "0o"):
+		n, err = strconv.ParseInt(plain[2:], 8, 64)
+	}
+	if err == nil {
+		return n
+	}
+	if YAML_FLOAT_RE.MatchString(plain) {
+		if f, err := strconv.ParseFloat(plain, 64); err == nil {
+			return f
+		}
+	}
+	return plain
+}
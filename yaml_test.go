@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+/* The same configuration as JSON, YAML and TOML */
+var CONFIG_FORMAT_TESTS = map[string]string{
+	"conf.json": `{
+		"interval": 10,
+		"prefix": "cache.{hostname}",
+		"regexps": [
+			{"name": "user", "re": "^user:\\d+$", "priority": 2},
+			{"name": "post", "re": "^post:"}
+		],
+		"proxies": {"10.0.0.1:22122": "twemproxy-a"},
+		"ports": [11211, 11212],
+		"alerts": [{"name": "hot", "share": 0.5}],
+		"report_movers": true
+	}`,
+	"conf.yaml": `---
+# Report every ten seconds
+interval: 10
+prefix: 'cache.{hostname}'
+regexps:
+- name: user
+  re: "^user:\\d+$"  # "#" in quotes isn't a comment
+  priority: 2
+- {name: post, re: "^post:"}
+proxies:
+  10.0.0.1:22122: twemproxy-a
+ports: [11211, 11212]
+alerts:
+  - name: hot
+    share: 0.5
+report_movers: true
+`,
+	"conf.toml": `
+# Report every ten seconds
+interval = 10
+prefix = 'cache.{hostname}'
+ports = [
+  11211,
+  11212,  # trailing commas are allowed
+]
+report_movers = true
+alerts = [{name = "hot", share = 0.5}]
+
+[[regexps]]
+name = "user"
+re = '^user:\d+$'
+priority = 2
+
+[[regexps]]
+name = "post"
+re = "^post:"
+
+[proxies]
+"10.0.0.1:22122" = "twemproxy-a"
+`,
+}
+
+func TestReadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcsauna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configs := map[string]Config{}
+	for name, data := range CONFIG_FORMAT_TESTS {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, []byte(data), 0644)
+		if configs[name], err = ReadConfig(path); err != nil {
+			t.Errorf("Unexpected error reading %s: %v\n", name, err)
+		}
+	}
+	if expected := configs["conf.json"]; expected.Interval != Duration(10*time.Second) || len(expected.Regexps) != 2 {
+		t.Fatalf("Unexpected config from JSON %+v\n", expected)
+	}
+	for _, name := range []string{"conf.yaml", "conf.toml"} {
+		if !reflect.DeepEqual(configs[name], configs["conf.json"]) {
+			t.Errorf("Expected %s to match the JSON, got %+v\n", name, configs[name])
+		}
+	}
+
+	/* Errors in the file are reported, as are errors in the config */
+	for name, data := range map[string]string{
+		"bad.yaml":     "interval: 10\n  prefix: foo\n",
+		"bad.toml":     "interval = 10\ninterval = 20\n",
+		"invalid.yaml": "interval: 10\nkey_delimiter: ':'\nregexps: [{name: foo, re: ^foo}]\n",
+	} {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, []byte(data), 0644)
+		if _, err := ReadConfig(path); err == nil {
+			t.Errorf("Expected error reading %s\n", name)
+		}
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		yaml     string
+		expected interface{}
+	}{
+		{"", nil},
+		{"- 1\n- -2.5\n- 0x1f\n- ~\n- yes\n- '1'", []interface{}{int64(1), -2.5, int64(31), nil, "yes", "1"}},
+		{`a: "tab\there \u00e9"`, map[string]interface{}{"a": "tab\there \u00e9"}},
+		{`a: 'it''s # not a comment'`, map[string]interface{}{"a": "it's # not a comment"}},
+		{"a:\n  b:\n    - c: 1\n      d: 2\n", map[string]interface{}{
+			"a": map[string]interface{}{"b": []interface{}{map[string]interface{}{"c": int64(1), "d": int64(2)}}},
+		}},
+		{"a: |\n  one\n   two\n\nb: >-\n  folded\n  line\n\n  next\n", map[string]interface{}{
+			"a": "one\n two\n", "b": "folded line\nnext",
+		}},
+		{"a:\nb: {c: [1, {d: e}], f}\n", map[string]interface{}{
+			"a": nil, "b": map[string]interface{}{"c": []interface{}{int64(1), map[string]interface{}{"d": "e"}}, "f": nil},
+		}},
+	}
+	for _, test := range tests {
+		actual, err := parseYAML([]byte(test.yaml))
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %v\n", test.yaml, err)
+		} else if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Expected %q to parse as %#v, got %#v\n", test.yaml, test.expected, actual)
+		}
+	}
+
+	for _, yaml := range []string{"a: 1\na: 2", "a: [1, 2", "a: *alias", "a: 1\n\tb: 2", "a: \"\\q\""} {
+		if _, err := parseYAML([]byte(yaml)); err == nil {
+			t.Errorf("Expected error parsing %q\n", yaml)
+		}
+	}
+}